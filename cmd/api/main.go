@@ -2,27 +2,41 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/andev0x/socks5-proxy-analytics/internal/config"
 	"github.com/andev0x/socks5-proxy-analytics/internal/handlers"
 	"github.com/andev0x/socks5-proxy-analytics/internal/logger"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
 	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
 func main() {
+	// Deferred first so it runs last, after the other deferred cleanup.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
-	log, err := logger.New(cfg.Logging.Level)
+	log, err := logger.New(cfg.Logging.Level, cfg.Logging.File)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
 		os.Exit(1)
@@ -32,14 +46,25 @@ func main() {
 	}()
 
 	zapLog := log.GetZapLogger()
+	stopReopen := log.ReopenOnSignal(syscall.SIGHUP)
+	defer stopReopen()
+	zapLog.Info("Configuration loaded", zap.Strings("config", cfg.Settings()))
+
+	// Check the certificate and keys before anything else so bad ones fail fast.
+	tlsConfig, err := apiTLSConfig(cfg, zapLog)
+	if err != nil {
+		zapLog.Fatal("Failed to set up API TLS", zap.Error(err))
+	}
+	apiKeys, err := handlers.APIKeysFromConfig(cfg)
+	if err != nil {
+		zapLog.Fatal("Invalid api.auth configuration", zap.Error(err))
+	}
 
 	// Initialize database
-	db, err := storage.NewDatabase(cfg)
+	repo, err := storage.NewRepository(cfg)
 	if err != nil {
 		zapLog.Fatal("Failed to initialize database", zap.Error(err))
 	}
-
-	repo := storage.NewPostgresRepository(db)
 	defer func() {
 		if err := repo.Close(); err != nil {
 			zapLog.Error("failed to close repository", zap.Error(err))
@@ -51,24 +76,88 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.API.TrustedProxies); err != nil {
+		zapLog.Fatal("Invalid api.trusted_proxies", zap.Error(err))
+	}
+	router.Use(gin.Recovery(), handlers.RequestLogger(zapLog))
+
+	// The limiter is created even when disabled so a config reload can turn it on.
+	limiter := security.NewRateLimiter(
+		cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst, cfg.RateLimit.Enabled, zapLog,
+	)
+	limiter.StartSweeper(
+		time.Duration(cfg.RateLimit.SweepIntervalMs)*time.Millisecond,
+		time.Duration(cfg.RateLimit.BucketTTLMs)*time.Millisecond,
+	)
+	defer limiter.Stop()
+	router.Use(handlers.RateLimit(limiter))
+	reloadOnSignal(cfg, log, limiter)
 
 	// Initialize handler
-	handler := handlers.NewHandler(repo, zapLog)
+	handler := handlers.NewHandler(repo, cfg, zapLog)
 
 	// Register routes
 	router.GET("/health", handler.Health)
-	router.GET("/stats/top-domains", handler.GetTopDomains)
-	router.GET("/stats/source-ips", handler.GetTopSourceIPs)
-	router.GET("/stats/traffic", handler.GetTrafficStats)
-	router.GET("/logs/traffic", handler.GetTrafficLogs)
+	router.GET("/livez", handler.Live)
+	router.GET("/readyz", handler.Health)
+
+	// Everything but the health checks needs an API key once keys are set.
+	routes := router.Group("/")
+	if len(cfg.API.Auth.Keys) > 0 || len(cfg.API.Auth.ScopedKeys) > 0 {
+		routes.Use(handlers.APIKeyAuth(apiKeys), handlers.RequireScope(handlers.ScopeRead))
+	} else {
+		zapLog.Warn("API authentication is disabled; set api.auth.keys before exposing the API beyond localhost")
+	}
+	routes.GET("/stats/top-domains", handler.GetTopDomains)
+	routes.GET("/stats/source-ips", handler.GetTopSourceIPs)
+	routes.GET("/stats/source-ips/:ip/domains", handler.GetDomainsForSourceIP)
+	routes.GET("/stats/users", handler.GetTopUsers)
+	routes.GET("/stats/ports", handler.GetTopPorts)
+	routes.GET("/stats/traffic", handler.GetTrafficStats)
+	routes.GET("/stats/concurrency", handler.GetConcurrentConnections)
+	routes.GET("/stats/timeseries", handler.GetTrafficTimeSeries)
+	routes.GET("/stats/usage", handler.GetUserDailyUsage)
+	routes.GET("/stats/quota", handler.GetQuotaUsage)
+	routes.GET("/stats/suspicious", handler.GetSuspiciousConnections)
+	routes.GET("/stats/regions", handler.GetRegionStats)
+	routes.GET("/stats/countries", handler.GetCountryStats)
+	routes.GET("/stats/protocols", handler.GetProtocolStats)
+	routes.GET("/stats/failures", handler.GetFailureStats)
+	routes.GET("/stats/stream", handler.StreamStats)
+	routes.GET("/logs/traffic", handler.GetTrafficLogs)
+
+	// Admin endpoints are only served when a key grants the admin scope.
+	if apiKeys.HasScope(handlers.ScopeAdmin) {
+		admin := router.Group("/admin", handlers.APIKeyAuth(apiKeys), handlers.RequireScope(handlers.ScopeAdmin))
+		admin.PUT("/loglevel", handlers.SetLogLevel(log))
+		admin.GET("/whitelist", handler.GetWhitelist)
+		admin.POST("/whitelist", handler.AddWhitelistEntry)
+		admin.DELETE("/whitelist/*entry", handler.DeleteWhitelistEntry)
+	}
 
-	zapLog.Info("API server starting", zap.String("address", fmt.Sprintf("%s:%d", cfg.API.Address, cfg.API.Port)))
+	addr := fmt.Sprintf("%s:%d", cfg.API.Address, cfg.API.Port)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	// Event streams never finish on their own, so end them when shutdown starts.
+	server.RegisterOnShutdown(handler.CloseStreams)
+
+	zapLog.Info("API server starting", zap.String("address", addr), zap.Bool("tls", tlsConfig != nil))
 
 	// Run server in a goroutine
 	go func() {
-		addr := fmt.Sprintf("%s:%d", cfg.API.Address, cfg.API.Port)
-		if err := router.Run(addr); err != nil {
+		var err error
+		if tlsConfig != nil {
+			// The certificate comes from tlsConfig.GetCertificate.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			zapLog.Error("failed to run API server", zap.Error(err))
 			os.Exit(1)
 		}
@@ -80,4 +169,66 @@ func main() {
 
 	<-sigChan
 	zapLog.Info("API server shutting down gracefully...")
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(cfg.API.ShutdownTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		zapLog.Error("API server did not shut down cleanly", zap.Error(err))
+		exitCode = 1
+
+		return
+	}
+
+	zapLog.Info("Shutdown complete")
+}
+
+// apiTLSConfig returns the HTTPS config when api.tls is enabled, or nil to
+// serve plain HTTP.
+func apiTLSConfig(cfg *config.Config, log *zap.Logger) (*tls.Config, error) {
+	tlsCfg := cfg.API.TLS
+	if !tlsCfg.Enabled {
+		return nil, nil
+	}
+	if tlsCfg.CertFile == "" || tlsCfg.KeyFile == "" {
+		return nil, errors.New("api.tls.enabled requires api.tls.cert_file and api.tls.key_file")
+	}
+
+	certs, err := security.NewCertReloader(tlsCfg.CertFile, tlsCfg.KeyFile, log)
+	if err != nil {
+		return nil, err
+	}
+
+	return certs.TLSConfig(), nil
+}
+
+// reloadOnSignal reloads the configuration on SIGHUP, alongside the log file
+// reopen, and applies the log level and rate limit to the running server.
+// Changed settings that only take effect after a restart are logged.
+func reloadOnSignal(cfg *config.Config, log *logger.Logger, limiter *security.RateLimiter) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			updated, err := config.Load()
+			if err != nil {
+				log.Error("Failed to reload config", zap.Error(err))
+
+				continue
+			}
+			for _, key := range config.RestartRequired(cfg, updated, "api", "database", "logging", "rate_limit") {
+				log.Warn("Config change needs a restart to take effect", zap.String("key", key))
+			}
+
+			if _, err := log.SetLevel(updated.Logging.Level); err != nil {
+				log.Warn("Keeping the current log level", zap.Error(err))
+			}
+			limiter.Reconfigure(
+				updated.RateLimit.RequestsPerSecond, updated.RateLimit.Burst, updated.RateLimit.Enabled,
+			)
+			log.Info("Config reloaded")
+		}
+	}()
 }
@@ -0,0 +1,82 @@
+// Package main provides a command that bulk-imports historical traffic logs.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/importer"
+	"github.com/andev0x/socks5-proxy-analytics/internal/logger"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+func main() {
+	file := flag.String("file", "", "path to a CSV or JSONL file of traffic logs")
+	format := flag.String("format", "", "input format: csv or jsonl (default: detect from extension)")
+	skipInvalid := flag.Bool("skip-invalid", true, "skip rows that fail validation instead of aborting")
+	batchSize := flag.Int("batch-size", 0, "rows per insert batch (default: pipeline.batch_size)")
+	flag.Parse()
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "Usage: import -file <path> [-format csv|jsonl] [-skip-invalid=false]")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	log, err := logger.New(cfg.Logging.Level, cfg.Logging.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = log.Sync()
+	}()
+
+	zapLog := log.GetZapLogger()
+
+	repo, err := storage.NewRepository(cfg)
+	if err != nil {
+		zapLog.Fatal("Failed to initialize database", zap.Error(err))
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			zapLog.Error("failed to close repository", zap.Error(err))
+		}
+	}()
+
+	if *batchSize <= 0 {
+		*batchSize = cfg.Pipeline.BatchSize
+	}
+
+	imp := importer.New(repo, *batchSize, *skipInvalid, zapLog)
+	result, err := imp.ImportFile(context.Background(), *file, importer.Format(*format))
+
+	imported := 0
+	skipped := 0
+	if result != nil {
+		imported = result.Imported
+		skipped = len(result.Errors)
+		for _, rowErr := range result.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *file, rowErr)
+		}
+		if result.Truncated {
+			fmt.Fprintf(os.Stderr, "%s: skipped truncated final record\n", *file)
+		}
+	}
+
+	if err != nil {
+		zapLog.Error("Import failed", zap.Error(err), zap.Int("imported", imported))
+		os.Exit(1)
+	}
+
+	zapLog.Info("Import complete", zap.Int("imported", imported), zap.Int("skipped", skipped))
+}
@@ -2,39 +2,128 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/handlers"
 	"github.com/andev0x/socks5-proxy-analytics/internal/logger"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
 	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
 	"github.com/andev0x/socks5-proxy-analytics/internal/proxy"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
 	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/andev0x/socks5-proxy-analytics/internal/tracing"
 	"go.uber.org/zap"
 )
 
 func main() {
-	cfg, zapLog := initializeApp()
+	check := flag.Bool("check-config", false,
+		"validate the config, print the effective settings and ping the database, then exit")
+	flag.Parse()
+	if *check {
+		os.Exit(checkConfig(os.Stdout, os.Stderr))
+	}
+
+	cfg, log := initializeApp()
+	zapLog := log.GetZapLogger()
+	shutdownTracing := initializeTracing(cfg, zapLog)
+	defer shutdownTracing()
 	repo := initializeDatabase(cfg, zapLog)
 	defer closeRepository(repo, zapLog)
 
-	collector, normalizer, publisher := initializePipeline(cfg, repo, zapLog)
-	proxyServer := initializeProxy(cfg, zapLog, collector)
+	analytics := pipeline.NewAnalyticsSwitch(cfg.Pipeline.AnalyticsEnabled, zapLog)
+	m := initializeMetrics(cfg, analytics, zapLog)
+	latency := initializeLatencyTracker(cfg)
+	anomalies := initializeAnomalyDetector(cfg, m, zapLog)
+	tail := initializeTailHub(cfg, m, zapLog)
+	spill := initializeSpill(cfg, repo, zapLog)
+	retention := initializeRetention(cfg, repo, m, zapLog)
+	collector, normalizer, publisher := initializePipeline(
+		cfg, repo, analytics, latency, anomalies, tail, spill, m, zapLog,
+	)
+	monitor, healthServer := initializeHealth(
+		cfg, zapLog, repo, analytics, latency, anomalies, tail, collector, normalizer, publisher, m,
+	)
+	rateLimiter := initializeRateLimiter(cfg, zapLog)
+	quotas := initializeQuotas(cfg, repo, zapLog)
+	proxyServer, whitelistSync := initializeProxy(
+		cfg, zapLog, repo, collector, rateLimiter, quotas, m,
+		pipeline.AllReady(normalizer.Ready(), publisher.Ready()),
+	)
+	reloadOnSignal(cfg, log, rateLimiter, proxyServer)
+
+	waitForShutdown(cfg, zapLog, proxyServer, collector, normalizer, publisher)
+	stopHealth(zapLog, monitor, healthServer, latency)
+	if anomalies != nil {
+		anomalies.Stop()
+	}
+	rateLimiter.Stop()
+	if quotas != nil {
+		quotas.Stop()
+	}
+	if whitelistSync != nil {
+		whitelistSync.Stop()
+	}
+	if spill != nil {
+		spill.Stop()
+	}
+	if retention != nil {
+		retention.Stop()
+	}
+}
+
+// checkTimeout caps how long checkConfig waits for the database.
+const checkTimeout = 10 * time.Second
+
+// checkConfig loads and validates the configuration, prints the effective
+// settings with secrets redacted and checks that the database answers,
+// without starting anything. It returns the process exit code.
+func checkConfig(stdout, stderr io.Writer) int {
+	cfg, err := config.Load()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Configuration is invalid: %v\n", err)
+
+		return 1
+	}
+
+	_, _ = fmt.Fprintln(stdout, "Effective configuration:")
+	for _, setting := range cfg.Settings() {
+		_, _ = fmt.Fprintf(stdout, "  %s\n", setting)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	if err := storage.CheckConnection(ctx, cfg); err != nil {
+		_, _ = fmt.Fprintf(stderr, "Database check failed: %v\n", err)
+
+		return 1
+	}
+	_, _ = fmt.Fprintln(stdout, "Configuration is valid and the database is reachable")
 
-	waitForShutdown(zapLog, proxyServer, publisher, normalizer)
+	return 0
 }
 
-func initializeApp() (*config.Config, *zap.Logger) {
+func initializeApp() (*config.Config, *logger.Logger) {
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
-	log, err := logger.New(cfg.Logging.Level)
+	log, err := logger.New(cfg.Logging.Level, cfg.Logging.File)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
 		os.Exit(1)
@@ -43,16 +132,51 @@ func initializeApp() (*config.Config, *zap.Logger) {
 		_ = log.Sync()
 	}()
 
-	return cfg, log.GetZapLogger()
+	// Reopen the log file on SIGHUP so logrotate can move it away.
+	log.ReopenOnSignal(syscall.SIGHUP)
+	log.GetZapLogger().Info("Configuration loaded", zap.Strings("config", cfg.Settings()))
+
+	return cfg, log
 }
 
+// initializeTracing exports spans when tracing.endpoint is set. The returned
+// function flushes the spans still pending.
+func initializeTracing(cfg *config.Config, zapLog *zap.Logger) func() {
+	shutdown, err := tracing.Setup(
+		context.Background(), cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.SampleRatio,
+	)
+	if err != nil {
+		zapLog.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
+	if cfg.Tracing.Endpoint != "" {
+		zapLog.Info("Tracing enabled", zap.String("endpoint", cfg.Tracing.Endpoint))
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdown(ctx); err != nil {
+			zapLog.Error("Failed to flush traces", zap.Error(err))
+		}
+	}
+}
+
+// initializeDatabase wraps the repository to reconnect after
+// database.reconnect_after_failures consecutive failed writes, unless that is 0.
 func initializeDatabase(cfg *config.Config, zapLog *zap.Logger) storage.Repository {
-	db, err := storage.NewDatabase(cfg)
+	repo, err := storage.NewRepository(cfg)
 	if err != nil {
 		zapLog.Fatal("Failed to initialize database", zap.Error(err))
 	}
+	if cfg.Database.ReconnectAfterFailures <= 0 {
+		return repo
+	}
+
+	reconnecting := storage.NewReconnectingRepository(repo, cfg.Database.ReconnectAfterFailures, zapLog)
+	reconnecting.Start(time.Duration(cfg.Database.ReconnectIntervalMs) * time.Millisecond)
 
-	return storage.NewPostgresRepository(db)
+	return reconnecting
 }
 
 func closeRepository(repo storage.Repository, zapLog *zap.Logger) {
@@ -61,14 +185,164 @@ func closeRepository(repo storage.Repository, zapLog *zap.Logger) {
 	}
 }
 
+// initializeLatencyTracker returns nil when live latency percentiles are disabled.
+func initializeLatencyTracker(cfg *config.Config) *pipeline.LatencyTracker {
+	if !cfg.Pipeline.LiveLatency.Enabled {
+		return nil
+	}
+
+	tracker := pipeline.NewLatencyTracker()
+	tracker.Start(time.Duration(cfg.Pipeline.LiveLatency.WindowMs) * time.Millisecond)
+
+	return tracker
+}
+
+// initializeAnomalyDetector returns nil when anomaly detection is disabled.
+func initializeAnomalyDetector(cfg *config.Config, m *metrics.Metrics, zapLog *zap.Logger) *pipeline.AnomalyDetector {
+	settings := cfg.Pipeline.Anomaly
+	if !settings.Enabled {
+		return nil
+	}
+
+	detector := pipeline.NewAnomalyDetector(pipeline.AnomalyThresholds{
+		Connections:      settings.ConnectionsThreshold,
+		Bytes:            settings.BytesThreshold,
+		BaselineMultiple: settings.BaselineMultiple,
+		MinConnections:   settings.MinConnections,
+		MinBytes:         settings.MinBytes,
+	}, settings.BaselineWindows, settings.MaxIPs, zapLog)
+	if m != nil {
+		detector.SetOnAnomaly(func(pipeline.Anomaly) {
+			m.AnomaliesDetected.Inc()
+		})
+	}
+	detector.Start(time.Duration(settings.WindowMs) * time.Millisecond)
+
+	return detector
+}
+
+// initializeTailHub returns nil when the live tail is disabled.
+func initializeTailHub(cfg *config.Config, m *metrics.Metrics, zapLog *zap.Logger) *pipeline.TailHub {
+	if !cfg.Pipeline.Tail.Enabled {
+		return nil
+	}
+
+	hub := pipeline.NewTailHub(cfg.Pipeline.Tail.MaxClients, cfg.Pipeline.Tail.BufferSize, zapLog)
+	if m != nil {
+		hub.SetOnDrop(m.TailDrops.Inc)
+	}
+
+	return hub
+}
+
+// initializeMetrics registers the Prometheus metrics and starts serving them.
+// It returns nil, which records nothing, when metrics are disabled.
+func initializeMetrics(cfg *config.Config, analytics *pipeline.AnalyticsSwitch, zapLog *zap.Logger) *metrics.Metrics {
+	if !cfg.Metrics.Enabled {
+		if cfg.Debug.PprofEnabled {
+			zapLog.Warn("debug.pprof_enabled has no effect while metrics are disabled")
+		}
+
+		return nil
+	}
+
+	m, err := metrics.NewMetrics()
+	if err != nil {
+		zapLog.Fatal("Failed to initialize metrics", zap.Error(err))
+	}
+	if cfg.Metrics.Exemplars {
+		m.EnableExemplars(tracing.TraceID)
+	}
+	if cfg.Debug.PprofEnabled {
+		m.EnablePprof()
+		zapLog.Warn("pprof profiles are served on the metrics port; keep it on a trusted network")
+	}
+
+	analytics.SetOnChange(func(enabled bool) {
+		if enabled {
+			m.AnalyticsEnabled.Set(1)
+		} else {
+			m.AnalyticsEnabled.Set(0)
+		}
+	})
+
+	go func() {
+		if err := m.StartServer(cfg.Metrics.Address, cfg.Metrics.Port); err != nil {
+			zapLog.Error("metrics server error", zap.Error(err))
+		}
+	}()
+
+	zapLog.Info("Metrics server started",
+		zap.String("address", fmt.Sprintf("%s:%d", cfg.Metrics.Address, cfg.Metrics.Port)))
+
+	return m
+}
+
+// initializeSpill returns nil when spilling failed batches to disk is disabled.
+func initializeSpill(cfg *config.Config, repo storage.Repository, zapLog *zap.Logger) *pipeline.Spill {
+	if !cfg.Pipeline.Spill.Enabled {
+		return nil
+	}
+
+	spill, err := pipeline.NewSpill(cfg.Pipeline.Spill.Dir, cfg.Pipeline.Spill.MaxBytes, repo, zapLog)
+	if err != nil {
+		zapLog.Fatal("Failed to initialize spill", zap.Error(err))
+	}
+	spill.Start(time.Duration(cfg.Pipeline.Spill.ReplayIntervalMs) * time.Millisecond)
+
+	return spill
+}
+
+// initializeRetention returns nil when traffic logs are kept forever.
+func initializeRetention(
+	cfg *config.Config, repo storage.Repository, m *metrics.Metrics, zapLog *zap.Logger,
+) *pipeline.Retention {
+	if cfg.Retention.MaxAge <= 0 {
+		return nil
+	}
+
+	retention := pipeline.NewRetention(repo, cfg.Retention.MaxAge, zapLog)
+	if m != nil {
+		retention.SetOnDeleted(func(rows int64) {
+			m.RetentionDeletes.Add(float64(rows))
+		})
+	}
+	retention.Start(time.Duration(cfg.Retention.IntervalMs) * time.Millisecond)
+
+	return retention
+}
+
 func initializePipeline(
-	cfg *config.Config, repo storage.Repository, zapLog *zap.Logger,
+	cfg *config.Config, repo storage.Repository,
+	analytics *pipeline.AnalyticsSwitch, latency *pipeline.LatencyTracker, anomalies *pipeline.AnomalyDetector,
+	tail *pipeline.TailHub, spill *pipeline.Spill, m *metrics.Metrics, zapLog *zap.Logger,
 ) (*pipeline.Collector, *pipeline.Normalizer, *pipeline.Publisher) {
 	collectorChan := make(chan pipeline.RawTrafficEvent, cfg.Pipeline.BufferSize)
 	normalizerOutputChan := make(chan *models.TrafficLog, cfg.Pipeline.BufferSize)
 
+	idGen, err := pipeline.NewIDGenerator(cfg.Database.PrimaryKey)
+	if err != nil {
+		zapLog.Fatal("Invalid database configuration", zap.Error(err))
+	}
+
 	collector := pipeline.NewCollector(collectorChan, zapLog)
+	collector.SetAnalyticsSwitch(analytics)
+	collector.SetMetrics(m)
+	if err := collector.SetOverflowPolicy(cfg.Pipeline.OverflowPolicy, cfg.Pipeline.OverflowSampleRate); err != nil {
+		zapLog.Fatal("Invalid pipeline configuration", zap.Error(err))
+	}
+
 	normalizer := pipeline.NewNormalizer(collectorChan, normalizerOutputChan, zapLog)
+	normalizer.SetIDGenerator(idGen)
+	normalizer.SetAnalyticsSwitch(analytics)
+	normalizer.SetLatencyTracker(latency)
+	normalizer.SetAnomalyDetector(anomalies)
+	normalizer.SetTailHub(tail)
+	normalizer.SetMetrics(m)
+	if err := normalizer.SetOverflowMode(cfg.Pipeline.NormalizerOverflow); err != nil {
+		zapLog.Fatal("Invalid pipeline configuration", zap.Error(err))
+	}
+	addEnrichers(cfg, normalizer, m, zapLog)
 	normalizer.Start(cfg.Pipeline.Workers)
 
 	publisher := pipeline.NewPublisher(
@@ -78,27 +352,264 @@ func initializePipeline(
 		cfg.Pipeline.FlushInterval,
 		zapLog,
 	)
+	publisher.SetAnalyticsSwitch(analytics)
+	publisher.SetMetrics(m)
+	publisher.SetSpill(spill)
+	publisher.SetRetry(pipeline.RetryPolicy{
+		MaxAttempts:    cfg.Pipeline.Retry.MaxAttempts,
+		InitialBackoff: time.Duration(cfg.Pipeline.Retry.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.Pipeline.Retry.MaxBackoffMs) * time.Millisecond,
+		MaxElapsed:     time.Duration(cfg.Pipeline.Retry.MaxElapsedMs) * time.Millisecond,
+	}, cfg.Pipeline.Retry.MaxHeldBatches)
+	publisher.SetCoalescing(
+		cfg.Pipeline.Coalesce.MinBatchSize,
+		time.Duration(cfg.Pipeline.Coalesce.MaxLatencyMs)*time.Millisecond,
+	)
+	publisher.SetMaxBatchBytes(cfg.Pipeline.MaxBatchBytes)
 	publisher.Start()
 
 	return collector, normalizer, publisher
 }
 
+// addEnrichers adds the configured enrichers to normalizer. GeoIP runs first
+// so the region enricher sees the source country.
+func addEnrichers(cfg *config.Config, normalizer *pipeline.Normalizer, m *metrics.Metrics, zapLog *zap.Logger) {
+	enrichment := cfg.Pipeline.Enrichment
+	if enrichment.GeoIPCountryDB != "" || enrichment.GeoIPASNDB != "" {
+		geoIP, err := pipeline.NewGeoIPEnricher(enrichment.GeoIPCountryDB, enrichment.GeoIPASNDB)
+		if err != nil {
+			zapLog.Warn("Skipping GeoIP enrichment from an unreadable database", zap.Error(err))
+		}
+		if geoIP.Enabled() {
+			normalizer.AddEnricher(geoIP)
+		}
+	}
+	normalizer.AddEnricher(newRegionEnricher(cfg.Pipeline.Regions))
+	if enrichment.ReverseDNS {
+		reverseDNS := pipeline.NewReverseDNSEnricher(
+			time.Duration(enrichment.ReverseDNSTimeoutMs)*time.Millisecond,
+			enrichment.ReverseDNSWorkers,
+			enrichment.ReverseDNSCacheSize,
+		)
+		reverseDNS.SetFailureTTL(time.Duration(enrichment.ReverseDNSRetryMs) * time.Millisecond)
+		if m != nil {
+			reverseDNS.SetOnFailure(m.ReverseDNSFailures.Inc)
+		}
+		normalizer.AddEnricher(reverseDNS)
+	}
+}
+
+func newRegionEnricher(groups []config.RegionGroup) *pipeline.RegionEnricher {
+	regions := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		regions[group.Name] = append(regions[group.Name], group.Countries...)
+	}
+
+	return pipeline.NewRegionEnricher(regions)
+}
+
+func initializeHealth(
+	cfg *config.Config, zapLog *zap.Logger, repo storage.Repository,
+	analytics *pipeline.AnalyticsSwitch, latency *pipeline.LatencyTracker, anomalies *pipeline.AnomalyDetector,
+	tail *pipeline.TailHub, collector *pipeline.Collector, normalizer *pipeline.Normalizer, publisher *pipeline.Publisher,
+	m *metrics.Metrics,
+) (*pipeline.HealthMonitor, *http.Server) {
+	monitor := pipeline.NewHealthMonitor(
+		cfg.Health.QueueWarnThreshold,
+		cfg.Health.QueueCriticalThreshold,
+		time.Duration(cfg.Health.CriticalSustainMs)*time.Millisecond,
+		zapLog,
+	)
+	monitor.SetMetrics(m)
+
+	monitor.AddQueue("collector", collector)
+	monitor.AddQueue("normalizer", normalizer)
+	monitor.AddQueue("publisher", publisher)
+	if db, ok := repo.(pipeline.Dependency); ok {
+		monitor.AddDependency("database", db)
+	}
+	monitor.Start(time.Duration(cfg.Health.SampleIntervalMs) * time.Millisecond)
+
+	// The health port is unauthenticated, so anything that changes state
+	// needs a key from api.auth with the admin scope.
+	apiKeys, err := handlers.APIKeysFromConfig(cfg)
+	if err != nil {
+		zapLog.Fatal("Invalid api.auth configuration", zap.Error(err))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", monitor.StatusHandler)
+	mux.HandleFunc("/ready", monitor.ReadyHandler)
+	mux.HandleFunc("GET /analytics", analytics.Handler)
+	mux.Handle("POST /analytics", apiKeys.Require(handlers.ScopeAdmin, http.HandlerFunc(analytics.Handler)))
+
+	// Live stats and logs carry details of every client, so they are only
+	// served to clients presenting an API key with the read scope.
+	private := map[string]http.HandlerFunc{}
+	if latency != nil {
+		private["/stats/latency/live"] = latency.Handler
+	}
+	if anomalies != nil {
+		private["/stats/anomalies"] = anomalies.Handler
+	}
+	if tail != nil {
+		private["GET /logs/stream"] = tail.Handler
+	}
+	if len(private) > 0 && !apiKeys.HasScope(handlers.ScopeRead) {
+		zapLog.Warn("Live stats and tail are not served without API keys; set api.auth.keys or api.auth.scoped_keys",
+			zap.Strings("endpoints", slices.Sorted(maps.Keys(private))))
+		clear(private)
+	}
+	for pattern, handler := range private {
+		mux.Handle(pattern, apiKeys.Require(handlers.ScopeRead, handler))
+	}
+
+	addr := fmt.Sprintf("%s:%d", cfg.Health.Address, cfg.Health.Port)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zapLog.Error("health server error", zap.Error(err))
+		}
+	}()
+
+	zapLog.Info("Health server started", zap.String("address", addr))
+
+	return monitor, server
+}
+
+func stopHealth(
+	zapLog *zap.Logger, monitor *pipeline.HealthMonitor, server *http.Server, latency *pipeline.LatencyTracker,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		zapLog.Error("Error stopping health server", zap.Error(err))
+	}
+
+	monitor.Stop()
+	if latency != nil {
+		latency.Stop()
+	}
+}
+
+// initializeRateLimiter creates the limiter even when rate limiting is
+// disabled, so a config reload can turn it on.
+func initializeRateLimiter(cfg *config.Config, zapLog *zap.Logger) *security.RateLimiter {
+	limiter := security.NewRateLimiter(
+		cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst, cfg.RateLimit.Enabled, zapLog,
+	)
+	limiter.StartSweeper(
+		time.Duration(cfg.RateLimit.SweepIntervalMs)*time.Millisecond,
+		time.Duration(cfg.RateLimit.BucketTTLMs)*time.Millisecond,
+	)
+
+	return limiter
+}
+
+// initializeQuotas returns nil when proxy.quota is disabled.
+func initializeQuotas(cfg *config.Config, repo storage.Repository, zapLog *zap.Logger) *proxy.Quotas {
+	quotaCfg := cfg.Proxy.Quota
+	if !quotaCfg.Enabled {
+		return nil
+	}
+
+	quotas := proxy.NewQuotas(
+		repo, time.Duration(quotaCfg.WindowMs)*time.Millisecond, quotaCfg.IPBytes, quotaCfg.UserBytes, zapLog,
+	)
+	if err := quotas.Refresh(context.Background()); err != nil {
+		zapLog.Error("failed to load byte quota usage", zap.Error(err))
+	}
+	quotas.Start(time.Duration(quotaCfg.RefreshIntervalMs) * time.Millisecond)
+
+	return quotas
+}
+
+// initializeProxy applies the whitelist entries managed through the API
+// before accepting connections. The returned sync is nil when
+// proxy.whitelist_sync_interval_ms is 0.
 func initializeProxy(
-	cfg *config.Config, zapLog *zap.Logger, collector *pipeline.Collector,
-) *proxy.Server {
+	cfg *config.Config, zapLog *zap.Logger, repo storage.Repository, collector *pipeline.Collector,
+	rateLimiter *security.RateLimiter, quotas *proxy.Quotas, m *metrics.Metrics, ready <-chan struct{},
+) (*proxy.Server, *proxy.WhitelistSync) {
 	proxyServer := proxy.NewServer(cfg, zapLog, collector)
+	proxyServer.SetReadyGate(ready)
+	proxyServer.SetMetrics(m)
+	proxyServer.SetRateLimiter(rateLimiter)
+	if quotas != nil {
+		proxyServer.SetQuotas(quotas)
+	}
+
+	whitelistSync := proxy.NewWhitelistSync(repo, proxyServer, zapLog)
+	if err := whitelistSync.Sync(context.Background()); err != nil {
+		zapLog.Error("failed to load whitelist entries", zap.Error(err))
+	}
+
 	if err := proxyServer.Start(); err != nil {
 		zapLog.Fatal("Failed to start proxy server", zap.Error(err))
 	}
 
 	zapLog.Info("SOCKS5 Proxy Analytics started successfully")
 
-	return proxyServer
+	if cfg.Proxy.WhitelistSyncIntervalMs <= 0 {
+		return proxyServer, nil
+	}
+	whitelistSync.Start(time.Duration(cfg.Proxy.WhitelistSyncIntervalMs) * time.Millisecond)
+
+	return proxyServer, whitelistSync
 }
 
+// reloadOnSignal reloads the configuration on SIGHUP, alongside the log file
+// reopen, and applies the log level, rate limit, whitelist, credentials and
+// egress rules to the running components. Changed settings that only take
+// effect after a restart are logged.
+func reloadOnSignal(
+	cfg *config.Config, log *logger.Logger, rateLimiter *security.RateLimiter, proxyServer *proxy.Server,
+) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			updated, err := config.Load()
+			if err != nil {
+				log.Error("Failed to reload config", zap.Error(err))
+
+				continue
+			}
+			restart := config.RestartRequired(
+				cfg, updated,
+				"proxy", "database", "pipeline", "health", "metrics", "tracing", "debug", "logging", "rate_limit", "retention",
+			)
+			for _, key := range restart {
+				log.Warn("Config change needs a restart to take effect", zap.String("key", key))
+			}
+
+			if _, err := log.SetLevel(updated.Logging.Level); err != nil {
+				log.Warn("Keeping the current log level", zap.Error(err))
+			}
+			rateLimiter.Reconfigure(
+				updated.RateLimit.RequestsPerSecond, updated.RateLimit.Burst, updated.RateLimit.Enabled,
+			)
+			if err := proxyServer.Reload(updated); err != nil {
+				log.Error("Failed to reload egress rules", zap.Error(err))
+			}
+			log.Info("Config reloaded")
+		}
+	}()
+}
+
+// waitForShutdown shuts down in dependency order: the proxy stops accepting
+// and drains open connections, then each pipeline stage drains its buffer
+// into the next, so no collected event is lost.
 func waitForShutdown(
-	zapLog *zap.Logger, proxyServer *proxy.Server,
-	publisher *pipeline.Publisher, normalizer *pipeline.Normalizer,
+	cfg *config.Config, zapLog *zap.Logger, proxyServer *proxy.Server,
+	collector *pipeline.Collector, normalizer *pipeline.Normalizer, publisher *pipeline.Publisher,
 ) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -106,12 +617,19 @@ func waitForShutdown(
 	<-sigChan
 	zapLog.Info("Shutting down gracefully...")
 
-	if err := proxyServer.Stop(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(cfg.Proxy.ShutdownTimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	if err := proxyServer.Shutdown(ctx); err != nil {
 		zapLog.Error("Error stopping proxy server", zap.Error(err))
 	}
 
-	publisher.Stop()
-	normalizer.Close()
+	collector.Close()
+	// The publisher drains concurrently: a paused publisher only starts
+	// reading once closed, and the normalizer blocks while its output is full.
+	go normalizer.Close()
+	publisher.Close()
 
 	zapLog.Info("Shutdown complete")
 }
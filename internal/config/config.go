@@ -4,6 +4,9 @@ package config
 import (
 	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
@@ -15,27 +18,200 @@ type Config struct {
 	Proxy struct {
 		Address string `mapstructure:"address"`
 		Port    int    `mapstructure:"port"`
-		Auth    struct {
+		// Listeners are host:port addresses to accept connections on, e.g.
+		// an IPv4 and an IPv6 address. When empty the proxy listens on
+		// Address:Port alone.
+		Listeners []string `mapstructure:"listeners"`
+		Auth      struct {
 			Enabled  bool   `mapstructure:"enabled"`
 			Username string `mapstructure:"username"`
 			Password string `mapstructure:"password"`
+			// PasswordFile is read for Password when Password is empty.
+			PasswordFile string `mapstructure:"password_file"`
+			// Users lists additional per-user credentials, so traffic can be
+			// attributed and users revoked individually.
+			Users []Credential `mapstructure:"users"`
 		} `mapstructure:"auth"`
 		MaxConnections int      `mapstructure:"max_connections"`
 		IPWhitelist    []string `mapstructure:"ip_whitelist"`
+		// WhitelistSyncIntervalMs is how often the proxy applies the whitelist
+		// entries managed through the API's admin endpoints (0 = never).
+		WhitelistSyncIntervalMs int `mapstructure:"whitelist_sync_interval_ms"`
+		RelayBufferBytes        int `mapstructure:"relay_buffer_bytes"`
+		// DialTimeoutMs caps how long a dial to a destination may take (0 =
+		// no limit beyond the OS's). DialKeepAliveMs is the TCP keep-alive
+		// period of destination connections; negative disables keep-alives.
+		DialTimeoutMs   int `mapstructure:"dial_timeout_ms"`
+		DialKeepAliveMs int `mapstructure:"dial_keepalive_ms"`
+		// IdleTimeoutMs closes a proxied connection once no byte has crossed
+		// it in either direction for this long. 0 disables the timeout.
+		IdleTimeoutMs int `mapstructure:"idle_timeout_ms"`
+		// MaxDialsPerDestination caps concurrent connections to one
+		// destination address; excess dials are refused. 0 disables the cap.
+		MaxDialsPerDestination int `mapstructure:"max_dials_per_destination"`
+		// BlockPrivateDestinations refuses dials to loopback, RFC 1918,
+		// link-local and ULA addresses except those listed as exceptions.
+		BlockPrivateDestinations     bool     `mapstructure:"block_private_destinations"`
+		PrivateDestinationExceptions []string `mapstructure:"private_destination_exceptions"`
+		// Egress allows or denies destinations by exact domain, "*.suffix"
+		// wildcard, IP or CIDR. Deny rules win; a non-empty allow list refuses
+		// everything it doesn't match. RulesFile adds "allow <rule>" and
+		// "deny <rule>" lines and is re-read on SIGHUP.
+		Egress struct {
+			Allow     []string `mapstructure:"allow"`
+			Deny      []string `mapstructure:"deny"`
+			RulesFile string   `mapstructure:"rules_file"`
+		} `mapstructure:"egress"`
+		// LogFailures emits traffic events for blocked, failed and
+		// unauthenticated connection attempts, not just successful ones.
+		LogFailures bool `mapstructure:"log_failures"`
+		// LogAccepts emits a traffic event for every client connection that
+		// gets past the whitelist, rate limit and connection limit, when it
+		// is accepted.
+		LogAccepts bool `mapstructure:"log_accepts"`
+		// ReadyWarmupMs caps how long the listener waits for the pipeline to
+		// become ready before accepting anyway; 0 waits indefinitely.
+		ReadyWarmupMs int `mapstructure:"ready_warmup_ms"`
+		// ShutdownTimeoutMs caps how long shutdown waits for open connections
+		// before closing them.
+		ShutdownTimeoutMs int `mapstructure:"shutdown_timeout_ms"`
+		// DecisionCache caches auth and whitelist decisions per client; a zero TTL disables it.
+		DecisionCache struct {
+			TTLMs      int `mapstructure:"ttl_ms"`
+			MaxEntries int `mapstructure:"max_entries"`
+		} `mapstructure:"decision_cache"`
+		// AcceptLog writes an audit record of refused dials and a sample of
+		// accepted ones, independent of the traffic pipeline.
+		AcceptLog struct {
+			Enabled            bool    `mapstructure:"enabled"`
+			AcceptedSampleRate float64 `mapstructure:"accepted_sample_rate"`
+			HashSourceIP       bool    `mapstructure:"hash_source_ip"`
+			HashSalt           string  `mapstructure:"hash_salt"`
+		} `mapstructure:"accept_log"`
+		// TLS terminates TLS on the SOCKS listener. Handshakes below
+		// MinVersion ("1.0" to "1.3") are refused; CipherSuites limits the
+		// TLS 1.2 suites, using Go's names, and keeps the defaults when empty.
+		TLS struct {
+			Enabled      bool     `mapstructure:"enabled"`
+			CertFile     string   `mapstructure:"cert_file"`
+			KeyFile      string   `mapstructure:"key_file"`
+			MinVersion   string   `mapstructure:"min_version"`
+			CipherSuites []string `mapstructure:"cipher_suites"`
+		} `mapstructure:"tls"`
+		// Compression deflates the client leg in both directions. Clients must
+		// speak the same framing (e.g. a local tunnel agent), so it is off by default.
+		Compression struct {
+			Enabled bool `mapstructure:"enabled"`
+			Level   int  `mapstructure:"level"`
+		} `mapstructure:"compression"`
+		// InterimIntervalMs emits an interim traffic event with the byte
+		// deltas of each open TCP connection at this interval (0 = off).
+		InterimIntervalMs int `mapstructure:"interim_interval_ms"`
+		// UDP enables SOCKS5 UDP ASSOCIATE. Each destination flow is logged
+		// once it has been idle for IdleTimeoutMs or its association ends.
+		UDP struct {
+			Enabled       bool `mapstructure:"enabled"`
+			IdleTimeoutMs int  `mapstructure:"idle_timeout_ms"`
+		} `mapstructure:"udp"`
+		// Quota refuses new connections from a source IP or user that
+		// transferred more than IPBytes or UserBytes (0 = no limit) within the
+		// last WindowMs, according to the traffic logs in the database, which
+		// are re-read every RefreshIntervalMs.
+		Quota struct {
+			Enabled           bool  `mapstructure:"enabled"`
+			WindowMs          int   `mapstructure:"window_ms"`
+			IPBytes           int64 `mapstructure:"ip_bytes"`
+			UserBytes         int64 `mapstructure:"user_bytes"`
+			RefreshIntervalMs int   `mapstructure:"refresh_interval_ms"`
+		} `mapstructure:"quota"`
+		// Throttle paces each TCP connection to BytesPerSecond in each
+		// direction (0 = unlimited). Users overrides the limit for the
+		// connections of individual authenticated users.
+		Throttle struct {
+			BytesPerSecond int64          `mapstructure:"bytes_per_second"`
+			Users          []UserThrottle `mapstructure:"users"`
+		} `mapstructure:"throttle"`
+		// SNI records the TLS SNI hostname as the domain of connections
+		// made by IP, read from the first bytes the client sends.
+		SNI struct {
+			Enabled bool `mapstructure:"enabled"`
+		} `mapstructure:"sni"`
 	} `mapstructure:"proxy"`
 
 	API struct {
-		Address string `mapstructure:"address"`
-		Port    int    `mapstructure:"port"`
+		Address       string `mapstructure:"address"`
+		Port          int    `mapstructure:"port"`
+		Int64AsString bool   `mapstructure:"int64_as_string"`
+		// MaxPageSize caps the limit of endpoints returning individual logs.
+		MaxPageSize int `mapstructure:"max_page_size"`
+		// MaxStreams caps the open /stats/stream subscriptions; 0 means no cap.
+		MaxStreams int `mapstructure:"max_streams"`
+		// ShutdownTimeoutMs caps how long shutdown waits for in-flight requests.
+		ShutdownTimeoutMs int `mapstructure:"shutdown_timeout_ms"`
+		// HealthCheckTimeoutMs caps how long /health and /readyz wait for
+		// the database to answer.
+		HealthCheckTimeoutMs int `mapstructure:"health_check_timeout_ms"`
+		// TrustedProxies are the IPs or CIDRs of reverse proxies whose
+		// X-Forwarded-For header is believed when rate limiting and logging
+		// requests. Empty trusts none, so the peer address is used.
+		TrustedProxies []string `mapstructure:"trusted_proxies"`
+		// AdminToken is an API key with the admin scope, required by the
+		// /admin endpoints and the proxy's POST /analytics along with any
+		// admin key in Auth.ScopedKeys.
+		AdminToken string `mapstructure:"admin_token"`
+		// Auth requires an API key, as a bearer token, X-API-Key header or
+		// api_key query parameter, on every endpoint but the health checks.
+		// Keys grant the "read" scope; ScopedKeys list their scopes, "read"
+		// and/or "admin". Authentication is off while both are empty.
+		Auth struct {
+			Keys       []string `mapstructure:"keys"`
+			ScopedKeys []APIKey `mapstructure:"scoped_keys"`
+		} `mapstructure:"auth"`
+		// TLS serves the API over HTTPS. The certificate is reloaded when
+		// CertFile or KeyFile change.
+		TLS struct {
+			Enabled  bool   `mapstructure:"enabled"`
+			CertFile string `mapstructure:"cert_file"`
+			KeyFile  string `mapstructure:"key_file"`
+		} `mapstructure:"tls"`
 	} `mapstructure:"api"`
 
 	Database struct {
+		// Driver selects the storage backend: "postgres", "clickhouse" or
+		// "memory" (nothing persisted; for tests and local runs).
+		Driver   string `mapstructure:"driver"`
 		Host     string `mapstructure:"host"`
 		Port     int    `mapstructure:"port"`
 		User     string `mapstructure:"user"`
 		Password string `mapstructure:"password"`
-		Database string `mapstructure:"database"`
-		SSLMode  string `mapstructure:"sslmode"`
+		// PasswordFile is read for Password when Password is empty, e.g.
+		// a mounted Kubernetes or Vault secret.
+		PasswordFile string `mapstructure:"password_file"`
+		Database     string `mapstructure:"database"`
+		SSLMode      string `mapstructure:"sslmode"`
+		// PrimaryKey selects how traffic logs are identified: "autoincrement"
+		// or "uuid" (client-side UUIDs that don't collide across proxies).
+		PrimaryKey string `mapstructure:"primary_key"`
+		// MemoryMaxLogs caps the logs kept by the memory driver; the oldest
+		// are discarded beyond it (0 = unlimited).
+		MemoryMaxLogs int `mapstructure:"memory_max_logs"`
+		// InsertBatchSize is the number of rows per INSERT statement
+		// (0 = pipeline.batch_size).
+		InsertBatchSize int `mapstructure:"insert_batch_size"`
+		// OnConflict selects what happens to a saved log whose DedupeColumn
+		// value is already stored: "error", "ignore" or "update".
+		OnConflict   string `mapstructure:"on_conflict"`
+		DedupeColumn string `mapstructure:"dedupe_column"`
+		// Connection pool limits. MaxOpenConns of 0 means unlimited and
+		// ConnMaxLifetimeMs of 0 keeps connections open indefinitely.
+		MaxOpenConns      int `mapstructure:"max_open_conns"`
+		MaxIdleConns      int `mapstructure:"max_idle_conns"`
+		ConnMaxLifetimeMs int `mapstructure:"conn_max_lifetime_ms"`
+		// ReconnectAfterFailures is the number of consecutive failed writes
+		// after which the proxy reports the database as unhealthy and
+		// reconnects, retrying every ReconnectIntervalMs (0 = never).
+		ReconnectAfterFailures int `mapstructure:"reconnect_after_failures"`
+		ReconnectIntervalMs    int `mapstructure:"reconnect_interval_ms"`
 	} `mapstructure:"database"`
 
 	Pipeline struct {
@@ -43,32 +219,199 @@ type Config struct {
 		BufferSize    int `mapstructure:"buffer_size"`
 		BatchSize     int `mapstructure:"batch_size"`
 		FlushInterval int `mapstructure:"flush_interval_ms"`
+		// MaxBatchBytes also flushes a batch once the estimated size of its
+		// logs reaches it (0 = no limit).
+		MaxBatchBytes int64 `mapstructure:"max_batch_bytes"`
+		// Coalesce holds back flushes of batches smaller than MinBatchSize
+		// until the oldest log has waited MaxLatencyMs.
+		Coalesce struct {
+			MinBatchSize int `mapstructure:"min_batch_size"`
+			MaxLatencyMs int `mapstructure:"max_latency_ms"`
+		} `mapstructure:"coalesce"`
+		// AnalyticsEnabled is the startup state of the runtime analytics switch.
+		AnalyticsEnabled bool `mapstructure:"analytics_enabled"`
+		// NormalizerOverflow is "block" or "drop-newest" when the publisher
+		// falls behind.
+		NormalizerOverflow string `mapstructure:"normalizer_overflow"`
+		// OverflowPolicy is "block", "drop" or "sample" when the collector's
+		// channel is full; "sample" keeps one in every OverflowSampleRate.
+		OverflowPolicy     string `mapstructure:"overflow_policy"`
+		OverflowSampleRate int    `mapstructure:"overflow_sample_rate"`
+		// Retry retries failed batch writes with exponential backoff; batches
+		// that still fail are spilled or up to MaxHeldBatches are kept in memory.
+		Retry struct {
+			MaxAttempts      int `mapstructure:"max_attempts"`
+			InitialBackoffMs int `mapstructure:"initial_backoff_ms"`
+			MaxBackoffMs     int `mapstructure:"max_backoff_ms"`
+			MaxElapsedMs     int `mapstructure:"max_elapsed_ms"`
+			MaxHeldBatches   int `mapstructure:"max_held_batches"`
+		} `mapstructure:"retry"`
+		// Spill writes batches the database rejects to Dir and replays them
+		// every ReplayIntervalMs, deleting the oldest past MaxBytes.
+		Spill struct {
+			Enabled          bool   `mapstructure:"enabled"`
+			Dir              string `mapstructure:"dir"`
+			MaxBytes         int64  `mapstructure:"max_bytes"`
+			ReplayIntervalMs int    `mapstructure:"replay_interval_ms"`
+		} `mapstructure:"spill"`
+		// Regions groups source countries into named regions, overriding the
+		// default continent of each listed country.
+		Regions     []RegionGroup `mapstructure:"regions"`
+		LiveLatency struct {
+			Enabled  bool `mapstructure:"enabled"`
+			WindowMs int  `mapstructure:"window_ms"`
+		} `mapstructure:"live_latency"`
+		// Anomaly flags source IPs whose connections or bytes per WindowMs
+		// exceed an absolute threshold or BaselineMultiple times their average
+		// over the previous BaselineWindows windows. A zero threshold is off.
+		Anomaly struct {
+			Enabled              bool    `mapstructure:"enabled"`
+			WindowMs             int     `mapstructure:"window_ms"`
+			BaselineWindows      int     `mapstructure:"baseline_windows"`
+			MaxIPs               int     `mapstructure:"max_ips"`
+			ConnectionsThreshold int64   `mapstructure:"connections_threshold"`
+			BytesThreshold       int64   `mapstructure:"bytes_threshold"`
+			BaselineMultiple     float64 `mapstructure:"baseline_multiple"`
+			// MinConnections and MinBytes keep low-volume windows from
+			// tripping the baseline multiple.
+			MinConnections int64 `mapstructure:"min_connections"`
+			MinBytes       int64 `mapstructure:"min_bytes"`
+		} `mapstructure:"anomaly"`
+		// Tail streams normalized logs to at most MaxClients clients of
+		// /logs/stream, each buffering up to BufferSize logs before it is
+		// dropped as too slow.
+		Tail struct {
+			Enabled    bool `mapstructure:"enabled"`
+			MaxClients int  `mapstructure:"max_clients"`
+			BufferSize int  `mapstructure:"buffer_size"`
+		} `mapstructure:"tail"`
+		Enrichment struct {
+			// ReverseDNS fills the domain of connections made by IP from a PTR lookup.
+			ReverseDNS          bool `mapstructure:"reverse_dns"`
+			ReverseDNSTimeoutMs int  `mapstructure:"reverse_dns_timeout_ms"`
+			ReverseDNSWorkers   int  `mapstructure:"reverse_dns_workers"`
+			ReverseDNSCacheSize int  `mapstructure:"reverse_dns_cache_size"`
+			ReverseDNSRetryMs   int  `mapstructure:"reverse_dns_retry_ms"` // how long a failure is cached
+			// GeoIPCountryDB and GeoIPASNDB are paths to MaxMind GeoLite2
+			// Country (or City) and ASN databases; empty skips the lookup.
+			GeoIPCountryDB string `mapstructure:"geoip_country_db"`
+			GeoIPASNDB     string `mapstructure:"geoip_asn_db"`
+		} `mapstructure:"enrichment"`
 	} `mapstructure:"pipeline"`
 
+	Health struct {
+		Address                string  `mapstructure:"address"`
+		Port                   int     `mapstructure:"port"`
+		QueueWarnThreshold     float64 `mapstructure:"queue_warn_threshold"`
+		QueueCriticalThreshold float64 `mapstructure:"queue_critical_threshold"`
+		CriticalSustainMs      int     `mapstructure:"critical_sustain_ms"`
+		SampleIntervalMs       int     `mapstructure:"sample_interval_ms"`
+	} `mapstructure:"health"`
+
+	Metrics struct {
+		// Enabled serves Prometheus metrics from the proxy at Address:Port/metrics.
+		Enabled bool   `mapstructure:"enabled"`
+		Address string `mapstructure:"address"`
+		Port    int    `mapstructure:"port"`
+		// Exemplars attaches trace IDs to latency histogram observations.
+		Exemplars bool `mapstructure:"exemplars"`
+	} `mapstructure:"metrics"`
+
+	Tracing struct {
+		// Endpoint is the OTLP/HTTP collector URL spans are exported to, e.g.
+		// "http://localhost:4318". Empty disables tracing.
+		Endpoint    string `mapstructure:"endpoint"`
+		ServiceName string `mapstructure:"service_name"`
+		// SampleRatio is the fraction of new traces recorded, from 0 to 1.
+		SampleRatio float64 `mapstructure:"sample_ratio"`
+	} `mapstructure:"tracing"`
+
+	Debug struct {
+		// PprofEnabled serves net/http/pprof profiles under /debug/pprof/ on
+		// the metrics server. Only enable it on a trusted network.
+		PprofEnabled bool `mapstructure:"pprof_enabled"`
+	} `mapstructure:"debug"`
+
 	Logging struct {
 		Level  string `mapstructure:"level"`
 		Format string `mapstructure:"format"`
+		File   string `mapstructure:"file"`
 	} `mapstructure:"logging"`
 
 	RateLimit struct {
 		Enabled           bool `mapstructure:"enabled"`
 		RequestsPerSecond int  `mapstructure:"requests_per_second"`
+		// Burst is the token bucket capacity; 0 uses RequestsPerSecond.
+		Burst int `mapstructure:"burst"`
+		// Buckets idle for BucketTTLMs are evicted every SweepIntervalMs.
+		BucketTTLMs     int `mapstructure:"bucket_ttl_ms"`
+		SweepIntervalMs int `mapstructure:"sweep_interval_ms"`
 	} `mapstructure:"rate_limit"`
+
+	Retention struct {
+		// MaxAge is how long traffic logs are kept, e.g. "720h"; older logs
+		// are deleted every IntervalMs. 0 keeps logs forever.
+		MaxAge     time.Duration `mapstructure:"max_age"`
+		IntervalMs int           `mapstructure:"interval_ms"`
+		// BatchSize bounds the rows deleted per statement, keeping each
+		// transaction short.
+		BatchSize int `mapstructure:"batch_size"`
+	} `mapstructure:"retention"`
+
+	provenance map[string]string
+}
+
+// Value sources reported by Provenance.
+const (
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Credential is a SOCKS5 username and password.
+type Credential struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+// UserThrottle is the per-connection bandwidth limit of one user, in bytes
+// per second in each direction; 0 exempts the user from proxy.throttle.
+type UserThrottle struct {
+	Username       string `mapstructure:"username"`
+	BytesPerSecond int64  `mapstructure:"bytes_per_second"`
+}
+
+// APIKey is an API key and the scopes it grants.
+type APIKey struct {
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// RegionGroup names a set of ISO 3166-1 alpha-2 country codes reported as one region.
+type RegionGroup struct {
+	Name      string   `mapstructure:"name"`
+	Countries []string `mapstructure:"countries"`
 }
 
 // Load loads application configuration from:
 // 1. .env file (if present)
-// 2. config.yml file
+// 2. the file named by CONFIG_FILE, or configs/config.yml if present
 // 3. environment variables (highest priority)
 //
-// It validates that required database settings are provided.
+// It fails with every problem Validate finds in the result.
 func Load() (*Config, error) {
 	// Load .env file if it exists (no error if missing).
 	_ = godotenv.Load()
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yml")
-	viper.AddConfigPath("./configs")
+	// An explicit file must exist; its format follows the extension
+	// (.yml, .yaml, .json or .toml).
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		viper.SetConfigFile(file)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yml")
+		viper.AddConfigPath("./configs")
+	}
 
 	setDefaults()
 
@@ -89,52 +432,222 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	cfg.provenance = provenance()
 
-	// Validate required database configuration.
-	if cfg.Database.Host == "" {
-		return nil, fmt.Errorf("critical: DB_HOST environment variable not set")
-	}
-	if cfg.Database.User == "" {
-		return nil, fmt.Errorf("critical: DB_USER environment variable not set")
+	if err := readSecretFile(&cfg.Database.Password, cfg.Database.PasswordFile); err != nil {
+		return nil, fmt.Errorf("error reading database.password_file: %w", err)
 	}
-	if cfg.Database.Password == "" {
-		return nil, fmt.Errorf("critical: DB_PASSWORD environment variable not set")
+	if err := readSecretFile(&cfg.Proxy.Auth.Password, cfg.Proxy.Auth.PasswordFile); err != nil {
+		return nil, fmt.Errorf("error reading proxy.auth.password_file: %w", err)
 	}
-	if cfg.Database.Database == "" {
-		return nil, fmt.Errorf("critical: DB_NAME environment variable not set")
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
 
 	return &cfg, nil
 }
 
-// bindEnvs binds all supported environment variables to viper keys.
-func bindEnvs() error {
-	bindings := map[string]string{
-		"proxy.address":                  "PROXY_ADDRESS",
-		"proxy.port":                     "PROXY_PORT",
-		"proxy.auth.enabled":             "PROXY_AUTH_ENABLED",
-		"proxy.auth.username":            "PROXY_AUTH_USERNAME",
-		"proxy.auth.password":            "PROXY_AUTH_PASSWORD",
-		"proxy.max_connections":          "PROXY_MAX_CONNECTIONS",
-		"api.address":                    "API_ADDRESS",
-		"api.port":                       "API_PORT",
-		"database.host":                  "DB_HOST",
-		"database.port":                  "DB_PORT",
-		"database.user":                  "DB_USER",
-		"database.password":              "DB_PASSWORD",
-		"database.database":              "DB_NAME",
-		"database.sslmode":               "DB_SSLMODE",
-		"pipeline.workers":               "PIPELINE_WORKERS",
-		"pipeline.buffer_size":           "PIPELINE_BUFFER_SIZE",
-		"pipeline.batch_size":            "PIPELINE_BATCH_SIZE",
-		"pipeline.flush_interval_ms":     "PIPELINE_FLUSH_INTERVAL_MS",
-		"logging.level":                  "LOG_LEVEL",
-		"logging.format":                 "LOG_FORMAT",
-		"rate_limit.enabled":             "RATE_LIMIT_ENABLED",
-		"rate_limit.requests_per_second": "RATE_LIMIT_RPS",
+// readSecretFile sets *secret to the contents of file, without the trailing
+// newline, unless the secret is already set or no file is given.
+func readSecretFile(secret *string, file string) error {
+	if *secret != "" || file == "" {
+		return nil
 	}
 
-	for key, env := range bindings {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	*secret = strings.TrimRight(string(data), "\r\n")
+
+	return nil
+}
+
+// Provenance reports where each effective configuration value came from:
+// SourceEnv, SourceFile or SourceDefault, keyed by dotted config key. Values
+// are deliberately not included so the result is safe to expose.
+func (c *Config) Provenance() map[string]string {
+	out := make(map[string]string, len(c.provenance))
+	for key, source := range c.provenance {
+		out[key] = source
+	}
+
+	return out
+}
+
+// provenance classifies every known key by the highest-priority source that set it.
+func provenance() map[string]string {
+	sources := make(map[string]string)
+	for _, key := range viper.AllKeys() {
+		switch {
+		case envSet(envBindings[key]):
+			sources[key] = SourceEnv
+		case viper.InConfig(key):
+			sources[key] = SourceFile
+		default:
+			sources[key] = SourceDefault
+		}
+	}
+
+	return sources
+}
+
+func envSet(env string) bool {
+	if env == "" {
+		return false
+	}
+	_, ok := os.LookupEnv(env)
+
+	return ok
+}
+
+// envBindings maps viper keys to the environment variables that override them.
+var envBindings = map[string]string{
+	"proxy.address":                              "PROXY_ADDRESS",
+	"proxy.port":                                 "PROXY_PORT",
+	"proxy.auth.enabled":                         "PROXY_AUTH_ENABLED",
+	"proxy.auth.username":                        "PROXY_AUTH_USERNAME",
+	"proxy.auth.password":                        "PROXY_AUTH_PASSWORD",
+	"proxy.auth.password_file":                   "PROXY_AUTH_PASSWORD_FILE",
+	"proxy.max_connections":                      "PROXY_MAX_CONNECTIONS",
+	"proxy.whitelist_sync_interval_ms":           "PROXY_WHITELIST_SYNC_INTERVAL_MS",
+	"proxy.relay_buffer_bytes":                   "PROXY_RELAY_BUFFER_BYTES",
+	"proxy.compression.enabled":                  "PROXY_COMPRESSION_ENABLED",
+	"proxy.decision_cache.ttl_ms":                "PROXY_DECISION_CACHE_TTL_MS",
+	"proxy.decision_cache.max_entries":           "PROXY_DECISION_CACHE_MAX_ENTRIES",
+	"proxy.dial_timeout_ms":                      "PROXY_DIAL_TIMEOUT_MS",
+	"proxy.idle_timeout_ms":                      "PROXY_IDLE_TIMEOUT_MS",
+	"proxy.dial_keepalive_ms":                    "PROXY_DIAL_KEEPALIVE_MS",
+	"proxy.max_dials_per_destination":            "PROXY_MAX_DIALS_PER_DESTINATION",
+	"proxy.block_private_destinations":           "PROXY_BLOCK_PRIVATE_DESTINATIONS",
+	"proxy.egress.rules_file":                    "PROXY_EGRESS_RULES_FILE",
+	"proxy.log_failures":                         "PROXY_LOG_FAILURES",
+	"proxy.log_accepts":                          "PROXY_LOG_ACCEPTS",
+	"proxy.ready_warmup_ms":                      "PROXY_READY_WARMUP_MS",
+	"proxy.shutdown_timeout_ms":                  "PROXY_SHUTDOWN_TIMEOUT_MS",
+	"proxy.compression.level":                    "PROXY_COMPRESSION_LEVEL",
+	"proxy.interim_interval_ms":                  "PROXY_INTERIM_INTERVAL_MS",
+	"proxy.udp.enabled":                          "PROXY_UDP_ENABLED",
+	"proxy.udp.idle_timeout_ms":                  "PROXY_UDP_IDLE_TIMEOUT_MS",
+	"proxy.quota.enabled":                        "PROXY_QUOTA_ENABLED",
+	"proxy.quota.window_ms":                      "PROXY_QUOTA_WINDOW_MS",
+	"proxy.quota.ip_bytes":                       "PROXY_QUOTA_IP_BYTES",
+	"proxy.quota.user_bytes":                     "PROXY_QUOTA_USER_BYTES",
+	"proxy.quota.refresh_interval_ms":            "PROXY_QUOTA_REFRESH_INTERVAL_MS",
+	"proxy.throttle.bytes_per_second":            "PROXY_THROTTLE_BYTES_PER_SECOND",
+	"proxy.sni.enabled":                          "PROXY_SNI_ENABLED",
+	"proxy.accept_log.enabled":                   "PROXY_ACCEPT_LOG_ENABLED",
+	"proxy.accept_log.accepted_sample_rate":      "PROXY_ACCEPT_LOG_ACCEPTED_SAMPLE_RATE",
+	"proxy.accept_log.hash_source_ip":            "PROXY_ACCEPT_LOG_HASH_SOURCE_IP",
+	"proxy.accept_log.hash_salt":                 "PROXY_ACCEPT_LOG_HASH_SALT",
+	"proxy.tls.enabled":                          "PROXY_TLS_ENABLED",
+	"proxy.tls.cert_file":                        "PROXY_TLS_CERT_FILE",
+	"proxy.tls.key_file":                         "PROXY_TLS_KEY_FILE",
+	"proxy.tls.min_version":                      "PROXY_TLS_MIN_VERSION",
+	"proxy.tls.cipher_suites":                    "PROXY_TLS_CIPHER_SUITES",
+	"api.address":                                "API_ADDRESS",
+	"api.port":                                   "API_PORT",
+	"api.int64_as_string":                        "API_INT64_AS_STRING",
+	"api.max_page_size":                          "API_MAX_PAGE_SIZE",
+	"api.max_streams":                            "API_MAX_STREAMS",
+	"api.shutdown_timeout_ms":                    "API_SHUTDOWN_TIMEOUT_MS",
+	"api.health_check_timeout_ms":                "API_HEALTH_CHECK_TIMEOUT_MS",
+	"api.trusted_proxies":                        "API_TRUSTED_PROXIES",
+	"api.admin_token":                            "API_ADMIN_TOKEN",
+	"api.auth.keys":                              "API_AUTH_KEYS",
+	"api.tls.enabled":                            "API_TLS_ENABLED",
+	"api.tls.cert_file":                          "API_TLS_CERT_FILE",
+	"api.tls.key_file":                           "API_TLS_KEY_FILE",
+	"database.driver":                            "DB_DRIVER",
+	"database.host":                              "DB_HOST",
+	"database.port":                              "DB_PORT",
+	"database.user":                              "DB_USER",
+	"database.password":                          "DB_PASSWORD",
+	"database.password_file":                     "DB_PASSWORD_FILE",
+	"database.database":                          "DB_NAME",
+	"database.sslmode":                           "DB_SSLMODE",
+	"database.primary_key":                       "DB_PRIMARY_KEY",
+	"database.memory_max_logs":                   "DB_MEMORY_MAX_LOGS",
+	"database.insert_batch_size":                 "DB_INSERT_BATCH_SIZE",
+	"database.on_conflict":                       "DB_ON_CONFLICT",
+	"database.dedupe_column":                     "DB_DEDUPE_COLUMN",
+	"database.max_open_conns":                    "DB_MAX_OPEN_CONNS",
+	"database.max_idle_conns":                    "DB_MAX_IDLE_CONNS",
+	"database.conn_max_lifetime_ms":              "DB_CONN_MAX_LIFETIME_MS",
+	"database.reconnect_after_failures":          "DB_RECONNECT_AFTER_FAILURES",
+	"database.reconnect_interval_ms":             "DB_RECONNECT_INTERVAL_MS",
+	"pipeline.workers":                           "PIPELINE_WORKERS",
+	"pipeline.buffer_size":                       "PIPELINE_BUFFER_SIZE",
+	"pipeline.batch_size":                        "PIPELINE_BATCH_SIZE",
+	"pipeline.flush_interval_ms":                 "PIPELINE_FLUSH_INTERVAL_MS",
+	"pipeline.max_batch_bytes":                   "PIPELINE_MAX_BATCH_BYTES",
+	"pipeline.coalesce.min_batch_size":           "PIPELINE_COALESCE_MIN_BATCH_SIZE",
+	"pipeline.coalesce.max_latency_ms":           "PIPELINE_COALESCE_MAX_LATENCY_MS",
+	"pipeline.analytics_enabled":                 "PIPELINE_ANALYTICS_ENABLED",
+	"pipeline.normalizer_overflow":               "PIPELINE_NORMALIZER_OVERFLOW",
+	"pipeline.overflow_policy":                   "PIPELINE_OVERFLOW_POLICY",
+	"pipeline.overflow_sample_rate":              "PIPELINE_OVERFLOW_SAMPLE_RATE",
+	"pipeline.retry.max_attempts":                "PIPELINE_RETRY_MAX_ATTEMPTS",
+	"pipeline.retry.initial_backoff_ms":          "PIPELINE_RETRY_INITIAL_BACKOFF_MS",
+	"pipeline.retry.max_backoff_ms":              "PIPELINE_RETRY_MAX_BACKOFF_MS",
+	"pipeline.retry.max_elapsed_ms":              "PIPELINE_RETRY_MAX_ELAPSED_MS",
+	"pipeline.retry.max_held_batches":            "PIPELINE_RETRY_MAX_HELD_BATCHES",
+	"pipeline.spill.enabled":                     "PIPELINE_SPILL_ENABLED",
+	"pipeline.spill.dir":                         "PIPELINE_SPILL_DIR",
+	"pipeline.spill.max_bytes":                   "PIPELINE_SPILL_MAX_BYTES",
+	"pipeline.spill.replay_interval_ms":          "PIPELINE_SPILL_REPLAY_INTERVAL_MS",
+	"pipeline.live_latency.enabled":              "PIPELINE_LIVE_LATENCY_ENABLED",
+	"pipeline.live_latency.window_ms":            "PIPELINE_LIVE_LATENCY_WINDOW_MS",
+	"pipeline.anomaly.enabled":                   "PIPELINE_ANOMALY_ENABLED",
+	"pipeline.anomaly.window_ms":                 "PIPELINE_ANOMALY_WINDOW_MS",
+	"pipeline.anomaly.baseline_windows":          "PIPELINE_ANOMALY_BASELINE_WINDOWS",
+	"pipeline.anomaly.max_ips":                   "PIPELINE_ANOMALY_MAX_IPS",
+	"pipeline.anomaly.connections_threshold":     "PIPELINE_ANOMALY_CONNECTIONS_THRESHOLD",
+	"pipeline.anomaly.bytes_threshold":           "PIPELINE_ANOMALY_BYTES_THRESHOLD",
+	"pipeline.anomaly.baseline_multiple":         "PIPELINE_ANOMALY_BASELINE_MULTIPLE",
+	"pipeline.anomaly.min_connections":           "PIPELINE_ANOMALY_MIN_CONNECTIONS",
+	"pipeline.anomaly.min_bytes":                 "PIPELINE_ANOMALY_MIN_BYTES",
+	"pipeline.tail.enabled":                      "PIPELINE_TAIL_ENABLED",
+	"pipeline.tail.max_clients":                  "PIPELINE_TAIL_MAX_CLIENTS",
+	"pipeline.tail.buffer_size":                  "PIPELINE_TAIL_BUFFER_SIZE",
+	"pipeline.enrichment.reverse_dns":            "PIPELINE_ENRICHMENT_REVERSE_DNS",
+	"pipeline.enrichment.reverse_dns_timeout_ms": "PIPELINE_ENRICHMENT_REVERSE_DNS_TIMEOUT_MS",
+	"pipeline.enrichment.reverse_dns_workers":    "PIPELINE_ENRICHMENT_REVERSE_DNS_WORKERS",
+	"pipeline.enrichment.reverse_dns_cache_size": "PIPELINE_ENRICHMENT_REVERSE_DNS_CACHE_SIZE",
+	"pipeline.enrichment.reverse_dns_retry_ms":   "PIPELINE_ENRICHMENT_REVERSE_DNS_RETRY_MS",
+	"pipeline.enrichment.geoip_country_db":       "PIPELINE_ENRICHMENT_GEOIP_COUNTRY_DB",
+	"pipeline.enrichment.geoip_asn_db":           "PIPELINE_ENRICHMENT_GEOIP_ASN_DB",
+	"health.address":                             "HEALTH_ADDRESS",
+	"health.port":                                "HEALTH_PORT",
+	"health.queue_warn_threshold":                "HEALTH_QUEUE_WARN_THRESHOLD",
+	"health.queue_critical_threshold":            "HEALTH_QUEUE_CRITICAL_THRESHOLD",
+	"health.critical_sustain_ms":                 "HEALTH_CRITICAL_SUSTAIN_MS",
+	"health.sample_interval_ms":                  "HEALTH_SAMPLE_INTERVAL_MS",
+	"metrics.enabled":                            "METRICS_ENABLED",
+	"metrics.address":                            "METRICS_ADDRESS",
+	"metrics.port":                               "METRICS_PORT",
+	"metrics.exemplars":                          "METRICS_EXEMPLARS",
+	"tracing.endpoint":                           "TRACING_ENDPOINT",
+	"tracing.service_name":                       "TRACING_SERVICE_NAME",
+	"tracing.sample_ratio":                       "TRACING_SAMPLE_RATIO",
+	"debug.pprof_enabled":                        "DEBUG_PPROF_ENABLED",
+	"logging.level":                              "LOG_LEVEL",
+	"logging.format":                             "LOG_FORMAT",
+	"logging.file":                               "LOG_FILE",
+	"rate_limit.enabled":                         "RATE_LIMIT_ENABLED",
+	"rate_limit.requests_per_second":             "RATE_LIMIT_RPS",
+	"rate_limit.burst":                           "RATE_LIMIT_BURST",
+	"rate_limit.bucket_ttl_ms":                   "RATE_LIMIT_BUCKET_TTL_MS",
+	"rate_limit.sweep_interval_ms":               "RATE_LIMIT_SWEEP_INTERVAL_MS",
+	"retention.max_age":                          "RETENTION_MAX_AGE",
+	"retention.interval_ms":                      "RETENTION_INTERVAL_MS",
+	"retention.batch_size":                       "RETENTION_BATCH_SIZE",
+}
+
+// bindEnvs binds all supported environment variables to viper keys.
+func bindEnvs() error {
+	for key, env := range envBindings {
 		if err := viper.BindEnv(key, env); err != nil {
 			return fmt.Errorf("failed to bind env %s: %w", env, err)
 		}
@@ -147,28 +660,149 @@ func bindEnvs() error {
 func setDefaults() {
 	viper.SetDefault("proxy.address", "0.0.0.0")
 	viper.SetDefault("proxy.port", 1080)
+	viper.SetDefault("proxy.listeners", []string{})
 	viper.SetDefault("proxy.max_connections", 10000)
 	viper.SetDefault("proxy.auth.enabled", false)
+	viper.SetDefault("proxy.auth.password_file", "")
+	viper.SetDefault("proxy.whitelist_sync_interval_ms", 10000)
+	viper.SetDefault("proxy.relay_buffer_bytes", 32*1024)
+	viper.SetDefault("proxy.dial_timeout_ms", 30000)
+	viper.SetDefault("proxy.idle_timeout_ms", 600000)
+	viper.SetDefault("proxy.dial_keepalive_ms", 30000)
+	viper.SetDefault("proxy.max_dials_per_destination", 0)
+	viper.SetDefault("proxy.block_private_destinations", false)
+	viper.SetDefault("proxy.private_destination_exceptions", []string{})
+	viper.SetDefault("proxy.egress.allow", []string{})
+	viper.SetDefault("proxy.egress.deny", []string{})
+	viper.SetDefault("proxy.egress.rules_file", "")
+	viper.SetDefault("proxy.log_failures", true)
+	viper.SetDefault("proxy.log_accepts", false)
+	viper.SetDefault("proxy.ready_warmup_ms", 10000)
+	viper.SetDefault("proxy.shutdown_timeout_ms", 30000)
+	viper.SetDefault("proxy.compression.enabled", false)
+	viper.SetDefault("proxy.decision_cache.ttl_ms", 5000)
+	viper.SetDefault("proxy.decision_cache.max_entries", 10000)
+	viper.SetDefault("proxy.compression.level", 6)
+	viper.SetDefault("proxy.interim_interval_ms", 0)
+	viper.SetDefault("proxy.udp.enabled", false)
+	viper.SetDefault("proxy.udp.idle_timeout_ms", 60000)
+	viper.SetDefault("proxy.quota.enabled", false)
+	viper.SetDefault("proxy.quota.window_ms", 86400000)
+	viper.SetDefault("proxy.quota.ip_bytes", 0)
+	viper.SetDefault("proxy.quota.user_bytes", 0)
+	viper.SetDefault("proxy.quota.refresh_interval_ms", 60000)
+	viper.SetDefault("proxy.throttle.bytes_per_second", 0)
+	viper.SetDefault("proxy.sni.enabled", false)
+	viper.SetDefault("proxy.accept_log.enabled", true)
+	viper.SetDefault("proxy.accept_log.accepted_sample_rate", 0.0)
+	viper.SetDefault("proxy.accept_log.hash_source_ip", false)
+	viper.SetDefault("proxy.accept_log.hash_salt", "")
+	viper.SetDefault("proxy.tls.enabled", false)
+	viper.SetDefault("proxy.tls.min_version", "1.2")
+	viper.SetDefault("proxy.tls.cipher_suites", []string{})
 
 	viper.SetDefault("api.address", "0.0.0.0")
 	viper.SetDefault("api.port", 8080)
+	viper.SetDefault("api.int64_as_string", false)
+	viper.SetDefault("api.max_page_size", 1000)
+	viper.SetDefault("api.max_streams", 100)
+	viper.SetDefault("api.shutdown_timeout_ms", 30000)
+	viper.SetDefault("api.health_check_timeout_ms", 2000)
+	viper.SetDefault("api.trusted_proxies", []string{})
+	viper.SetDefault("api.admin_token", "")
+	viper.SetDefault("api.auth.keys", []string{})
+	viper.SetDefault("api.tls.enabled", false)
 
 	// Database defaults (no credentials).
+	viper.SetDefault("database.driver", "postgres")
 	viper.SetDefault("database.host", "")
 	viper.SetDefault("database.port", 5432)
 	viper.SetDefault("database.user", "")
 	viper.SetDefault("database.password", "")
+	viper.SetDefault("database.password_file", "")
 	viper.SetDefault("database.database", "")
 	viper.SetDefault("database.sslmode", "disable")
+	viper.SetDefault("database.primary_key", "autoincrement")
+	viper.SetDefault("database.memory_max_logs", 1000000)
+	viper.SetDefault("database.insert_batch_size", 0)
+	viper.SetDefault("database.on_conflict", "error")
+	viper.SetDefault("database.dedupe_column", "uuid")
+	viper.SetDefault("database.max_open_conns", 25)
+	viper.SetDefault("database.max_idle_conns", 10)
+	viper.SetDefault("database.conn_max_lifetime_ms", 1800000)
+	viper.SetDefault("database.reconnect_after_failures", 3)
+	viper.SetDefault("database.reconnect_interval_ms", 5000)
 
 	viper.SetDefault("pipeline.workers", 4)
 	viper.SetDefault("pipeline.buffer_size", 10000)
 	viper.SetDefault("pipeline.batch_size", 100)
 	viper.SetDefault("pipeline.flush_interval_ms", 5000)
+	viper.SetDefault("pipeline.max_batch_bytes", 0)
+	viper.SetDefault("pipeline.coalesce.min_batch_size", 0)
+	viper.SetDefault("pipeline.coalesce.max_latency_ms", 30000)
+	viper.SetDefault("pipeline.analytics_enabled", true)
+	viper.SetDefault("pipeline.normalizer_overflow", "block")
+	viper.SetDefault("pipeline.overflow_policy", "drop")
+	viper.SetDefault("pipeline.overflow_sample_rate", 10)
+	viper.SetDefault("pipeline.retry.max_attempts", 5)
+	viper.SetDefault("pipeline.retry.initial_backoff_ms", 100)
+	viper.SetDefault("pipeline.retry.max_backoff_ms", 5000)
+	viper.SetDefault("pipeline.retry.max_elapsed_ms", 30000)
+	viper.SetDefault("pipeline.retry.max_held_batches", 10)
+	viper.SetDefault("pipeline.spill.enabled", false)
+	viper.SetDefault("pipeline.spill.dir", "spill")
+	viper.SetDefault("pipeline.spill.max_bytes", 1<<30)
+	viper.SetDefault("pipeline.spill.replay_interval_ms", 10000)
+	viper.SetDefault("pipeline.live_latency.enabled", false)
+	viper.SetDefault("pipeline.live_latency.window_ms", 60000)
+	viper.SetDefault("pipeline.anomaly.enabled", false)
+	viper.SetDefault("pipeline.anomaly.window_ms", 60000)
+	viper.SetDefault("pipeline.anomaly.baseline_windows", 10)
+	viper.SetDefault("pipeline.anomaly.max_ips", 10000)
+	viper.SetDefault("pipeline.anomaly.connections_threshold", 0)
+	viper.SetDefault("pipeline.anomaly.bytes_threshold", 0)
+	viper.SetDefault("pipeline.anomaly.baseline_multiple", 5.0)
+	viper.SetDefault("pipeline.anomaly.min_connections", 20)
+	viper.SetDefault("pipeline.anomaly.min_bytes", 10<<20)
+	viper.SetDefault("pipeline.tail.enabled", false)
+	viper.SetDefault("pipeline.tail.max_clients", 10)
+	viper.SetDefault("pipeline.tail.buffer_size", 256)
+	viper.SetDefault("pipeline.enrichment.reverse_dns", false)
+	viper.SetDefault("pipeline.enrichment.reverse_dns_timeout_ms", 500)
+	viper.SetDefault("pipeline.enrichment.reverse_dns_workers", 4)
+	viper.SetDefault("pipeline.enrichment.reverse_dns_cache_size", 10000)
+	viper.SetDefault("pipeline.enrichment.reverse_dns_retry_ms", 30000)
+	viper.SetDefault("pipeline.enrichment.geoip_country_db", "")
+	viper.SetDefault("pipeline.enrichment.geoip_asn_db", "")
+
+	viper.SetDefault("health.address", "0.0.0.0")
+	viper.SetDefault("health.port", 8081)
+	viper.SetDefault("health.queue_warn_threshold", 0.75)
+	viper.SetDefault("health.queue_critical_threshold", 0.95)
+	viper.SetDefault("health.critical_sustain_ms", 10000)
+	viper.SetDefault("health.sample_interval_ms", 1000)
+
+	viper.SetDefault("metrics.enabled", true)
+	viper.SetDefault("metrics.address", "0.0.0.0")
+	viper.SetDefault("metrics.port", 9090)
+	viper.SetDefault("metrics.exemplars", false)
+
+	viper.SetDefault("tracing.endpoint", "")
+	viper.SetDefault("tracing.service_name", "socks5-proxy")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	viper.SetDefault("debug.pprof_enabled", false)
 
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "json")
+	viper.SetDefault("logging.file", "")
 
 	viper.SetDefault("rate_limit.enabled", false)
 	viper.SetDefault("rate_limit.requests_per_second", 100)
+	viper.SetDefault("rate_limit.burst", 0)
+	viper.SetDefault("rate_limit.bucket_ttl_ms", 300000)
+	viper.SetDefault("rate_limit.sweep_interval_ms", 60000)
+	viper.SetDefault("retention.max_age", "0")
+	viper.SetDefault("retention.interval_ms", 3600000)
+	viper.SetDefault("retention.batch_size", 10000)
 }
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// setRequiredEnv sets the database variables Load refuses to run without.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_USER", "analytics")
+	t.Setenv("DB_PASSWORD", "secret")
+	t.Setenv("DB_NAME", "analytics")
+}
+
+func TestProvenance(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "configs"), 0o755); err != nil {
+		t.Fatalf("failed to create configs dir: %v", err)
+	}
+	configFile := filepath.Join(dir, "configs", "config.yml")
+	if err := os.WriteFile(configFile, []byte("api:\n  port: 9000\nproxy:\n  port: 1080\n"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Chdir(dir)
+
+	setRequiredEnv(t)
+	// Env wins over the file.
+	t.Setenv("PROXY_PORT", "1081")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Proxy.Port != 1081 || cfg.API.Port != 9000 {
+		t.Fatalf("unexpected effective values proxy.port=%d api.port=%d", cfg.Proxy.Port, cfg.API.Port)
+	}
+
+	provenance := cfg.Provenance()
+	for key, want := range map[string]string{
+		"proxy.port":        SourceEnv,
+		"database.password": SourceEnv,
+		"api.port":          SourceFile,
+		"pipeline.workers":  SourceDefault,
+	} {
+		if got := provenance[key]; got != want {
+			t.Errorf("expected %s to come from %q, got %q", key, want, got)
+		}
+	}
+
+	for key, source := range provenance {
+		if source != SourceEnv && source != SourceFile && source != SourceDefault {
+			t.Errorf("unexpected source %q for %s", source, key)
+		}
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	setRequiredEnv(t)
+
+	files := map[string]string{
+		"proxy.json": `{"proxy": {"port": 1090}, "pipeline": {"workers": 8}}`,
+		"proxy.toml": "[proxy]\nport = 1090\n\n[pipeline]\nworkers = 8\n",
+		"proxy.yaml": "proxy:\n  port: 1090\npipeline:\n  workers: 8\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write config file: %v", err)
+			}
+			t.Setenv("CONFIG_FILE", path)
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("failed to load config: %v", err)
+			}
+			if cfg.Proxy.Port != 1090 || cfg.Pipeline.Workers != 8 {
+				t.Errorf("unexpected proxy.port=%d pipeline.workers=%d", cfg.Proxy.Port, cfg.Pipeline.Workers)
+			}
+		})
+	}
+
+	// Unlike the default location, an explicit file must exist.
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "missing.json"))
+	if _, err := Load(); err == nil {
+		t.Error("expected a missing CONFIG_FILE to fail")
+	}
+}
+
+func TestDialPolicyFromEnv(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+
+	setRequiredEnv(t)
+	t.Setenv("PROXY_DIAL_TIMEOUT_MS", "5000")
+	t.Setenv("PROXY_BLOCK_PRIVATE_DESTINATIONS", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if cfg.Proxy.DialTimeoutMs != 5000 || cfg.Proxy.DialKeepAliveMs != 30000 || !cfg.Proxy.BlockPrivateDestinations {
+		t.Errorf("unexpected dial settings timeout=%d keepalive=%d block_private=%v",
+			cfg.Proxy.DialTimeoutMs, cfg.Proxy.DialKeepAliveMs, cfg.Proxy.BlockPrivateDestinations)
+	}
+}
+
+func TestTLSCipherSuitesFromEnv(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+
+	setRequiredEnv(t)
+	t.Setenv("PROXY_TLS_CIPHER_SUITES",
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	want := []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	if !slices.Equal(cfg.Proxy.TLS.CipherSuites, want) {
+		t.Errorf("expected cipher suites %v, got %v", want, cfg.Proxy.TLS.CipherSuites)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Chdir(t.TempDir())
+
+	setRequiredEnv(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Retention.MaxAge != 0 {
+		t.Errorf("expected logs to be kept forever by default, got %v", cfg.Retention.MaxAge)
+	}
+
+	viper.Reset()
+	t.Setenv("RETENTION_MAX_AGE", "720h")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Retention.MaxAge != 720*time.Hour || cfg.Retention.BatchSize != 10000 {
+		t.Errorf("unexpected retention max_age=%v batch_size=%d", cfg.Retention.MaxAge, cfg.Retention.BatchSize)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := &Config{}
+	old.Proxy.Port = 1080
+	old.API.Port = 8080
+
+	updated := &Config{}
+	updated.Proxy.Port = 1081
+	updated.API.Port = 8081
+	updated.Proxy.TLS.Enabled = true
+	updated.Proxy.IPWhitelist = []string{"10.0.0.0/8"}
+	updated.Proxy.Auth.Users = []Credential{{Username: "alice", Password: "secret"}}
+	updated.RateLimit.RequestsPerSecond = 5
+	updated.Logging.Level = "debug"
+
+	changed := RestartRequired(old, updated, "proxy", "logging", "rate_limit")
+	if !slices.Equal(changed, []string{"proxy.port", "proxy.tls.enabled"}) {
+		t.Errorf("expected only the proxy port and TLS to need a restart, got %v", changed)
+	}
+}
+
+func TestPasswordFiles(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	dbFile := filepath.Join(dir, "db_password")
+	proxyFile := filepath.Join(dir, "proxy_password")
+	if err := os.WriteFile(dbFile, []byte("db-secret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	if err := os.WriteFile(proxyFile, []byte("proxy-secret\r\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	setRequiredEnv(t)
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("DB_PASSWORD_FILE", dbFile)
+	t.Setenv("PROXY_AUTH_PASSWORD_FILE", proxyFile)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Database.Password != "db-secret" || cfg.Proxy.Auth.Password != "proxy-secret" {
+		t.Errorf("expected the passwords from the files without newlines, got %q and %q",
+			cfg.Database.Password, cfg.Proxy.Auth.Password)
+	}
+
+	// An explicit value wins over the file.
+	viper.Reset()
+	t.Setenv("DB_PASSWORD", "explicit")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if cfg.Database.Password != "explicit" {
+		t.Errorf("expected the explicit password, got %q", cfg.Database.Password)
+	}
+
+	// A file that can't be read is an error, as is no password at all.
+	viper.Reset()
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("DB_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if _, err := Load(); err == nil {
+		t.Error("expected a missing password file to fail")
+	}
+	viper.Reset()
+	t.Setenv("DB_PASSWORD_FILE", "")
+	if _, err := Load(); err == nil {
+		t.Error("expected a missing password to fail")
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// reloadable lists the settings, by dotted key or key prefix, that running
+// components pick up when the configuration is reloaded.
+var reloadable = []string{
+	"logging.level",
+	"proxy.ip_whitelist",
+	"proxy.auth.username",
+	"proxy.auth.password",
+	"proxy.auth.password_file",
+	"proxy.auth.users",
+	"proxy.egress",
+	"rate_limit.enabled",
+	"rate_limit.requests_per_second",
+	"rate_limit.burst",
+}
+
+// RestartRequired returns the dotted keys of the settings in the given
+// top-level sections (e.g. "api") that differ between old and updated but
+// only take effect after a restart, such as listen addresses and ports.
+func RestartRequired(old, updated *Config, sections ...string) []string {
+	var changed []string
+	diff("", reflect.ValueOf(*old), reflect.ValueOf(*updated), &changed)
+
+	return slices.DeleteFunc(changed, func(key string) bool {
+		section, _, _ := strings.Cut(key, ".")
+
+		return !slices.Contains(sections, section)
+	})
+}
+
+// diff appends the keys of the leaf settings that differ between a and b,
+// two values of the same struct type, skipping reloadable ones.
+func diff(prefix string, a, b reflect.Value, changed *[]string) {
+	for i := range a.NumField() {
+		field := a.Type().Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if !field.IsExported() || tag == "" {
+			continue
+		}
+
+		key := prefix + tag
+		if isReloadable(key) {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			diff(key+".", a.Field(i), b.Field(i), changed)
+
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			*changed = append(*changed, key)
+		}
+	}
+}
+
+func isReloadable(key string) bool {
+	return slices.ContainsFunc(reloadable, func(setting string) bool {
+		return key == setting || strings.HasPrefix(key, setting+".")
+	})
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// redactedValue replaces secrets in Redacted.
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with the secrets it holds,
+// passwords, API keys, the admin token and the accept log salt, masked. Unset
+// secrets stay empty so it still shows which are configured. New secret
+// settings must be masked here; TestRedactedCoversSecrets fails for those
+// whose key looks like a secret's.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+
+	redact(&redacted.Proxy.Auth.Password)
+	redacted.Proxy.Auth.Users = slices.Clone(c.Proxy.Auth.Users)
+	for i := range redacted.Proxy.Auth.Users {
+		redact(&redacted.Proxy.Auth.Users[i].Password)
+	}
+	redact(&redacted.Proxy.AcceptLog.HashSalt)
+
+	redact(&redacted.API.AdminToken)
+	redacted.API.Auth.Keys = slices.Clone(c.API.Auth.Keys)
+	for i := range redacted.API.Auth.Keys {
+		redact(&redacted.API.Auth.Keys[i])
+	}
+	redacted.API.Auth.ScopedKeys = slices.Clone(c.API.Auth.ScopedKeys)
+	for i := range redacted.API.Auth.ScopedKeys {
+		redact(&redacted.API.Auth.ScopedKeys[i].Key)
+	}
+
+	redact(&redacted.Database.Password)
+
+	return &redacted
+}
+
+func redact(secret *string) {
+	if *secret != "" {
+		*secret = redactedValue
+	}
+}
+
+// Settings returns every setting of the redacted configuration as a
+// "dotted.key: value" line, in the order of the Config struct.
+func (c *Config) Settings() []string {
+	var lines []string
+	settings("", reflect.ValueOf(*c.Redacted()), &lines)
+
+	return lines
+}
+
+func settings(prefix string, v reflect.Value, lines *[]string) {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if !field.IsExported() || tag == "" {
+			continue
+		}
+
+		key := prefix + tag
+		if field.Type.Kind() == reflect.Struct {
+			settings(key+".", v.Field(i), lines)
+
+			continue
+		}
+		*lines = append(*lines, fmt.Sprintf("%s: %v", key, v.Field(i).Interface()))
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	cfg := defaultConfig(t)
+	cfg.Proxy.Auth.Password = "proxy-secret"
+	cfg.Proxy.Auth.Users = []Credential{{Username: "alice", Password: "alice-secret"}}
+	cfg.Proxy.AcceptLog.HashSalt = "salt-secret"
+	cfg.API.AdminToken = "admin-secret"
+	cfg.API.Auth.Keys = []string{"key-secret"}
+	cfg.API.Auth.ScopedKeys = []APIKey{{Key: "scoped-secret", Scopes: []string{"read"}}}
+
+	redacted := cfg.Redacted()
+	settings := strings.Join(redacted.Settings(), "\n")
+	if strings.Contains(settings, "secret") {
+		t.Errorf("expected every secret to be redacted, got\n%s", settings)
+	}
+	if redacted.Proxy.Auth.Users[0].Username != "alice" || redacted.API.Auth.ScopedKeys[0].Scopes[0] != "read" {
+		t.Error("expected everything but the secrets to be kept")
+	}
+
+	// The original is left untouched.
+	if cfg.Database.Password != "secret" || cfg.Proxy.Auth.Users[0].Password != "alice-secret" ||
+		cfg.API.Auth.Keys[0] != "key-secret" || cfg.API.Auth.ScopedKeys[0].Key != "scoped-secret" {
+		t.Error("expected Redacted not to modify the config")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	cfg := defaultConfig(t)
+
+	settings := cfg.Settings()
+	for _, want := range []string{
+		"proxy.port: 1080",
+		"proxy.auth.password: ",
+		"database.password: [REDACTED]",
+		"pipeline.workers: 4",
+		"retention.max_age: 0s",
+	} {
+		if !slices.Contains(settings, want) {
+			t.Errorf("expected setting %q, got %q", want, settings)
+		}
+	}
+}
+
+// secretKey matches the keys of settings holding secrets.
+var secretKey = regexp.MustCompile(`(^|\.|_)(password|token|secret|salt|keys?)$`)
+
+// notSecret lists keys secretKey matches that hold no secret.
+var notSecret = []string{"database.primary_key"}
+
+// fillSecrets sets every setting of v whose key looks like a secret's to
+// value, adding an element to slices of structs so their fields are covered.
+func fillSecrets(prefix string, v reflect.Value, value string) {
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if !field.IsExported() || tag == "" {
+			continue
+		}
+
+		key := prefix + tag
+		f := v.Field(i)
+		secret := secretKey.MatchString(key) && !slices.Contains(notSecret, key)
+		switch {
+		case f.Kind() == reflect.Struct:
+			fillSecrets(key+".", f, value)
+		case f.Kind() == reflect.String && secret:
+			f.SetString(value)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String && secret:
+			f.Set(reflect.Append(f, reflect.ValueOf(value)))
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+			f.Set(reflect.Append(f, reflect.New(f.Type().Elem()).Elem()))
+			fillSecrets(key+".", f.Index(f.Len()-1), value)
+		}
+	}
+}
+
+func TestRedactedCoversSecrets(t *testing.T) {
+	cfg := defaultConfig(t)
+	fillSecrets("", reflect.ValueOf(cfg).Elem(), "s3cret")
+
+	if cfg.Database.Password != "s3cret" || cfg.API.Auth.ScopedKeys[0].Key != "s3cret" {
+		t.Fatal("expected secrets to be filled in")
+	}
+	for _, setting := range cfg.Settings() {
+		if strings.Contains(setting, "s3cret") {
+			t.Errorf("expected %q to be redacted", setting)
+		}
+	}
+}
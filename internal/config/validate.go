@@ -0,0 +1,293 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validator collects the problems found in a configuration.
+type validator struct {
+	errs []error
+}
+
+// check records the problem described by format and args unless ok.
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf(format, args...))
+	}
+}
+
+// port checks that key, a TCP port, is between 1 and 65535.
+func (v *validator) port(key string, port int) {
+	v.check(port > 0 && port <= 65535, "%s must be between 1 and 65535, got %d", key, port)
+}
+
+// nonNegative checks that key, where 0 means off or unlimited, is not negative.
+func (v *validator) nonNegative(key string, value int64) {
+	v.check(value >= 0, "%s must not be negative, got %d", key, value)
+}
+
+// positive checks that key is greater than zero.
+func (v *validator) positive(key string, value int64) {
+	v.check(value > 0, "%s must be greater than 0, got %d", key, value)
+}
+
+// fraction checks that key is between 0 and 1.
+func (v *validator) fraction(key string, value float64) {
+	v.check(value >= 0 && value <= 1, "%s must be between 0 and 1, got %v", key, value)
+}
+
+// oneOf checks that key, when set, is one of allowed. Empty values select
+// the default and are accepted.
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	v.check(value == "" || slices.Contains(allowed, value),
+		"%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+}
+
+// Validate checks that settings are within range and consistent with each
+// other. It returns an error listing every problem found, one per line, or
+// nil if there are none.
+func (c *Config) Validate() error {
+	var v validator
+
+	c.validateProxy(&v)
+	c.validateAPI(&v)
+	c.validateDatabase(&v)
+	c.validatePipeline(&v)
+
+	v.oneOf("logging.level", c.Logging.Level, "debug", "info", "warn", "error")
+
+	v.port("health.port", c.Health.Port)
+	v.check(c.Health.QueueWarnThreshold > 0 && c.Health.QueueWarnThreshold <= c.Health.QueueCriticalThreshold &&
+		c.Health.QueueCriticalThreshold <= 1,
+		"health.queue_warn_threshold and health.queue_critical_threshold must satisfy 0 < warn <= critical <= 1, "+
+			"got %v and %v", c.Health.QueueWarnThreshold, c.Health.QueueCriticalThreshold)
+	v.nonNegative("health.critical_sustain_ms", int64(c.Health.CriticalSustainMs))
+	v.positive("health.sample_interval_ms", int64(c.Health.SampleIntervalMs))
+
+	if c.Metrics.Enabled {
+		v.port("metrics.port", c.Metrics.Port)
+	}
+	v.fraction("tracing.sample_ratio", c.Tracing.SampleRatio)
+
+	if c.RateLimit.Enabled {
+		v.positive("rate_limit.requests_per_second", int64(c.RateLimit.RequestsPerSecond))
+		v.nonNegative("rate_limit.burst", int64(c.RateLimit.Burst))
+	}
+	// The sweeper runs even while rate limiting is off, so a reload can
+	// turn it on.
+	v.positive("rate_limit.bucket_ttl_ms", int64(c.RateLimit.BucketTTLMs))
+	v.positive("rate_limit.sweep_interval_ms", int64(c.RateLimit.SweepIntervalMs))
+
+	v.nonNegative("retention.max_age", int64(c.Retention.MaxAge))
+	if c.Retention.MaxAge > 0 {
+		v.positive("retention.interval_ms", int64(c.Retention.IntervalMs))
+		v.positive("retention.batch_size", int64(c.Retention.BatchSize))
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateProxy(v *validator) {
+	p := &c.Proxy
+
+	if len(p.Listeners) == 0 {
+		v.port("proxy.port", p.Port)
+	}
+	for _, addr := range p.Listeners {
+		_, port, err := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+		v.check(err == nil && n > 0 && n <= 65535, "proxy.listeners entry %q must be host:port", addr)
+	}
+
+	v.nonNegative("proxy.max_connections", int64(p.MaxConnections))
+	v.nonNegative("proxy.whitelist_sync_interval_ms", int64(p.WhitelistSyncIntervalMs))
+	v.nonNegative("proxy.relay_buffer_bytes", int64(p.RelayBufferBytes))
+	v.nonNegative("proxy.dial_timeout_ms", int64(p.DialTimeoutMs))
+	v.nonNegative("proxy.idle_timeout_ms", int64(p.IdleTimeoutMs))
+	v.nonNegative("proxy.max_dials_per_destination", int64(p.MaxDialsPerDestination))
+	v.nonNegative("proxy.ready_warmup_ms", int64(p.ReadyWarmupMs))
+	v.nonNegative("proxy.shutdown_timeout_ms", int64(p.ShutdownTimeoutMs))
+	v.nonNegative("proxy.interim_interval_ms", int64(p.InterimIntervalMs))
+	v.nonNegative("proxy.decision_cache.ttl_ms", int64(p.DecisionCache.TTLMs))
+	v.fraction("proxy.accept_log.accepted_sample_rate", p.AcceptLog.AcceptedSampleRate)
+
+	c.validateProxyAuth(v)
+	c.validateProxyTLS(v)
+	if p.Compression.Enabled {
+		v.check(p.Compression.Level >= -2 && p.Compression.Level <= 9,
+			"proxy.compression.level must be between -2 and 9, got %d", p.Compression.Level)
+	}
+	if p.UDP.Enabled {
+		v.positive("proxy.udp.idle_timeout_ms", int64(p.UDP.IdleTimeoutMs))
+	}
+	if p.Quota.Enabled {
+		v.positive("proxy.quota.window_ms", int64(p.Quota.WindowMs))
+		v.positive("proxy.quota.refresh_interval_ms", int64(p.Quota.RefreshIntervalMs))
+		v.nonNegative("proxy.quota.ip_bytes", p.Quota.IPBytes)
+		v.nonNegative("proxy.quota.user_bytes", p.Quota.UserBytes)
+	}
+
+	v.nonNegative("proxy.throttle.bytes_per_second", p.Throttle.BytesPerSecond)
+	for i, user := range p.Throttle.Users {
+		v.check(user.Username != "", "proxy.throttle.users[%d] must have a username", i)
+		v.nonNegative(fmt.Sprintf("proxy.throttle.users[%d].bytes_per_second", i), user.BytesPerSecond)
+	}
+}
+
+func (c *Config) validateProxyTLS(v *validator) {
+	t := &c.Proxy.TLS
+	if !t.Enabled {
+		return
+	}
+
+	v.check(t.CertFile != "" && t.KeyFile != "",
+		"proxy.tls.cert_file and proxy.tls.key_file are required when proxy.tls.enabled is set")
+	v.check(slices.Contains([]string{"1.0", "1.1", "1.2", "1.3"}, t.MinVersion),
+		"proxy.tls.min_version must be one of 1.0, 1.1, 1.2, 1.3, got %q", t.MinVersion)
+
+	// Only the suites Go considers secure are accepted.
+	var suites []string
+	for _, suite := range tls.CipherSuites() {
+		suites = append(suites, suite.Name)
+	}
+	for i, name := range t.CipherSuites {
+		v.check(slices.Contains(suites, strings.TrimSpace(name)),
+			"proxy.tls.cipher_suites[%d] %q is not a known secure cipher suite, such as %s", i, name, suites[0])
+	}
+}
+
+func (c *Config) validateProxyAuth(v *validator) {
+	auth := &c.Proxy.Auth
+	if !auth.Enabled {
+		return
+	}
+
+	v.check(auth.Username != "" || len(auth.Users) > 0,
+		"proxy.auth.enabled is set but no credentials are configured; set proxy.auth.username and "+
+			"proxy.auth.password or proxy.auth.users")
+	v.check((auth.Username == "") == (auth.Password == ""),
+		"proxy.auth.username and proxy.auth.password (or proxy.auth.password_file) must be set together")
+	for i, user := range auth.Users {
+		v.check(user.Username != "" && user.Password != "",
+			"proxy.auth.users[%d] must have a username and a password", i)
+	}
+}
+
+func (c *Config) validateAPI(v *validator) {
+	v.port("api.port", c.API.Port)
+	v.nonNegative("api.max_page_size", int64(c.API.MaxPageSize))
+	v.nonNegative("api.max_streams", int64(c.API.MaxStreams))
+	v.nonNegative("api.shutdown_timeout_ms", int64(c.API.ShutdownTimeoutMs))
+	v.positive("api.health_check_timeout_ms", int64(c.API.HealthCheckTimeoutMs))
+	for i, proxy := range c.API.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		v.check(err == nil || net.ParseIP(proxy) != nil,
+			"api.trusted_proxies[%d] must be an IP or CIDR, got %q", i, proxy)
+	}
+	if c.API.TLS.Enabled {
+		v.check(c.API.TLS.CertFile != "" && c.API.TLS.KeyFile != "",
+			"api.tls.cert_file and api.tls.key_file are required when api.tls.enabled is set")
+	}
+	for i, key := range c.API.Auth.ScopedKeys {
+		v.check(key.Key != "", "api.auth.scoped_keys[%d].key must not be empty", i)
+		v.check(len(key.Scopes) > 0, "api.auth.scoped_keys[%d].scopes must not be empty", i)
+		for _, scope := range key.Scopes {
+			v.check(scope == "read" || scope == "admin",
+				"api.auth.scoped_keys[%d].scopes must contain only read or admin, got %q", i, scope)
+		}
+	}
+}
+
+func (c *Config) validateDatabase(v *validator) {
+	db := &c.Database
+
+	v.nonNegative("database.insert_batch_size", int64(db.InsertBatchSize))
+	v.nonNegative("database.max_open_conns", int64(db.MaxOpenConns))
+	v.nonNegative("database.max_idle_conns", int64(db.MaxIdleConns))
+	v.nonNegative("database.conn_max_lifetime_ms", int64(db.ConnMaxLifetimeMs))
+	v.nonNegative("database.reconnect_after_failures", int64(db.ReconnectAfterFailures))
+	if db.ReconnectAfterFailures > 0 {
+		v.positive("database.reconnect_interval_ms", int64(db.ReconnectIntervalMs))
+	}
+	if db.MaxOpenConns > 0 {
+		v.check(db.MaxIdleConns <= db.MaxOpenConns,
+			"database.max_idle_conns (%d) must not exceed database.max_open_conns (%d)", db.MaxIdleConns, db.MaxOpenConns)
+	}
+
+	v.oneOf("database.driver", db.Driver, "postgres", "clickhouse", "memory")
+	v.oneOf("database.primary_key", db.PrimaryKey, "autoincrement", "uuid")
+	v.oneOf("database.on_conflict", db.OnConflict, "error", "ignore", "update")
+	v.check(db.Driver == "" || db.Driver == "postgres" || db.OnConflict == "" || db.OnConflict == "error",
+		"database.on_conflict %q is only supported by the postgres driver", db.OnConflict)
+
+	// The memory driver needs no server.
+	if db.Driver == "memory" {
+		v.nonNegative("database.memory_max_logs", int64(db.MemoryMaxLogs))
+
+		return
+	}
+	v.check(db.Host != "", "database.host is required (set DB_HOST)")
+	v.port("database.port", db.Port)
+	v.check(db.User != "", "database.user is required (set DB_USER)")
+	v.check(db.Password != "", "database.password is required (set DB_PASSWORD or DB_PASSWORD_FILE)")
+	v.check(db.Database != "", "database.database is required (set DB_NAME)")
+}
+
+func (c *Config) validatePipeline(v *validator) {
+	p := &c.Pipeline
+
+	v.positive("pipeline.workers", int64(p.Workers))
+	v.positive("pipeline.buffer_size", int64(p.BufferSize))
+	v.positive("pipeline.batch_size", int64(p.BatchSize))
+	if p.BatchSize > 0 && p.BufferSize > 0 {
+		v.check(p.BatchSize <= p.BufferSize,
+			"pipeline.batch_size (%d) must not exceed pipeline.buffer_size (%d)", p.BatchSize, p.BufferSize)
+	}
+	v.positive("pipeline.flush_interval_ms", int64(p.FlushInterval))
+	v.nonNegative("pipeline.max_batch_bytes", p.MaxBatchBytes)
+	v.nonNegative("pipeline.coalesce.min_batch_size", int64(p.Coalesce.MinBatchSize))
+	if p.Coalesce.MinBatchSize > 0 {
+		v.positive("pipeline.coalesce.max_latency_ms", int64(p.Coalesce.MaxLatencyMs))
+	}
+	v.oneOf("pipeline.overflow_policy", p.OverflowPolicy, "drop", "block", "sample")
+	v.oneOf("pipeline.normalizer_overflow", p.NormalizerOverflow, "block", "drop-newest")
+	if p.OverflowPolicy == "sample" {
+		v.positive("pipeline.overflow_sample_rate", int64(p.OverflowSampleRate))
+	}
+
+	v.nonNegative("pipeline.retry.max_attempts", int64(p.Retry.MaxAttempts))
+	v.nonNegative("pipeline.retry.initial_backoff_ms", int64(p.Retry.InitialBackoffMs))
+	v.check(p.Retry.InitialBackoffMs <= p.Retry.MaxBackoffMs,
+		"pipeline.retry.initial_backoff_ms (%d) must not exceed pipeline.retry.max_backoff_ms (%d)",
+		p.Retry.InitialBackoffMs, p.Retry.MaxBackoffMs)
+	v.nonNegative("pipeline.retry.max_held_batches", int64(p.Retry.MaxHeldBatches))
+
+	if p.Spill.Enabled {
+		v.check(p.Spill.Dir != "", "pipeline.spill.dir is required when pipeline.spill.enabled is set")
+		v.positive("pipeline.spill.max_bytes", p.Spill.MaxBytes)
+		v.positive("pipeline.spill.replay_interval_ms", int64(p.Spill.ReplayIntervalMs))
+	}
+	if p.LiveLatency.Enabled {
+		v.positive("pipeline.live_latency.window_ms", int64(p.LiveLatency.WindowMs))
+	}
+	if p.Anomaly.Enabled {
+		v.positive("pipeline.anomaly.window_ms", int64(p.Anomaly.WindowMs))
+		v.positive("pipeline.anomaly.baseline_windows", int64(p.Anomaly.BaselineWindows))
+		v.nonNegative("pipeline.anomaly.max_ips", int64(p.Anomaly.MaxIPs))
+	}
+	if p.Tail.Enabled {
+		v.positive("pipeline.tail.max_clients", int64(p.Tail.MaxClients))
+		v.positive("pipeline.tail.buffer_size", int64(p.Tail.BufferSize))
+	}
+	if p.Enrichment.ReverseDNS {
+		v.positive("pipeline.enrichment.reverse_dns_timeout_ms", int64(p.Enrichment.ReverseDNSTimeoutMs))
+		v.positive("pipeline.enrichment.reverse_dns_workers", int64(p.Enrichment.ReverseDNSWorkers))
+		v.nonNegative("pipeline.enrichment.reverse_dns_retry_ms", int64(p.Enrichment.ReverseDNSRetryMs))
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// defaultConfig returns the defaults with the required database settings.
+func defaultConfig(t *testing.T) *Config {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	setDefaults()
+
+	var cfg Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		t.Fatalf("failed to unmarshal defaults: %v", err)
+	}
+	cfg.Database.Host = "localhost"
+	cfg.Database.User = "analytics"
+	cfg.Database.Password = "secret"
+	cfg.Database.Database = "analytics"
+
+	return &cfg
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if err := defaultConfig(t).Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"zero workers", func(c *Config) { c.Pipeline.Workers = 0 }, "pipeline.workers must be greater than 0"},
+		{"zero buffer", func(c *Config) { c.Pipeline.BufferSize = 0 }, "pipeline.buffer_size must be greater than 0"},
+		{"zero batch", func(c *Config) { c.Pipeline.BatchSize = 0 }, "pipeline.batch_size must be greater than 0"},
+		{"batch over buffer", func(c *Config) { c.Pipeline.BatchSize = 20000 },
+			"pipeline.batch_size (20000) must not exceed pipeline.buffer_size (10000)"},
+		{"zero flush interval", func(c *Config) { c.Pipeline.FlushInterval = 0 },
+			"pipeline.flush_interval_ms must be greater than 0"},
+		{"sample rate", func(c *Config) {
+			c.Pipeline.OverflowPolicy = "sample"
+			c.Pipeline.OverflowSampleRate = 0
+		}, "pipeline.overflow_sample_rate must be greater than 0"},
+		{"backoff", func(c *Config) { c.Pipeline.Retry.InitialBackoffMs = 10000 },
+			"pipeline.retry.initial_backoff_ms (10000) must not exceed pipeline.retry.max_backoff_ms (5000)"},
+		{"spill dir", func(c *Config) {
+			c.Pipeline.Spill.Enabled = true
+			c.Pipeline.Spill.Dir = ""
+		}, "pipeline.spill.dir is required"},
+		{"negative proxy port", func(c *Config) { c.Proxy.Port = -1 }, "proxy.port must be between 1 and 65535, got -1"},
+		{"api port too high", func(c *Config) { c.API.Port = 70000 }, "api.port must be between 1 and 65535"},
+		{"database port", func(c *Config) { c.Database.Port = 0 }, "database.port must be between 1 and 65535"},
+		{"health port", func(c *Config) { c.Health.Port = 0 }, "health.port must be between 1 and 65535"},
+		{"metrics port", func(c *Config) { c.Metrics.Port = 0 }, "metrics.port must be between 1 and 65535"},
+		{"listener", func(c *Config) { c.Proxy.Listeners = []string{"0.0.0.0"} },
+			`proxy.listeners entry "0.0.0.0" must be host:port`},
+		{"negative max connections", func(c *Config) { c.Proxy.MaxConnections = -1 },
+			"proxy.max_connections must not be negative"},
+		{"auth without credentials", func(c *Config) { c.Proxy.Auth.Enabled = true },
+			"proxy.auth.enabled is set but no credentials are configured"},
+		{"auth without password", func(c *Config) {
+			c.Proxy.Auth.Enabled = true
+			c.Proxy.Auth.Username = "alice"
+		}, "proxy.auth.username and proxy.auth.password (or proxy.auth.password_file) must be set together"},
+		{"auth user without password", func(c *Config) {
+			c.Proxy.Auth.Enabled = true
+			c.Proxy.Auth.Users = []Credential{{Username: "bob"}}
+		}, "proxy.auth.users[0] must have a username and a password"},
+		{"proxy tls files", func(c *Config) { c.Proxy.TLS.Enabled = true },
+			"proxy.tls.cert_file and proxy.tls.key_file are required"},
+		{"compression level", func(c *Config) {
+			c.Proxy.Compression.Enabled = true
+			c.Proxy.Compression.Level = 10
+		}, "proxy.compression.level must be between -2 and 9"},
+		{"quota window", func(c *Config) {
+			c.Proxy.Quota.Enabled = true
+			c.Proxy.Quota.WindowMs = 0
+		}, "proxy.quota.window_ms must be greater than 0"},
+		{"throttle user", func(c *Config) { c.Proxy.Throttle.Users = []UserThrottle{{BytesPerSecond: 1}} },
+			"proxy.throttle.users[0] must have a username"},
+		{"accept sample rate", func(c *Config) { c.Proxy.AcceptLog.AcceptedSampleRate = 2 },
+			"proxy.accept_log.accepted_sample_rate must be between 0 and 1"},
+		{"api tls files", func(c *Config) { c.API.TLS.Enabled = true },
+			"api.tls.cert_file and api.tls.key_file are required"},
+		{"database host", func(c *Config) { c.Database.Host = "" }, "database.host is required (set DB_HOST)"},
+		{"database password", func(c *Config) { c.Database.Password = "" },
+			"database.password is required (set DB_PASSWORD or DB_PASSWORD_FILE)"},
+		{"idle over open conns", func(c *Config) { c.Database.MaxIdleConns = 50 },
+			"database.max_idle_conns (50) must not exceed database.max_open_conns (25)"},
+		{"health thresholds", func(c *Config) { c.Health.QueueWarnThreshold = 0.99 },
+			"health.queue_warn_threshold and health.queue_critical_threshold must satisfy"},
+		{"health sample interval", func(c *Config) { c.Health.SampleIntervalMs = 0 },
+			"health.sample_interval_ms must be greater than 0"},
+		{"tracing ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "tracing.sample_ratio must be between 0 and 1"},
+		{"rate limit", func(c *Config) {
+			c.RateLimit.Enabled = true
+			c.RateLimit.RequestsPerSecond = 0
+		}, "rate_limit.requests_per_second must be greater than 0"},
+		{"rate limit sweep while disabled", func(c *Config) { c.RateLimit.SweepIntervalMs = 0 },
+			"rate_limit.sweep_interval_ms must be greater than 0"},
+		{"retention batch", func(c *Config) {
+			c.Retention.MaxAge = 1
+			c.Retention.BatchSize = 0
+		}, "retention.batch_size must be greater than 0"},
+		{"database driver", func(c *Config) { c.Database.Driver = "mysql" },
+			`database.driver must be one of postgres, clickhouse, memory, got "mysql"`},
+		{"primary key", func(c *Config) { c.Database.PrimaryKey = "serial" },
+			`database.primary_key must be one of autoincrement, uuid, got "serial"`},
+		{"on conflict", func(c *Config) { c.Database.OnConflict = "replace" },
+			`database.on_conflict must be one of error, ignore, update, got "replace"`},
+		{"on conflict driver", func(c *Config) {
+			c.Database.Driver = "clickhouse"
+			c.Database.OnConflict = "ignore"
+		}, `database.on_conflict "ignore" is only supported by the postgres driver`},
+		{"overflow policy", func(c *Config) { c.Pipeline.OverflowPolicy = "discard" },
+			`pipeline.overflow_policy must be one of drop, block, sample, got "discard"`},
+		{"normalizer overflow", func(c *Config) { c.Pipeline.NormalizerOverflow = "drop" },
+			`pipeline.normalizer_overflow must be one of block, drop-newest, got "drop"`},
+		{"tls min version", func(c *Config) {
+			c.Proxy.TLS.Enabled = true
+			c.Proxy.TLS.MinVersion = "1.4"
+		}, `proxy.tls.min_version must be one of 1.0, 1.1, 1.2, 1.3, got "1.4"`},
+		{"tls cipher suite", func(c *Config) {
+			c.Proxy.TLS.Enabled = true
+			c.Proxy.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+		}, `proxy.tls.cipher_suites[0] "TLS_RSA_WITH_RC4_128_SHA" is not a known secure cipher suite`},
+		{"logging level", func(c *Config) { c.Logging.Level = "verbose" },
+			`logging.level must be one of debug, info, warn, error, got "verbose"`},
+		{"api key scope", func(c *Config) { c.API.Auth.ScopedKeys = []APIKey{{Key: "k", Scopes: []string{"write"}}} },
+			`api.auth.scoped_keys[0].scopes must contain only read or admin, got "write"`},
+		{"api key without scopes", func(c *Config) { c.API.Auth.ScopedKeys = []APIKey{{Key: "k"}} },
+			"api.auth.scoped_keys[0].scopes must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig(t)
+			tt.change(cfg)
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	cfg := defaultConfig(t)
+	cfg.Pipeline.Workers = 0
+	cfg.Proxy.Port = -1
+	cfg.Database.Host = ""
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 3 {
+		t.Errorf("expected one line per problem, got %q", err.Error())
+	}
+
+	// The memory driver needs no database server.
+	cfg = defaultConfig(t)
+	cfg.Database.Driver = "memory"
+	cfg.Database.Host = ""
+	cfg.Database.Port = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected the memory driver to need no database settings, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// SetLogLevel changes the level of log to the "level" field of the JSON body
+// and responds with the new and previous levels.
+func SetLogLevel(log *logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Level string `json:"level" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with a level"})
+
+			return
+		}
+
+		previous, err := log.SetLevel(body.Level)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "level must be one of debug, info, warn or error"})
+
+			return
+		}
+		log.Info("Log level changed", zap.String("level", body.Level), zap.String("previous", previous),
+			zap.String(requestIDKey, RequestID(c)))
+
+		c.JSON(http.StatusOK, gin.H{"level": body.Level, "previous": previous})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/logger"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	log, err := logger.New("info", "")
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	keys, err := NewAPIKeys([]string{"reader"}, []config.APIKey{{Key: "secret", Scopes: []string{ScopeAdmin}}})
+	if err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+	router := gin.New()
+	router.PUT("/admin/loglevel", APIKeyAuth(keys), RequireScope(ScopeAdmin), SetLogLevel(log))
+
+	put := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	if w := put("", `{"level":"debug"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	if w := put("wrong", `{"level":"debug"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with a wrong token, got %d", w.Code)
+	}
+	if w := put("reader", `{"level":"debug"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with a read-only key, got %d", w.Code)
+	}
+	if w := put("secret", `{"level":"verbose"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown level, got %d", w.Code)
+	}
+	if log.Level() != "info" {
+		t.Errorf("expected rejected requests to keep the level, got %q", log.Level())
+	}
+
+	w := put("secret", `{"level":"debug"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"level":"debug","previous":"info"}` {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if log.Level() != "debug" {
+		t.Errorf("expected the level to change, got %q", log.Level())
+	}
+}
+
+func TestWhitelistEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{}
+	cfg.Proxy.IPWhitelist = []string{"192.0.2.1"}
+	handler := NewHandler(storage.NewInMemoryRepository(10), cfg, zap.NewNop())
+	router := gin.New()
+	router.GET("/admin/whitelist", handler.GetWhitelist)
+	router.POST("/admin/whitelist", handler.AddWhitelistEntry)
+	router.DELETE("/admin/whitelist/*entry", handler.DeleteWhitelistEntry)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	for _, body := range []string{`{}`, `{"entry":"not-an-ip"}`, `{"entry":"10.0.0.0/33"}`} {
+		if w := serve(http.MethodPost, "/admin/whitelist", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	w := serve(http.MethodPost, "/admin/whitelist", `{"entry":"10.1.2.3/8","comment":"office"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"entry":"10.0.0.0/8"`) {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(http.MethodGet, "/admin/whitelist", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"config":["192.0.2.1"]`) ||
+		!strings.Contains(w.Body.String(), `"comment":"office"`) {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/admin/whitelist/10.0.0.0/8", http.StatusNoContent},
+		{"/admin/whitelist/10.0.0.0/8", http.StatusNotFound},
+		{"/admin/whitelist/192.0.2.1", http.StatusConflict},
+		{"/admin/whitelist/invalid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := serve(http.MethodDelete, tt.path, ""); w.Code != tt.want {
+			t.Errorf("DELETE %s: expected %d, got %d", tt.path, tt.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// API key scopes. An admin key may use every endpoint.
+const (
+	ScopeRead  = "read"
+	ScopeAdmin = "admin"
+)
+
+// APIKeyHeader carries an API key, as an alternative to a bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyQuery is the query parameter carrying an API key, for clients such
+// as browser EventSource that can't set headers.
+const apiKeyQuery = "api_key"
+
+// scopesKey is the gin context key holding the scopes of the request's key.
+const scopesKey = "api_scopes"
+
+// APIKeys holds the accepted API keys and the scopes each grants.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	// Comparing fixed-length hashes keeps the comparison time independent
+	// of the keys' lengths as well as their contents.
+	hash   [sha256.Size]byte
+	scopes []string
+}
+
+// NewAPIKeys builds the key set from readKeys, which grant the read scope,
+// and scoped keys, which grant the scopes they list. Empty read keys are
+// ignored; an empty scoped key or an unknown scope is an error.
+func NewAPIKeys(readKeys []string, scoped []config.APIKey) (*APIKeys, error) {
+	k := &APIKeys{}
+	for _, key := range readKeys {
+		if key != "" {
+			k.keys = append(k.keys, apiKey{hash: sha256.Sum256([]byte(key)), scopes: []string{ScopeRead}})
+		}
+	}
+	for i, key := range scoped {
+		if key.Key == "" {
+			return nil, fmt.Errorf("scoped API key %d is empty", i)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("scoped API key %d has no scopes", i)
+		}
+		for _, scope := range key.Scopes {
+			if scope != ScopeRead && scope != ScopeAdmin {
+				return nil, fmt.Errorf("scoped API key %d has unknown scope %q, expected read or admin", i, scope)
+			}
+		}
+		k.keys = append(k.keys, apiKey{hash: sha256.Sum256([]byte(key.Key)), scopes: key.Scopes})
+	}
+
+	return k, nil
+}
+
+// APIKeysFromConfig builds the key set from api.auth, with api.admin_token as
+// an admin key.
+func APIKeysFromConfig(cfg *config.Config) (*APIKeys, error) {
+	scoped := slices.Clone(cfg.API.Auth.ScopedKeys)
+	if cfg.API.AdminToken != "" {
+		scoped = append(scoped, config.APIKey{Key: cfg.API.AdminToken, Scopes: []string{ScopeAdmin}})
+	}
+
+	return NewAPIKeys(cfg.API.Auth.Keys, scoped)
+}
+
+// HasScope reports whether any key grants scope.
+func (k *APIKeys) HasScope(scope string) bool {
+	return slices.ContainsFunc(k.keys, func(key apiKey) bool {
+		return grants(key.scopes, scope)
+	})
+}
+
+// scopes returns the scopes granted to given, and whether it is a known key.
+// Every key is compared, so the time taken doesn't reveal which matched.
+func (k *APIKeys) scopes(given string) ([]string, bool) {
+	hash := sha256.Sum256([]byte(given))
+	var scopes []string
+	matched := false
+	for _, key := range k.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash[:]) == 1 {
+			scopes = append(scopes, key.scopes...)
+			matched = true
+		}
+	}
+
+	return scopes, matched
+}
+
+// APIKeyAuth rejects requests that don't present one of keys with 401 and
+// records the key's scopes for RequireScope. The key is read from
+// "Authorization: Bearer <key>", the X-API-Key header or the api_key query
+// parameter, in that order.
+func APIKeyAuth(keys *APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given := presentedAPIKey(c.Request)
+		if given == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key"})
+
+			return
+		}
+
+		scopes, ok := keys.scopes(given)
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+
+			return
+		}
+		c.Set(scopesKey, scopes)
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests whose API key doesn't grant scope with 403.
+// It must run after APIKeyAuth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get(scopesKey)
+		granted, _ := scopes.([]string)
+		if !grants(granted, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+
+			return
+		}
+		c.Next()
+	}
+}
+
+// Require is APIKeyAuth followed by RequireScope for plain net/http servers,
+// such as the proxy's health server: next only runs for a key granting scope.
+func (k *APIKeys) Require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := presentedAPIKey(r)
+		scopes, ok := k.scopes(given)
+		switch {
+		case given == "" || !ok:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Missing or invalid API key")
+		case !grants(scopes, scope):
+			writeJSONError(w, http.StatusForbidden, "API key lacks the "+scope+" scope")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func grants(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func presentedAPIKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+
+	return r.URL.Query().Get(apiKeyQuery)
+}
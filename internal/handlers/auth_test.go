@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	keys, err := NewAPIKeys([]string{"", "first-key", "second"}, []config.APIKey{
+		{Key: "operator", Scopes: []string{ScopeAdmin}},
+		{Key: "analyst", Scopes: []string{ScopeRead}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.GET("/health", ok)
+	router.GET("/stats/traffic", APIKeyAuth(keys), RequireScope(ScopeRead), ok)
+	router.PUT("/admin/loglevel", APIKeyAuth(keys), RequireScope(ScopeAdmin), ok)
+
+	get := func(path string, header http.Header) int {
+		method := http.MethodGet
+		if path == "/admin/loglevel" {
+			method = http.MethodPut
+		}
+		req := httptest.NewRequest(method, path, nil)
+		req.Header = header
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	header := func(name, value string) http.Header {
+		h := http.Header{}
+		h.Set(name, value)
+
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"health is exempt", "/health", http.Header{}, http.StatusOK},
+		{"missing key", "/stats/traffic", http.Header{}, http.StatusUnauthorized},
+		{"bearer token", "/stats/traffic", header("Authorization", "Bearer first-key"), http.StatusOK},
+		{"header", "/stats/traffic", header(APIKeyHeader, "second"), http.StatusOK},
+		{"query", "/stats/traffic?api_key=second", http.Header{}, http.StatusOK},
+		{"wrong key", "/stats/traffic", header(APIKeyHeader, "first"), http.StatusUnauthorized},
+		{"wrong scheme", "/stats/traffic", header("Authorization", "Basic first-key"), http.StatusUnauthorized},
+		{"empty configured key", "/stats/traffic", header("Authorization", "Bearer "), http.StatusUnauthorized},
+		{"scoped read key", "/stats/traffic", header(APIKeyHeader, "analyst"), http.StatusOK},
+		{"admin key reads", "/stats/traffic", header(APIKeyHeader, "operator"), http.StatusOK},
+		{"admin key", "/admin/loglevel", header(APIKeyHeader, "operator"), http.StatusOK},
+		{"read key on admin", "/admin/loglevel", header(APIKeyHeader, "analyst"), http.StatusForbidden},
+		{"plain key on admin", "/admin/loglevel", header(APIKeyHeader, "second"), http.StatusForbidden},
+		{"no key on admin", "/admin/loglevel", http.Header{}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := get(tt.path, tt.header); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAPIKeysRequire(t *testing.T) {
+	keys, err := NewAPIKeys([]string{"reader"}, []config.APIKey{{Key: "operator", Scopes: []string{ScopeAdmin}}})
+	if err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+	handler := keys.Require(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"missing key", "", http.StatusUnauthorized},
+		{"wrong key", "operat", http.StatusUnauthorized},
+		{"read key", "reader", http.StatusForbidden},
+		{"admin key", "operator", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/analytics", nil)
+		if tt.key != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+	}
+}
+
+func TestNewAPIKeysValidatesScopes(t *testing.T) {
+	invalid := [][]config.APIKey{
+		{{Key: "", Scopes: []string{ScopeRead}}},
+		{{Key: "key"}},
+		{{Key: "key", Scopes: []string{"write"}}},
+	}
+	for _, scoped := range invalid {
+		if _, err := NewAPIKeys(nil, scoped); err == nil {
+			t.Errorf("expected %+v to be rejected", scoped)
+		}
+	}
+
+	keys, err := NewAPIKeys([]string{"reader"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+	if !keys.HasScope(ScopeRead) || keys.HasScope(ScopeAdmin) {
+		t.Error("expected plain keys to grant only the read scope")
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// respond writes obj as JSON, rendering int64 values as strings when
+// api.int64_as_string is enabled so JavaScript clients don't lose precision.
+func (h *Handler) respond(c *gin.Context, status int, obj any) {
+	if h.cfg.API.Int64AsString {
+		obj = stringifyInt64(reflect.ValueOf(obj))
+	}
+
+	c.JSON(status, obj)
+}
+
+// jsonField is a single key/value pair of a jsonObject.
+type jsonField struct {
+	key   string
+	value any
+}
+
+// jsonObject is a JSON object that preserves struct field order when encoded.
+type jsonObject []jsonField
+
+// MarshalJSON encodes the object with its fields in declaration order.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// stringifyInt64 rebuilds v as a JSON-encodable value in which every int64
+// is replaced by its decimal string. Types with their own MarshalJSON (such as
+// time.Time) are passed through untouched.
+func stringifyInt64(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return stringifyInt64(v.Elem())
+	case reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Struct:
+		return stringifyStruct(v)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = stringifyInt64(v.Index(i))
+		}
+
+		return items
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+
+		out := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out[iter.Key().String()] = stringifyInt64(iter.Value())
+		}
+
+		return out
+	default:
+		return v.Interface()
+	}
+}
+
+func stringifyStruct(v reflect.Value) jsonObject {
+	t := v.Type()
+	obj := make(jsonObject, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if field.Anonymous && name == "" && fv.Kind() == reflect.Struct {
+			obj = append(obj, stringifyStruct(fv)...)
+
+			continue
+		}
+
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		obj = append(obj, jsonField{key: name, value: stringifyInt64(fv)})
+	}
+
+	return obj
+}
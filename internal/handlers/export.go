@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Response formats of /logs/traffic.
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// flushEvery is the number of streamed rows after which the response is
+// flushed to the client.
+const flushEvery = 500
+
+// parseFormat reads the response format from the format query parameter,
+// falling back to the Accept header and then JSON. On an unknown format it
+// writes a 400 response and returns false.
+func parseFormat(c *gin.Context) (string, bool) {
+	switch format := c.Query("format"); format {
+	case formatJSON, formatCSV, formatNDJSON:
+		return format, true
+	case "":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of json, csv or ndjson"})
+
+		return "", false
+	}
+
+	for accept := range strings.SplitSeq(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return formatCSV, true
+		case "application/x-ndjson", "application/ndjson":
+			return formatNDJSON, true
+		case "application/json":
+			return formatJSON, true
+		}
+	}
+
+	return formatJSON, true
+}
+
+// logEncoder encodes traffic logs in one response format.
+type logEncoder interface {
+	begin() error
+	encode(log *models.TrafficLog) error
+	end() error
+	// flush hands anything the encoder buffers to the response writer.
+	flush() error
+}
+
+// trafficLogWriter streams traffic logs to the response as they are read,
+// flushing after the first log and then every flushEvery logs, so a large
+// result set is never held in memory and the client starts receiving data
+// right away. The response headers are only written with the first log, or
+// by close, so an error before then can still be answered with a 500.
+type trafficLogWriter struct {
+	c         *gin.Context
+	format    string
+	stringify bool
+	encoder   logEncoder
+	rows      int
+}
+
+func (h *Handler) newTrafficLogWriter(c *gin.Context, format string) *trafficLogWriter {
+	return &trafficLogWriter{c: c, format: format, stringify: h.cfg.API.Int64AsString}
+}
+
+// started reports whether the response has been committed.
+func (w *trafficLogWriter) started() bool {
+	return w.encoder != nil
+}
+
+func (w *trafficLogWriter) start() error {
+	w.c.Status(http.StatusOK)
+	switch w.format {
+	case formatCSV:
+		w.c.Header("Content-Type", "text/csv; charset=utf-8")
+		w.c.Header("Content-Disposition", `attachment; filename="traffic_logs.csv"`)
+		w.encoder = &csvEncoder{writer: csv.NewWriter(w.c.Writer)}
+	case formatNDJSON:
+		w.c.Header("Content-Type", "application/x-ndjson")
+		w.encoder = &jsonEncoder{writer: w.c.Writer, stringify: w.stringify}
+	default:
+		w.c.Header("Content-Type", "application/json; charset=utf-8")
+		w.encoder = &jsonEncoder{writer: w.c.Writer, stringify: w.stringify, array: true}
+	}
+
+	return w.encoder.begin()
+}
+
+func (w *trafficLogWriter) write(log *models.TrafficLog) error {
+	if !w.started() {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if err := w.encoder.encode(log); err != nil {
+		return err
+	}
+	if w.rows++; w.rows == 1 || w.rows%flushEvery == 0 {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// close ends the response, which for no logs is an empty array, a CSV
+// header row or an empty body.
+func (w *trafficLogWriter) close() error {
+	if !w.started() {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if err := w.encoder.end(); err != nil {
+		return err
+	}
+
+	return w.flush()
+}
+
+func (w *trafficLogWriter) flush() error {
+	if err := w.encoder.flush(); err != nil {
+		return err
+	}
+	w.c.Writer.Flush()
+
+	return nil
+}
+
+// jsonEncoder writes logs as a JSON array, or as NDJSON with one object per
+// line when array is false.
+type jsonEncoder struct {
+	writer    io.Writer
+	stringify bool
+	array     bool
+	rows      int
+}
+
+func (e *jsonEncoder) begin() error {
+	if !e.array {
+		return nil
+	}
+	_, err := io.WriteString(e.writer, "[")
+
+	return err
+}
+
+func (e *jsonEncoder) encode(log *models.TrafficLog) error {
+	var obj any = log
+	if e.stringify {
+		obj = stringifyInt64(reflect.ValueOf(log))
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !e.array:
+		data = append(data, '\n')
+	case e.rows > 0:
+		data = append([]byte{','}, data...)
+	}
+	e.rows++
+	_, err = e.writer.Write(data)
+
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	if !e.array {
+		return nil
+	}
+	_, err := io.WriteString(e.writer, "]")
+
+	return err
+}
+
+func (e *jsonEncoder) flush() error {
+	return nil
+}
+
+// csvEncoder writes a header row of the TrafficLog JSON field names, which
+// cmd/import accepts, followed by one row per log.
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+// csvColumn is a TrafficLog field exported as a CSV column.
+type csvColumn struct {
+	name  string
+	index int
+}
+
+// trafficLogColumns lists the TrafficLog fields in declaration order, named
+// by their JSON tags.
+var trafficLogColumns = func() []csvColumn {
+	t := reflect.TypeFor[models.TrafficLog]()
+	columns := make([]csvColumn, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			columns = append(columns, csvColumn{name: name, index: i})
+		}
+	}
+
+	return columns
+}()
+
+func (e *csvEncoder) begin() error {
+	header := make([]string, len(trafficLogColumns))
+	for i, column := range trafficLogColumns {
+		header[i] = column.name
+	}
+
+	return e.writer.Write(header)
+}
+
+func (e *csvEncoder) encode(log *models.TrafficLog) error {
+	v := reflect.ValueOf(log).Elem()
+	record := make([]string, len(trafficLogColumns))
+	for i, column := range trafficLogColumns {
+		record[i] = csvValue(v.Field(column.index))
+	}
+
+	return e.writer.Write(record)
+}
+
+func (e *csvEncoder) end() error {
+	return nil
+}
+
+func (e *csvEncoder) flush() error {
+	e.writer.Flush()
+
+	return e.writer.Error()
+}
+
+// csvValue formats a field the way its JSON encoding would read, with nil
+// pointers left empty and timestamps in RFC3339. Strings that a spreadsheet
+// would evaluate as a formula are escaped.
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case string:
+		return escapeFormula(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// escapeFormula prefixes s with a quote when it starts with a character that
+// makes spreadsheets treat a cell as a formula, since domains and usernames
+// are chosen by clients (CSV injection).
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
@@ -2,10 +2,19 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -14,140 +23,599 @@ import (
 // Handler handles HTTP requests for the analytics API.
 type Handler struct {
 	repo storage.Repository
+	cfg  *config.Config
 	log  *zap.Logger
+	// streams is closed by CloseStreams to end every open event stream.
+	streams   chan struct{}
+	closeOnce sync.Once
+	// openStreams counts the open stats streams, capped at api.max_streams.
+	openStreams atomic.Int64
+	snapshots   snapshotCache
 }
 
-// NewHandler creates a new HTTP handler with the given repository and logger.
-func NewHandler(repo storage.Repository, log *zap.Logger) *Handler {
+// NewHandler creates a new HTTP handler with the given repository, configuration and logger.
+func NewHandler(repo storage.Repository, cfg *config.Config, log *zap.Logger) *Handler {
 	return &Handler{
-		repo: repo,
-		log:  log,
+		repo:    repo,
+		cfg:     cfg,
+		log:     log,
+		streams: make(chan struct{}),
 	}
 }
 
-// GetTopDomains returns the top domains by connection count.
+// GetTopDomains returns the top domains by connection count, optionally
+// restricted to those matching the filter query parameter.
 func (h *Handler) GetTopDomains(c *gin.Context) {
-	limit := 10
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
+	match, ok := parsePatternQuery(c, "filter")
+	if !ok {
+		return
 	}
 
-	domains, err := h.repo.GetTopDomains(c.Request.Context(), limit)
+	domains, err := h.repo.GetTopDomains(c.Request.Context(), limit, match)
 	if err != nil {
-		h.log.Error("failed to get top domains", zap.Error(err))
+		h.logger(c).Error("failed to get top domains", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve top domains"})
 
 		return
 	}
 
-	c.JSON(http.StatusOK, domains)
+	h.respond(c, http.StatusOK, domains)
 }
 
-// GetTopSourceIPs returns the top source IPs by connection count.
+// GetTopSourceIPs returns the top source IPs by connection count, optionally
+// restricted to those matching the filter query parameter.
 func (h *Handler) GetTopSourceIPs(c *gin.Context) {
-	limit := 10
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
+	match, ok := parsePatternQuery(c, "filter")
+	if !ok {
+		return
 	}
 
-	ips, err := h.repo.GetTopSourceIPs(c.Request.Context(), limit)
+	ips, err := h.repo.GetTopSourceIPs(c.Request.Context(), limit, match)
 	if err != nil {
-		h.log.Error("failed to get top source IPs", zap.Error(err))
+		h.logger(c).Error("failed to get top source IPs", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve top source IPs"})
 
 		return
 	}
 
-	c.JSON(http.StatusOK, ips)
+	h.respond(c, http.StatusOK, ips)
 }
 
-// GetTrafficStats returns aggregate traffic statistics for a time range.
-func (h *Handler) GetTrafficStats(c *gin.Context) {
-	startStr := c.Query("start")
-	endStr := c.Query("end")
+// GetTopUsers returns the top authenticated proxy users by connection count.
+func (h *Handler) GetTopUsers(c *gin.Context) {
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
 
-	var startTime, endTime time.Time
+	users, err := h.repo.GetTopUsers(c.Request.Context(), limit)
+	if err != nil {
+		h.logger(c).Error("failed to get top users", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve top users"})
 
-	if startStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, startStr); err == nil {
-			startTime = parsed
-		}
-	} else {
-		startTime = time.Now().Add(-24 * time.Hour)
+		return
 	}
 
-	if endStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, endStr); err == nil {
-			endTime = parsed
-		}
-	} else {
-		endTime = time.Now()
+	h.respond(c, http.StatusOK, users)
+}
+
+// GetDomainsForSourceIP returns the domains one source IP connected to, for
+// drilling down from the top source IPs.
+func (h *Handler) GetDomainsForSourceIP(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ip must be a valid IP address"})
+
+		return
+	}
+	sourceIP := ip.String()
+
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
+
+	domains, err := h.repo.GetDomainsForSourceIP(c.Request.Context(), sourceIP, startTime, endTime, limit)
+	if err != nil {
+		h.logger(c).Error("failed to get domains for source IP", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve domains for source IP"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, domains)
+}
+
+// GetTopPorts returns the top destination ports by connection count.
+func (h *Handler) GetTopPorts(c *gin.Context) {
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
+
+	ports, err := h.repo.GetTopPorts(c.Request.Context(), limit)
+	if err != nil {
+		h.logger(c).Error("failed to get top ports", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve top ports"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, ports)
+}
+
+// GetTrafficStats returns aggregate traffic statistics for a time range.
+func (h *Handler) GetTrafficStats(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
 	}
 
 	stats, err := h.repo.GetTrafficStats(c.Request.Context(), startTime, endTime)
 	if err != nil {
-		h.log.Error("failed to get traffic stats", zap.Error(err))
+		h.logger(c).Error("failed to get traffic stats", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve traffic stats"})
 
 		return
 	}
 
-	c.JSON(http.StatusOK, stats)
+	h.respond(c, http.StatusOK, stats)
 }
 
-// GetTrafficLogs returns paginated traffic logs for a time range.
+// GetTrafficLogs returns paginated traffic logs for a time range as JSON, or
+// as CSV or NDJSON when asked for by the format query parameter or the
+// Accept header.
 func (h *Handler) GetTrafficLogs(c *gin.Context) {
-	limit := 100
-	offset := 0
-	startStr := c.Query("start")
-	endStr := c.Query("end")
-
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
+	limit, ok := parseIntQuery(c, "limit", 100)
+	if !ok {
+		return
+	}
+	limit = h.clampPageSize(c, limit)
+
+	offset, ok := parseIntQuery(c, "offset", 0)
+	if !ok {
+		return
+	}
+
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	var filter storage.TrafficFilter
+	if cidr := c.Query("source_cidr"); cidr != "" {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source_cidr must be a CIDR such as 10.0.0.0/8"})
+
+			return
 		}
+		filter.SourceCIDR = network.String()
 	}
+	if sourceIP := c.Query("source_ip"); sourceIP != "" {
+		ip := net.ParseIP(sourceIP)
+		if ip == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source_ip must be a valid IP address"})
 
-	if o := c.Query("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
+			return
 		}
+		filter.SourceIP = ip.String()
+	}
+	filter.Domain = c.Query("domain")
+
+	format, ok := parseFormat(c)
+	if !ok {
+		return
+	}
+
+	w := h.newTrafficLogWriter(c, format)
+	err := h.repo.StreamTrafficByTimeRange(c.Request.Context(), startTime, endTime, limit, offset, filter, w.write)
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil && !w.started() {
+		h.logger(c).Error("failed to get traffic logs", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve traffic logs"})
+
+		return
+	}
+	if err != nil {
+		// The status has been sent, so the client only sees the response end early.
+		h.logger(c).Error("failed to stream traffic logs", zap.String("format", format), zap.Error(err))
+		c.Abort()
+	}
+}
+
+// maxConcurrencyBuckets bounds the number of buckets a single concurrency query may produce.
+const maxConcurrencyBuckets = 10000
+
+// parseSmoothWindow reads the optional smooth query parameter, the moving
+// average window in buckets. It writes a 400 response and returns false when
+// the value is invalid; 0 means no smoothing.
+func parseSmoothWindow(c *gin.Context) (int, bool) {
+	s := c.Query("smooth")
+	if s == "" {
+		return 0, true
+	}
+
+	window, err := strconv.Atoi(s)
+	if err != nil || window < 0 || window > maxConcurrencyBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "smooth must be a number of buckets between 0 and 10000"})
+
+		return 0, false
+	}
+
+	return window, true
+}
+
+// GetConcurrentConnections returns the peak and average number of
+// simultaneously open connections per time bucket, optionally with a moving
+// average over the number of buckets in the smooth query parameter.
+func (h *Handler) GetConcurrentConnections(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
 	}
 
-	var startTime, endTime time.Time
+	bucket := 5 * time.Minute
+	if b := c.Query("bucket"); b != "" {
+		parsed, err := time.ParseDuration(b)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must be a positive duration such as 1m or 1h"})
 
-	if startStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, startStr); err == nil {
-			startTime = parsed
+			return
 		}
-	} else {
-		startTime = time.Now().Add(-24 * time.Hour)
+		bucket = parsed
+	}
+
+	if endTime.Sub(startTime)/bucket > maxConcurrencyBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range contains too many buckets, use a larger bucket"})
+
+		return
+	}
+
+	smooth, ok := parseSmoothWindow(c)
+	if !ok {
+		return
+	}
+
+	buckets, err := h.repo.GetConcurrentConnections(c.Request.Context(), startTime, endTime, bucket, smooth)
+	if errors.Is(err, storage.ErrTooManyConnections) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range contains too many connections, use a narrower range"})
+
+		return
+	}
+	if err != nil {
+		h.logger(c).Error("failed to get concurrent connections", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve concurrent connections"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, buckets)
+}
+
+// timeSeriesIntervals are the bucket widths accepted by GetTrafficTimeSeries.
+var timeSeriesIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// GetTrafficTimeSeries returns connections, bytes and average latency per
+// time bucket, optionally with moving averages over the number of buckets in
+// the smooth query parameter.
+func (h *Handler) GetTrafficTimeSeries(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	interval, ok := timeSeriesIntervals[c.DefaultQuery("interval", "1h")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of 1m, 5m, 1h or 1d"})
+
+		return
+	}
+
+	if endTime.Sub(startTime)/interval > maxConcurrencyBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range contains too many buckets, use a larger interval"})
+
+		return
 	}
 
-	if endStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, endStr); err == nil {
-			endTime = parsed
+	smooth, ok := parseSmoothWindow(c)
+	if !ok {
+		return
+	}
+
+	series, err := h.repo.GetTrafficTimeSeries(c.Request.Context(), startTime, endTime, interval, smooth)
+	if err != nil {
+		h.logger(c).Error("failed to get traffic time series", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve traffic time series"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, series)
+}
+
+// GetUserDailyUsage returns bytes transferred per authenticated user per day.
+// Day boundaries follow the IANA time zone in the tz query parameter (default UTC).
+func (h *Handler) GetUserDailyUsage(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	loc := time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		parsed, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tz must be an IANA time zone such as Europe/Berlin"})
+
+			return
 		}
-	} else {
-		endTime = time.Now()
+		loc = parsed
 	}
 
-	logs, err := h.repo.GetTrafficByTimeRange(c.Request.Context(), startTime, endTime, limit, offset)
+	usage, err := h.repo.GetUserDailyUsage(c.Request.Context(), startTime, endTime, loc)
 	if err != nil {
-		h.log.Error("failed to get traffic logs", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve traffic logs"})
+		h.logger(c).Error("failed to get user daily usage", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user usage"})
 
 		return
 	}
 
-	c.JSON(http.StatusOK, logs)
+	h.respond(c, http.StatusOK, usage)
 }
 
-// Health returns a simple health check response.
-func (h *Handler) Health(c *gin.Context) {
+// GetQuotaUsage returns the bytes the source IP in the ip query parameter,
+// or the user in the user parameter, transferred within the proxy.quota
+// window, along with its limit. The limit is 0 when no quota applies.
+func (h *Handler) GetQuotaUsage(c *gin.Context) {
+	ip, user := c.Query("ip"), c.Query("user")
+	if (ip == "") == (user == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of ip or user must be set"})
+
+		return
+	}
+
+	quota := h.cfg.Proxy.Quota
+	usage := models.QuotaUsage{Username: user}
+	filter := storage.UsageFilter{ByUser: user != "", Key: user}
+	limit := quota.UserBytes
+	if ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ip must be a valid IP address"})
+
+			return
+		}
+		usage.SourceIP = parsed.String()
+		filter.Key = usage.SourceIP
+		limit = quota.IPBytes
+	}
+	if quota.Enabled {
+		usage.LimitBytes = limit
+	}
+
+	window := time.Duration(quota.WindowMs) * time.Millisecond
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	endTime := time.Now()
+	usage.WindowStart = endTime.Add(-window)
+
+	used, err := h.repo.GetByteUsage(c.Request.Context(), usage.WindowStart, endTime, filter)
+	if err != nil {
+		h.logger(c).Error("failed to get quota usage", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve quota usage"})
+
+		return
+	}
+	if len(used) > 0 {
+		usage.UsedBytes = used[0].Bytes
+	}
+	usage.Exceeded = usage.LimitBytes > 0 && usage.UsedBytes >= usage.LimitBytes
+
+	h.respond(c, http.StatusOK, usage)
+}
+
+// GetSuspiciousConnections returns connections to domains flagged as likely homographs.
+func (h *Handler) GetSuspiciousConnections(c *gin.Context) {
+	limit, ok := parseIntQuery(c, "limit", 100)
+	if !ok {
+		return
+	}
+	limit = h.clampPageSize(c, limit)
+
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	logs, err := h.repo.GetSuspiciousConnections(c.Request.Context(), startTime, endTime, limit)
+	if err != nil {
+		h.logger(c).Error("failed to get suspicious connections", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve suspicious connections"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, logs)
+}
+
+// GetRegionStats returns traffic statistics grouped by source region.
+func (h *Handler) GetRegionStats(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	stats, err := h.repo.GetRegionStats(c.Request.Context(), startTime, endTime)
+	if err != nil {
+		h.logger(c).Error("failed to get region stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve region stats"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, stats)
+}
+
+// GetProtocolStats returns traffic statistics grouped by protocol and by the
+// application category of the destination port.
+func (h *Handler) GetProtocolStats(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	stats, err := h.repo.GetProtocolBreakdown(c.Request.Context(), startTime, endTime)
+	if err != nil {
+		h.logger(c).Error("failed to get protocol stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve protocol stats"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, models.ProtocolBreakdown{
+		Protocols: mergeProtocolStats(stats, func(s models.ProtocolStats) models.ProtocolStats {
+			return models.ProtocolStats{Protocol: s.Protocol}
+		}),
+		Categories: mergeProtocolStats(stats, func(s models.ProtocolStats) models.ProtocolStats {
+			return models.ProtocolStats{Category: s.Category}
+		}),
+	})
+}
+
+// mergeProtocolStats totals the protocol and category pairs of stats by the
+// key group returns, ordered by count descending. Average latencies are
+// weighted by connection count.
+func mergeProtocolStats(
+	stats []models.ProtocolStats, group func(models.ProtocolStats) models.ProtocolStats,
+) []models.ProtocolStats {
+	merged := []models.ProtocolStats{}
+	index := make(map[models.ProtocolStats]int)
+	for _, s := range stats {
+		key := group(s)
+		i, ok := index[key]
+		if !ok {
+			i = len(merged)
+			index[key] = i
+			merged = append(merged, key)
+		}
+		total := &merged[i]
+		if count := total.Count + s.Count; count > 0 {
+			total.AvgLatency = (total.AvgLatency*float64(total.Count) + s.AvgLatency*float64(s.Count)) / float64(count)
+		}
+		total.Count += s.Count
+		total.TotalBytesIn += s.TotalBytesIn
+		total.TotalBytesOut += s.TotalBytesOut
+	}
+	slices.SortStableFunc(merged, func(a, b models.ProtocolStats) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+
+	return merged
+}
+
+// GetCountryStats returns traffic statistics grouped by source country, or by
+// destination country with direction=destination.
+func (h *Handler) GetCountryStats(c *gin.Context) {
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	var destination bool
+	switch c.DefaultQuery("direction", "source") {
+	case "source":
+	case "destination":
+		destination = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be source or destination"})
+
+		return
+	}
+
+	stats, err := h.repo.GetCountryStats(c.Request.Context(), startTime, endTime, destination)
+	if err != nil {
+		h.logger(c).Error("failed to get country stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve country stats"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, stats)
+}
+
+// GetFailureStats returns failed connection attempts grouped by status and
+// source IP.
+func (h *Handler) GetFailureStats(c *gin.Context) {
+	limit, ok := parseIntQuery(c, "limit", 100)
+	if !ok {
+		return
+	}
+	limit = h.clampPageSize(c, limit)
+
+	startTime, endTime, ok := parseTimeRange(c, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	stats, err := h.repo.GetFailureStats(c.Request.Context(), startTime, endTime, limit)
+	if err != nil {
+		h.logger(c).Error("failed to get failure stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve failure stats"})
+
+		return
+	}
+
+	h.respond(c, http.StatusOK, stats)
+}
+
+// Live reports that the process is up and serving requests. It checks no
+// dependencies, so a database outage doesn't get the process restarted.
+func (h *Handler) Live(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
+
+// Health reports whether the API can serve requests, returning 503 with the
+// failing dependency when the database does not answer a ping within
+// api.health_check_timeout_ms.
+func (h *Handler) Health(c *gin.Context) {
+	timeout := time.Duration(h.cfg.API.HealthCheckTimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	if err := h.repo.Ping(ctx); err != nil {
+		h.logger(c).Warn("health check failed", zap.String("dependency", "database"), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":       "unavailable",
+			"dependencies": gin.H{"database": "unreachable"},
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "dependencies": gin.H{"database": "ok"}})
+}
@@ -0,0 +1,699 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// fakeRepository is a storage.Repository stub returning canned results.
+// Methods a test doesn't override panic via the nil embedded interface.
+type fakeRepository struct {
+	storage.Repository
+	logs    []models.TrafficLog
+	stats   models.TrafficStats
+	regions []models.RegionStats
+	// protocols is returned by GetProtocolBreakdown.
+	protocols []models.ProtocolStats
+	// interval records the bucket width passed to GetTrafficTimeSeries.
+	interval time.Duration
+	// limit and filter record the arguments passed to GetTrafficByTimeRange.
+	limit  int
+	filter storage.TrafficFilter
+	// pingErr is returned by Ping.
+	pingErr error
+	// streamErr is returned by StreamTrafficByTimeRange after streaming logs.
+	streamErr error
+}
+
+func (f *fakeRepository) Ping(_ context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeRepository) GetTrafficStats(_ context.Context, _, _ time.Time) (*models.TrafficStats, error) {
+	return &f.stats, nil
+}
+
+func (f *fakeRepository) GetTrafficByTimeRange(
+	_ context.Context, _, _ time.Time, limit, _ int, filter storage.TrafficFilter,
+) ([]models.TrafficLog, error) {
+	f.limit = limit
+	f.filter = filter
+
+	return f.logs, nil
+}
+
+func (f *fakeRepository) StreamTrafficByTimeRange(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter storage.TrafficFilter,
+	fn func(*models.TrafficLog) error,
+) error {
+	logs, _ := f.GetTrafficByTimeRange(ctx, startTime, endTime, limit, offset, filter)
+	for i := range logs {
+		if err := fn(&logs[i]); err != nil {
+			return err
+		}
+	}
+
+	return f.streamErr
+}
+
+func (f *fakeRepository) GetRegionStats(_ context.Context, _, _ time.Time) ([]models.RegionStats, error) {
+	return f.regions, nil
+}
+
+func (f *fakeRepository) GetProtocolBreakdown(_ context.Context, _, _ time.Time) ([]models.ProtocolStats, error) {
+	return f.protocols, nil
+}
+
+func (f *fakeRepository) GetTrafficTimeSeries(
+	_ context.Context, _, _ time.Time, interval time.Duration, _ int,
+) ([]models.TrafficBucket, error) {
+	f.interval = interval
+
+	return []models.TrafficBucket{}, nil
+}
+
+func newTestRouter(t *testing.T, repo *fakeRepository, cfg *config.Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	handler := NewHandler(repo, cfg, zap.NewNop())
+	router := gin.New()
+	router.GET("/stats/traffic", handler.GetTrafficStats)
+	router.GET("/stats/top-domains", handler.GetTopDomains)
+	router.GET("/stats/source-ips", handler.GetTopSourceIPs)
+	router.GET("/logs/traffic", handler.GetTrafficLogs)
+	router.GET("/stats/regions", handler.GetRegionStats)
+	router.GET("/stats/countries", handler.GetCountryStats)
+	router.GET("/stats/protocols", handler.GetProtocolStats)
+	router.GET("/stats/stream", handler.StreamStats)
+	router.GET("/stats/timeseries", handler.GetTrafficTimeSeries)
+	router.GET("/stats/source-ips/:ip/domains", handler.GetDomainsForSourceIP)
+	router.GET("/health", handler.Health)
+	router.GET("/livez", handler.Live)
+
+	return router
+}
+
+func TestInt64AsString(t *testing.T) {
+	const big = int64(9007199254740993) // 2^53 + 1, not representable as a float64
+
+	repo := &fakeRepository{
+		logs: []models.TrafficLog{{
+			ID:        1,
+			SourceIP:  "192.168.1.1",
+			Port:      443,
+			Timestamp: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+			LatencyMs: 45,
+			BytesIn:   big,
+			BytesOut:  512,
+		}},
+		stats: models.TrafficStats{TotalConnections: 3, TotalBytesIn: big},
+	}
+
+	cfg := &config.Config{}
+	cfg.API.Int64AsString = true
+	router := newTestRouter(t, repo, cfg)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/traffic", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var logs []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &logs); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(logs))
+	}
+	if logs[0]["bytes_in"] != "9007199254740993" {
+		t.Errorf("expected bytes_in as string, got %#v", logs[0]["bytes_in"])
+	}
+	if logs[0]["latency_ms"] != "45" {
+		t.Errorf("expected latency_ms as string, got %#v", logs[0]["latency_ms"])
+	}
+	if logs[0]["port"] != float64(443) {
+		t.Errorf("expected port to stay numeric, got %#v", logs[0]["port"])
+	}
+	if logs[0]["timestamp"] != "2025-01-01T12:00:00Z" {
+		t.Errorf("expected RFC3339 timestamp, got %#v", logs[0]["timestamp"])
+	}
+	if _, ok := logs[0]["DeletedAt"]; ok {
+		t.Error("expected json:\"-\" fields to be omitted")
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/traffic", nil))
+
+	var stats map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if stats["total_bytes_in"] != "9007199254740993" {
+		t.Errorf("expected total_bytes_in as string, got %#v", stats["total_bytes_in"])
+	}
+	if stats["avg_latency_ms"] != float64(0) {
+		t.Errorf("expected avg_latency_ms to stay numeric, got %#v", stats["avg_latency_ms"])
+	}
+}
+
+func TestInt64AsNumberByDefault(t *testing.T) {
+	repo := &fakeRepository{stats: models.TrafficStats{TotalBytesIn: 1024}}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/traffic", nil))
+
+	var stats map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if stats["total_bytes_in"] != float64(1024) {
+		t.Errorf("expected numeric total_bytes_in, got %#v", stats["total_bytes_in"])
+	}
+}
+
+func TestGetTrafficLogsRejectsInvalidSourceCIDR(t *testing.T) {
+	router := newTestRouter(t, &fakeRepository{}, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/traffic?source_cidr=10.0.0.0/33", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestGetRegionStats(t *testing.T) {
+	repo := &fakeRepository{regions: []models.RegionStats{
+		{Region: "Europe", Count: 2, TotalBytesIn: 400},
+		{Region: "Asia", Count: 1, TotalBytesIn: 50},
+	}}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/regions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var regions []models.RegionStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &regions); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(regions) != 2 || regions[0].Region != "Europe" || regions[0].Count != 2 {
+		t.Errorf("unexpected regions %+v", regions)
+	}
+}
+
+func TestGetProtocolStats(t *testing.T) {
+	repo := &fakeRepository{protocols: []models.ProtocolStats{
+		{Protocol: "tcp", Category: models.CategoryWeb, Count: 3, TotalBytesIn: 300, AvgLatency: 10},
+		{Protocol: "udp", Category: models.CategoryDNS, Count: 2, TotalBytesIn: 20, AvgLatency: 0},
+		{Protocol: "tcp", Category: models.CategorySSH, Count: 1, TotalBytesOut: 50, AvgLatency: 30},
+		{Protocol: "udp", Category: models.CategoryWeb, Count: 1, TotalBytesIn: 100, AvgLatency: 40},
+	}}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/protocols", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var breakdown models.ProtocolBreakdown
+	if err := json.Unmarshal(rec.Body.Bytes(), &breakdown); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	wantProtocols := []models.ProtocolStats{
+		{Protocol: "tcp", Count: 4, TotalBytesIn: 300, TotalBytesOut: 50, AvgLatency: 15},
+		{Protocol: "udp", Count: 3, TotalBytesIn: 120, AvgLatency: 40.0 / 3},
+	}
+	wantCategories := []models.ProtocolStats{
+		{Category: models.CategoryWeb, Count: 4, TotalBytesIn: 400, AvgLatency: 17.5},
+		{Category: models.CategoryDNS, Count: 2, TotalBytesIn: 20},
+		{Category: models.CategorySSH, Count: 1, TotalBytesOut: 50, AvgLatency: 30},
+	}
+	if !slices.Equal(breakdown.Protocols, wantProtocols) {
+		t.Errorf("expected protocols %+v, got %+v", wantProtocols, breakdown.Protocols)
+	}
+	if !slices.Equal(breakdown.Categories, wantCategories) {
+		t.Errorf("expected categories %+v, got %+v", wantCategories, breakdown.Categories)
+	}
+}
+
+func TestGetTrafficTimeSeriesRejectsInvalidSmooth(t *testing.T) {
+	router := newTestRouter(t, &fakeRepository{}, &config.Config{})
+
+	for _, smooth := range []string{"-1", "abc"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/timeseries?smooth="+smooth, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("smooth=%s: expected status 400, got %d", smooth, rec.Code)
+		}
+	}
+}
+
+func TestGetTrafficTimeSeriesInterval(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		want   time.Duration
+	}{
+		{"", http.StatusOK, time.Hour},
+		{"?interval=5m", http.StatusOK, 5 * time.Minute},
+		{"?interval=1d", http.StatusOK, 24 * time.Hour},
+		{"?interval=2h", http.StatusBadRequest, 0},
+		{"?interval=hour')--", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			repo := &fakeRepository{}
+			router := newTestRouter(t, repo, &config.Config{})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/timeseries"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, rec.Code)
+			}
+			if repo.interval != tt.want {
+				t.Errorf("expected interval %v, got %v", tt.want, repo.interval)
+			}
+		})
+	}
+}
+
+func TestGetDomainsForSourceIPRejectsInvalidIP(t *testing.T) {
+	router := newTestRouter(t, &fakeRepository{}, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/source-ips/not-an-ip/domains", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestMalformedQueryParametersReturn400(t *testing.T) {
+	tests := []struct {
+		url   string
+		field string
+	}{
+		{"/stats/traffic?start=garbage", "start"},
+		{"/stats/traffic?end=2025-13-01", "end"},
+		{"/logs/traffic?start=yesterday", "start"},
+		{"/logs/traffic?limit=ten", "limit"},
+		{"/logs/traffic?offset=-5", "offset"},
+		{"/stats/top-domains?filter=a*.example.com", "filter"},
+		{"/stats/source-ips?filter=10.*.0.1", "filter"},
+		{"/stats/countries?direction=outbound", "direction"},
+		{"/stats/stream?interval=10ms", "interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			router := newTestRouter(t, &fakeRepository{}, &config.Config{})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400, got %d", rec.Code)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if !strings.HasPrefix(body["error"], tt.field+" ") {
+				t.Errorf("expected error naming %q, got %q", tt.field, body["error"])
+			}
+		})
+	}
+}
+
+func TestAbsentQueryParametersUseDefaults(t *testing.T) {
+	router := newTestRouter(t, &fakeRepository{}, &config.Config{})
+
+	for _, url := range []string{"/stats/traffic", "/logs/traffic", "/logs/traffic?start=&limit="} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", url, rec.Code)
+		}
+	}
+}
+
+func TestGetTrafficLogsClampsPageSize(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.MaxPageSize = 1000
+
+	tests := []struct {
+		query   string
+		want    int
+		clamped string
+	}{
+		{"", 100, ""},
+		{"?limit=1000", 1000, ""},
+		{"?limit=100000000", 1000, "1000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			repo := &fakeRepository{}
+			router := newTestRouter(t, repo, cfg)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/traffic"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if repo.limit != tt.want {
+				t.Errorf("expected limit %d, got %d", tt.want, repo.limit)
+			}
+			if got := rec.Header().Get("X-Page-Size-Clamped"); got != tt.clamped {
+				t.Errorf("expected X-Page-Size-Clamped %q, got %q", tt.clamped, got)
+			}
+		})
+	}
+}
+
+func TestGetTrafficLogsSourceIPAndDomainFilters(t *testing.T) {
+	repo := &fakeRepository{}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/traffic?source_ip=10.0.0.1&domain=example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if repo.filter.SourceIP != "10.0.0.1" || repo.filter.Domain != "example.com" {
+		t.Errorf("unexpected filter %+v", repo.filter)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/traffic?source_ip=10.0.0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid source_ip, got %d", rec.Code)
+	}
+}
+
+func TestGetTrafficLogsExportFormats(t *testing.T) {
+	firstByte := int64(130)
+	repo := &fakeRepository{logs: []models.TrafficLog{
+		{
+			ID: 1, SourceIP: "192.168.1.1", Domain: "example.com", Port: 443, FirstByteMs: &firstByte,
+			Timestamp: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), Status: models.StatusSuccess,
+		},
+		{
+			ID: 2, SourceIP: "192.168.1.2", Domain: "a,b.example", Username: "=1+2",
+			Timestamp: time.Date(2025, 1, 1, 12, 0, 1, 0, time.UTC),
+		},
+	}}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/logs/traffic"+query, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := get("?format=csv", "")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || len(lines) != 3 {
+		t.Fatalf("unexpected CSV response %d %q", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(lines[0], "id,uuid,source_ip,") || strings.Contains(lines[0], "DeletedAt") {
+		t.Errorf("expected a header of JSON field names, got %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "1,,192.168.1.1,") || !strings.Contains(lines[1], ",2025-01-01T12:00:00Z,") ||
+		!strings.Contains(lines[1], ",130,") || !strings.Contains(lines[2], `"a,b.example"`) {
+		t.Errorf("unexpected CSV rows %q", lines[1:])
+	}
+	if !strings.Contains(lines[2], ",'=1+2,") {
+		t.Errorf("expected a formula-like username to be escaped, got %q", lines[2])
+	}
+
+	w = get("", "application/x-ndjson")
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if w.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 2 {
+		t.Fatalf("unexpected NDJSON response %q", w.Body.String())
+	}
+	var log models.TrafficLog
+	if err := json.Unmarshal([]byte(lines[1]), &log); err != nil || log.Domain != "a,b.example" {
+		t.Errorf("unexpected NDJSON line %q: %v", lines[1], err)
+	}
+
+	if w := get("?format=json", "text/csv"); !strings.HasPrefix(w.Body.String(), "[") {
+		t.Errorf("expected the format parameter to win over Accept, got %q", w.Body.String())
+	}
+	if w := get("?format=xml", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown format, got %d", w.Code)
+	}
+
+	repo.logs = nil
+	w = get("?format=csv", "")
+	if !strings.HasPrefix(w.Body.String(), "id,") || strings.Count(w.Body.String(), "\n") != 1 {
+		t.Errorf("expected only a header row for no logs, got %q", w.Body.String())
+	}
+}
+
+func TestGetTrafficLogsStreamErrors(t *testing.T) {
+	repo := &fakeRepository{streamErr: errors.New("connection reset")}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs/traffic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 when no log was sent, got %d %q", w.Code, w.Body.String())
+	}
+
+	repo.logs = []models.TrafficLog{{ID: 1, SourceIP: "192.168.1.1"}, {ID: 2, SourceIP: "192.168.1.2"}}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs/traffic", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), `[{"id":1,`) ||
+		strings.HasSuffix(w.Body.String(), "]") {
+		t.Errorf("expected a truncated array once logs were sent, got %d %q", w.Code, w.Body.String())
+	}
+
+	repo.streamErr = nil
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs/traffic", nil))
+	var logs []models.TrafficLog
+	if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil || len(logs) != 2 {
+		t.Errorf("expected both logs as a JSON array, got %q: %v", w.Body.String(), err)
+	}
+
+	repo.logs = nil
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs/traffic", nil))
+	if w.Body.String() != "[]" {
+		t.Errorf("expected an empty array, got %q", w.Body.String())
+	}
+}
+
+func TestHealthChecksDatabase(t *testing.T) {
+	repo := &fakeRepository{}
+	router := newTestRouter(t, repo, &config.Config{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return w
+	}
+
+	if w := get("/health"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the database up, got %d", w.Code)
+	}
+
+	repo.pingErr = errors.New("connection refused")
+	w := get("/health")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"database":"unreachable"`) {
+		t.Errorf("expected 503 naming the database, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/livez"); w.Code != http.StatusOK {
+		t.Errorf("expected liveness to ignore the database, got %d", w.Code)
+	}
+}
+
+func TestStreamStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := storage.NewInMemoryRepository(0)
+	now := time.Now()
+	logs := []*models.TrafficLog{
+		{SourceIP: "10.0.0.1", Domain: "example.com", Timestamp: now.Add(-time.Minute), DurationMs: 30000,
+			BytesIn: 6000, Status: models.StatusSuccess},
+		{SourceIP: "10.0.0.2", Domain: "example.com", Timestamp: now.Add(-50 * time.Second), DurationMs: 30000,
+			BytesIn: 3000, Status: models.StatusSuccess},
+	}
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+
+	handler := NewHandler(repo, &config.Config{}, zap.NewNop())
+	router := gin.New()
+	router.GET("/stats/stream", handler.StreamStats)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/stats/stream?window=5m&interval=1s")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	if event != "event:stats\n" {
+		t.Fatalf("expected a stats event, got %q", event)
+	}
+	var snapshot models.StatsSnapshot
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data:")), &snapshot); err != nil {
+		t.Fatalf("failed to decode snapshot %q: %v", data, err)
+	}
+	if snapshot.Traffic.TotalConnections != 2 || snapshot.BytesInPerSec != 30 || snapshot.PeakConnections != 2 ||
+		len(snapshot.TopDomains) != 1 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	// Closing the streams ends the response instead of leaving it open.
+	handler.CloseStreams()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Errorf("expected the stream to end cleanly, got %v", err)
+	}
+}
+
+func TestStreamStatsCapsSubscribers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.API.MaxStreams = 1
+	handler := NewHandler(storage.NewInMemoryRepository(0), cfg, zap.NewNop())
+	router := gin.New()
+	router.GET("/stats/stream", handler.StreamStats)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	t.Cleanup(handler.CloseStreams)
+
+	first, err := http.Get(server.URL + "/stats/stream")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = first.Body.Close()
+	}()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("expected the first stream to open, got %d", first.StatusCode)
+	}
+
+	second, err := http.Get(server.URL + "/stats/stream")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	_ = second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a second stream to be refused with 503, got %d", second.StatusCode)
+	}
+}
+
+func TestSnapshotCacheSharesSnapshots(t *testing.T) {
+	var cache snapshotCache
+	loads := 0
+	load := func(context.Context, time.Duration, int) (*models.StatsSnapshot, error) {
+		loads++
+
+		return &models.StatsSnapshot{Timestamp: time.Now()}, nil
+	}
+
+	key := snapshotKey{window: time.Minute, limit: 10}
+	for range 3 {
+		if _, err := cache.get(context.Background(), key, load); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected subscribers to share one snapshot, got %d loads", loads)
+	}
+
+	if _, err := cache.get(context.Background(), snapshotKey{window: time.Minute, limit: 5}, load); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loads != 2 {
+		t.Errorf("expected a different limit to take its own snapshot, got %d loads", loads)
+	}
+}
+
+func TestGetQuotaUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := storage.NewInMemoryRepository(10)
+	now := time.Now()
+	for _, log := range []*models.TrafficLog{
+		{SourceIP: "192.0.2.1", Username: "alice", Timestamp: now, BytesIn: 600, BytesOut: 100},
+		{SourceIP: "192.0.2.2", Username: "alice", Timestamp: now, BytesIn: 50},
+	} {
+		if err := repo.SaveTrafficLog(context.Background(), log); err != nil {
+			t.Fatalf("failed to save log: %v", err)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.Proxy.Quota.Enabled = true
+	cfg.Proxy.Quota.WindowMs = 3600000
+	cfg.Proxy.Quota.IPBytes = 500
+	cfg.Proxy.Quota.UserBytes = 1000
+	router := gin.New()
+	router.GET("/stats/quota", NewHandler(repo, cfg, zap.NewNop()).GetQuotaUsage)
+
+	get := func(query string) (int, models.QuotaUsage) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats/quota?"+query, nil))
+		var usage models.QuotaUsage
+		_ = json.Unmarshal(w.Body.Bytes(), &usage)
+
+		return w.Code, usage
+	}
+
+	for _, query := range []string{"", "ip=192.0.2.1&user=alice", "ip=invalid"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, code)
+		}
+	}
+
+	code, usage := get("ip=192.0.2.1")
+	if code != http.StatusOK || usage.SourceIP != "192.0.2.1" || usage.UsedBytes != 700 || usage.LimitBytes != 500 ||
+		!usage.Exceeded {
+		t.Errorf("unexpected IP usage %d %+v", code, usage)
+	}
+	code, usage = get("user=alice")
+	if code != http.StatusOK || usage.Username != "alice" || usage.UsedBytes != 750 || usage.LimitBytes != 1000 ||
+		usage.Exceeded {
+		t.Errorf("unexpected user usage %d %+v", code, usage)
+	}
+	if _, usage = get("ip=198.51.100.1"); usage.UsedBytes != 0 || usage.Exceeded {
+		t.Errorf("expected an unseen IP to have no usage, got %+v", usage)
+	}
+}
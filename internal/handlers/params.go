@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// parseTimeRange reads the optional start and end query parameters as RFC3339
+// timestamps, defaulting to lookback before now and now. A value that is
+// present but malformed is rejected rather than replaced by the default, so
+// clients never silently get data for a different window. On failure it
+// writes a 400 response naming the parameter and returns false.
+func parseTimeRange(c *gin.Context, lookback time.Duration) (start, end time.Time, ok bool) {
+	now := time.Now()
+
+	start, ok = parseTimeQuery(c, "start", now.Add(-lookback))
+	if !ok {
+		return start, end, false
+	}
+	end, ok = parseTimeQuery(c, "end", now)
+
+	return start, end, ok
+}
+
+func parseTimeQuery(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	s, present := c.GetQuery(name)
+	if !present || s == "" {
+		return def, true
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s must be an RFC3339 timestamp such as 2025-01-01T00:00:00Z", name),
+		})
+
+		return time.Time{}, false
+	}
+
+	return parsed, true
+}
+
+// parseIntQuery reads an optional non-negative integer query parameter,
+// returning def when it is absent. On a malformed or negative value it writes
+// a 400 response naming the parameter and returns false.
+func parseIntQuery(c *gin.Context, name string, def int) (int, bool) {
+	s, present := c.GetQuery(name)
+	if !present || s == "" {
+		return def, true
+	}
+
+	parsed, err := strconv.Atoi(s)
+	if err != nil || parsed < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a non-negative integer", name)})
+
+		return 0, false
+	}
+
+	return parsed, true
+}
+
+// parseDurationQuery reads an optional duration query parameter such as "5s",
+// returning def when it is absent. On a malformed value or one below minimum
+// it writes a 400 response naming the parameter and returns false.
+func parseDurationQuery(c *gin.Context, name string, def, minimum time.Duration) (time.Duration, bool) {
+	s, present := c.GetQuery(name)
+	if !present || s == "" {
+		return def, true
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil || parsed < minimum {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s must be a duration of at least %s", name, minimum),
+		})
+
+		return 0, false
+	}
+
+	return parsed, true
+}
+
+// parsePatternQuery reads an optional match pattern with "*" wildcards at
+// either end. On a "*" elsewhere it writes a 400 response naming the
+// parameter and returns false.
+func parsePatternQuery(c *gin.Context, name string) (storage.Pattern, bool) {
+	match, err := storage.ParsePattern(c.Query(name))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s may only use * at its start or end, as in *.example.com", name),
+		})
+
+		return storage.Pattern{}, false
+	}
+
+	return match, true
+}
+
+// clampPageSize caps limit at api.max_page_size so a single request cannot
+// load an unbounded number of rows. When it lowers the limit it reports the
+// applied value in the X-Page-Size-Clamped response header.
+func (h *Handler) clampPageSize(c *gin.Context, limit int) int {
+	maxPageSize := h.cfg.API.MaxPageSize
+	if maxPageSize <= 0 || limit <= maxPageSize {
+		return limit
+	}
+
+	c.Header("X-Page-Size-Clamped", strconv.Itoa(maxPageSize))
+
+	return maxPageSize
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit rejects requests from a client IP that has exceeded limiter's
+// rate with 429 and a Retry-After header in whole seconds.
+func RateLimit(limiter *security.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := c.ClientIP()
+		if limiter.Allow(client) {
+			c.Next()
+
+			return
+		}
+
+		retryAfter := math.Ceil(limiter.RetryAfter(client).Seconds())
+		c.Header("Retry-After", strconv.Itoa(max(1, int(retryAfter))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+	}
+}
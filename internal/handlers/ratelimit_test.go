@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(RateLimit(security.NewRateLimiter(1, 0, true, zap.NewNop())))
+	router.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	// A new client starts with a full bucket of one token.
+	if w := get("192.0.2.1:1000"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	w := get("192.0.2.1:1001")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the bucket is empty, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After: 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	if w := get("192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("expected another client to be unaffected, got %d", w.Code)
+	}
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("failed to set trusted proxies: %v", err)
+	}
+	router.Use(RateLimit(security.NewRateLimiter(1, 0, true, zap.NewNop())))
+	router.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	get := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	// A direct client can't get a fresh bucket by claiming another address.
+	if code := get("192.0.2.1:1000", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := get("192.0.2.1:1001", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected a spoofed X-Forwarded-For to be ignored, got %d", code)
+	}
+
+	// Behind a trusted proxy each forwarded client has its own bucket.
+	if code := get("10.0.0.5:1000", "198.51.100.3"); code != http.StatusOK {
+		t.Errorf("expected the forwarded client to be served, got %d", code)
+	}
+	if code := get("10.0.0.5:1001", "198.51.100.4"); code != http.StatusOK {
+		t.Errorf("expected another forwarded client to be unaffected, got %d", code)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the ID correlating a request with its log entries.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID.
+const requestIDKey = "request_id"
+
+// maxRequestIDLength bounds client-supplied request IDs so they can't bloat
+// log entries.
+const maxRequestIDLength = 128
+
+// RequestLogger logs every request to log once it completes, with its
+// method, path, status, latency, client IP and request ID. The ID is taken
+// from the X-Request-ID header, or generated when absent or malformed, and
+// echoed in the response so clients can quote it.
+func RequestLogger(log *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+			zap.String(requestIDKey, id),
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate).String(); errs != "" {
+			fields = append(fields, zap.String("errors", errs))
+		}
+
+		switch {
+		case status >= 500:
+			log.Error("API request", fields...)
+		case status >= 400:
+			log.Warn("API request", fields...)
+		default:
+			log.Info("API request", fields...)
+		}
+	}
+}
+
+// RequestID returns the ID RequestLogger assigned to the request, or "" when
+// the middleware isn't installed.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII without spaces.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := range len(id) {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// logger returns the handler's logger annotated with the request ID, so
+// error logs can be matched to the request log entry.
+func (h *Handler) logger(c *gin.Context) *zap.Logger {
+	if id := RequestID(c); id != "" {
+		return h.log.With(zap.String(requestIDKey, id))
+	}
+
+	return h.log
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := zap.New(core)
+	handler := NewHandler(&fakeRepository{pingErr: errors.New("connection refused")}, &config.Config{}, log)
+	router := gin.New()
+	router.Use(RequestLogger(log))
+	router.GET("/health", handler.Health)
+	router.GET("/livez", handler.Live)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if got := w.Header().Get(RequestIDHeader); got != "req-42" {
+		t.Errorf("expected the request ID to be propagated, got %q", got)
+	}
+	entries := logs.TakeAll()
+	if len(entries) != 2 {
+		t.Fatalf("expected a handler and a request log entry, got %d", len(entries))
+	}
+	if got := entries[0].ContextMap()["request_id"]; entries[0].Message != "health check failed" || got != "req-42" {
+		t.Errorf("expected the handler log to carry the request ID, got %q %v", entries[0].Message, got)
+	}
+	fields := entries[1].ContextMap()
+	if entries[1].Level != zapcore.ErrorLevel || fields["method"] != http.MethodGet || fields["path"] != "/health" ||
+		fields["status"] != int64(http.StatusServiceUnavailable) || fields["request_id"] != "req-42" ||
+		fields["client_ip"] != "192.0.2.1" {
+		t.Errorf("unexpected request log %v %v", entries[1].Level, fields)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/livez", nil)
+	req.Header.Set(RequestIDHeader, "has spaces")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	id := w.Header().Get(RequestIDHeader)
+	if _, err := uuid.Parse(id); err != nil {
+		t.Errorf("expected a generated UUID for a malformed request ID, got %q", id)
+	}
+	entries = logs.TakeAll()
+	if len(entries) != 1 || entries[0].Level != zapcore.InfoLevel || entries[0].ContextMap()["request_id"] != id {
+		t.Errorf("expected one info request log with the generated ID, got %v", entries)
+	}
+}
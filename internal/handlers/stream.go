@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// minStreamInterval bounds how often stats streams query the database: a
+// snapshot is shared by every stream with the same window and limit for this
+// long.
+const minStreamInterval = time.Second
+
+// StreamStats pushes a models.StatsSnapshot as a server-sent "stats" event
+// immediately and then every interval query parameter (default 5s), covering
+// the last window (default 5m) and the top limit domains (default 10). A
+// failed update is sent as an "error" event and the stream carries on. The
+// stream ends when the client disconnects or CloseStreams is called. Once
+// api.max_streams streams are open, further ones are refused with 503.
+func (h *Handler) StreamStats(c *gin.Context) {
+	interval, ok := parseDurationQuery(c, "interval", 5*time.Second, minStreamInterval)
+	if !ok {
+		return
+	}
+	window, ok := parseDurationQuery(c, "window", 5*time.Minute, time.Second)
+	if !ok {
+		return
+	}
+	limit, ok := parseIntQuery(c, "limit", 10)
+	if !ok {
+		return
+	}
+	limit = h.clampPageSize(c, limit)
+
+	open := h.openStreams.Add(1)
+	defer h.openStreams.Add(-1)
+	if maxStreams := h.cfg.API.MaxStreams; maxStreams > 0 && open > int64(maxStreams) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many open stats streams"})
+
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := h.snapshots.get(ctx, snapshotKey{window, limit}, h.statsSnapshot)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			h.logger(c).Error("failed to get live stats", zap.Error(err))
+			c.SSEvent("error", gin.H{"error": "Failed to retrieve stats"})
+		case h.cfg.API.Int64AsString:
+			c.SSEvent("stats", stringifyInt64(reflect.ValueOf(snapshot)))
+		default:
+			c.SSEvent("stats", snapshot)
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.streams:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CloseStreams ends every open event stream, so a graceful shutdown doesn't
+// wait for their clients to disconnect.
+func (h *Handler) CloseStreams() {
+	h.closeOnce.Do(func() {
+		close(h.streams)
+	})
+}
+
+// snapshotKey identifies the snapshots that streams can share.
+type snapshotKey struct {
+	window time.Duration
+	limit  int
+}
+
+// snapshotCache holds the latest snapshot for each window and limit, so
+// streams asking for the same ones query the database once between them.
+type snapshotCache struct {
+	mu      sync.Mutex
+	entries map[snapshotKey]*models.StatsSnapshot
+}
+
+// get returns the cached snapshot for key if it is younger than
+// minStreamInterval, and otherwise takes a new one with load. Loads are
+// serialized so concurrent streams wait for one another instead of each
+// querying the database.
+func (sc *snapshotCache) get(ctx context.Context, key snapshotKey,
+	load func(context.Context, time.Duration, int) (*models.StatsSnapshot, error),
+) (*models.StatsSnapshot, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	if snapshot, ok := sc.entries[key]; ok && now.Sub(snapshot.Timestamp) < minStreamInterval {
+		return snapshot, nil
+	}
+	for k, snapshot := range sc.entries {
+		if now.Sub(snapshot.Timestamp) >= minStreamInterval {
+			delete(sc.entries, k)
+		}
+	}
+
+	snapshot, err := load(ctx, key.window, key.limit)
+	if err != nil {
+		return nil, err
+	}
+	if sc.entries == nil {
+		sc.entries = make(map[snapshotKey]*models.StatsSnapshot)
+	}
+	sc.entries[key] = snapshot
+
+	return snapshot, nil
+}
+
+// statsSnapshot aggregates the traffic of the last window with the same
+// queries as /stats/traffic, /stats/concurrency and /stats/top-domains.
+func (h *Handler) statsSnapshot(ctx context.Context, window time.Duration, limit int) (*models.StatsSnapshot, error) {
+	now := time.Now()
+	snapshot := &models.StatsSnapshot{Timestamp: now, WindowStart: now.Add(-window)}
+
+	traffic, err := h.repo.GetTrafficStats(ctx, snapshot.WindowStart, now)
+	if err != nil {
+		return nil, fmt.Errorf("traffic stats: %w", err)
+	}
+	snapshot.Traffic = *traffic
+	snapshot.BytesInPerSec = float64(traffic.TotalBytesIn) / window.Seconds()
+	snapshot.BytesOutPerSec = float64(traffic.TotalBytesOut) / window.Seconds()
+
+	buckets, err := h.repo.GetConcurrentConnections(ctx, snapshot.WindowStart, now, window, 0)
+	if err != nil {
+		return nil, fmt.Errorf("concurrent connections: %w", err)
+	}
+	for _, bucket := range buckets {
+		snapshot.PeakConnections = max(snapshot.PeakConnections, bucket.MaxConcurrent)
+	}
+
+	snapshot.TopDomains, err = h.repo.GetTopDomains(ctx, limit, storage.Pattern{})
+	if err != nil {
+		return nil, fmt.Errorf("top domains: %w", err)
+	}
+
+	return snapshot, nil
+}
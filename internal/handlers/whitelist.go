@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GetWhitelist returns the proxy's IP whitelist: the entries fixed by
+// proxy.ip_whitelist and those managed at runtime through the API.
+func (h *Handler) GetWhitelist(c *gin.Context) {
+	entries, err := h.repo.GetWhitelistEntries(c.Request.Context())
+	if err != nil {
+		h.logger(c).Error("failed to get whitelist entries", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve whitelist"})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"config": h.configWhitelist(), "entries": entries})
+}
+
+// AddWhitelistEntry adds the IP or CIDR range in the "entry" field of the
+// JSON body to the managed whitelist, with an optional "comment". Adding an
+// existing entry updates its comment.
+func (h *Handler) AddWhitelistEntry(c *gin.Context) {
+	var body struct {
+		Entry   string `json:"entry" binding:"required"`
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object with an entry"})
+
+		return
+	}
+	canonical, err := security.CanonicalWhitelistEntry(body.Entry)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry must be an IP address or CIDR range"})
+
+		return
+	}
+
+	entry := &models.WhitelistEntry{Entry: canonical, Comment: body.Comment}
+	if err := h.repo.AddWhitelistEntry(c.Request.Context(), entry); err != nil {
+		h.logger(c).Error("failed to add whitelist entry", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add whitelist entry"})
+
+		return
+	}
+	h.logger(c).Info("Whitelist entry added", zap.String("entry", canonical), zap.String("comment", body.Comment))
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// DeleteWhitelistEntry removes the IP or CIDR range in the path, e.g.
+// /admin/whitelist/10.0.0.0/8, from the managed whitelist. Entries from
+// proxy.ip_whitelist can only be removed from the config.
+func (h *Handler) DeleteWhitelistEntry(c *gin.Context) {
+	canonical, err := security.CanonicalWhitelistEntry(strings.TrimPrefix(c.Param("entry"), "/"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "entry must be an IP address or CIDR range"})
+
+		return
+	}
+
+	deleted, err := h.repo.DeleteWhitelistEntry(c.Request.Context(), canonical)
+	if err != nil {
+		h.logger(c).Error("failed to delete whitelist entry", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete whitelist entry"})
+
+		return
+	}
+	if !deleted {
+		if slices.Contains(h.configWhitelist(), canonical) {
+			c.JSON(http.StatusConflict, gin.H{"error": "entry is set by proxy.ip_whitelist; remove it from the config"})
+
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "entry is not in the whitelist"})
+
+		return
+	}
+	h.logger(c).Info("Whitelist entry deleted", zap.String("entry", canonical))
+
+	c.Status(http.StatusNoContent)
+}
+
+// configWhitelist returns proxy.ip_whitelist in canonical form.
+func (h *Handler) configWhitelist() []string {
+	entries := make([]string, 0, len(h.cfg.Proxy.IPWhitelist))
+	for _, entry := range h.cfg.Proxy.IPWhitelist {
+		if canonical, err := security.CanonicalWhitelistEntry(entry); err == nil {
+			entries = append(entries, canonical)
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,385 @@
+// Package importer bulk-loads historical traffic logs from CSV or JSONL files.
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Format identifies the encoding of an import file.
+type Format string
+
+// Supported import formats.
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// csvColumns are the accepted CSV header names, matching TrafficLog JSON fields.
+var csvColumns = []string{
+	"source_ip", "destination_ip", "domain", "port", "timestamp",
+	"latency_ms", "bytes_in", "bytes_out", "protocol",
+}
+
+// RowError describes a record that failed to parse or validate.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Result summarizes an import run.
+type Result struct {
+	Imported int
+	Errors   []*RowError
+	// Truncated is set when the file ended in a partial JSONL record, as left
+	// behind by a writer killed mid-write. The partial record is skipped.
+	Truncated bool
+}
+
+// errTruncatedRecord marks an unparseable final JSONL line that has no
+// trailing newline, i.e. a record cut short rather than a malformed one.
+var errTruncatedRecord = errors.New("truncated final record")
+
+// Importer reads traffic log records and saves them in batches.
+type Importer struct {
+	repo        storage.Repository
+	batchSize   int
+	skipInvalid bool
+	log         *zap.Logger
+}
+
+// New creates an importer. When skipInvalid is true, bad rows are reported in
+// the Result and skipped; otherwise the first bad row aborts the import.
+func New(repo storage.Repository, batchSize int, skipInvalid bool, log *zap.Logger) *Importer {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	return &Importer{
+		repo:        repo,
+		batchSize:   batchSize,
+		skipInvalid: skipInvalid,
+		log:         log,
+	}
+}
+
+// DetectFormat infers the import format from a file extension.
+func DetectFormat(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("cannot detect import format of %s", path)
+	}
+}
+
+// ImportFile imports the file at path, detecting its format from the extension
+// when format is empty.
+func (i *Importer) ImportFile(ctx context.Context, path string, format Format) (*Result, error) {
+	if format == "" {
+		detected, err := DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
+
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return i.Import(ctx, f, format)
+}
+
+// Import reads records in the given format from r and saves them.
+func (i *Importer) Import(ctx context.Context, r io.Reader, format Format) (*Result, error) {
+	var next func() (*models.TrafficLog, int, error)
+
+	switch format {
+	case FormatCSV:
+		reader, err := newCSVReader(r)
+		if err != nil {
+			return nil, err
+		}
+		next = reader.next
+	case FormatJSONL:
+		next = newJSONLReader(r).next
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+
+	result := &Result{}
+	batch := make([]*models.TrafficLog, 0, i.batchSize)
+
+	for {
+		log, line, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if errors.Is(err, errTruncatedRecord) {
+			i.log.Warn("skipping truncated final import record", zap.Int("line", line))
+			result.Truncated = true
+
+			break
+		}
+
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			if !i.skipInvalid {
+				return result, rowErr
+			}
+			i.log.Warn("skipping invalid import row", zap.Int("line", rowErr.Line), zap.Error(rowErr.Err))
+			result.Errors = append(result.Errors, rowErr)
+
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read line %d: %w", line, err)
+		}
+
+		batch = append(batch, log)
+		if len(batch) >= i.batchSize {
+			if err := i.flush(ctx, batch, result); err != nil {
+				return result, err
+			}
+			batch = make([]*models.TrafficLog, 0, i.batchSize)
+		}
+	}
+
+	if err := i.flush(ctx, batch, result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (i *Importer) flush(ctx context.Context, batch []*models.TrafficLog, result *Result) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := i.repo.SaveTrafficLogs(ctx, batch); err != nil {
+		return fmt.Errorf("failed to save imported batch: %w", err)
+	}
+	result.Imported += len(batch)
+
+	return nil
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for idx, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = idx
+	}
+
+	for _, required := range []string{"source_ip", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing required column %q", required)
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvReader) next() (*models.TrafficLog, int, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, parseErr.Line, &RowError{Line: parseErr.Line, Err: parseErr.Err}
+		}
+
+		return nil, 0, err
+	}
+	line, _ := c.reader.FieldPos(0)
+
+	fields := make(map[string]string, len(csvColumns))
+	for _, name := range csvColumns {
+		if idx, ok := c.columns[name]; ok && idx < len(record) {
+			fields[name] = unescapeFormula(strings.TrimSpace(record[idx]))
+		}
+	}
+
+	log, err := parseFields(fields)
+	if err != nil {
+		return nil, line, &RowError{Line: line, Err: err}
+	}
+
+	return log, line, nil
+}
+
+// unescapeFormula removes the quote the API's CSV export puts before values
+// starting with a formula character, so exported files load back unchanged.
+func unescapeFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+
+	return s
+}
+
+func parseFields(fields map[string]string) (*models.TrafficLog, error) {
+	log := &models.TrafficLog{
+		SourceIP:      fields["source_ip"],
+		DestinationIP: fields["destination_ip"],
+		Domain:        fields["domain"],
+		Protocol:      fields["protocol"],
+	}
+
+	var err error
+	if log.Timestamp, err = time.Parse(time.RFC3339, fields["timestamp"]); err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q", fields["timestamp"])
+	}
+
+	ints := []struct {
+		name string
+		dst  *int64
+	}{
+		{"latency_ms", &log.LatencyMs},
+		{"bytes_in", &log.BytesIn},
+		{"bytes_out", &log.BytesOut},
+	}
+	for _, f := range ints {
+		if fields[f.name] == "" {
+			continue
+		}
+		if *f.dst, err = strconv.ParseInt(fields[f.name], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", f.name, fields[f.name])
+		}
+	}
+
+	if fields["port"] != "" {
+		if log.Port, err = strconv.Atoi(fields["port"]); err != nil {
+			return nil, fmt.Errorf("invalid port %q", fields["port"])
+		}
+	}
+
+	if err := validate(log); err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+	// terminated reports whether the last scanned line ended in a newline.
+	terminated bool
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	j := &jsonlReader{scanner: bufio.NewScanner(r)}
+	j.scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	j.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance > 0 {
+			j.terminated = data[advance-1] == '\n'
+		}
+
+		return advance, token, err
+	})
+
+	return j
+}
+
+func (j *jsonlReader) next() (*models.TrafficLog, int, error) {
+	for j.scanner.Scan() {
+		j.line++
+
+		raw := strings.TrimSpace(j.scanner.Text())
+		if raw == "" {
+			continue
+		}
+
+		var log models.TrafficLog
+		if err := json.Unmarshal([]byte(raw), &log); err != nil {
+			if !j.terminated {
+				return nil, j.line, errTruncatedRecord
+			}
+
+			return nil, j.line, &RowError{Line: j.line, Err: fmt.Errorf("invalid JSON: %w", err)}
+		}
+
+		// Storage assigns identity and bookkeeping columns.
+		log.ID = 0
+		log.CreatedAt = time.Time{}
+
+		if err := validate(&log); err != nil {
+			return nil, j.line, &RowError{Line: j.line, Err: err}
+		}
+
+		return &log, j.line, nil
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return nil, j.line, err
+	}
+
+	return nil, j.line, io.EOF
+}
+
+// validate checks that an imported record is usable.
+func validate(log *models.TrafficLog) error {
+	if net.ParseIP(log.SourceIP) == nil {
+		return fmt.Errorf("invalid source_ip %q", log.SourceIP)
+	}
+	if log.Timestamp.IsZero() {
+		return errors.New("missing timestamp")
+	}
+	if log.Port < 0 || log.Port > 65535 {
+		return fmt.Errorf("port %d out of range", log.Port)
+	}
+	if log.LatencyMs < 0 || log.BytesIn < 0 || log.BytesOut < 0 {
+		return errors.New("latency and byte counts must not be negative")
+	}
+	if log.Protocol == "" {
+		log.Protocol = "tcp"
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// recordingRepository captures saved logs; only SaveTrafficLogs is exercised.
+type recordingRepository struct {
+	storage.Repository
+	saved []*models.TrafficLog
+}
+
+func (r *recordingRepository) SaveTrafficLogs(_ context.Context, logs []*models.TrafficLog) error {
+	r.saved = append(r.saved, logs...)
+
+	return nil
+}
+
+func TestImportSkipsBadRows(t *testing.T) {
+	tests := []struct {
+		file    string
+		badLine int
+	}{
+		{"testdata/traffic.csv", 3},
+		{"testdata/traffic.jsonl", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			repo := &recordingRepository{}
+			imp := New(repo, 1, true, zap.NewNop())
+
+			result, err := imp.ImportFile(context.Background(), tt.file, "")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if result.Imported != 2 || len(repo.saved) != 2 {
+				t.Fatalf("expected 2 imported rows, got %d (saved %d)", result.Imported, len(repo.saved))
+			}
+			if repo.saved[0].SourceIP != "192.168.1.10" || repo.saved[1].SourceIP != "192.168.1.12" {
+				t.Errorf("unexpected rows saved: %s, %s", repo.saved[0].SourceIP, repo.saved[1].SourceIP)
+			}
+			if repo.saved[0].BytesIn != 1024 || repo.saved[0].Port != 443 {
+				t.Errorf("unexpected field values: %+v", repo.saved[0])
+			}
+
+			if len(result.Errors) != 1 {
+				t.Fatalf("expected 1 row error, got %d", len(result.Errors))
+			}
+			if result.Errors[0].Line != tt.badLine {
+				t.Errorf("expected error on line %d, got %d", tt.badLine, result.Errors[0].Line)
+			}
+		})
+	}
+}
+
+func TestImportAbortsOnBadRow(t *testing.T) {
+	repo := &recordingRepository{}
+	imp := New(repo, 100, false, zap.NewNop())
+
+	result, err := imp.ImportFile(context.Background(), "testdata/traffic.csv", "")
+
+	var rowErr *RowError
+	if !errors.As(err, &rowErr) {
+		t.Fatalf("expected a RowError, got %v", err)
+	}
+	if rowErr.Line != 3 {
+		t.Errorf("expected error on line 3, got %d", rowErr.Line)
+	}
+	if result.Imported != 0 || len(repo.saved) != 0 {
+		t.Errorf("expected nothing saved before the batch filled, got %d", len(repo.saved))
+	}
+}
+
+func TestImportSkipsTruncatedFinalRecord(t *testing.T) {
+	input := `{"source_ip":"192.168.1.10","port":443,"timestamp":"2025-01-01T12:00:00Z"}
+{"source_ip":"192.168.1.11","port":80,"timestamp":"2025-01-01T12:01:00Z"}
+{"source_ip":"192.168.1.12","port":4`
+
+	repo := &recordingRepository{}
+	imp := New(repo, 100, false, zap.NewNop())
+
+	result, err := imp.Import(context.Background(), strings.NewReader(input), FormatJSONL)
+	if err != nil {
+		t.Fatalf("expected truncated record to be skipped, got %v", err)
+	}
+	if !result.Truncated {
+		t.Error("expected result to report the truncated record")
+	}
+	if result.Imported != 2 || len(repo.saved) != 2 || repo.saved[1].SourceIP != "192.168.1.11" {
+		t.Errorf("expected the 2 complete records to load, got %d", result.Imported)
+	}
+
+	// The same bytes followed by a newline are a complete but malformed record.
+	_, err = imp.Import(context.Background(), strings.NewReader(input+"\n"), FormatJSONL)
+	var rowErr *RowError
+	if !errors.As(err, &rowErr) || rowErr.Line != 3 {
+		t.Errorf("expected a RowError on line 3 for a terminated malformed record, got %v", err)
+	}
+}
+
+func TestImportUnescapesFormulaValues(t *testing.T) {
+	input := "source_ip,domain,timestamp\n" +
+		"192.168.1.10,'=cmd.example,2025-01-01T12:00:00Z\n" +
+		"192.168.1.11,'example.com,2025-01-01T12:00:00Z\n"
+
+	repo := &recordingRepository{}
+	imp := New(repo, 100, false, zap.NewNop())
+
+	if _, err := imp.Import(context.Background(), strings.NewReader(input), FormatCSV); err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	if len(repo.saved) != 2 || repo.saved[0].Domain != "=cmd.example" {
+		t.Fatalf("expected the export's formula escaping to be removed, got %d logs", len(repo.saved))
+	}
+	if repo.saved[1].Domain != "'example.com" {
+		t.Errorf("expected other quotes to be kept, got %q", repo.saved[1].Domain)
+	}
+}
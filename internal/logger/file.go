@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// reopenableFile is a zapcore.WriteSyncer backed by a file that can be
+// reopened in place, so writes follow the path after external log rotation.
+type reopenableFile struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openReopenableFile(path string) (*reopenableFile, error) {
+	f := &reopenableFile{path: filepath.Clean(path)}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write appends p to the current file.
+func (f *reopenableFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Write(p)
+}
+
+// Sync flushes the current file to disk.
+func (f *reopenableFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Sync()
+}
+
+// Reopen closes the current file handle and opens the path again, creating a
+// new file if the old one was moved away.
+func (f *reopenableFile) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	old := f.file
+	f.file = file
+	f.mu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+
+	return nil
+}
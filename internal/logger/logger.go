@@ -3,6 +3,8 @@ package logger
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -11,6 +13,8 @@ import (
 // Logger wraps zap.Logger with additional formatting methods.
 type Logger struct {
 	*zap.Logger
+	file  *reopenableFile
+	level zap.AtomicLevel
 }
 
 // GetZapLogger returns the underlying zap.Logger.
@@ -18,8 +22,9 @@ func (l *Logger) GetZapLogger() *zap.Logger {
 	return l.Logger
 }
 
-// New creates a new logger with the specified log level.
-func New(level string) (*Logger, error) {
+// New creates a new logger with the specified log level. When file is not
+// empty, logs are appended to that file instead of stdout.
+func New(level, file string) (*Logger, error) {
 	var config zap.Config
 
 	switch level {
@@ -45,12 +50,107 @@ func New(level string) (*Logger, error) {
 	config.EncoderConfig.TimeKey = "timestamp"
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	logger, err := config.Build()
+	if file == "" {
+		logger, err := config.Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build logger: %w", err)
+		}
+
+		return &Logger{Logger: logger, level: config.Level}, nil
+	}
+
+	sink, err := openReopenableFile(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build logger: %w", err)
+		return nil, err
+	}
+
+	encoder := zapcore.NewJSONEncoder(config.EncoderConfig)
+	if config.Encoding == "console" {
+		encoder = zapcore.NewConsoleEncoder(config.EncoderConfig)
 	}
 
-	return &Logger{logger}, nil
+	logger := zap.New(
+		zapcore.NewCore(encoder, sink, config.Level),
+		zap.AddCaller(),
+		zap.AddStacktrace(zap.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+	)
+
+	return &Logger{Logger: logger, file: sink, level: config.Level}, nil
+}
+
+// Level returns the current minimum level, e.g. "info".
+func (l *Logger) Level() string {
+	return l.level.String()
+}
+
+// SetLevel changes the minimum level of the running logger to one of
+// "debug", "info", "warn" or "error" and returns the previous level. The
+// encoding chosen at startup is kept.
+func (l *Logger) SetLevel(level string) (string, error) {
+	var parsed zapcore.Level
+	switch level {
+	case "debug":
+		parsed = zap.DebugLevel
+	case "info":
+		parsed = zap.InfoLevel
+	case "warn":
+		parsed = zap.WarnLevel
+	case "error":
+		parsed = zap.ErrorLevel
+	default:
+		return "", fmt.Errorf("unknown log level %q", level)
+	}
+
+	previous := l.level.String()
+	l.level.SetLevel(parsed)
+
+	return previous, nil
+}
+
+// Reopen reopens the log file so writes go to a fresh file after external
+// rotation (e.g. logrotate moving the old file away). It is a no-op when
+// logging to stdout.
+func (l *Logger) Reopen() error {
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Reopen()
+}
+
+// ReopenOnSignal reopens the log file whenever one of sigs is received, which
+// is the classic SIGHUP convention for cooperating with logrotate. The
+// returned function stops watching for the signals.
+func (l *Logger) ReopenOnSignal(sigs ...os.Signal) func() {
+	if l.file == nil {
+		return func() {}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigChan, sigs...)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigChan:
+				if err := l.Reopen(); err != nil {
+					l.Error("failed to reopen log file", zap.Error(err))
+
+					continue
+				}
+				l.Info("log file reopened")
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
 }
 
 // Fatal logs a fatal error and exits the application.
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	log, err := New("info", path)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	log.Debug("hidden")
+	previous, err := log.SetLevel("debug")
+	if err != nil || previous != "info" {
+		t.Fatalf("expected the previous level info, got %q, %v", previous, err)
+	}
+	log.Debug("shown")
+
+	if _, err := log.SetLevel("verbose"); err == nil || log.Level() != "debug" {
+		t.Errorf("expected an unknown level to be rejected and the level kept, got %v, %s", err, log.Level())
+	}
+
+	content, _ := os.ReadFile(path)
+	if strings.Contains(string(content), "hidden") || !strings.Contains(string(content), "shown") {
+		t.Errorf("expected only the message logged after the change, got %q", content)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.log")
+
+	log, err := New("info", path)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	stop := log.ReopenOnSignal(syscall.SIGHUP)
+	defer stop()
+
+	log.Info("before rotation")
+
+	// Simulate logrotate moving the file away.
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("failed to rotate log file: %v", err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("failed to send SIGHUP: %v", err)
+	}
+
+	// Wait for the reopen to create the new file.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log file was not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	log.Info("after rotation")
+	_ = log.Sync()
+
+	newContent, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read new log file: %v", err)
+	}
+	oldContent, err := os.ReadFile(rotated)
+	if err != nil {
+		t.Fatalf("failed to read rotated log file: %v", err)
+	}
+
+	if !strings.Contains(string(newContent), "after rotation") {
+		t.Errorf("expected new file to contain post-rotation line, got %q", newContent)
+	}
+	if strings.Contains(string(oldContent), "after rotation") {
+		t.Error("expected rotated file not to receive post-rotation lines")
+	}
+	if !strings.Contains(string(oldContent), "before rotation") {
+		t.Errorf("expected rotated file to keep pre-rotation line, got %q", oldContent)
+	}
+}
@@ -2,46 +2,85 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/pprof"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"net/http"
 )
 
+// TraceIDFunc returns the trace ID carried by ctx, or "" when there is none.
+type TraceIDFunc func(ctx context.Context) string
+
 // Metrics holds all Prometheus metrics.
 type Metrics struct {
 	// Connection metrics
-	ActiveConnections prometheus.Gauge
-	TotalConnections  prometheus.Counter
-	ClosedConnections prometheus.Counter
+	ActiveConnections      prometheus.Gauge
+	TotalConnections       prometheus.Counter
+	ClosedConnections      prometheus.Counter
+	BlockedDestinations    prometheus.Counter
+	EgressDenied           prometheus.Counter
+	RejectedConnections    prometheus.Counter
+	WhitelistRejections    prometheus.Counter
+	AuthFailures           prometheus.Counter
+	RateLimitedConnections prometheus.Counter
+	IdleTimeouts           prometheus.Counter
+	QuotaRejections        prometheus.Counter
 
 	// Traffic metrics
 	BytesIn  prometheus.Counter
 	BytesOut prometheus.Counter
 
+	// CompressionBytes counts the bytes of compressed client tunnels by
+	// layer (wire or logical) and direction (in or out).
+	CompressionBytes *prometheus.CounterVec
+
 	// Latency metrics
 	LatencyHistogram prometheus.Histogram
 
 	// Pipeline metrics
-	EventsCollected   prometheus.Counter
-	EventsProcessed   prometheus.Counter
-	EventsPublished   prometheus.Counter
-	ProcessingLatency prometheus.Histogram
+	EventsCollected    prometheus.Counter
+	EventsProcessed    prometheus.Counter
+	EventsPublished    prometheus.Counter
+	ProcessingLatency  prometheus.Histogram
+	AnalyticsEnabled   prometheus.Gauge
+	ReverseDNSFailures prometheus.Counter
+	AnomaliesDetected  prometheus.Counter
+	TailDrops          prometheus.Counter
+	// EventsDropped is labeled by the pipeline stage that dropped the
+	// event, "collector" or "normalizer".
+	EventsDropped *prometheus.CounterVec
+	// QueueDepth and QueueCapacity are labeled by pipeline stage: the
+	// collector and normalizer channels and the publisher's failed batches
+	// held for retry.
+	QueueDepth    *prometheus.GaugeVec
+	QueueCapacity *prometheus.GaugeVec
 
 	// Database metrics
-	DBQueryDuration prometheus.Histogram
-	DBErrors        prometheus.Counter
+	DBQueryDuration  prometheus.Histogram
+	DBErrors         prometheus.Counter
+	RetentionDeletes prometheus.Counter
+
+	traceID  TraceIDFunc
+	pprof    bool
+	registry *prometheus.Registry
 }
 
-// NewMetrics creates and registers all metrics.
+// NewMetrics creates all metrics and registers them with a registry owned by
+// the returned Metrics, so calling it more than once does not conflict and
+// metrics registered globally by libraries are not exposed.
 func NewMetrics() (*Metrics, error) {
-	m := &Metrics{}
+	m := &Metrics{registry: prometheus.NewRegistry()}
 	m.initializeConnectionMetrics()
 	m.initializeTrafficMetrics()
 	m.initializePipelineMetrics()
 	m.initializeDatabaseMetrics()
-	m.registerAllMetrics()
+	if err := m.registerAllMetrics(); err != nil {
+		return nil, err
+	}
 
 	return m, nil
 }
@@ -59,6 +98,38 @@ func (m *Metrics) initializeConnectionMetrics() {
 		Name: "socks5_proxy_closed_connections",
 		Help: "Total number of closed proxy connections",
 	})
+	m.BlockedDestinations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_blocked_destinations_total",
+		Help: "Total number of dials refused because the destination is a private or internal address",
+	})
+	m.EgressDenied = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_egress_denied_total",
+		Help: "Total number of connections refused by the egress allow/deny rules",
+	})
+	m.RejectedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_rejected_connections_total",
+		Help: "Total number of client connections closed because proxy.max_connections was reached",
+	})
+	m.WhitelistRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_whitelist_rejections_total",
+		Help: "Total number of client connections refused because the source IP is not in proxy.ip_whitelist",
+	})
+	m.AuthFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_auth_failures_total",
+		Help: "Total number of SOCKS5 username/password authentication attempts that failed",
+	})
+	m.RateLimitedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_rate_limited_connections_total",
+		Help: "Total number of client connections refused because the source IP exceeded rate_limit",
+	})
+	m.IdleTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_idle_timeouts_total",
+		Help: "Total number of proxied connections closed because they were idle for proxy.idle_timeout_ms",
+	})
+	m.QuotaRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_proxy_quota_rejections_total",
+		Help: "Total number of connections refused because the source IP or user exceeded its proxy.quota",
+	})
 }
 
 func (m *Metrics) initializeTrafficMetrics() {
@@ -70,6 +141,10 @@ func (m *Metrics) initializeTrafficMetrics() {
 		Name: "socks5_proxy_bytes_out_total",
 		Help: "Total bytes sent by proxy",
 	})
+	m.CompressionBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "socks5_proxy_compression_bytes_total",
+		Help: "Bytes of compressed client tunnels, on the wire and uncompressed, by direction",
+	}, []string{"layer", "direction"})
 	m.LatencyHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "socks5_proxy_latency_ms",
 		Help:    "Distribution of connection latencies in milliseconds",
@@ -95,6 +170,34 @@ func (m *Metrics) initializePipelineMetrics() {
 		Help:    "Pipeline event processing latency in milliseconds",
 		Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500},
 	})
+	m.AnalyticsEnabled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pipeline_analytics_enabled",
+		Help: "1 while analytics collection is on, 0 while it is switched off",
+	})
+	m.ReverseDNSFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pipeline_reverse_dns_failures_total",
+		Help: "Total reverse DNS lookups that failed, timed out or were dropped because every worker was busy",
+	})
+	m.EventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_events_dropped_total",
+		Help: "Total events dropped by each pipeline stage because the next one was full or the stage was closed",
+	}, []string{"stage"})
+	m.AnomaliesDetected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pipeline_anomalies_detected_total",
+		Help: "Total source IP traffic spikes flagged by the anomaly detector",
+	})
+	m.TailDrops = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pipeline_tail_dropped_clients_total",
+		Help: "Total live-tail clients disconnected for falling behind the pipeline",
+	})
+	m.QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pipeline_queue_depth",
+		Help: "Items buffered in each pipeline queue, sampled every health.sample_interval_ms",
+	}, []string{"queue"})
+	m.QueueCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pipeline_queue_capacity",
+		Help: "Capacity of each pipeline queue; for the publisher, pipeline.retry.max_held_batches",
+	}, []string{"queue"})
 }
 
 func (m *Metrics) initializeDatabaseMetrics() {
@@ -107,29 +210,119 @@ func (m *Metrics) initializeDatabaseMetrics() {
 		Name: "db_errors_total",
 		Help: "Total database errors",
 	})
+	m.RetentionDeletes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "db_retention_deleted_rows_total",
+		Help: "Total traffic logs deleted for being older than retention.max_age",
+	})
 }
 
-func (m *Metrics) registerAllMetrics() {
-	prometheus.MustRegister(
+// EnableExemplars attaches a trace_id exemplar, looked up with traceID, to
+// latency observations so dashboards can jump from a bucket to a trace.
+func (m *Metrics) EnableExemplars(traceID TraceIDFunc) {
+	m.traceID = traceID
+}
+
+// ObserveLatency records a connection latency in milliseconds.
+func (m *Metrics) ObserveLatency(ctx context.Context, ms float64) {
+	m.observe(ctx, m.LatencyHistogram, ms)
+}
+
+// ObserveProcessingLatency records a pipeline processing latency in milliseconds.
+func (m *Metrics) ObserveProcessingLatency(ctx context.Context, ms float64) {
+	m.observe(ctx, m.ProcessingLatency, ms)
+}
+
+func (m *Metrics) observe(ctx context.Context, histogram prometheus.Histogram, value float64) {
+	if m.traceID != nil {
+		if id := m.traceID(ctx); id != "" {
+			if eo, ok := histogram.(prometheus.ExemplarObserver); ok {
+				eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": id})
+
+				return
+			}
+		}
+	}
+
+	histogram.Observe(value)
+}
+
+func (m *Metrics) registerAllMetrics() error {
+	all := []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		m.ActiveConnections,
 		m.TotalConnections,
 		m.ClosedConnections,
+		m.BlockedDestinations,
+		m.EgressDenied,
+		m.RejectedConnections,
+		m.WhitelistRejections,
+		m.AuthFailures,
+		m.RateLimitedConnections,
+		m.IdleTimeouts,
+		m.QuotaRejections,
 		m.BytesIn,
 		m.BytesOut,
+		m.CompressionBytes,
 		m.LatencyHistogram,
 		m.EventsCollected,
 		m.EventsProcessed,
 		m.EventsPublished,
 		m.ProcessingLatency,
+		m.AnalyticsEnabled,
+		m.ReverseDNSFailures,
+		m.EventsDropped,
+		m.AnomaliesDetected,
+		m.TailDrops,
+		m.QueueDepth,
+		m.QueueCapacity,
 		m.DBQueryDuration,
 		m.DBErrors,
-	)
+		m.RetentionDeletes,
+	}
+	for _, collector := range all {
+		if err := m.registry.Register(collector); err != nil {
+			return fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Handler returns an HTTP handler serving the metrics in m's registry.
+func (m *Metrics) Handler() http.Handler {
+	// OpenMetrics is required for exemplars to be exposed.
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+}
+
+// EnablePprof also serves the net/http/pprof profiles under /debug/pprof/
+// on the metrics server. They expose internals and can be expensive to
+// collect, so only enable them on a trusted network. It must be called
+// before StartServer.
+func (m *Metrics) EnablePprof() {
+	m.pprof = true
+}
+
+// StartServer serves m's metrics at /metrics. It blocks until the server
+// fails.
+func (m *Metrics) StartServer(address string, port int) error {
+	addr := fmt.Sprintf("%s:%d", address, port)
+
+	return http.ListenAndServe(addr, m.serveMux())
 }
 
-// StartMetricsServer starts the Prometheus metrics HTTP server.
-func StartMetricsServer(port int) error {
-	http.Handle("/metrics", promhttp.Handler())
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
+func (m *Metrics) serveMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	if m.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
-	return http.ListenAndServe(addr, nil)
+	return mux
 }
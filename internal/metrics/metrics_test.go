@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type traceIDKey struct{}
+
+func traceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+
+	return id
+}
+
+func newUnregisteredMetrics() *Metrics {
+	m := &Metrics{}
+	m.initializeTrafficMetrics()
+	m.initializePipelineMetrics()
+
+	return m
+}
+
+func histogramExemplars(t *testing.T, histogram prometheus.Histogram) []*dto.Exemplar {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := histogram.Write(&metric); err != nil {
+		t.Fatalf("failed to write histogram: %v", err)
+	}
+
+	var exemplars []*dto.Exemplar
+	for _, bucket := range metric.GetHistogram().GetBucket() {
+		if bucket.GetExemplar() != nil {
+			exemplars = append(exemplars, bucket.GetExemplar())
+		}
+	}
+
+	return exemplars
+}
+
+func TestObserveLatencyAttachesTraceExemplar(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	m := newUnregisteredMetrics()
+	m.EnableExemplars(traceIDFromContext)
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, traceID)
+	m.ObserveLatency(ctx, 42)
+
+	exemplars := histogramExemplars(t, m.LatencyHistogram)
+	if len(exemplars) != 1 {
+		t.Fatalf("expected 1 exemplar, got %d", len(exemplars))
+	}
+	if exemplars[0].GetValue() != 42 {
+		t.Errorf("expected exemplar value 42, got %v", exemplars[0].GetValue())
+	}
+
+	labels := exemplars[0].GetLabel()
+	if len(labels) != 1 || labels[0].GetName() != "trace_id" || labels[0].GetValue() != traceID {
+		t.Errorf("expected trace_id=%s exemplar label, got %v", traceID, labels)
+	}
+}
+
+func TestObserveLatencyWithoutExemplars(t *testing.T) {
+	m := newUnregisteredMetrics()
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "4bf92f3577b34da6a3ce929d0e0e4736")
+	m.ObserveProcessingLatency(ctx, 7)
+
+	if exemplars := histogramExemplars(t, m.ProcessingLatency); len(exemplars) != 0 {
+		t.Errorf("expected no exemplars when disabled, got %d", len(exemplars))
+	}
+}
+
+func TestNewMetricsUsesOwnRegistry(t *testing.T) {
+	first, err := NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	second, err := NewMetrics()
+	if err != nil {
+		t.Fatalf("expected a second NewMetrics to succeed, got %v", err)
+	}
+
+	first.TotalConnections.Inc()
+
+	recorder := httptest.NewRecorder()
+	second.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "socks5_proxy_total_connections 0") {
+		t.Errorf("expected the second registry to be unaffected by the first, got:\n%s", recorder.Body.String())
+	}
+}
+
+func TestPprofIsGated(t *testing.T) {
+	m, err := NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	m.serveMux().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected pprof to be off by default, got status %d", recorder.Code)
+	}
+
+	m.EnablePprof()
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline", "/metrics"} {
+		recorder := httptest.NewRecorder()
+		m.serveMux().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", path, recorder.Code)
+		}
+	}
+}
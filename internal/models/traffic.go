@@ -2,25 +2,101 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// TrafficLog represents a single traffic event through the proxy.
+// Statuses of a TrafficLog. Only successful connections moved traffic; the
+// others record attempts the proxy refused or could not complete, except
+// accepted, which records a client connection getting past the listener
+// before it authenticated or named a destination.
+const (
+	StatusSuccess           = "success"
+	StatusDialFailed        = "dial_failed"
+	StatusBlocked           = "blocked"
+	StatusAuthFailed        = "auth_failed"
+	StatusRateLimited       = "rate_limited"
+	StatusConnectionLimited = "connection_limited"
+	StatusAccepted          = "accepted"
+)
+
+// IsFailure reports whether status records a failed connection attempt.
+func IsFailure(status string) bool {
+	return status != StatusSuccess && status != StatusAccepted
+}
+
+// Application categories of a destination port, see CategoryOfPort.
+const (
+	CategoryWeb   = "web"
+	CategoryDNS   = "dns"
+	CategoryMail  = "mail"
+	CategorySSH   = "ssh"
+	CategoryOther = "other"
+)
+
+// PortCategory is a coarse application category and its destination ports.
+type PortCategory struct {
+	Name  string
+	Ports []int
+}
+
+// PortCategories classifies connections by their well-known destination
+// port, a rough indication of what the proxy is used for even when the
+// traffic itself is opaque. Every other port is CategoryOther.
+var PortCategories = []PortCategory{
+	{CategoryWeb, []int{80, 443, 8080, 8443}},
+	{CategoryDNS, []int{53, 853}},
+	{CategoryMail, []int{25, 110, 143, 465, 587, 993, 995}},
+	{CategorySSH, []int{22}},
+}
+
+// CategoryOfPort returns the application category of a destination port.
+func CategoryOfPort(port int) string {
+	for _, category := range PortCategories {
+		if slices.Contains(category.Ports, port) {
+			return category.Name
+		}
+	}
+
+	return CategoryOther
+}
+
+// TrafficLog represents a single traffic event through the proxy. Its
+// Timestamp is when it was emitted: when the connection closed or, for interim
+// logs, at the end of the interval whose bytes they carry. The connection
+// opened DurationMs earlier.
 type TrafficLog struct {
-	ID            uint           `gorm:"primaryKey" json:"id"`
-	SourceIP      string         `gorm:"index" json:"source_ip"`
-	DestinationIP string         `gorm:"index" json:"destination_ip"`
-	Domain        string         `gorm:"index" json:"domain"`
-	Port          int            `json:"port"`
-	Timestamp     time.Time      `gorm:"index" json:"timestamp"`
-	LatencyMs     int64          `json:"latency_ms"`
-	BytesIn       int64          `json:"bytes_in"`
-	BytesOut      int64          `json:"bytes_out"`
-	Protocol      string         `json:"protocol"`
-	CreatedAt     time.Time      `gorm:"autoCreateTime" json:"created_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UUID            *string   `gorm:"type:uuid;uniqueIndex" json:"uuid,omitempty"`
+	SourceIP        string    `gorm:"index" json:"source_ip"`
+	SourceCountry   string    `json:"source_country,omitempty"`
+	Region          string    `gorm:"index" json:"region,omitempty"`
+	Username        string    `gorm:"index" json:"username"`
+	DestinationIP   string    `gorm:"index" json:"destination_ip"`
+	DestCountry     string    `json:"dest_country,omitempty"`
+	DestASN         uint      `json:"dest_asn,omitempty"`
+	Domain          string    `gorm:"index" json:"domain"`
+	PunycodeDecoded string    `json:"punycode_decoded,omitempty"`
+	Suspicious      bool      `gorm:"index" json:"suspicious"`
+	Port            int       `json:"port"`
+	Timestamp       time.Time `gorm:"index" json:"timestamp"`
+	LatencyMs       int64     `json:"latency_ms"`
+	FirstByteMs     *int64    `json:"first_byte_ms"`
+	DurationMs      int64     `json:"duration_ms"`
+	BytesIn         int64     `json:"bytes_in"`
+	BytesOut        int64     `json:"bytes_out"`
+	Protocol        string    `json:"protocol"`
+	// Interim marks a mid-connection log of the bytes transferred since the
+	// previous one. Statistics count connections and average latencies over
+	// final logs only, and sum bytes over all of them.
+	Interim bool `gorm:"not null;default:false" json:"interim,omitempty"`
+	// Status is one of the Status constants. Logs of failed attempts moved no
+	// bytes and are left out of connection counts and averages.
+	Status    string         `gorm:"index;not null;default:success" json:"status"`
+	CreatedAt time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // TableName specifies the table name.
@@ -46,10 +122,150 @@ type SourceIPStats struct {
 	AvgLatency    float64 `json:"avg_latency_ms"`
 }
 
+// UserStats represents statistics for an authenticated proxy user.
+type UserStats struct {
+	Username      string  `json:"username"`
+	Count         int64   `json:"count"`
+	TotalBytesIn  int64   `json:"total_bytes_in"`
+	TotalBytesOut int64   `json:"total_bytes_out"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// PortStats represents statistics for a destination port.
+type PortStats struct {
+	Port          int     `json:"port"`
+	Count         int64   `json:"count"`
+	TotalBytesIn  int64   `json:"total_bytes_in"`
+	TotalBytesOut int64   `json:"total_bytes_out"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// FailureStats counts the failed connection attempts of a source IP with
+// one status.
+type FailureStats struct {
+	Status   string    `json:"status"`
+	SourceIP string    `json:"source_ip"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// CountryStats represents statistics for a source or destination country.
+type CountryStats struct {
+	Country       string  `json:"country"`
+	Count         int64   `json:"count"`
+	TotalBytesIn  int64   `json:"total_bytes_in"`
+	TotalBytesOut int64   `json:"total_bytes_out"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// RegionStats represents statistics for a source region.
+type RegionStats struct {
+	Region        string  `json:"region"`
+	Count         int64   `json:"count"`
+	TotalBytesIn  int64   `json:"total_bytes_in"`
+	TotalBytesOut int64   `json:"total_bytes_out"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// ProtocolStats represents statistics for a protocol, an application
+// category or, in repository results, a protocol and category pair.
+type ProtocolStats struct {
+	Protocol      string  `json:"protocol,omitempty"`
+	Category      string  `json:"category,omitempty"`
+	Count         int64   `json:"count"`
+	TotalBytesIn  int64   `json:"total_bytes_in"`
+	TotalBytesOut int64   `json:"total_bytes_out"`
+	AvgLatency    float64 `json:"avg_latency_ms"`
+}
+
+// ProtocolBreakdown groups traffic by protocol and by application category.
+type ProtocolBreakdown struct {
+	Protocols  []ProtocolStats `json:"protocols"`
+	Categories []ProtocolStats `json:"categories"`
+}
+
+// UserDailyUsage represents the traffic an authenticated user generated on
+// a single calendar day.
+type UserDailyUsage struct {
+	Username      string `json:"username"`
+	Day           string `json:"day"`
+	Count         int64  `json:"count"`
+	TotalBytesIn  int64  `json:"total_bytes_in"`
+	TotalBytesOut int64  `json:"total_bytes_out"`
+	TotalBytes    int64  `json:"total_bytes"`
+}
+
+// ByteUsage is the traffic of one source IP or user.
+type ByteUsage struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+// QuotaUsage compares the traffic of a source IP or user within the quota
+// window with its limit. A zero limit means no quota applies.
+type QuotaUsage struct {
+	SourceIP    string    `json:"source_ip,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	WindowStart time.Time `json:"window_start"`
+	UsedBytes   int64     `json:"used_bytes"`
+	LimitBytes  int64     `json:"limit_bytes"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
 // TrafficStats represents overall traffic statistics.
 type TrafficStats struct {
 	TotalConnections int64   `json:"total_connections"`
 	TotalBytesIn     int64   `json:"total_bytes_in"`
 	TotalBytesOut    int64   `json:"total_bytes_out"`
 	AvgLatency       float64 `json:"avg_latency_ms"`
+	AvgFirstByte     float64 `json:"avg_first_byte_ms"`
+	// AvgDuration and MaxDuration measure how long connections stayed open
+	// after the dial completed.
+	AvgDuration float64 `json:"avg_duration_ms"`
+	MaxDuration int64   `json:"max_duration_ms"`
+}
+
+// StatsSnapshot is one periodic update of the live stats stream: activity
+// over the window since WindowStart plus the overall top domains.
+type StatsSnapshot struct {
+	Timestamp   time.Time `json:"timestamp"`
+	WindowStart time.Time `json:"window_start"`
+	// PeakConnections is the peak number of simultaneously open
+	// connections during the window, as recorded in the logs.
+	PeakConnections int64         `json:"peak_connections"`
+	Traffic         TrafficStats  `json:"traffic"`
+	BytesInPerSec   float64       `json:"bytes_in_per_sec"`
+	BytesOutPerSec  float64       `json:"bytes_out_per_sec"`
+	TopDomains      []DomainStats `json:"top_domains"`
+}
+
+// ConcurrencyBucket represents how many connections were simultaneously
+// active during a time bucket.
+type ConcurrencyBucket struct {
+	BucketStart   time.Time `json:"bucket_start"`
+	MaxConcurrent int64     `json:"max_concurrent"`
+	AvgConcurrent float64   `json:"avg_concurrent"`
+	// SmoothedAvgConcurrent is the moving average of AvgConcurrent, set only when smoothing was requested.
+	SmoothedAvgConcurrent *float64 `json:"smoothed_avg_concurrent,omitempty"`
+}
+
+// TrafficBucket summarizes the logs emitted during a time bucket: the
+// connections that closed in it and the bytes their logs carried. The
+// smoothed fields are moving averages over the preceding buckets and are set
+// only when smoothing was requested.
+type TrafficBucket struct {
+	BucketStart         time.Time `json:"bucket_start"`
+	Connections         int64     `json:"connections"`
+	BytesIn             int64     `json:"bytes_in"`
+	BytesOut            int64     `json:"bytes_out"`
+	AvgLatency          float64   `json:"avg_latency_ms"`
+	SmoothedConnections *float64  `json:"smoothed_connections,omitempty"`
+	SmoothedBytes       *float64  `json:"smoothed_bytes,omitempty"`
+}
+
+// TrafficGap is a time bucket in which fewer connections closed than
+// expected.
+type TrafficGap struct {
+	BucketStart time.Time `json:"bucket_start"`
+	Count       int64     `json:"count"`
 }
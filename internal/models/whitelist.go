@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// WhitelistEntry is an IP address or CIDR range added to the proxy's source
+// IP whitelist through the API, on top of proxy.ip_whitelist.
+type WhitelistEntry struct {
+	Entry     string    `gorm:"primaryKey" json:"entry"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name.
+func (WhitelistEntry) TableName() string {
+	return "ip_whitelist"
+}
@@ -0,0 +1,289 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"go.uber.org/zap"
+)
+
+// maxRecentAnomalies bounds the anomalies kept for the /stats/anomalies endpoint.
+const maxRecentAnomalies = 100
+
+// Anomaly reasons.
+const (
+	AnomalyConnections = "connections"
+	AnomalyBytes       = "bytes"
+)
+
+// AnomalyThresholds configures when a source IP's traffic in the current
+// window is flagged. A zero field disables that check.
+type AnomalyThresholds struct {
+	// Connections and Bytes are absolute limits per window.
+	Connections int64
+	Bytes       int64
+	// BaselineMultiple flags a window exceeding this multiple of the IP's
+	// average over the previous windows, once it has at least MinConnections
+	// connections or MinBytes bytes.
+	BaselineMultiple float64
+	MinConnections   int64
+	MinBytes         int64
+}
+
+// Anomaly is a source IP whose traffic in one window exceeded a threshold.
+type Anomaly struct {
+	SourceIP    string    `json:"source_ip"`
+	Reason      string    `json:"reason"`
+	Value       int64     `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Baseline    float64   `json:"baseline"`
+	WindowStart time.Time `json:"window_start"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// windowCounts is the traffic of one source IP in one window.
+type windowCounts struct {
+	connections int64
+	bytes       int64
+}
+
+// ipHistory is one source IP's current window and a ring buffer of its
+// previous windows.
+type ipHistory struct {
+	current windowCounts
+	history []windowCounts
+	next    int
+	filled  int
+	// fired records the reasons already reported in the current window.
+	fired map[string]bool
+}
+
+// baseline returns the average connections and bytes over the previous
+// windows recorded so far.
+func (h *ipHistory) baseline() (connections, bytes float64) {
+	if h.filled == 0 {
+		return 0, 0
+	}
+	for _, counts := range h.history[:h.filled] {
+		connections += float64(counts.connections)
+		bytes += float64(counts.bytes)
+	}
+
+	return connections / float64(h.filled), bytes / float64(h.filled)
+}
+
+func (h *ipHistory) rotate() {
+	h.history[h.next] = h.current
+	h.next = (h.next + 1) % len(h.history)
+	h.filled = min(h.filled+1, len(h.history))
+	h.current = windowCounts{}
+	clear(h.fired)
+}
+
+func (h *ipHistory) idle() bool {
+	return h.current == windowCounts{} && !slices.ContainsFunc(h.history, func(counts windowCounts) bool {
+		return counts != windowCounts{}
+	})
+}
+
+// AnomalyDetector flags source IPs whose connections or bytes in the current
+// window exceed an absolute threshold or a multiple of their recent baseline.
+// State is in memory: each IP keeps a fixed number of previous windows, IPs
+// with no traffic in any of them are forgotten on rotation, and at most
+// maxIPs are tracked at once.
+type AnomalyDetector struct {
+	thresholds AnomalyThresholds
+	windows    int
+	maxIPs     int
+	log        *zap.Logger
+	onAnomaly  func(Anomaly)
+
+	mu          sync.Mutex
+	ips         map[string]*ipHistory
+	windowStart time.Time
+	recent      []Anomaly
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewAnomalyDetector creates a detector comparing each window with the
+// average of the previous baselineWindows windows.
+func NewAnomalyDetector(thresholds AnomalyThresholds, baselineWindows, maxIPs int, log *zap.Logger) *AnomalyDetector {
+	return &AnomalyDetector{
+		thresholds:  thresholds,
+		windows:     max(baselineWindows, 1),
+		maxIPs:      maxIPs,
+		log:         log,
+		ips:         make(map[string]*ipHistory),
+		windowStart: time.Now(),
+		stop:        make(chan struct{}),
+	}
+}
+
+// SetOnAnomaly registers a callback run for every anomaly detected. It must
+// be called before Observe.
+func (d *AnomalyDetector) SetOnAnomaly(fn func(Anomaly)) {
+	d.onAnomaly = fn
+}
+
+// Observe adds trafficLog to its source IP's current window and reports the
+// first anomaly of each reason per window. Interim logs add bytes but not a
+// connection, and logs of accepted, refused or failed attempts are ignored,
+// as in the traffic statistics. A nil detector ignores it.
+func (d *AnomalyDetector) Observe(trafficLog *models.TrafficLog) {
+	if d == nil || trafficLog.SourceIP == "" || trafficLog.Status != models.StatusSuccess {
+		return
+	}
+
+	d.mu.Lock()
+	ip, ok := d.ips[trafficLog.SourceIP]
+	if !ok {
+		if d.maxIPs > 0 && len(d.ips) >= d.maxIPs {
+			d.mu.Unlock()
+
+			return
+		}
+		ip = &ipHistory{history: make([]windowCounts, d.windows), fired: make(map[string]bool)}
+		d.ips[trafficLog.SourceIP] = ip
+	}
+	if !trafficLog.Interim {
+		ip.current.connections++
+	}
+	ip.current.bytes += trafficLog.BytesIn + trafficLog.BytesOut
+
+	detected := d.detect(trafficLog.SourceIP, ip)
+	d.recent = append(d.recent, detected...)
+	if overflow := len(d.recent) - maxRecentAnomalies; overflow > 0 {
+		d.recent = slices.Delete(d.recent, 0, overflow)
+	}
+	d.mu.Unlock()
+
+	for _, anomaly := range detected {
+		d.log.Warn("Traffic anomaly detected",
+			zap.String("source_ip", anomaly.SourceIP),
+			zap.String("reason", anomaly.Reason),
+			zap.Int64("value", anomaly.Value),
+			zap.Float64("threshold", anomaly.Threshold),
+			zap.Float64("baseline", anomaly.Baseline))
+		if d.onAnomaly != nil {
+			d.onAnomaly(anomaly)
+		}
+	}
+}
+
+// detect returns the anomalies of ip's current window not yet reported.
+func (d *AnomalyDetector) detect(source string, ip *ipHistory) []Anomaly {
+	baselineConnections, baselineBytes := ip.baseline()
+	checks := []struct {
+		reason         string
+		value          int64
+		baseline       float64
+		limit, minimum int64
+	}{
+		{AnomalyConnections, ip.current.connections, baselineConnections,
+			d.thresholds.Connections, d.thresholds.MinConnections},
+		{AnomalyBytes, ip.current.bytes, baselineBytes, d.thresholds.Bytes, d.thresholds.MinBytes},
+	}
+
+	var detected []Anomaly
+	for _, check := range checks {
+		if ip.fired[check.reason] {
+			continue
+		}
+		threshold, exceeded := d.exceeded(check.value, check.baseline, check.limit, check.minimum, ip.filled)
+		if !exceeded {
+			continue
+		}
+		ip.fired[check.reason] = true
+		detected = append(detected, Anomaly{
+			SourceIP:    source,
+			Reason:      check.reason,
+			Value:       check.value,
+			Threshold:   threshold,
+			Baseline:    check.baseline,
+			WindowStart: d.windowStart,
+			DetectedAt:  time.Now(),
+		})
+	}
+
+	return detected
+}
+
+// exceeded reports whether value crosses the absolute limit or, once the IP
+// has a baseline and value reaches minimum, the baseline multiple, along with
+// the threshold crossed.
+func (d *AnomalyDetector) exceeded(value int64, baseline float64, limit, minimum int64, filled int) (float64, bool) {
+	if limit > 0 && value > limit {
+		return float64(limit), true
+	}
+	if d.thresholds.BaselineMultiple <= 0 || filled == 0 || value < minimum {
+		return 0, false
+	}
+	// A quiet IP's baseline counts as one, so a single connection after
+	// silence isn't a spike.
+	threshold := d.thresholds.BaselineMultiple * max(baseline, 1)
+
+	return threshold, float64(value) > threshold
+}
+
+// Rotate starts a new window, moving the current one into each IP's history
+// and forgetting IPs with no traffic in any kept window.
+func (d *AnomalyDetector) Rotate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for source, ip := range d.ips {
+		ip.rotate()
+		if ip.idle() {
+			delete(d.ips, source)
+		}
+	}
+	d.windowStart = time.Now()
+}
+
+// Anomalies returns the recently detected anomalies, newest first.
+func (d *AnomalyDetector) Anomalies() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	anomalies := slices.Clone(d.recent)
+	slices.Reverse(anomalies)
+
+	return anomalies
+}
+
+// Start rotates the window every interval until Stop is called.
+func (d *AnomalyDetector) Start(interval time.Duration) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.Rotate()
+			}
+		}
+	}()
+}
+
+// Stop halts window rotation.
+func (d *AnomalyDetector) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Handler serves the recently detected anomalies as JSON.
+func (d *AnomalyDetector) Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d.Anomalies())
+}
@@ -2,46 +2,177 @@
 package pipeline
 
 import (
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
 	"go.uber.org/zap"
 )
 
 // RawTrafficEvent represents an unprocessed traffic event from the proxy.
 type RawTrafficEvent struct {
-	SourceIP      string
-	DestinationIP string
-	Domain        string
-	Port          int
-	Timestamp     time.Time
-	LatencyMs     int64
-	BytesIn       int64
-	BytesOut      int64
-	Protocol      string
+	SourceIP          string
+	Username          string // Authenticated SOCKS5 user; empty when auth is disabled.
+	DestinationIP     string
+	Domain            string
+	Port              int
+	Timestamp         time.Time // When emitted: on close, or at the end of an interim interval.
+	LatencyMs         int64     // Dial latency.
+	FirstByteMs       int64     // Dial completion to first destination byte; set when FirstByteReceived.
+	FirstByteReceived bool
+	DurationMs        int64 // Time the connection stayed open after the dial completed.
+	BytesIn           int64
+	BytesOut          int64
+	Protocol          string
+	// Interim marks an event emitted while the connection is still open; its
+	// byte counts are the deltas since the previous event. The final event of
+	// a connection has Interim unset.
+	Interim bool
+	// Status is one of the models.Status constants; empty means success.
+	Status string
 }
 
 // Collector collects raw traffic events from the proxy.
 type Collector struct {
-	out chan RawTrafficEvent
-	log *zap.Logger
+	out        chan RawTrafficEvent
+	log        *zap.Logger
+	analytics  *AnalyticsSwitch
+	metrics    *metrics.Metrics
+	overflow   string
+	sampleRate uint64
+	overflows  atomic.Uint64
+
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	once    sync.Once
+
+	drops dropWarner
 }
 
 // NewCollector creates a new traffic event collector.
 func NewCollector(out chan RawTrafficEvent, log *zap.Logger) *Collector {
 	return &Collector{
-		out: out,
-		log: log,
+		out:      out,
+		log:      log,
+		overflow: OverflowDrop,
+		closing:  make(chan struct{}),
 	}
 }
 
+// SetAnalyticsSwitch makes Collect a no-op while the switch is off.
+func (c *Collector) SetAnalyticsSwitch(s *AnalyticsSwitch) {
+	c.analytics = s
+}
+
+// SetMetrics counts collected events in m. It must be called before the
+// proxy starts collecting.
+func (c *Collector) SetMetrics(m *metrics.Metrics) {
+	c.metrics = m
+}
+
+// SetOverflowPolicy sets what Collect does when the channel is full:
+// OverflowDrop (the default) discards the event, OverflowBlock waits for room
+// and OverflowSample waits for one in every sampleRate events and discards the
+// rest. It must be called before the proxy starts collecting.
+func (c *Collector) SetOverflowPolicy(policy string, sampleRate int) error {
+	switch policy {
+	case "", OverflowDrop:
+		c.overflow = OverflowDrop
+	case OverflowBlock:
+		c.overflow = OverflowBlock
+	case OverflowSample:
+		if sampleRate < 1 {
+			return fmt.Errorf("overflow sample rate must be at least 1, got %d", sampleRate)
+		}
+		c.overflow = OverflowSample
+		c.sampleRate = uint64(sampleRate)
+	default:
+		return fmt.Errorf("unknown collector overflow policy %q", policy)
+	}
+
+	return nil
+}
+
 // Collect adds a raw traffic event to the collection channel.
 func (c *Collector) Collect(event RawTrafficEvent) error {
+	if !c.analytics.Enabled() {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		c.dropped("collector closed, dropping events")
+
+		return nil
+	}
+
 	select {
 	case c.out <- event:
+		c.collected()
+
 		return nil
 	default:
-		c.log.Warn("collector channel full, dropping event")
+	}
+
+	if c.overflow == OverflowDrop ||
+		(c.overflow == OverflowSample && (c.overflows.Add(1)-1)%c.sampleRate != 0) {
+		c.dropped("collector channel full, dropping events")
 
 		return nil
 	}
+
+	select {
+	case c.out <- event:
+		c.collected()
+	case <-c.closing:
+		c.dropped("collector closed, dropping events")
+	}
+
+	return nil
+}
+
+func (c *Collector) collected() {
+	if c.metrics != nil {
+		c.metrics.EventsCollected.Inc()
+	}
+}
+
+// dropped counts a dropped event and logs a rate-limited warning.
+func (c *Collector) dropped(msg string) {
+	if c.metrics != nil {
+		c.metrics.EventsDropped.WithLabelValues("collector").Inc()
+	}
+	c.drops.dropped(c.log, msg)
+}
+
+// Close closes the collection channel so the normalizer can drain it and
+// exit. Events collected afterwards, or still waiting for room under the
+// block and sample policies, are dropped.
+func (c *Collector) Close() {
+	c.once.Do(func() {
+		close(c.closing)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.out)
+	}
+}
+
+// Depth returns the number of events waiting in the collection channel.
+func (c *Collector) Depth() int {
+	return len(c.out)
+}
+
+// Capacity returns the size of the collection channel buffer.
+func (c *Collector) Capacity() int {
+	return cap(c.out)
 }
@@ -0,0 +1,33 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// dropWarnInterval is the minimum time between two "dropping events" warnings.
+const dropWarnInterval = 10 * time.Second
+
+// dropWarner rate-limits the warnings a pipeline stage logs when it drops
+// events, so that a flood of drops does not also flood the log.
+type dropWarner struct {
+	mu       sync.Mutex
+	drops    int64
+	lastWarn time.Time
+}
+
+// dropped records a drop and logs msg at most once per dropWarnInterval,
+// carrying the number of drops since the previous warning.
+func (w *dropWarner) dropped(log *zap.Logger, msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.drops++
+	if now := time.Now(); now.Sub(w.lastWarn) >= dropWarnInterval {
+		log.Warn(msg, zap.Int64("dropped", w.drops))
+		w.drops = 0
+		w.lastWarn = now
+	}
+}
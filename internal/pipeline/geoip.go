@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIPLookup looks an address up in a MaxMind database, decoding the record
+// into result.
+type geoIPLookup interface {
+	Lookup(ip net.IP, result any) error
+}
+
+// geoIPCountry is the part of a GeoLite2/GeoIP2 Country or City record the
+// enricher reads. The registered country is the fallback for addresses, such
+// as anycast ones, without a located country.
+type geoIPCountry struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// geoIPASN is the part of a GeoLite2 ASN record the enricher reads.
+type geoIPASN struct {
+	Number uint `maxminddb:"autonomous_system_number"`
+}
+
+// GeoIPEnricher sets TrafficLog.SourceCountry and DestCountry from a MaxMind
+// Country (or City) database and DestASN from an ASN database. The databases
+// are memory-mapped and a lookup is a tree walk without I/O, and non-public
+// addresses are skipped, so enrichment adds a small, bounded cost per log.
+type GeoIPEnricher struct {
+	country geoIPLookup
+	asn     geoIPLookup
+}
+
+// NewGeoIPEnricher opens the country and ASN databases at the given paths;
+// either may be empty. A database that can't be opened is left out and
+// reported in the returned error, and the enricher works with the other one;
+// Enabled reports whether any database was opened.
+func NewGeoIPEnricher(countryPath, asnPath string) (*GeoIPEnricher, error) {
+	e := &GeoIPEnricher{}
+	var errs []error
+	if countryPath != "" {
+		reader, err := maxminddb.Open(countryPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to open GeoIP country database: %w", err))
+		} else {
+			e.country = reader
+		}
+	}
+	if asnPath != "" {
+		reader, err := maxminddb.Open(asnPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to open GeoIP ASN database: %w", err))
+		} else {
+			e.asn = reader
+		}
+	}
+
+	return e, errors.Join(errs...)
+}
+
+// Enabled reports whether the enricher has a database to look addresses up in.
+func (e *GeoIPEnricher) Enabled() bool {
+	return e.country != nil || e.asn != nil
+}
+
+// Enrich fills the countries and destination ASN that are still empty.
+func (e *GeoIPEnricher) Enrich(log *models.TrafficLog) {
+	if e.country != nil {
+		if log.SourceCountry == "" {
+			log.SourceCountry = e.lookupCountry(log.SourceIP)
+		}
+		if log.DestCountry == "" {
+			log.DestCountry = e.lookupCountry(log.DestinationIP)
+		}
+	}
+	if e.asn != nil && log.DestASN == 0 {
+		if ip := publicIP(log.DestinationIP); ip != nil {
+			var record geoIPASN
+			if err := e.asn.Lookup(ip, &record); err == nil {
+				log.DestASN = record.Number
+			}
+		}
+	}
+}
+
+func (e *GeoIPEnricher) lookupCountry(addr string) string {
+	ip := publicIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	var record geoIPCountry
+	if err := e.country.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+
+	return record.RegisteredCountry.ISOCode
+}
+
+// publicIP parses addr, returning nil for invalid addresses and ones that
+// can't be in a GeoIP database, such as private and loopback addresses.
+func publicIP(addr string) net.IP {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return nil
+	}
+
+	return ip
+}
@@ -0,0 +1,267 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Pipeline health states reported by HealthMonitor.
+const (
+	HealthStatusHealthy   = "healthy"
+	HealthStatusDegraded  = "degraded"
+	HealthStatusUnhealthy = "unhealthy"
+)
+
+// QueueDepth reports the occupancy of a single pipeline queue.
+type QueueDepth struct {
+	Name      string  `json:"name"`
+	Depth     int     `json:"depth"`
+	Capacity  int     `json:"capacity"`
+	Occupancy float64 `json:"occupancy"`
+}
+
+// HealthReport is a point-in-time view of pipeline queue health.
+type HealthReport struct {
+	Status        string       `json:"status"`
+	Ready         bool         `json:"ready"`
+	Queues        []QueueDepth `json:"queues"`
+	CriticalSince *time.Time   `json:"critical_since,omitempty"`
+	// Dependencies maps each dependency to "ok" or "unreachable".
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// Queue is a pipeline stage whose backlog can be measured.
+type Queue interface {
+	Depth() int
+	Capacity() int
+}
+
+type namedQueue struct {
+	name  string
+	queue Queue
+}
+
+// Dependency is a service the pipeline writes to, such as the database. It
+// must report its health without blocking.
+type Dependency interface {
+	Healthy() bool
+}
+
+type namedDependency struct {
+	name       string
+	dependency Dependency
+}
+
+// HealthMonitor samples pipeline queue depths and reports the pipeline as
+// unhealthy once any queue stays above the critical threshold for longer than
+// the configured sustain window.
+type HealthMonitor struct {
+	queues            []namedQueue
+	dependencies      []namedDependency
+	warnThreshold     float64
+	criticalThreshold float64
+	sustain           time.Duration
+	criticalSince     time.Time
+	unhealthy         bool
+	mu                sync.Mutex
+	log               *zap.Logger
+	metrics           *metrics.Metrics
+	wg                sync.WaitGroup
+	ctx               context.Context
+	cancel            context.CancelFunc
+}
+
+// NewHealthMonitor creates a queue health monitor. Thresholds are occupancy
+// ratios between 0 and 1.
+func NewHealthMonitor(
+	warnThreshold, criticalThreshold float64,
+	sustain time.Duration,
+	log *zap.Logger,
+) *HealthMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &HealthMonitor{
+		warnThreshold:     warnThreshold,
+		criticalThreshold: criticalThreshold,
+		sustain:           sustain,
+		log:               log,
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+}
+
+// SetMetrics exports the depth and capacity of every queue through exporter
+// on each sample. It must be called before Start.
+func (m *HealthMonitor) SetMetrics(exporter *metrics.Metrics) {
+	m.metrics = exporter
+}
+
+// AddQueue registers a queue to monitor under the given name.
+func (m *HealthMonitor) AddQueue(name string, queue Queue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queues = append(m.queues, namedQueue{name: name, queue: queue})
+}
+
+// AddDependency registers a dependency under the given name. The pipeline is
+// reported unhealthy and not ready while it is unhealthy.
+func (m *HealthMonitor) AddDependency(name string, dependency Dependency) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dependencies = append(m.dependencies, namedDependency{name: name, dependency: dependency})
+}
+
+// Start samples the queues periodically so sustained saturation is detected
+// even when nobody is polling the health endpoints.
+func (m *HealthMonitor) Start(interval time.Duration) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.Sample()
+			}
+		}
+	}()
+}
+
+// Stop stops background sampling.
+func (m *HealthMonitor) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Sample takes a fresh reading of every queue and returns the resulting report.
+func (m *HealthMonitor) Sample() HealthReport {
+	return m.sampleAt(time.Now())
+}
+
+func (m *HealthMonitor) sampleAt(now time.Time) HealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := m.sampleQueues(now)
+	if len(m.dependencies) == 0 {
+		return report
+	}
+
+	report.Dependencies = make(map[string]string, len(m.dependencies))
+	for _, d := range m.dependencies {
+		report.Dependencies[d.name] = "ok"
+		if !d.dependency.Healthy() {
+			report.Dependencies[d.name] = "unreachable"
+			report.Status = HealthStatusUnhealthy
+			report.Ready = false
+		}
+	}
+
+	return report
+}
+
+// sampleQueues reads every queue and applies the thresholds. m.mu must be
+// held.
+func (m *HealthMonitor) sampleQueues(now time.Time) HealthReport {
+	report := HealthReport{
+		Status: HealthStatusHealthy,
+		Ready:  true,
+		Queues: make([]QueueDepth, 0, len(m.queues)),
+	}
+
+	var maxOccupancy float64
+	for _, q := range m.queues {
+		depth := q.queue.Depth()
+		capacity := q.queue.Capacity()
+		occupancy := 0.0
+		if capacity > 0 {
+			occupancy = float64(depth) / float64(capacity)
+		}
+		if occupancy > maxOccupancy {
+			maxOccupancy = occupancy
+		}
+		if m.metrics != nil {
+			m.metrics.QueueDepth.WithLabelValues(q.name).Set(float64(depth))
+			m.metrics.QueueCapacity.WithLabelValues(q.name).Set(float64(capacity))
+		}
+
+		report.Queues = append(report.Queues, QueueDepth{
+			Name:      q.name,
+			Depth:     depth,
+			Capacity:  capacity,
+			Occupancy: occupancy,
+		})
+	}
+
+	if maxOccupancy < m.criticalThreshold {
+		if m.unhealthy {
+			m.log.Info("pipeline queues recovered below critical threshold")
+		}
+		m.criticalSince = time.Time{}
+		m.unhealthy = false
+		if maxOccupancy >= m.warnThreshold {
+			report.Status = HealthStatusDegraded
+		}
+
+		return report
+	}
+
+	if m.criticalSince.IsZero() {
+		m.criticalSince = now
+	}
+	since := m.criticalSince
+	report.CriticalSince = &since
+	report.Status = HealthStatusDegraded
+
+	if now.Sub(m.criticalSince) >= m.sustain {
+		if !m.unhealthy {
+			m.log.Warn("pipeline queues above critical threshold, reporting not ready",
+				zap.Float64("occupancy", maxOccupancy),
+				zap.Time("critical_since", m.criticalSince))
+		}
+		m.unhealthy = true
+		report.Status = HealthStatusUnhealthy
+		report.Ready = false
+	}
+
+	return report
+}
+
+// StatusHandler serves the current health report. It always responds 200 so
+// the queue depths stay observable while the pipeline is unhealthy.
+func (m *HealthMonitor) StatusHandler(w http.ResponseWriter, _ *http.Request) {
+	writeHealthReport(w, http.StatusOK, m.Sample())
+}
+
+// ReadyHandler responds 503 while the pipeline is unhealthy, its queues
+// saturated or a dependency unreachable, so load balancers divert traffic
+// away from this instance.
+func (m *HealthMonitor) ReadyHandler(w http.ResponseWriter, _ *http.Request) {
+	report := m.Sample()
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Primary key strategies for traffic logs.
+const (
+	// PrimaryKeyAutoincrement leaves identity to the database sequence.
+	PrimaryKeyAutoincrement = "autoincrement"
+	// PrimaryKeyUUID assigns a client-side UUID to every log so logs from
+	// several proxies can be merged without ID collisions.
+	PrimaryKeyUUID = "uuid"
+)
+
+// IDGenerator produces client-side identifiers for traffic logs.
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDGenerator generates random (version 4) UUIDs.
+type UUIDGenerator struct{}
+
+// NewID returns a new random UUID.
+func (UUIDGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// NewIDGenerator returns the generator for the given primary key strategy,
+// or nil when the database assigns identity.
+func NewIDGenerator(strategy string) (IDGenerator, error) {
+	switch strategy {
+	case "", PrimaryKeyAutoincrement:
+		return nil, nil
+	case PrimaryKeyUUID:
+		return UUIDGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown primary key strategy %q", strategy)
+	}
+}
@@ -0,0 +1,125 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	// maxTrackedLatencyMs is the largest latency the live histogram records;
+	// larger values are clamped to it.
+	maxTrackedLatencyMs = int64(time.Hour / time.Millisecond)
+	latencySigFigs      = 3
+)
+
+// LivePercentiles are approximate latency percentiles from the in-memory histogram.
+type LivePercentiles struct {
+	Count       int64     `json:"count"`
+	P50         int64     `json:"p50_ms"`
+	P95         int64     `json:"p95_ms"`
+	P99         int64     `json:"p99_ms"`
+	Max         int64     `json:"max_ms"`
+	WindowStart time.Time `json:"window_start"`
+}
+
+// LatencyTracker keeps an HDR histogram of dial latencies so percentiles can
+// be served without querying the database. Readings cover the current and
+// previous window, so they never drop to zero right after a rotation.
+type LatencyTracker struct {
+	mu          sync.Mutex
+	window      *hdrhistogram.WindowedHistogram
+	windowStart time.Time
+	previous    time.Time
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewLatencyTracker creates an empty latency tracker.
+func NewLatencyTracker() *LatencyTracker {
+	now := time.Now()
+
+	return &LatencyTracker{
+		window:      hdrhistogram.NewWindowed(2, 1, maxTrackedLatencyMs, latencySigFigs),
+		windowStart: now,
+		previous:    now,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Record adds a latency observation in milliseconds. A nil tracker ignores it.
+func (t *LatencyTracker) Record(latencyMs int64) {
+	if t == nil {
+		return
+	}
+
+	latencyMs = max(latencyMs, 0)
+	latencyMs = min(latencyMs, maxTrackedLatencyMs)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_ = t.window.Current.RecordValue(latencyMs)
+}
+
+// Rotate starts a new window, discarding observations older than the previous one.
+func (t *LatencyTracker) Rotate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.window.Rotate()
+	t.previous = t.windowStart
+	t.windowStart = time.Now()
+}
+
+// Percentiles returns the approximate percentiles over the current and previous windows.
+func (t *LatencyTracker) Percentiles() LivePercentiles {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	merged := t.window.Merge()
+
+	return LivePercentiles{
+		Count:       merged.TotalCount(),
+		P50:         merged.ValueAtQuantile(50),
+		P95:         merged.ValueAtQuantile(95),
+		P99:         merged.ValueAtQuantile(99),
+		Max:         merged.Max(),
+		WindowStart: t.previous,
+	}
+}
+
+// Start rotates the histogram every interval until Stop is called.
+func (t *LatencyTracker) Start(interval time.Duration) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.Rotate()
+			}
+		}
+	}()
+}
+
+// Stop halts window rotation.
+func (t *LatencyTracker) Stop() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+// Handler serves the current approximate percentiles as JSON.
+func (t *LatencyTracker) Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(t.Percentiles())
+}
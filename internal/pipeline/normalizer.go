@@ -1,56 +1,287 @@
 package pipeline
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
 	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"go.uber.org/zap"
 )
 
 // Normalizer processes raw traffic events and converts them to traffic logs.
 type Normalizer struct {
-	in  chan RawTrafficEvent
-	out chan *models.TrafficLog
-	log *zap.Logger
+	in        chan RawTrafficEvent
+	out       chan *models.TrafficLog
+	idGen     IDGenerator
+	log       *zap.Logger
+	ready     chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closing   chan struct{}
+	workers   sync.WaitGroup
+	analytics *AnalyticsSwitch
+	latency   *LatencyTracker
+	anomalies *AnomalyDetector
+	tail      *TailHub
+	enrichers []Enricher
+	metrics   *metrics.Metrics
+	overflow  string
+	drops     dropWarner
 }
 
 // NewNormalizer creates a new traffic event normalizer.
 func NewNormalizer(in chan RawTrafficEvent, out chan *models.TrafficLog, log *zap.Logger) *Normalizer {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Normalizer{
-		in:  in,
-		out: out,
-		log: log,
+		in:      in,
+		out:     out,
+		log:     log,
+		ready:   make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+		closing: make(chan struct{}),
 	}
 }
 
+// SetIDGenerator assigns a client-side UUID to every normalized log. It must
+// be called before Start.
+func (n *Normalizer) SetIDGenerator(gen IDGenerator) {
+	n.idGen = gen
+}
+
+// SetAnalyticsSwitch parks the workers while the switch is off. It must be
+// called before Start.
+func (n *Normalizer) SetAnalyticsSwitch(s *AnalyticsSwitch) {
+	n.analytics = s
+}
+
+// SetLatencyTracker records every event's dial latency in t. It must be
+// called before Start.
+func (n *Normalizer) SetLatencyTracker(t *LatencyTracker) {
+	n.latency = t
+}
+
+// SetAnomalyDetector feeds every normalized log to d. It must be called
+// before Start.
+func (n *Normalizer) SetAnomalyDetector(d *AnomalyDetector) {
+	n.anomalies = d
+}
+
+// SetTailHub sends every normalized log to the live-tail clients of h. It
+// must be called before Start.
+func (n *Normalizer) SetTailHub(h *TailHub) {
+	n.tail = h
+}
+
+// SetMetrics counts processed events in m and records how long each took to
+// normalize and enrich. It must be called before Start.
+func (n *Normalizer) SetMetrics(m *metrics.Metrics) {
+	n.metrics = m
+}
+
+// SetOverflowMode sets what happens when the output channel is full, either
+// OverflowBlock (the default) or OverflowDropNewest. It must be called before
+// Start.
+func (n *Normalizer) SetOverflowMode(mode string) error {
+	switch mode {
+	case "", OverflowBlock, OverflowDropNewest:
+		n.overflow = mode
+
+		return nil
+	default:
+		return fmt.Errorf("unknown normalizer overflow mode %q", mode)
+	}
+}
+
+// AddEnricher appends an enrichment step run on every normalized log. It must
+// be called before Start.
+func (n *Normalizer) AddEnricher(e Enricher) {
+	n.enrichers = append(n.enrichers, e)
+}
+
 // Start begins processing events with the specified number of workers.
 func (n *Normalizer) Start(numWorkers int) {
+	var started sync.WaitGroup
+	started.Add(numWorkers)
+	n.workers.Add(numWorkers)
+
 	for i := 0; i < numWorkers; i++ {
-		go n.process()
+		go func() {
+			defer n.workers.Done()
+			started.Done()
+			n.process()
+		}()
 	}
+
+	go func() {
+		started.Wait()
+		close(n.ready)
+	}()
+}
+
+// Ready returns a channel that is closed once every worker is running.
+func (n *Normalizer) Ready() <-chan struct{} {
+	return n.ready
 }
 
 func (n *Normalizer) process() {
-	for event := range n.in {
-		trafficLog := &models.TrafficLog{
-			SourceIP:      event.SourceIP,
-			DestinationIP: event.DestinationIP,
-			Domain:        event.Domain,
-			Port:          event.Port,
-			Timestamp:     event.Timestamp,
-			LatencyMs:     event.LatencyMs,
-			BytesIn:       event.BytesIn,
-			BytesOut:      event.BytesOut,
-			Protocol:      event.Protocol,
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.closing:
+			n.drain()
+
+			return
+		case event, ok := <-n.in:
+			if !ok {
+				return
+			}
+			n.normalize(event)
+		}
+	}
+}
+
+// drain normalizes the events left in the input channel without waiting for
+// more to arrive.
+func (n *Normalizer) drain() {
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case event, ok := <-n.in:
+			if !ok {
+				return
+			}
+			n.normalize(event)
+		default:
+			return
 		}
+	}
+}
+
+func (n *Normalizer) normalize(event RawTrafficEvent) {
+	// Events already collected are still normalized during shutdown, even
+	// while analytics is paused.
+	select {
+	case <-n.analytics.Resumed():
+	case <-n.closing:
+	case <-n.ctx.Done():
+		return
+	}
+	status := event.Status
+	if status == "" {
+		status = models.StatusSuccess
+	}
+	if !event.Interim && status == models.StatusSuccess {
+		n.latency.Record(event.LatencyMs)
+	}
+	start := time.Now()
+
+	trafficLog := &models.TrafficLog{
+		SourceIP:      event.SourceIP,
+		Username:      event.Username,
+		DestinationIP: event.DestinationIP,
+		Domain:        event.Domain,
+		Port:          event.Port,
+		Timestamp:     event.Timestamp,
+		LatencyMs:     event.LatencyMs,
+		DurationMs:    event.DurationMs,
+		BytesIn:       event.BytesIn,
+		BytesOut:      event.BytesOut,
+		Protocol:      event.Protocol,
+		Interim:       event.Interim,
+		Status:        status,
+	}
+
+	if trafficLog.Domain != "" {
+		trafficLog.PunycodeDecoded, trafficLog.Suspicious = AnalyzeDomain(trafficLog.Domain)
+	}
 
+	for _, enricher := range n.enrichers {
+		enricher.Enrich(trafficLog)
+	}
+
+	n.anomalies.Observe(trafficLog)
+
+	if n.idGen != nil {
+		id := n.idGen.NewID()
+		trafficLog.UUID = &id
+	}
+
+	if event.FirstByteReceived {
+		firstByteMs := event.FirstByteMs
+		trafficLog.FirstByteMs = &firstByteMs
+	}
+
+	if n.metrics != nil {
+		n.metrics.EventsProcessed.Inc()
+		elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+		n.metrics.ObserveProcessingLatency(context.Background(), elapsed)
+	}
+
+	n.tail.Publish(trafficLog)
+	n.send(trafficLog)
+}
+
+// send hands trafficLog to the publisher. In block mode it waits for room
+// and only drops the log if the normalizer is stopped meanwhile.
+func (n *Normalizer) send(trafficLog *models.TrafficLog) {
+	if n.overflow == OverflowDropNewest {
 		select {
 		case n.out <- trafficLog:
 		default:
-			n.log.Warn("normalizer output channel full, dropping event")
+			n.dropped("normalizer output channel full, dropping event")
 		}
+
+		return
+	}
+
+	select {
+	case n.out <- trafficLog:
+	case <-n.ctx.Done():
+		n.dropped("normalizer stopped, dropping event")
+	}
+}
+
+// dropped counts a dropped event and logs a rate-limited warning.
+func (n *Normalizer) dropped(msg string) {
+	if n.metrics != nil {
+		n.metrics.EventsDropped.WithLabelValues("normalizer").Inc()
 	}
+	n.drops.dropped(n.log, msg)
+}
+
+// Depth returns the number of normalized logs waiting in the output channel.
+func (n *Normalizer) Depth() int {
+	return len(n.out)
+}
+
+// Capacity returns the size of the output channel buffer.
+func (n *Normalizer) Capacity() int {
+	return cap(n.out)
+}
+
+// Stop stops the workers without normalizing the rest of the input channel,
+// waits for them to exit and closes the output channel. Call only one of
+// Stop or Close.
+func (n *Normalizer) Stop() {
+	n.cancel()
+	n.workers.Wait()
+	close(n.out)
 }
 
-// Close closes the normalizer output channel.
+// Close normalizes the events left in the input channel, waits for the
+// workers to exit and closes the output channel. Close the input channel
+// first (see Collector.Close) so no event arrives after the drain. Call only
+// one of Stop or Close.
 func (n *Normalizer) Close() {
+	close(n.closing)
+	n.workers.Wait()
+	n.cancel()
 	close(n.out)
 }
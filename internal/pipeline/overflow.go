@@ -0,0 +1,14 @@
+package pipeline
+
+// Overflow modes for a pipeline stage whose output channel is full.
+const (
+	// OverflowBlock waits for room, applying backpressure upstream.
+	OverflowBlock = "block"
+	// OverflowDropNewest discards the normalized log that did not fit.
+	OverflowDropNewest = "drop-newest"
+	// OverflowDrop discards the collected event that did not fit.
+	OverflowDrop = "drop"
+	// OverflowSample keeps one in every N events that do not fit, waiting
+	// for room for those, and discards the rest.
+	OverflowSample = "sample"
+)
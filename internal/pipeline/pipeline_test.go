@@ -1,11 +1,36 @@
 package pipeline
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestCollector(t *testing.T) {
@@ -44,6 +69,124 @@ func TestCollector(t *testing.T) {
 	}
 }
 
+func TestCollectorOverflowPolicies(t *testing.T) {
+	newCollector := func(t *testing.T, policy string, sampleRate int, log *zap.Logger) (
+		*Collector, chan RawTrafficEvent, *metrics.Metrics,
+	) {
+		t.Helper()
+
+		events := make(chan RawTrafficEvent, 1)
+		m := &metrics.Metrics{
+			EventsCollected: prometheus.NewCounter(prometheus.CounterOpts{Name: "collected"}),
+			EventsDropped:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"stage"}),
+		}
+		collector := NewCollector(events, log)
+		collector.SetMetrics(m)
+		if err := collector.SetOverflowPolicy(policy, sampleRate); err != nil {
+			t.Fatalf("failed to set overflow policy: %v", err)
+		}
+
+		return collector, events, m
+	}
+
+	// collectBlocks reports whether Collect waits for room, then frees one
+	// slot and waits for it to return.
+	collectBlocks := func(collector *Collector, events chan RawTrafficEvent) bool {
+		done := make(chan struct{})
+		go func() {
+			_ = collector.Collect(RawTrafficEvent{})
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return false
+		case <-time.After(50 * time.Millisecond):
+		}
+		<-events
+		<-done
+
+		return true
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		collector, _, m := newCollector(t, "", 0, zap.New(core))
+
+		for i := 0; i < 5; i++ {
+			_ = collector.Collect(RawTrafficEvent{})
+		}
+
+		if dropped := testutil.ToFloat64(m.EventsDropped.WithLabelValues("collector")); dropped != 4 {
+			t.Errorf("expected 4 drops, got %v", dropped)
+		}
+		if warnings := logs.Len(); warnings != 1 {
+			t.Errorf("expected a single rate-limited warning, got %d", warnings)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		collector, events, m := newCollector(t, OverflowBlock, 0, zap.NewNop())
+		_ = collector.Collect(RawTrafficEvent{})
+
+		if !collectBlocks(collector, events) {
+			t.Fatal("expected Collect to wait for room")
+		}
+		if collected := testutil.ToFloat64(m.EventsCollected); collected != 2 {
+			t.Errorf("expected both events to be collected, got %v", collected)
+		}
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		collector, events, m := newCollector(t, OverflowSample, 3, zap.NewNop())
+		_ = collector.Collect(RawTrafficEvent{})
+
+		// Of three overflowing events, the first waits for room and the
+		// other two are dropped.
+		if !collectBlocks(collector, events) {
+			t.Fatal("expected the sampled event to wait for room")
+		}
+		_ = collector.Collect(RawTrafficEvent{})
+		_ = collector.Collect(RawTrafficEvent{})
+
+		if collected := testutil.ToFloat64(m.EventsCollected); collected != 2 {
+			t.Errorf("expected 2 events collected, got %v", collected)
+		}
+		if dropped := testutil.ToFloat64(m.EventsDropped.WithLabelValues("collector")); dropped != 2 {
+			t.Errorf("expected 2 events dropped, got %v", dropped)
+		}
+	})
+
+	t.Run("close releases blocked collects", func(t *testing.T) {
+		collector, _, m := newCollector(t, OverflowBlock, 0, zap.NewNop())
+		_ = collector.Collect(RawTrafficEvent{})
+
+		done := make(chan struct{})
+		go func() {
+			_ = collector.Collect(RawTrafficEvent{})
+			close(done)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		collector.Close()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected Close to release the blocked Collect")
+		}
+		if dropped := testutil.ToFloat64(m.EventsDropped.WithLabelValues("collector")); dropped != 1 {
+			t.Errorf("expected the blocked event to be dropped, got %v", dropped)
+		}
+	})
+
+	if err := NewCollector(nil, zap.NewNop()).SetOverflowPolicy(OverflowSample, 0); err == nil {
+		t.Error("expected a sample rate below 1 to be rejected")
+	}
+	if err := NewCollector(nil, zap.NewNop()).SetOverflowPolicy("drop-oldest", 0); err == nil {
+		t.Error("expected an unknown overflow policy to be rejected")
+	}
+}
+
 func TestWorkerPool(t *testing.T) {
 	log, _ := zap.NewDevelopment()
 	pool := NewWorkerPool(4, log)
@@ -101,3 +244,1310 @@ func TestConnectionPool(t *testing.T) {
 		t.Errorf("expected 5 active connections, got %d", pool.GetActiveConnections())
 	}
 }
+
+func TestHealthMonitorExportsQueueMetrics(t *testing.T) {
+	m, err := metrics.NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	eventChan := make(chan RawTrafficEvent, 10)
+	collector := NewCollector(eventChan, zap.NewNop())
+
+	monitor := NewHealthMonitor(0.5, 0.9, time.Minute, zap.NewNop())
+	monitor.SetMetrics(m)
+	monitor.AddQueue("collector", collector)
+
+	for i := 0; i < 3; i++ {
+		_ = collector.Collect(RawTrafficEvent{SourceIP: "192.168.1.1"})
+	}
+	monitor.Sample()
+
+	if got := testutil.ToFloat64(m.QueueDepth.WithLabelValues("collector")); got != 3 {
+		t.Errorf("expected collector depth 3, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.QueueCapacity.WithLabelValues("collector")); got != 10 {
+		t.Errorf("expected collector capacity 10, got %v", got)
+	}
+}
+
+func TestHealthMonitorCriticalQueue(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	eventChan := make(chan RawTrafficEvent, 10)
+	collector := NewCollector(eventChan, log)
+
+	monitor := NewHealthMonitor(0.5, 0.9, 50*time.Millisecond, log)
+	monitor.AddQueue("collector", collector)
+
+	recorder := httptest.NewRecorder()
+	monitor.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200 for empty queue, got %d", recorder.Code)
+	}
+
+	// Drive the queue past the critical threshold.
+	for i := 0; i < 10; i++ {
+		_ = collector.Collect(RawTrafficEvent{SourceIP: "192.168.1.1"})
+	}
+
+	if report := monitor.Sample(); !report.Ready || report.Status != HealthStatusDegraded {
+		t.Fatalf("expected degraded but ready before sustain window, got %+v", report)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	recorder = httptest.NewRecorder()
+	monitor.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for sustained critical queue, got %d", recorder.Code)
+	}
+
+	var report HealthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Status != HealthStatusUnhealthy {
+		t.Errorf("expected status %s, got %s", HealthStatusUnhealthy, report.Status)
+	}
+	if len(report.Queues) != 1 || report.Queues[0].Depth != 10 || report.Queues[0].Capacity != 10 {
+		t.Errorf("expected collector depth 10/10, got %+v", report.Queues)
+	}
+
+	// Draining the queue makes the pipeline ready again.
+	for len(eventChan) > 0 {
+		<-eventChan
+	}
+
+	recorder = httptest.NewRecorder()
+	monitor.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200 after draining, got %d", recorder.Code)
+	}
+}
+
+type fakeDependency struct {
+	healthy atomic.Bool
+}
+
+func (d *fakeDependency) Healthy() bool { return d.healthy.Load() }
+
+func TestHealthMonitorDependency(t *testing.T) {
+	db := &fakeDependency{}
+	db.healthy.Store(true)
+
+	monitor := NewHealthMonitor(0.5, 0.9, time.Minute, zap.NewNop())
+	monitor.AddDependency("database", db)
+
+	if report := monitor.Sample(); !report.Ready || report.Dependencies["database"] != "ok" {
+		t.Fatalf("expected ready with a healthy database, got %+v", report)
+	}
+
+	db.healthy.Store(false)
+	recorder := httptest.NewRecorder()
+	monitor.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with an unreachable database, got %d", recorder.Code)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if report.Status != HealthStatusUnhealthy || report.Dependencies["database"] != "unreachable" {
+		t.Errorf("expected the database reported unreachable, got %+v", report)
+	}
+
+	db.healthy.Store(true)
+	if report := monitor.Sample(); !report.Ready || report.Status != HealthStatusHealthy {
+		t.Errorf("expected ready once the database recovered, got %+v", report)
+	}
+}
+
+func TestNormalizerUUIDPrimaryKey(t *testing.T) {
+	log, _ := zap.NewDevelopment()
+	in := make(chan RawTrafficEvent, 100)
+	out := make(chan *models.TrafficLog, 100)
+
+	gen, err := NewIDGenerator(PrimaryKeyUUID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	normalizer := NewNormalizer(in, out, log)
+	normalizer.SetIDGenerator(gen)
+	normalizer.Start(4)
+
+	const events = 100
+	for i := 0; i < events; i++ {
+		in <- RawTrafficEvent{SourceIP: "192.168.1.1", Timestamp: time.Now()}
+	}
+
+	seen := make(map[string]bool, events)
+	for i := 0; i < events; i++ {
+		select {
+		case trafficLog := <-out:
+			if trafficLog.UUID == nil {
+				t.Fatal("expected UUID to be assigned")
+			}
+			if _, err := uuid.Parse(*trafficLog.UUID); err != nil {
+				t.Fatalf("expected a valid UUID, got %q", *trafficLog.UUID)
+			}
+			if seen[*trafficLog.UUID] {
+				t.Fatalf("duplicate UUID %s", *trafficLog.UUID)
+			}
+			seen[*trafficLog.UUID] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for normalized log")
+		}
+	}
+	close(in)
+}
+
+func TestNewIDGenerator(t *testing.T) {
+	if gen, err := NewIDGenerator(PrimaryKeyAutoincrement); err != nil || gen != nil {
+		t.Errorf("expected no generator for autoincrement, got %v, %v", gen, err)
+	}
+	if _, err := NewIDGenerator("snowflake"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestAnalyzeDomain(t *testing.T) {
+	tests := []struct {
+		domain         string
+		wantDecoded    string
+		wantSuspicious bool
+	}{
+		{domain: "example.com"},
+		{domain: "foo_bar.example.com"},
+		{domain: "xn--mnchen-3ya.de", wantDecoded: "münchen.de"},
+		{domain: "xn--fiqs8s.cn", wantDecoded: "中国.cn"},
+		// "аррӏе" spelled entirely with Cyrillic lookalikes.
+		{domain: "xn--80ak6aa92e.com", wantDecoded: "аррӏе.com", wantSuspicious: true},
+		// Cyrillic "а" followed by Latin "pple".
+		{domain: "xn--pple-43d.com", wantDecoded: "аpple.com", wantSuspicious: true},
+		{domain: "www.xn--g-0tb89c.com", wantDecoded: "www.ԁоg.com", wantSuspicious: true},
+		// Unicode that was never punycode-encoded is still checked.
+		{domain: "pаypal.com", wantSuspicious: true},
+		// Invalid IDNA is flagged rather than rejected.
+		{domain: "xn--a.com", wantDecoded: "\u0080.com", wantSuspicious: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			decoded, suspicious := AnalyzeDomain(tt.domain)
+			if decoded != tt.wantDecoded {
+				t.Errorf("expected decoded %q, got %q", tt.wantDecoded, decoded)
+			}
+			if suspicious != tt.wantSuspicious {
+				t.Errorf("expected suspicious=%v, got %v", tt.wantSuspicious, suspicious)
+			}
+		})
+	}
+}
+
+func TestAllReady(t *testing.T) {
+	log := zap.NewNop()
+	in := make(chan RawTrafficEvent)
+	out := make(chan *models.TrafficLog)
+
+	normalizer := NewNormalizer(in, out, log)
+	publisher := NewPublisher(out, nil, 10, 1000, log)
+	ready := AllReady(normalizer.Ready(), publisher.Ready())
+
+	normalizer.Start(2)
+	select {
+	case <-ready:
+		t.Fatal("expected not ready before the publisher starts")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	publisher.Start()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for pipeline readiness")
+	}
+
+	publisher.Stop()
+	close(in)
+}
+
+func TestAnalyticsSwitch(t *testing.T) {
+	log := zap.NewNop()
+	in := make(chan RawTrafficEvent, 10)
+	out := make(chan *models.TrafficLog, 10)
+
+	analytics := NewAnalyticsSwitch(true, log)
+	var gauge []bool
+	analytics.SetOnChange(func(enabled bool) {
+		gauge = append(gauge, enabled)
+	})
+
+	collector := NewCollector(in, log)
+	collector.SetAnalyticsSwitch(analytics)
+	normalizer := NewNormalizer(in, out, log)
+	normalizer.SetAnalyticsSwitch(analytics)
+	normalizer.Start(1)
+
+	analytics.Set(false)
+	for i := 0; i < 5; i++ {
+		_ = collector.Collect(RawTrafficEvent{SourceIP: "192.168.1.1", Timestamp: time.Now()})
+	}
+	if collector.Depth() != 0 {
+		t.Errorf("expected no events collected while analytics are off, got %d", collector.Depth())
+	}
+
+	select {
+	case <-out:
+		t.Fatal("expected no normalized logs while analytics are off")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rec := httptest.NewRecorder()
+	analytics.Handler(rec, httptest.NewRequest(http.MethodPost, "/analytics", strings.NewReader(`{"enabled": true}`)))
+	if rec.Code != http.StatusOK || !analytics.Enabled() {
+		t.Fatalf("expected POST to switch analytics back on, got status %d", rec.Code)
+	}
+
+	_ = collector.Collect(RawTrafficEvent{SourceIP: "192.168.1.1", Timestamp: time.Now()})
+	select {
+	case trafficLog := <-out:
+		if trafficLog.SourceIP != "192.168.1.1" {
+			t.Errorf("unexpected log %+v", trafficLog)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected collection to resume after switching analytics back on")
+	}
+
+	if len(gauge) != 3 || !gauge[0] || gauge[1] || !gauge[2] {
+		t.Errorf("expected state changes [true false true], got %v", gauge)
+	}
+	close(in)
+}
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := NewLatencyTracker()
+
+	// Uniform 1..10000ms, so pN is N% of 10000.
+	for ms := int64(1); ms <= 10000; ms++ {
+		tracker.Record(ms)
+	}
+
+	got := tracker.Percentiles()
+	if got.Count != 10000 {
+		t.Fatalf("expected 10000 observations, got %d", got.Count)
+	}
+
+	const tolerance = 0.01
+	for _, tc := range []struct {
+		name      string
+		got, want int64
+	}{
+		{"p50", got.P50, 5000},
+		{"p95", got.P95, 9500},
+		{"p99", got.P99, 9900},
+	} {
+		if diff := float64(tc.got-tc.want) / float64(tc.want); diff > tolerance || diff < -tolerance {
+			t.Errorf("expected %s within 1%% of %d, got %d", tc.name, tc.want, tc.got)
+		}
+	}
+
+	// Observations survive one rotation and are dropped after the second.
+	tracker.Rotate()
+	if count := tracker.Percentiles().Count; count != 10000 {
+		t.Errorf("expected previous window to be kept after one rotation, got %d", count)
+	}
+	tracker.Rotate()
+	if count := tracker.Percentiles().Count; count != 0 {
+		t.Errorf("expected histogram to be empty after two rotations, got %d", count)
+	}
+}
+
+func TestRegionEnricher(t *testing.T) {
+	enricher := NewRegionEnricher(map[string][]string{"Nordics": {"se", "NO", "DK", "FI", "IS"}})
+
+	tests := []struct {
+		country string
+		want    string
+	}{
+		{country: "DE", want: "Europe"},
+		{country: "us", want: "North America"},
+		{country: "JP", want: "Asia"},
+		{country: "BR", want: "South America"},
+		{country: "SE", want: "Nordics"},
+		{country: "ZZ", want: ""},
+		{country: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		trafficLog := &models.TrafficLog{SourceCountry: tt.country}
+		enricher.Enrich(trafficLog)
+		if trafficLog.Region != tt.want {
+			t.Errorf("country %q: expected region %q, got %q", tt.country, tt.want, trafficLog.Region)
+		}
+	}
+}
+
+// fakeGeoIP answers lookups from records keyed by address.
+type fakeGeoIP map[string]any
+
+func (f fakeGeoIP) Lookup(ip net.IP, result any) error {
+	record, ok := f[ip.String()]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+func TestGeoIPEnricher(t *testing.T) {
+	enricher := &GeoIPEnricher{
+		country: fakeGeoIP{
+			"203.0.113.7":   map[string]any{"Country": map[string]string{"ISOCode": "DE"}},
+			"198.51.100.20": map[string]any{"RegisteredCountry": map[string]string{"ISOCode": "US"}},
+			"10.0.0.1":      map[string]any{"Country": map[string]string{"ISOCode": "ZZ"}},
+		},
+		asn: fakeGeoIP{"198.51.100.20": map[string]uint{"Number": 15169}},
+	}
+
+	trafficLog := &models.TrafficLog{SourceIP: "203.0.113.7", DestinationIP: "198.51.100.20"}
+	enricher.Enrich(trafficLog)
+	if trafficLog.SourceCountry != "DE" || trafficLog.DestCountry != "US" || trafficLog.DestASN != 15169 {
+		t.Errorf("unexpected enrichment %q %q %d", trafficLog.SourceCountry, trafficLog.DestCountry, trafficLog.DestASN)
+	}
+
+	trafficLog = &models.TrafficLog{SourceIP: "10.0.0.1", DestinationIP: "example.com"}
+	enricher.Enrich(trafficLog)
+	if trafficLog.SourceCountry != "" || trafficLog.DestCountry != "" || trafficLog.DestASN != 0 {
+		t.Errorf("expected private and invalid addresses to be skipped, got %+v", trafficLog)
+	}
+
+	trafficLog = &models.TrafficLog{SourceIP: "203.0.113.7", SourceCountry: "FR"}
+	enricher.Enrich(trafficLog)
+	if trafficLog.SourceCountry != "FR" {
+		t.Errorf("expected a known country to be kept, got %q", trafficLog.SourceCountry)
+	}
+
+	// The region enricher, added after GeoIP, sees the looked up country.
+	normalized := &models.TrafficLog{SourceIP: "203.0.113.7"}
+	for _, e := range []Enricher{enricher, NewRegionEnricher(nil)} {
+		e.Enrich(normalized)
+	}
+	if normalized.Region != "Europe" {
+		t.Errorf("expected the region of the GeoIP country, got %q", normalized.Region)
+	}
+}
+
+func TestNewGeoIPEnricherMissingDatabase(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	enricher, err := NewGeoIPEnricher(missing, "")
+	if err == nil || !strings.Contains(err.Error(), "country database") {
+		t.Errorf("expected the missing database to be reported, got %v", err)
+	}
+	if enricher.Enabled() {
+		t.Error("expected an enricher without databases to be disabled")
+	}
+
+	enricher.Enrich(&models.TrafficLog{SourceIP: "203.0.113.7"})
+}
+
+func TestNormalizerRunsEnrichers(t *testing.T) {
+	in := make(chan RawTrafficEvent, 1)
+	out := make(chan *models.TrafficLog, 1)
+
+	normalizer := NewNormalizer(in, out, zap.NewNop())
+	normalizer.AddEnricher(enricherFunc(func(trafficLog *models.TrafficLog) {
+		trafficLog.SourceCountry = "FR"
+	}))
+	normalizer.AddEnricher(NewRegionEnricher(nil))
+	normalizer.Start(1)
+
+	in <- RawTrafficEvent{SourceIP: "192.0.2.1", Timestamp: time.Now()}
+
+	select {
+	case trafficLog := <-out:
+		if trafficLog.Region != "Europe" {
+			t.Errorf("expected enrichers to run in order, got region %q", trafficLog.Region)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for normalized log")
+	}
+	close(in)
+}
+
+type enricherFunc func(*models.TrafficLog)
+
+func (f enricherFunc) Enrich(trafficLog *models.TrafficLog) { f(trafficLog) }
+
+func TestReverseDNSEnricher(t *testing.T) {
+	var lookups int
+	enricher := NewReverseDNSEnricher(50*time.Millisecond, 1, 2)
+	enricher.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		switch addr {
+		case "93.184.216.34":
+			return []string{"example.com."}, nil
+		case "10.0.0.1":
+			<-ctx.Done()
+
+			return nil, ctx.Err()
+		default:
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		}
+	}
+	var failures int
+	enricher.SetOnFailure(func() { failures++ })
+
+	// enrich waits for the background lookup Enrich may start.
+	enrich := func(log *models.TrafficLog) *models.TrafficLog {
+		enricher.Enrich(log)
+		enricher.lookups.Wait()
+
+		return log
+	}
+
+	if first := enrich(&models.TrafficLog{DestinationIP: "93.184.216.34"}); first.Domain != "" {
+		t.Errorf("expected the first log to go out before the lookup finished, got %q", first.Domain)
+	}
+	if second := enrich(&models.TrafficLog{DestinationIP: "93.184.216.34"}); second.Domain != "example.com" {
+		t.Errorf("expected domain example.com, got %q", second.Domain)
+	}
+	if lookups != 1 {
+		t.Errorf("expected cached result to be reused, got %d lookups", lookups)
+	}
+
+	named := enrich(&models.TrafficLog{DestinationIP: "93.184.216.34", Domain: "requested.example"})
+	if named.Domain != "requested.example" || lookups != 1 {
+		t.Errorf("expected requested domain to be kept without a lookup, got %q", named.Domain)
+	}
+
+	enrich(&models.TrafficLog{DestinationIP: "192.0.2.1"})
+	missing := enrich(&models.TrafficLog{DestinationIP: "192.0.2.1"})
+	if missing.Domain != "" || failures != 0 || lookups != 2 {
+		t.Errorf("expected an address without PTR to be cached without a failure, got %q, %d failures, %d lookups",
+			missing.Domain, failures, lookups)
+	}
+
+	enrich(&models.TrafficLog{DestinationIP: "10.0.0.1"})
+	slow := enrich(&models.TrafficLog{DestinationIP: "10.0.0.1"})
+	if slow.Domain != "" || failures != 1 || lookups != 3 {
+		t.Errorf("expected a timed out lookup to be counted once and cached, got %q, %d failures, %d lookups",
+			slow.Domain, failures, lookups)
+	}
+
+	// The cache holds two entries, so the first address was evicted.
+	enrich(&models.TrafficLog{DestinationIP: "93.184.216.34"})
+	if lookups != 4 {
+		t.Errorf("expected evicted address to be looked up again, got %d lookups", lookups)
+	}
+}
+
+func TestReverseDNSEnricherRetriesFailuresAfterTTL(t *testing.T) {
+	var lookups int
+	enricher := NewReverseDNSEnricher(time.Second, 1, 10)
+	enricher.SetFailureTTL(20 * time.Millisecond)
+	enricher.lookupAddr = func(context.Context, string) ([]string, error) {
+		lookups++
+
+		return nil, errors.New("server misbehaving")
+	}
+
+	for range 2 {
+		enricher.Enrich(&models.TrafficLog{DestinationIP: "93.184.216.34"})
+		enricher.lookups.Wait()
+	}
+	if lookups != 1 {
+		t.Fatalf("expected the failure to be cached, got %d lookups", lookups)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	enricher.Enrich(&models.TrafficLog{DestinationIP: "93.184.216.34"})
+	enricher.lookups.Wait()
+	if lookups != 2 {
+		t.Errorf("expected the address to be retried once the failure expired, got %d lookups", lookups)
+	}
+}
+
+func TestReverseDNSEnricherDropsWhenBusy(t *testing.T) {
+	enricher := NewReverseDNSEnricher(time.Second, 1, 10)
+	enricher.slots <- struct{}{}
+	enricher.lookupAddr = func(context.Context, string) ([]string, error) {
+		t.Fatal("expected no lookup while every slot is busy")
+
+		return nil, nil
+	}
+	var failures int
+	enricher.SetOnFailure(func() { failures++ })
+
+	log := &models.TrafficLog{DestinationIP: "93.184.216.34"}
+	enricher.Enrich(log)
+	if log.Domain != "" || failures != 1 {
+		t.Errorf("expected dropped lookup to leave domain empty and count a failure, got %q, %d", log.Domain, failures)
+	}
+}
+
+// batchRecorder is a storage.Repository that records the size of every saved batch.
+type batchRecorder struct {
+	storage.Repository
+	mu      sync.Mutex
+	batches []int
+}
+
+func (r *batchRecorder) SaveTrafficLogs(_ context.Context, logs []*models.TrafficLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, len(logs))
+
+	return nil
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]int(nil), r.batches...)
+}
+
+func TestPublisherCoalescesSmallBatches(t *testing.T) {
+	const maxLatency = 300 * time.Millisecond
+
+	in := make(chan *models.TrafficLog, 100)
+	repo := &batchRecorder{}
+	publisher := NewPublisher(in, repo, 100, 10, zap.NewNop())
+	publisher.SetCoalescing(6, maxLatency)
+	publisher.Start()
+	defer publisher.Stop()
+
+	// Three bursts of two logs, each spanning several ticks, coalesce into one write.
+	for burst := 0; burst < 3; burst++ {
+		in <- &models.TrafficLog{}
+		in <- &models.TrafficLog{}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(repo.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := repo.sizes(); len(sizes) != 1 || sizes[0] != 6 {
+		t.Fatalf("expected the bursts to coalesce into a single write of 6, got %v", sizes)
+	}
+
+	// A lone log stays below the minimum batch size and is flushed at the latency bound.
+	start := time.Now()
+	in <- &models.TrafficLog{}
+	for len(repo.sizes()) == 1 && time.Since(start) < 2*time.Second {
+		time.Sleep(5 * time.Millisecond)
+	}
+	waited := time.Since(start)
+
+	if sizes := repo.sizes(); len(sizes) != 2 || sizes[1] != 1 {
+		t.Fatalf("expected the lone log to be flushed on its own, got %v", sizes)
+	}
+	if waited < maxLatency-50*time.Millisecond || waited > maxLatency+200*time.Millisecond {
+		t.Errorf("expected the lone log to be flushed after about %v, took %v", maxLatency, waited)
+	}
+}
+
+func TestPublisherWithoutCoalescingFlushesEveryTick(t *testing.T) {
+	in := make(chan *models.TrafficLog, 100)
+	repo := &batchRecorder{}
+	publisher := NewPublisher(in, repo, 100, 10, zap.NewNop())
+	publisher.Start()
+	defer publisher.Stop()
+
+	for burst := 0; burst < 3; burst++ {
+		in <- &models.TrafficLog{}
+		time.Sleep(40 * time.Millisecond)
+	}
+
+	if sizes := repo.sizes(); len(sizes) != 3 {
+		t.Errorf("expected one write per burst, got %v", sizes)
+	}
+}
+
+func TestPublisherFlushesOnMaxBatchBytes(t *testing.T) {
+	in := make(chan *models.TrafficLog, 100)
+	repo := &batchRecorder{}
+	// Neither the count nor the hour-long interval triggers a flush.
+	publisher := NewPublisher(in, repo, 100, 3600000, zap.NewNop())
+
+	// Each log is estimated at logRowBytes plus its 72-byte domain, so every
+	// third one reaches the limit.
+	log := &models.TrafficLog{Domain: strings.Repeat("a", 72)}
+	if size := estimatedBytes(log); size != logRowBytes+72 {
+		t.Fatalf("expected an estimate of %d bytes, got %d", logRowBytes+72, size)
+	}
+	publisher.SetMaxBatchBytes(3 * (logRowBytes + 72))
+	publisher.Start()
+
+	for i := 0; i < 7; i++ {
+		in <- log
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(repo.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := repo.sizes(); len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 3 {
+		t.Fatalf("expected two writes of 3 logs, got %v", sizes)
+	}
+
+	publisher.Stop()
+	if sizes := repo.sizes(); len(sizes) != 3 || sizes[2] != 1 {
+		t.Errorf("expected the remaining log to be flushed on stop, got %v", sizes)
+	}
+}
+
+// failingRepo fails every save while down is set, and the next failures
+// saves after that, and records the rest.
+type failingRepo struct {
+	batchRecorder
+	down     atomic.Bool
+	failures atomic.Int64
+}
+
+func (r *failingRepo) SaveTrafficLogs(ctx context.Context, logs []*models.TrafficLog) error {
+	if r.down.Load() || r.failures.Add(-1) >= 0 {
+		return errors.New("database unavailable")
+	}
+
+	return r.batchRecorder.SaveTrafficLogs(ctx, logs)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: 100, 2: 200, 3: 400, 4: 800, 5: 1000, 9: 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if got := policy.backoff(attempt); got < want/2 || got > want {
+				t.Fatalf("attempt %d: expected backoff in [%v, %v], got %v", attempt, want/2, want, got)
+			}
+		}
+	}
+}
+
+func TestPublisherRetriesFailedBatches(t *testing.T) {
+	newRetryingPublisher := func(repo *failingRepo, m *metrics.Metrics, policy RetryPolicy) (
+		*Publisher, chan *models.TrafficLog,
+	) {
+		logs := make(chan *models.TrafficLog, 10)
+		publisher := NewPublisher(logs, repo, 2, 20, zap.NewNop())
+		publisher.SetMetrics(m)
+		publisher.SetRetry(policy, 1)
+		publisher.Start()
+
+		return publisher, logs
+	}
+	newMetrics := func() *metrics.Metrics {
+		return &metrics.Metrics{
+			EventsPublished: prometheus.NewCounter(prometheus.CounterOpts{Name: "published"}),
+			DBQueryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "db_duration"}),
+			DBErrors:        prometheus.NewCounter(prometheus.CounterOpts{Name: "db_errors"}),
+		}
+	}
+
+	t.Run("transient failures", func(t *testing.T) {
+		repo := &failingRepo{}
+		repo.failures.Store(2)
+		m := newMetrics()
+
+		publisher, logs := newRetryingPublisher(repo, m, RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+		logs <- &models.TrafficLog{}
+		logs <- &models.TrafficLog{}
+		close(logs)
+		publisher.Close()
+
+		if sizes := repo.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+			t.Errorf("expected the batch saved on the third attempt, got %v", sizes)
+		}
+		if errs := testutil.ToFloat64(m.DBErrors); errs != 2 {
+			t.Errorf("expected a DB error per failed attempt, got %v", errs)
+		}
+	})
+
+	t.Run("held until the database recovers", func(t *testing.T) {
+		repo := &failingRepo{}
+		repo.down.Store(true)
+
+		publisher, logs := newRetryingPublisher(repo, newMetrics(), RetryPolicy{MaxAttempts: 2})
+		logs <- &models.TrafficLog{}
+		if depth := publisher.Depth(); depth != 0 {
+			t.Errorf("expected a filling batch not to count towards the depth, got %d", depth)
+		}
+		logs <- &models.TrafficLog{}
+		time.Sleep(50 * time.Millisecond)
+		if depth, capacity := publisher.Depth(), publisher.Capacity(); depth != 1 || capacity != 1 {
+			t.Errorf("expected the held batch reported as depth 1 of 1, got %d of %d", depth, capacity)
+		}
+		repo.down.Store(false)
+
+		deadline := time.Now().Add(time.Second)
+		for len(repo.sizes()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(logs)
+		publisher.Close()
+
+		if sizes := repo.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+			t.Errorf("expected the held batch saved after recovery, got %v", sizes)
+		}
+	})
+
+	t.Run("stop interrupts backoff", func(t *testing.T) {
+		repo := &failingRepo{}
+		repo.down.Store(true)
+
+		publisher, logs := newRetryingPublisher(repo, newMetrics(), RetryPolicy{
+			MaxAttempts: 100, InitialBackoff: time.Minute, MaxBackoff: time.Minute,
+		})
+		logs <- &models.TrafficLog{}
+		logs <- &models.TrafficLog{}
+		time.Sleep(20 * time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			publisher.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("expected Stop to interrupt the retry backoff")
+		}
+	})
+}
+
+func TestSpillReplaysFailedBatches(t *testing.T) {
+	repo := &failingRepo{}
+	repo.down.Store(true)
+
+	spill, err := NewSpill(t.TempDir(), 1<<20, repo, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create spill: %v", err)
+	}
+
+	logs := make(chan *models.TrafficLog, 10)
+	publisher := NewPublisher(logs, repo, 2, 60000, zap.NewNop())
+	publisher.SetSpill(spill)
+	publisher.Start()
+	for i := 0; i < 3; i++ {
+		logs <- &models.TrafficLog{ID: uint(i + 1), SourceIP: "10.0.0.1", Port: 443}
+	}
+	close(logs)
+	publisher.Close()
+
+	if replayed, err := spill.Replay(context.Background()); err == nil || replayed != 0 {
+		t.Fatalf("expected replay to fail while the database is down, got %d, %v", replayed, err)
+	}
+
+	repo.down.Store(false)
+	replayed, err := spill.Replay(context.Background())
+	if err != nil || replayed != 3 {
+		t.Fatalf("expected 3 logs replayed, got %d, %v", replayed, err)
+	}
+	if sizes := repo.sizes(); len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("expected the two spilled batches replayed in order, got %v", sizes)
+	}
+
+	if replayed, err := spill.Replay(context.Background()); err != nil || replayed != 0 {
+		t.Errorf("expected replayed batches to be removed, got %d, %v", replayed, err)
+	}
+}
+
+func TestSpillEvictsOldestBatches(t *testing.T) {
+	dir := t.TempDir()
+	batch := []*models.TrafficLog{{SourceIP: "10.0.0.1", Domain: strings.Repeat("a", 200)}}
+
+	spill, err := NewSpill(dir, 1<<20, &batchRecorder{}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create spill: %v", err)
+	}
+	if err := spill.Write(batch); err != nil {
+		t.Fatalf("failed to spill batch: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, spillPattern))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 spill file, got %v, %v", files, err)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatalf("failed to stat spill file: %v", err)
+	}
+
+	// Room for two and a half batches.
+	spill.maxBytes = info.Size()*2 + info.Size()/2
+	for i := 0; i < 2; i++ {
+		if err := spill.Write(batch); err != nil {
+			t.Fatalf("failed to spill batch %d: %v", i, err)
+		}
+	}
+
+	remaining, err := filepath.Glob(filepath.Join(dir, spillPattern))
+	if err != nil {
+		t.Fatalf("failed to list spill files: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0] == files[0] {
+		t.Errorf("expected the oldest batch evicted to stay under the bound, got %v", remaining)
+	}
+}
+
+func TestPipelineCloseDrainsBufferedEvents(t *testing.T) {
+	events := make(chan RawTrafficEvent, 10)
+	logs := make(chan *models.TrafficLog, 10)
+	analytics := NewAnalyticsSwitch(true, zap.NewNop())
+	repo := &batchRecorder{}
+
+	collector := NewCollector(events, zap.NewNop())
+	normalizer := NewNormalizer(events, logs, zap.NewNop())
+	normalizer.SetAnalyticsSwitch(analytics)
+	normalizer.Start(2)
+	// A long flush interval and a paused switch leave everything buffered.
+	publisher := NewPublisher(logs, repo, 100, 60000, zap.NewNop())
+	publisher.SetAnalyticsSwitch(analytics)
+	publisher.Start()
+
+	for i := 0; i < 3; i++ {
+		_ = collector.Collect(RawTrafficEvent{SourceIP: "10.0.0.1"})
+	}
+	analytics.Set(false)
+
+	collector.Close()
+	normalizer.Close()
+	publisher.Close()
+
+	if sizes := repo.sizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("expected all 3 buffered events to be saved on close, got %v", sizes)
+	}
+
+	// Collecting after close must not panic.
+	analytics.Set(true)
+	_ = collector.Collect(RawTrafficEvent{})
+}
+
+func TestPipelineEndToEnd(t *testing.T) {
+	events := make(chan RawTrafficEvent, 10)
+	logs := make(chan *models.TrafficLog, 10)
+	repo := storage.NewInMemoryRepository(0)
+
+	collector := NewCollector(events, zap.NewNop())
+	normalizer := NewNormalizer(events, logs, zap.NewNop())
+	normalizer.Start(2)
+	publisher := NewPublisher(logs, repo, 100, 60000, zap.NewNop())
+	publisher.Start()
+
+	for _, domain := range []string{"example.com", "example.com", "example.org"} {
+		_ = collector.Collect(RawTrafficEvent{
+			SourceIP: "10.0.0.1", Domain: domain, Port: 443, Timestamp: time.Now(), BytesIn: 100,
+		})
+	}
+
+	collector.Close()
+	normalizer.Close()
+	publisher.Close()
+
+	stats, err := repo.GetTopDomains(context.Background(), 10, storage.Pattern{})
+	if err != nil {
+		t.Fatalf("failed to get top domains: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Domain != "example.com" || stats[0].Count != 2 || stats[0].TotalBytesIn != 200 {
+		t.Errorf("expected collected events to reach the repository, got %+v", stats)
+	}
+}
+
+func TestNormalizerStopsWithOpenInput(t *testing.T) {
+	events := make(chan RawTrafficEvent, 10)
+	logs := make(chan *models.TrafficLog, 10)
+	analytics := NewAnalyticsSwitch(false, zap.NewNop())
+
+	normalizer := NewNormalizer(events, logs, zap.NewNop())
+	normalizer.SetAnalyticsSwitch(analytics)
+	normalizer.Start(2)
+	<-normalizer.Ready()
+
+	// The paused switch holds this event until Stop cancels the worker.
+	events <- RawTrafficEvent{SourceIP: "10.0.0.1"}
+
+	done := make(chan struct{})
+	go func() {
+		normalizer.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return while the input channel was open")
+	}
+	if _, ok := <-logs; ok {
+		t.Error("expected the output channel to be closed without normalizing the paused event")
+	}
+}
+
+func TestNormalizerCloseDrainsOpenInput(t *testing.T) {
+	events := make(chan RawTrafficEvent, 10)
+	logs := make(chan *models.TrafficLog, 10)
+	analytics := NewAnalyticsSwitch(false, zap.NewNop())
+
+	normalizer := NewNormalizer(events, logs, zap.NewNop())
+	normalizer.SetAnalyticsSwitch(analytics)
+	normalizer.Start(2)
+	for i := 0; i < 3; i++ {
+		events <- RawTrafficEvent{SourceIP: "10.0.0.1"}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		normalizer.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return while the input channel was open")
+	}
+
+	count := 0
+	for range logs {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 buffered events to be normalized on close, got %d", count)
+	}
+}
+
+func TestNormalizerOverflowModes(t *testing.T) {
+	newDrops := func() *metrics.Metrics {
+		return &metrics.Metrics{
+			EventsProcessed:   prometheus.NewCounter(prometheus.CounterOpts{Name: "processed"}),
+			ProcessingLatency: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "processing"}),
+			EventsDropped:     prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"stage"}),
+		}
+	}
+
+	t.Run("block", func(t *testing.T) {
+		events := make(chan RawTrafficEvent, 10)
+		logs := make(chan *models.TrafficLog, 1)
+		m := newDrops()
+
+		normalizer := NewNormalizer(events, logs, zap.NewNop())
+		normalizer.SetMetrics(m)
+		normalizer.Start(1)
+		for i := 0; i < 3; i++ {
+			events <- RawTrafficEvent{SourceIP: "10.0.0.1"}
+		}
+
+		for i := 0; i < 3; i++ {
+			select {
+			case <-logs:
+			case <-time.After(time.Second):
+				t.Fatalf("expected event %d to wait for room instead of being dropped", i)
+			}
+		}
+		close(events)
+		normalizer.Close()
+
+		if dropped := testutil.ToFloat64(m.EventsDropped.WithLabelValues("normalizer")); dropped != 0 {
+			t.Errorf("expected no drops, got %v", dropped)
+		}
+	})
+
+	t.Run("drop-newest", func(t *testing.T) {
+		events := make(chan RawTrafficEvent, 10)
+		logs := make(chan *models.TrafficLog, 1)
+		m := newDrops()
+		core, warnings := observer.New(zapcore.WarnLevel)
+
+		normalizer := NewNormalizer(events, logs, zap.New(core))
+		normalizer.SetMetrics(m)
+		if err := normalizer.SetOverflowMode(OverflowDropNewest); err != nil {
+			t.Fatalf("failed to set overflow mode: %v", err)
+		}
+		normalizer.Start(1)
+		for i := 0; i < 3; i++ {
+			events <- RawTrafficEvent{SourceIP: "10.0.0.1"}
+		}
+		close(events)
+		normalizer.Close()
+
+		if dropped := testutil.ToFloat64(m.EventsDropped.WithLabelValues("normalizer")); dropped != 2 {
+			t.Errorf("expected 2 drops, got %v", dropped)
+		}
+		if n := warnings.Len(); n != 1 {
+			t.Errorf("expected a single rate-limited warning, got %d", n)
+		}
+	})
+
+	if err := NewNormalizer(nil, nil, zap.NewNop()).SetOverflowMode("drop-oldest"); err == nil {
+		t.Error("expected an unknown overflow mode to be rejected")
+	}
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	m := &metrics.Metrics{
+		EventsCollected:   prometheus.NewCounter(prometheus.CounterOpts{Name: "collected"}),
+		EventsProcessed:   prometheus.NewCounter(prometheus.CounterOpts{Name: "processed"}),
+		EventsPublished:   prometheus.NewCounter(prometheus.CounterOpts{Name: "published"}),
+		ProcessingLatency: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "processing"}),
+		DBQueryDuration:   prometheus.NewHistogram(prometheus.HistogramOpts{Name: "db_duration"}),
+		DBErrors:          prometheus.NewCounter(prometheus.CounterOpts{Name: "db_errors"}),
+	}
+
+	events := make(chan RawTrafficEvent, 10)
+	logs := make(chan *models.TrafficLog, 10)
+	repo := &batchRecorder{}
+
+	collector := NewCollector(events, zap.NewNop())
+	collector.SetMetrics(m)
+	normalizer := NewNormalizer(events, logs, zap.NewNop())
+	normalizer.SetMetrics(m)
+	normalizer.Start(1)
+	publisher := NewPublisher(logs, repo, 100, 60000, zap.NewNop())
+	publisher.SetMetrics(m)
+	publisher.Start()
+
+	for i := 0; i < 3; i++ {
+		_ = collector.Collect(RawTrafficEvent{SourceIP: "10.0.0.1"})
+	}
+	collector.Close()
+	normalizer.Close()
+	publisher.Close()
+
+	for name, counter := range map[string]prometheus.Counter{
+		"collected": m.EventsCollected,
+		"processed": m.EventsProcessed,
+		"published": m.EventsPublished,
+	} {
+		if got := testutil.ToFloat64(counter); got != 3 {
+			t.Errorf("expected 3 events %s, got %v", name, got)
+		}
+	}
+	for name, histogram := range map[string]prometheus.Histogram{
+		"processing latency": m.ProcessingLatency,
+		"db duration":        m.DBQueryDuration,
+	} {
+		var metric dto.Metric
+		_ = histogram.Write(&metric)
+		if count := metric.GetHistogram().GetSampleCount(); count == 0 {
+			t.Errorf("expected %s observations", name)
+		}
+	}
+	if got := testutil.ToFloat64(m.DBErrors); got != 0 {
+		t.Errorf("expected no database errors, got %v", got)
+	}
+}
+
+func TestRetentionPurgesOldLogs(t *testing.T) {
+	repo := storage.NewInMemoryRepository(0)
+	now := time.Now()
+	logs := []*models.TrafficLog{
+		{SourceIP: "10.0.0.1", Timestamp: now.Add(-48 * time.Hour)},
+		{SourceIP: "10.0.0.2", Timestamp: now.Add(-25 * time.Hour)},
+		{SourceIP: "10.0.0.3", Timestamp: now.Add(-time.Hour)},
+	}
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+
+	var counted int64
+	retention := NewRetention(repo, 24*time.Hour, zap.NewNop())
+	retention.SetOnDeleted(func(rows int64) {
+		counted += rows
+	})
+
+	deleted, err := retention.Purge(context.Background())
+	if err != nil || deleted != 2 || counted != 2 {
+		t.Fatalf("expected 2 logs deleted and counted, got %d (%d), %v", deleted, counted, err)
+	}
+	remaining, _ := repo.GetTrafficByTimeRange(context.Background(), now.Add(-72*time.Hour), now, 10, 0,
+		storage.TrafficFilter{})
+	if len(remaining) != 1 || remaining[0].SourceIP != "10.0.0.3" {
+		t.Errorf("expected only the recent log to remain, got %+v", remaining)
+	}
+
+	if deleted, err := retention.Purge(context.Background()); err != nil || deleted != 0 || counted != 2 {
+		t.Errorf("expected nothing left to delete, got %d, %v", deleted, err)
+	}
+}
+
+func TestAnomalyDetectorBaselineMultiple(t *testing.T) {
+	var fired []Anomaly
+	thresholds := AnomalyThresholds{BaselineMultiple: 3, MinConnections: 5, MinBytes: 1 << 30}
+	detector := NewAnomalyDetector(thresholds, 2, 0, zap.NewNop())
+	detector.SetOnAnomaly(func(anomaly Anomaly) {
+		fired = append(fired, anomaly)
+	})
+
+	connect := func(source string, n int) {
+		for range n {
+			detector.Observe(&models.TrafficLog{SourceIP: source, Status: models.StatusSuccess, BytesIn: 100})
+		}
+	}
+
+	// Without a previous window there is no baseline yet.
+	connect("10.0.0.1", 10)
+	detector.Rotate()
+	connect("10.0.0.1", 4)
+	detector.Rotate()
+	if len(fired) != 0 {
+		t.Fatalf("expected no anomalies while building the baseline, got %+v", fired)
+	}
+
+	// The baseline is (10+4)/2 = 7, so the 22nd connection crosses 3x.
+	connect("10.0.0.1", 21)
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusSuccess, Interim: true, BytesIn: 1})
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusAccepted})
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusRateLimited})
+	if len(fired) != 0 {
+		t.Fatalf("expected only final successful logs to count as connections, got %+v", fired)
+	}
+	connect("10.0.0.1", 5)
+	if len(fired) != 1 {
+		t.Fatalf("expected one anomaly per reason and window, got %+v", fired)
+	}
+	if got := fired[0]; got.SourceIP != "10.0.0.1" || got.Reason != AnomalyConnections || got.Value != 22 ||
+		got.Threshold != 21 || got.Baseline != 7 {
+		t.Errorf("unexpected anomaly %+v", got)
+	}
+
+	anomalies := detector.Anomalies()
+	if len(anomalies) != 1 || anomalies[0] != fired[0] {
+		t.Errorf("expected the anomaly to be listed, got %+v", anomalies)
+	}
+}
+
+func TestAnomalyDetectorThresholdsAndBounds(t *testing.T) {
+	detector := NewAnomalyDetector(AnomalyThresholds{Bytes: 1000}, 2, 2, zap.NewNop())
+
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusSuccess, BytesIn: 600})
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.2", Status: models.StatusSuccess, BytesOut: 10})
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.3", Status: models.StatusSuccess, BytesIn: 5000})
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusSuccess, BytesOut: 600})
+
+	anomalies := detector.Anomalies()
+	if len(anomalies) != 1 || anomalies[0].Reason != AnomalyBytes || anomalies[0].Value != 1200 {
+		t.Fatalf("expected only the tracked IP over the byte threshold, got %+v", anomalies)
+	}
+
+	// 10.0.0.2 goes quiet and is forgotten once its windows roll off,
+	// making room for a new IP.
+	detector.Rotate()
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.1", Status: models.StatusSuccess})
+	detector.Rotate()
+	detector.Rotate()
+	detector.Observe(&models.TrafficLog{SourceIP: "10.0.0.3", Status: models.StatusSuccess, BytesIn: 5000})
+	if anomalies := detector.Anomalies(); len(anomalies) != 2 || anomalies[0].SourceIP != "10.0.0.3" {
+		t.Errorf("expected the new IP tracked and listed first, got %+v", anomalies)
+	}
+
+	var disabled *AnomalyDetector
+	disabled.Observe(&models.TrafficLog{SourceIP: "10.0.0.1"})
+}
+
+func TestTailHubFiltersAndDropsSlowClients(t *testing.T) {
+	drops := 0
+	hub := NewTailHub(2, 1, zap.NewNop())
+	hub.SetOnDrop(func() {
+		drops++
+	})
+
+	domain, _ := storage.ParsePattern("*.example.com")
+	matching, err := hub.Subscribe(TailFilter{Domain: domain, Port: 443})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	slow, _ := hub.Subscribe(TailFilter{})
+	if _, err := hub.Subscribe(TailFilter{}); !errors.Is(err, ErrTooManyTailClients) {
+		t.Errorf("expected the client cap to apply, got %v", err)
+	}
+
+	hub.Publish(&models.TrafficLog{Domain: "api.example.com", Port: 80})
+	hub.Publish(&models.TrafficLog{Domain: "api.example.com", Port: 443})
+
+	if got := <-matching.Logs(); got.Port != 443 {
+		t.Errorf("expected only the matching log, got %+v", got)
+	}
+	// The second log overflowed the slow client's one-log buffer.
+	<-slow.Logs()
+	if _, ok := <-slow.Logs(); ok || drops != 1 || hub.Clients() != 1 {
+		t.Errorf("expected the slow client to be dropped, got %d drops and %d clients", drops, hub.Clients())
+	}
+
+	slow.Close()
+	matching.Close()
+	if hub.Clients() != 0 {
+		t.Errorf("expected no clients after closing, got %d", hub.Clients())
+	}
+
+	var disabled *TailHub
+	disabled.Publish(&models.TrafficLog{})
+}
+
+func TestTailHubHandlerStreamsEvents(t *testing.T) {
+	hub := NewTailHub(1, 10, zap.NewNop())
+	server := httptest.NewServer(http.HandlerFunc(hub.Handler))
+	t.Cleanup(server.Close)
+
+	if resp, err := http.Get(server.URL + "?port=70000"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid port to be rejected, got %v, %v", resp, err)
+	}
+
+	resp, err := http.Get(server.URL + "?source_ip=10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	hub.Publish(&models.TrafficLog{SourceIP: "10.0.0.2", Domain: "other.example"})
+	hub.Publish(&models.TrafficLog{SourceIP: "10.0.0.1", Domain: "example.com"})
+
+	event, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	if !strings.HasPrefix(event, "data: {") || !strings.Contains(event, `"domain":"example.com"`) {
+		t.Errorf("expected the matching log as an event, got %q", event)
+	}
+}
+
+func TestPublisherTracesFlushes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+
+	repo := &failingRepo{}
+	repo.failures.Store(1)
+	logs := make(chan *models.TrafficLog, 2)
+	publisher := NewPublisher(logs, repo, 2, 20, zap.NewNop())
+	publisher.SetRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}, 1)
+	publisher.Start()
+	logs <- &models.TrafficLog{}
+	logs <- &models.TrafficLog{}
+	close(logs)
+	publisher.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "pipeline.flush" {
+		t.Fatalf("expected one flush span, got %v", spans)
+	}
+	attrs := make(map[attribute.Key]int64)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value.AsInt64()
+	}
+	if attrs["pipeline.batch_size"] != 2 || attrs["pipeline.attempts"] != 2 {
+		t.Errorf("expected the batch size and both attempts recorded, got %v", attrs)
+	}
+}
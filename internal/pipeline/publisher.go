@@ -3,10 +3,16 @@ package pipeline
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
 	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/andev0x/socks5-proxy-analytics/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -16,12 +22,30 @@ type Publisher struct {
 	repo        storage.Repository
 	batchSize   int
 	flushTicker *time.Ticker
+	heldCount   atomic.Int64
 	log         *zap.Logger
 	wg          sync.WaitGroup
 	ctx         context.Context
 	cancel      context.CancelFunc
+	ready       chan struct{}
+	closing     chan struct{}
+	analytics   *AnalyticsSwitch
+	metrics     *metrics.Metrics
+	spill       *Spill
+	retry       RetryPolicy
+	held        [][]*models.TrafficLog
+	maxHeld     int
+
+	minBatchSize  int
+	maxLatency    time.Duration
+	maxBatchBytes int64
 }
 
+// logRowBytes is the estimated size of a traffic log's fixed-width columns
+// (IDs, timestamps, counters and flags) and row overhead, to which
+// estimatedBytes adds the length of its strings.
+const logRowBytes = 128
+
 // NewPublisher creates a new traffic log publisher.
 func NewPublisher(
 	in chan *models.TrafficLog,
@@ -40,6 +64,8 @@ func NewPublisher(
 		log:         log,
 		ctx:         ctx,
 		cancel:      cancel,
+		ready:       make(chan struct{}),
+		closing:     make(chan struct{}),
 	}
 }
 
@@ -49,18 +75,111 @@ func (p *Publisher) Start() {
 	go p.processBatch()
 }
 
+// SetAnalyticsSwitch pauses publishing while the switch is off; buffered logs
+// are flushed once it is switched back on. It must be called before Start.
+func (p *Publisher) SetAnalyticsSwitch(s *AnalyticsSwitch) {
+	p.analytics = s
+}
+
+// SetMetrics counts published logs and records batch write durations and
+// failures in m. It must be called before Start.
+func (p *Publisher) SetMetrics(m *metrics.Metrics) {
+	p.metrics = m
+}
+
+// SetCoalescing makes ticker flushes skip batches smaller than minBatchSize,
+// so bursts accumulate into fewer, larger writes. A held-back batch is still
+// flushed once its oldest log has waited maxLatency. Coalescing is off when
+// minBatchSize is below 2 or maxLatency is not positive. It must be called
+// before Start.
+func (p *Publisher) SetCoalescing(minBatchSize int, maxLatency time.Duration) {
+	p.minBatchSize = minBatchSize
+	p.maxLatency = maxLatency
+}
+
+// SetSpill writes batches the repository fails to save to s instead of
+// dropping them. It must be called before Start.
+func (p *Publisher) SetSpill(s *Spill) {
+	p.spill = s
+}
+
+// SetRetry retries failed batch writes according to policy. A batch that
+// still fails is spilled when a spill is set, otherwise up to maxHeldBatches
+// such batches are held in memory and retried on every flush tick, dropping
+// the oldest past that. It must be called before Start.
+func (p *Publisher) SetRetry(policy RetryPolicy, maxHeldBatches int) {
+	p.retry = policy
+	p.maxHeld = maxHeldBatches
+}
+
+// SetMaxBatchBytes also flushes the batch once its estimated size, see
+// estimatedBytes, reaches maxBytes, bounding the memory and statement size of
+// a batch of large logs. 0 disables the limit. It must be called before
+// Start.
+func (p *Publisher) SetMaxBatchBytes(maxBytes int64) {
+	p.maxBatchBytes = maxBytes
+}
+
+// estimatedBytes cheaply estimates the size of log once written: a fixed
+// cost per row plus the length of its variable-width columns.
+func estimatedBytes(log *models.TrafficLog) int64 {
+	size := logRowBytes + len(log.SourceIP) + len(log.SourceCountry) + len(log.Region) + len(log.Username) +
+		len(log.DestinationIP) + len(log.DestCountry) + len(log.Domain) + len(log.PunycodeDecoded) +
+		len(log.Protocol) + len(log.Status)
+	if log.UUID != nil {
+		size += len(*log.UUID)
+	}
+
+	return int64(size)
+}
+
+// full reports whether a batch of n logs of size estimated bytes must be
+// flushed right away.
+func (p *Publisher) full(n int, size int64) bool {
+	return n >= p.batchSize || (p.maxBatchBytes > 0 && size >= p.maxBatchBytes)
+}
+
+func (p *Publisher) coalescing() bool {
+	return p.minBatchSize > 1 && p.maxLatency > 0
+}
+
+// Ready returns a channel that is closed once the publisher is consuming logs.
+func (p *Publisher) Ready() <-chan struct{} {
+	return p.ready
+}
+
 func (p *Publisher) processBatch() {
 	defer p.wg.Done()
+	close(p.ready)
 
 	batch := make([]*models.TrafficLog, 0, p.batchSize)
 	defer func() {
+		p.retryHeld()
 		if len(batch) > 0 {
 			p.flushBatch(batch)
 		}
+		p.dropHeld()
 		p.flushTicker.Stop()
 	}()
 
+	// overdue fires when the oldest log in a coalescing batch has waited maxLatency.
+	var overdue <-chan time.Time
+	var batchBytes int64
+	flush := func() {
+		p.flushBatch(batch)
+		batch = make([]*models.TrafficLog, 0, p.batchSize)
+		batchBytes = 0
+		overdue = nil
+	}
+
 	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.analytics.Resumed():
+		case <-p.closing:
+		}
+
 		select {
 		case <-p.ctx.Done():
 			return
@@ -68,39 +187,163 @@ func (p *Publisher) processBatch() {
 			if log == nil {
 				return
 			}
+			if len(batch) == 0 && p.coalescing() {
+				overdue = time.After(p.maxLatency)
+			}
 			batch = append(batch, log)
-			if len(batch) >= p.batchSize {
-				p.flushBatch(batch)
-				batch = make([]*models.TrafficLog, 0, p.batchSize)
+			batchBytes += estimatedBytes(log)
+			if p.full(len(batch), batchBytes) {
+				flush()
 			}
 		case <-p.flushTicker.C:
+			p.retryHeld()
+			if len(batch) > 0 && (!p.coalescing() || len(batch) >= p.minBatchSize) {
+				flush()
+			}
+		case <-overdue:
 			if len(batch) > 0 {
-				p.flushBatch(batch)
-				batch = make([]*models.TrafficLog, 0, p.batchSize)
+				flush()
 			}
 		}
+		p.heldCount.Store(int64(len(p.held)))
 	}
 }
 
 func (p *Publisher) flushBatch(batch []*models.TrafficLog) {
-	ctx, cancel := context.WithTimeout(p.ctx, 30*time.Second)
+	if err := p.save(batch); err != nil {
+		p.log.Error("failed to save traffic logs", zap.Error(err), zap.Int("batch_size", len(batch)))
+		p.keep(batch)
+
+		return
+	}
+
+	p.log.Debug("batch saved successfully", zap.Int("batch_size", len(batch)))
+}
+
+// save writes batch, retrying failures according to the retry policy. Retries
+// stop early once the publisher is stopped.
+func (p *Publisher) save(batch []*models.TrafficLog) (err error) {
+	// Not derived from p.ctx: the final flush runs after Stop cancels it.
+	ctx, span := tracing.Tracer().Start(context.Background(), "pipeline.flush",
+		trace.WithAttributes(attribute.Int("pipeline.batch_size", len(batch))))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		span.SetAttributes(attribute.Int("pipeline.attempts", attempt))
+		err = p.saveOnce(ctx, batch)
+		if err == nil || attempt >= p.retry.MaxAttempts {
+			return err
+		}
+
+		wait := p.retry.backoff(attempt)
+		if p.retry.MaxElapsed > 0 && time.Since(start)+wait > p.retry.MaxElapsed {
+			return err
+		}
+		p.log.Warn("failed to save traffic logs, retrying", zap.Error(err),
+			zap.Int("attempt", attempt), zap.Duration("backoff", wait))
+
+		select {
+		case <-p.ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p *Publisher) saveOnce(ctx context.Context, batch []*models.TrafficLog) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	if err := p.repo.SaveTrafficLogs(ctx, batch); err != nil {
-		p.log.Error("failed to save traffic logs", zap.Error(err), zap.Int("batch_size", len(batch)))
-	} else {
-		p.log.Debug("batch saved successfully", zap.Int("batch_size", len(batch)))
+	start := time.Now()
+	err := p.repo.SaveTrafficLogs(ctx, batch)
+	if p.metrics != nil {
+		p.metrics.DBQueryDuration.Observe(float64(time.Since(start)) / float64(time.Millisecond))
+		if err != nil {
+			p.metrics.DBErrors.Inc()
+		} else {
+			p.metrics.EventsPublished.Add(float64(len(batch)))
+		}
+	}
+
+	return err
+}
+
+// keep spills a batch that could not be saved, or holds it for the next
+// flush tick.
+func (p *Publisher) keep(batch []*models.TrafficLog) {
+	if p.spill != nil {
+		if err := p.spill.Write(batch); err != nil {
+			p.log.Error("failed to spill traffic logs", zap.Error(err), zap.Int("batch_size", len(batch)))
+		}
+
+		return
 	}
+
+	if p.maxHeld < 1 {
+		return
+	}
+	p.held = append(p.held, batch)
+	if len(p.held) > p.maxHeld {
+		p.log.Error("too many failed batches held, dropping the oldest", zap.Int("batch_size", len(p.held[0])))
+		p.held = p.held[1:]
+	}
+}
+
+// retryHeld saves held batches oldest first, stopping at the first failure.
+func (p *Publisher) retryHeld() {
+	for len(p.held) > 0 {
+		if err := p.save(p.held[0]); err != nil {
+			p.log.Error("failed to save held traffic logs", zap.Error(err), zap.Int("held_batches", len(p.held)))
+
+			return
+		}
+		p.held = p.held[1:]
+	}
+}
+
+func (p *Publisher) dropHeld() {
+	for _, batch := range p.held {
+		p.log.Error("dropping traffic logs that could not be saved", zap.Int("batch_size", len(batch)))
+	}
+	p.held = nil
+	p.heldCount.Store(0)
+}
+
+// Depth returns the number of failed batches held for retry. The current
+// batch filling up is normal operation, so it is not counted.
+func (p *Publisher) Depth() int {
+	return int(p.heldCount.Load())
+}
+
+// Capacity returns the number of failed batches held before the oldest is
+// dropped; 0 when none are held, such as when they are spilled instead.
+func (p *Publisher) Capacity() int {
+	if p.spill != nil {
+		return 0
+	}
+
+	return max(p.maxHeld, 0)
 }
 
-// Stop stops the publisher and waits for pending operations.
+// Stop stops the publisher without reading the rest of its input channel,
+// flushes the current batch and waits for it to be saved.
 func (p *Publisher) Stop() {
 	p.cancel()
 	p.wg.Wait()
 }
 
-// Close closes the publisher input channel and stops processing.
+// Close publishes every log left in the input channel, which must already be
+// closed (see Normalizer.Close), and waits for the final flush. Logs are
+// published even while analytics is paused.
 func (p *Publisher) Close() {
-	close(p.in)
-	p.Stop()
+	close(p.closing)
+	p.wg.Wait()
+	p.cancel()
 }
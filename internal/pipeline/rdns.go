@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// defaultReverseDNSFailureTTL is how long a failed lookup is remembered
+// unless SetFailureTTL is called.
+const defaultReverseDNSFailureTTL = 30 * time.Second
+
+// ReverseDNSEnricher fills TrafficLog.Domain from a reverse DNS lookup of the
+// destination IP when the client connected by IP. Lookups never run on the
+// normalizer worker: an address missing from the cache starts a lookup in
+// the background and its log goes out without a hostname, so only later
+// connections to it are enriched. Each lookup has a timeout, at most workers
+// run at once, and results are kept in an LRU cache; failures are cached for
+// the failure TTL so an unresponsive address isn't retried for every log.
+type ReverseDNSEnricher struct {
+	timeout    time.Duration
+	failureTTL time.Duration
+	slots      chan struct{}
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	onFailure  func()
+	lookups    sync.WaitGroup
+
+	mu        sync.Mutex
+	cacheSize int
+	entries   map[string]*list.Element
+	lru       *list.List
+	pending   map[string]struct{}
+}
+
+// cachedHostname is a lookup result. Failures have an empty name and expire;
+// a zero expires never does.
+type cachedHostname struct {
+	ip      string
+	name    string
+	expires time.Time
+}
+
+// NewReverseDNSEnricher creates a reverse DNS enricher that runs at most
+// workers concurrent lookups, each limited to timeout, and caches up to
+// cacheSize results.
+func NewReverseDNSEnricher(timeout time.Duration, workers, cacheSize int) *ReverseDNSEnricher {
+	return &ReverseDNSEnricher{
+		timeout:    timeout,
+		failureTTL: defaultReverseDNSFailureTTL,
+		slots:      make(chan struct{}, max(workers, 1)),
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		cacheSize:  max(cacheSize, 1),
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		pending:    make(map[string]struct{}),
+	}
+}
+
+// SetFailureTTL sets how long a failed or timed out lookup is cached before
+// the address is looked up again. It must be called before the enricher is
+// used.
+func (e *ReverseDNSEnricher) SetFailureTTL(ttl time.Duration) {
+	e.failureTTL = ttl
+}
+
+// SetOnFailure registers fn to be called for every lookup that failed, timed
+// out or was dropped for lack of a free slot, e.g. to increment a counter.
+// It must be called before the enricher is used.
+func (e *ReverseDNSEnricher) SetOnFailure(fn func()) {
+	e.onFailure = fn
+}
+
+// Enrich sets log.Domain to the destination's hostname if it is empty and
+// cached, and otherwise starts a background lookup for later logs.
+func (e *ReverseDNSEnricher) Enrich(log *models.TrafficLog) {
+	if log.Domain != "" || log.DestinationIP == "" {
+		return
+	}
+
+	name, ok := e.cached(log.DestinationIP)
+	if !ok {
+		e.resolve(log.DestinationIP)
+
+		return
+	}
+
+	if name != "" {
+		log.Domain = name
+		log.PunycodeDecoded, log.Suspicious = AnalyzeDomain(name)
+	}
+}
+
+// resolve looks ip up in the background unless a lookup for it is already
+// running. When every slot is busy the lookup is dropped and counted as a
+// failure, but not cached, so the next log retries it.
+func (e *ReverseDNSEnricher) resolve(ip string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.pending[ip]; ok {
+		return
+	}
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		e.failed()
+
+		return
+	}
+	e.pending[ip] = struct{}{}
+
+	e.lookups.Add(1)
+	go func() {
+		defer e.lookups.Done()
+
+		name, ok := e.lookup(ip)
+		<-e.slots
+		if ok {
+			e.store(ip, name, time.Time{})
+		} else {
+			e.failed()
+			e.store(ip, "", time.Now().Add(e.failureTTL))
+		}
+	}()
+}
+
+func (e *ReverseDNSEnricher) failed() {
+	if e.onFailure != nil {
+		e.onFailure()
+	}
+}
+
+// lookup resolves ip, returning ok=false when the lookup failed. An address
+// without a PTR record is a successful lookup with an empty name, so it is
+// cached like any other result.
+func (e *ReverseDNSEnricher) lookup(ip string) (name string, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	names, err := e.lookupAddr(ctx, ip)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", true
+		}
+
+		return "", false
+	}
+	if len(names) == 0 {
+		return "", true
+	}
+
+	return strings.TrimSuffix(names[0], "."), true
+}
+
+func (e *ReverseDNSEnricher) cached(ip string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	elem, ok := e.entries[ip]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*cachedHostname)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		e.lru.Remove(elem)
+		delete(e.entries, ip)
+
+		return "", false
+	}
+	e.lru.MoveToFront(elem)
+
+	return entry.name, true
+}
+
+// store caches a lookup result and marks the lookup of ip as finished.
+func (e *ReverseDNSEnricher) store(ip, name string, expires time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.pending, ip)
+	if elem, ok := e.entries[ip]; ok {
+		entry := elem.Value.(*cachedHostname)
+		entry.name, entry.expires = name, expires
+		e.lru.MoveToFront(elem)
+
+		return
+	}
+
+	e.entries[ip] = e.lru.PushFront(&cachedHostname{ip: ip, name: name, expires: expires})
+	if e.lru.Len() > e.cacheSize {
+		oldest := e.lru.Back()
+		e.lru.Remove(oldest)
+		delete(e.entries, oldest.Value.(*cachedHostname).ip)
+	}
+}
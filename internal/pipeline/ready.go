@@ -0,0 +1,16 @@
+package pipeline
+
+// AllReady returns a channel that is closed once every channel in readies
+// has been closed.
+func AllReady(readies ...<-chan struct{}) <-chan struct{} {
+	all := make(chan struct{})
+
+	go func() {
+		for _, ready := range readies {
+			<-ready
+		}
+		close(all)
+	}()
+
+	return all
+}
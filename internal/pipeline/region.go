@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// Enricher adds derived fields to a normalized traffic log. Enrichers run in
+// the order they were added, so later ones can build on earlier results.
+type Enricher interface {
+	Enrich(log *models.TrafficLog)
+}
+
+// continentCountries lists ISO 3166-1 alpha-2 country codes by continent.
+var continentCountries = map[string]string{
+	"Africa": "AO BF BI BJ BW CD CF CG CI CM CV DJ DZ EG EH ER ET GA GH GM GN GQ GW KE KM LR LS LY MA MG ML MR " +
+		"MU MW MZ NA NE NG RE RW SC SD SH SL SN SO SS ST SZ TD TG TN TZ UG YT ZA ZM ZW",
+	"Antarctica": "AQ BV GS HM TF",
+	"Asia": "AE AF AM AZ BD BH BN BT CC CN CX GE HK ID IL IN IO IQ IR JO JP KG KH KP KR KW KZ LA LB LK MM MN " +
+		"MO MV MY NP OM PH PK PS QA SA SG SY TH TJ TL TM TR TW UZ VN YE",
+	"Europe": "AD AL AT AX BA BE BG BY CH CY CZ DE DK EE ES FI FO FR GB GG GI GR HR HU IE IM IS IT JE LI LT LU " +
+		"LV MC MD ME MK MT NL NO PL PT RO RS RU SE SI SJ SK SM UA VA XK",
+	"North America": "AG AI AW BB BL BM BQ BS BZ CA CR CU CW DM DO GD GL GP GT HN HT JM KN KY LC MF MQ MS MX NI " +
+		"PA PM PR SV SX TC TT US VC VG VI",
+	"Oceania":       "AS AU CK FJ FM GU KI MH MP NC NF NR NU NZ PF PG PN PW SB TK TO TV UM VU WF WS",
+	"South America": "AR BO BR CL CO EC FK GF GY PE PY SR UY VE",
+}
+
+// RegionEnricher sets TrafficLog.Region from the source country. Countries
+// map to their continent unless a custom region group claims them.
+type RegionEnricher struct {
+	regions map[string]string
+}
+
+// NewRegionEnricher creates a region enricher. custom maps a region name to
+// the country codes it contains and takes precedence over the continent
+// defaults.
+func NewRegionEnricher(custom map[string][]string) *RegionEnricher {
+	regions := make(map[string]string)
+	for continent, countries := range continentCountries {
+		for _, country := range strings.Fields(countries) {
+			regions[country] = continent
+		}
+	}
+
+	for region, countries := range custom {
+		for _, country := range countries {
+			regions[strings.ToUpper(country)] = region
+		}
+	}
+
+	return &RegionEnricher{regions: regions}
+}
+
+// Region returns the region for an ISO 3166-1 alpha-2 country code, or "" if unknown.
+func (e *RegionEnricher) Region(country string) string {
+	return e.regions[strings.ToUpper(country)]
+}
+
+// Enrich sets log.Region from log.SourceCountry.
+func (e *RegionEnricher) Enrich(log *models.TrafficLog) {
+	if log.SourceCountry != "" {
+		log.Region = e.Region(log.SourceCountry)
+	}
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Retention periodically deletes traffic logs older than a maximum age so
+// the database doesn't grow without bound.
+type Retention struct {
+	repo      storage.Repository
+	maxAge    time.Duration
+	log       *zap.Logger
+	onDeleted func(rows int64)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRetention returns a retention job deleting logs older than maxAge.
+func NewRetention(repo storage.Repository, maxAge time.Duration, log *zap.Logger) *Retention {
+	return &Retention{
+		repo:   repo,
+		maxAge: maxAge,
+		log:    log,
+		stop:   make(chan struct{}),
+	}
+}
+
+// SetOnDeleted sets a callback invoked with the number of rows each purge
+// deleted, e.g. to count them in a metric. It must be called before Start.
+func (r *Retention) SetOnDeleted(fn func(rows int64)) {
+	r.onDeleted = fn
+}
+
+// Start purges once right away and then every interval until Stop.
+func (r *Retention) Start(interval time.Duration) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			r.purge()
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *Retention) purge() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Abandon a long purge between batches on shutdown.
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	deleted, err := r.Purge(ctx)
+	if deleted > 0 {
+		r.log.Info("deleted expired traffic logs", zap.Int64("logs", deleted), zap.Duration("max_age", r.maxAge))
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		r.log.Error("failed to delete expired traffic logs", zap.Error(err))
+	}
+}
+
+// Purge deletes the logs older than the maximum age and returns how many
+// were deleted.
+func (r *Retention) Purge(ctx context.Context) (int64, error) {
+	deleted, err := r.repo.DeleteOlderThan(ctx, time.Now().Add(-r.maxAge))
+	if deleted > 0 && r.onDeleted != nil {
+		r.onDeleted(deleted)
+	}
+
+	return deleted, err
+}
+
+// Stop stops the purge loop, interrupting a purge in progress.
+func (r *Retention) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
@@ -0,0 +1,34 @@
+package pipeline
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy bounds how the publisher retries a failed batch write. Each
+// retry waits twice as long as the previous one, from InitialBackoff up to
+// MaxBackoff, with jitter so several proxies do not retry in lockstep.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt; below 2 disables retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxElapsed stops retrying once the next wait would end past it,
+	// measured from the first attempt. Zero means no limit.
+	MaxElapsed time.Duration
+}
+
+// backoff returns the wait before retry number attempt (starting at 1),
+// between half and all of the exponential delay.
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	delay := r.InitialBackoff
+	for i := 1; i < attempt && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, r.MaxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// spillPattern matches spilled batch files; names sort oldest first.
+const spillPattern = "batch-*.jsonl"
+
+// Spill keeps batches the database failed to save on local disk, one JSON
+// lines file per batch, and replays them once the database accepts writes
+// again. The directory is bounded: when it grows past maxBytes the oldest
+// batches are deleted.
+type Spill struct {
+	dir      string
+	maxBytes int64
+	repo     storage.Repository
+	log      *zap.Logger
+
+	mu   sync.Mutex
+	seq  uint64
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpill creates dir if needed and returns a spill writing into it.
+func NewSpill(dir string, maxBytes int64, repo storage.Repository, log *zap.Logger) (*Spill, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+
+	return &Spill{
+		dir:      dir,
+		maxBytes: maxBytes,
+		repo:     repo,
+		log:      log,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Write stores batch as a new file, then deletes the oldest files while the
+// directory exceeds its size bound.
+func (s *Spill) Write(batch []*models.TrafficLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("batch-%020d-%06d.jsonl", time.Now().UnixNano(), s.seq)
+	tmp := filepath.Join(s.dir, name+".tmp")
+
+	if err := writeBatch(tmp, batch); err != nil {
+		_ = os.Remove(tmp)
+
+		return err
+	}
+	// Rename so replay never sees a partially written file.
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+
+		return fmt.Errorf("failed to commit spill file: %w", err)
+	}
+
+	return s.evict()
+}
+
+func writeBatch(path string, batch []*models.TrafficLog) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, trafficLog := range batch {
+		// The database assigns the ID when the log is finally saved.
+		entry := *trafficLog
+		entry.ID = 0
+		if err := enc.Encode(&entry); err != nil {
+			return fmt.Errorf("failed to encode spilled log: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+
+	return file.Sync()
+}
+
+// evict must be called with mu held.
+func (s *Spill) evict() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; total > s.maxBytes && i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to evict spill file: %w", err)
+		}
+		total -= sizes[i]
+		s.log.Warn("spill directory full, dropped oldest batch", zap.String("file", filepath.Base(files[i])))
+	}
+
+	return nil
+}
+
+func (s *Spill) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, spillPattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spill files: %w", err)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Replay saves spilled batches oldest first and deletes each one once saved.
+// It stops at the first batch the database rejects and returns the number of
+// logs saved.
+func (s *Spill) Replay(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	files, err := s.files()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, path := range files {
+		batch, err := readBatch(path)
+		if os.IsNotExist(err) {
+			// Evicted since it was listed.
+			continue
+		}
+		if err != nil {
+			s.log.Error("discarding unreadable spill file",
+				zap.String("file", filepath.Base(path)), zap.Error(err))
+			_ = os.Remove(path)
+
+			continue
+		}
+
+		if err := s.repo.SaveTrafficLogs(ctx, batch); err != nil {
+			return replayed, fmt.Errorf("failed to replay spilled batch: %w", err)
+		}
+
+		s.mu.Lock()
+		err = os.Remove(path)
+		s.mu.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			return replayed, fmt.Errorf("failed to remove replayed spill file: %w", err)
+		}
+		replayed += len(batch)
+	}
+
+	return replayed, nil
+}
+
+func readBatch(path string) ([]*models.TrafficLog, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var batch []*models.TrafficLog
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var trafficLog models.TrafficLog
+		if err := dec.Decode(&trafficLog); err != nil {
+			return nil, fmt.Errorf("failed to decode spilled log: %w", err)
+		}
+		batch = append(batch, &trafficLog)
+	}
+
+	return batch, nil
+}
+
+// Start replays spilled batches every interval until Stop is called.
+func (s *Spill) Start(interval time.Duration) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.replay()
+			}
+		}
+	}()
+}
+
+func (s *Spill) replay() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	replayed, err := s.Replay(ctx)
+	if replayed > 0 {
+		s.log.Info("replayed spilled traffic logs", zap.Int("logs", replayed))
+	}
+	if err != nil {
+		s.log.Debug("spill replay stopped", zap.Error(err))
+	}
+}
+
+// Stop stops the replay loop. Batches still on disk are replayed after the
+// next start.
+func (s *Spill) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
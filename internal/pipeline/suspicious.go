@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+const punycodePrefix = "xn--"
+
+// latinConfusables are non-Latin letters that render like Latin letters in
+// common fonts. A label made up only of these is a whole-script homograph.
+var latinConfusables = map[rune]bool{
+	// Cyrillic.
+	'а': true, 'в': true, 'е': true, 'к': true, 'м': true, 'н': true, 'о': true, 'р': true,
+	'с': true, 'т': true, 'у': true, 'х': true, 'ѕ': true, 'і': true, 'ј': true, 'ԁ': true,
+	'ӏ': true, 'ԛ': true, 'ԝ': true, 'һ': true, 'ɡ': true,
+	// Greek.
+	'α': true, 'ο': true, 'ν': true, 'ι': true, 'κ': true, 'τ': true, 'ρ': true, 'υ': true,
+}
+
+// lookalikeScripts are scripts whose letters are easily confused with Latin
+// or with each other, so mixing any two of them (or one with Latin) in a
+// label is a typical homograph trick.
+var lookalikeScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Armenian}
+
+// AnalyzeDomain decodes punycode labels in domain and reports whether the
+// result looks like a homograph attack: mixed Latin/Cyrillic/Greek/Armenian
+// letters in one label, a label spelled entirely with Latin lookalikes, symbol
+// characters, or punycode that fails to decode. decoded is the Unicode form
+// when domain contains punycode and empty otherwise.
+func AnalyzeDomain(domain string) (decoded string, suspicious bool) {
+	labels := strings.Split(strings.ToLower(domain), ".")
+	hasPunycode := false
+
+	for i, label := range labels {
+		if strings.HasPrefix(label, punycodePrefix) {
+			hasPunycode = true
+
+			unicodeLabel, err := idna.Punycode.ToUnicode(label)
+			if err != nil {
+				return "", true
+			}
+			if _, err := idna.Lookup.ToUnicode(label); err != nil {
+				suspicious = true
+			}
+			labels[i] = unicodeLabel
+		}
+
+		if suspiciousLabel(labels[i]) {
+			suspicious = true
+		}
+	}
+
+	if hasPunycode {
+		decoded = strings.Join(labels, ".")
+	}
+
+	return decoded, suspicious
+}
+
+func suspiciousLabel(label string) bool {
+	scripts := make(map[*unicode.RangeTable]bool)
+	nonASCII := false
+	allConfusable := true
+
+	for _, r := range label {
+		if r > unicode.MaxASCII {
+			nonASCII = true
+		}
+		if !latinConfusables[r] {
+			allConfusable = false
+		}
+
+		switch {
+		case unicode.IsLetter(r):
+			for _, script := range lookalikeScripts {
+				if unicode.Is(script, r) {
+					scripts[script] = true
+				}
+			}
+		case unicode.IsDigit(r), unicode.IsMark(r), r == '-', r == '_':
+		default:
+			return true
+		}
+	}
+
+	if !nonASCII {
+		return false
+	}
+
+	return len(scripts) > 1 || allConfusable
+}
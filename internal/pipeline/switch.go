@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// AnalyticsSwitch turns analytics collection on and off at runtime. While it
+// is off the collector drops events without touching the channel and the
+// pipeline workers park until it is switched back on. Proxying is unaffected.
+type AnalyticsSwitch struct {
+	mu       sync.Mutex
+	enabled  bool
+	resumed  chan struct{}
+	onChange func(enabled bool)
+	log      *zap.Logger
+}
+
+// NewAnalyticsSwitch creates a switch in the given initial state.
+func NewAnalyticsSwitch(enabled bool, log *zap.Logger) *AnalyticsSwitch {
+	s := &AnalyticsSwitch{
+		enabled: enabled,
+		resumed: make(chan struct{}),
+		log:     log,
+	}
+	if enabled {
+		close(s.resumed)
+	}
+
+	return s
+}
+
+// SetOnChange registers fn to be called with the new state after every
+// change, e.g. to update a gauge. It is called once immediately.
+func (s *AnalyticsSwitch) SetOnChange(fn func(enabled bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onChange = fn
+	if fn != nil {
+		fn(s.enabled)
+	}
+}
+
+// Enabled reports whether analytics collection is on. A nil switch is always on.
+func (s *AnalyticsSwitch) Enabled() bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enabled
+}
+
+// Set switches analytics collection on or off.
+func (s *AnalyticsSwitch) Set(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.enabled == enabled {
+		return
+	}
+
+	s.enabled = enabled
+	if enabled {
+		close(s.resumed)
+	} else {
+		s.resumed = make(chan struct{})
+	}
+
+	s.log.Warn("Analytics collection toggled", zap.Bool("enabled", enabled))
+	if s.onChange != nil {
+		s.onChange(enabled)
+	}
+}
+
+// Resumed returns a channel that is closed while analytics are on. Workers
+// block on it to pause while analytics are off. A nil switch is always on.
+func (s *AnalyticsSwitch) Resumed() <-chan struct{} {
+	if s == nil {
+		return closedChan
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resumed
+}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+
+	return ch
+}()
+
+// Handler serves the switch state on GET and changes it on POST with a JSON
+// body such as {"enabled": false}.
+func (s *AnalyticsSwitch) Handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `body must be {"enabled": true|false}`, http.StatusBadRequest)
+
+			return
+		}
+		s.Set(*body.Enabled)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"enabled": s.Enabled()})
+}
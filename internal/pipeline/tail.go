@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// tailHeartbeat is how often an idle live-tail stream sends a comment, so
+// intermediaries don't close it.
+const tailHeartbeat = 15 * time.Second
+
+// ErrTooManyTailClients is returned by Subscribe when the hub is full.
+var ErrTooManyTailClients = errors.New("too many live-tail clients")
+
+// TailFilter selects the logs sent to one live-tail client. Zero fields
+// match every log.
+type TailFilter struct {
+	SourceIP string
+	Domain   storage.Pattern
+	Port     int
+}
+
+func (f TailFilter) matches(trafficLog *models.TrafficLog) bool {
+	return (f.SourceIP == "" || trafficLog.SourceIP == f.SourceIP) &&
+		f.Domain.Matches(trafficLog.Domain) &&
+		(f.Port == 0 || trafficLog.Port == f.Port)
+}
+
+// TailSubscription receives the logs matching its filter until it is closed
+// or dropped for falling behind.
+type TailSubscription struct {
+	hub    *TailHub
+	filter TailFilter
+	logs   chan *models.TrafficLog
+}
+
+// Logs returns the channel the matching logs arrive on. It is closed when the
+// hub drops the subscription because its buffer filled up.
+func (s *TailSubscription) Logs() <-chan *models.TrafficLog {
+	return s.logs
+}
+
+// Close unsubscribes. It is safe to call after the subscription was dropped.
+func (s *TailSubscription) Close() {
+	s.hub.remove(s)
+}
+
+// TailHub fans normalized logs out to live-tail clients. Each client has a
+// bounded buffer; a client whose buffer is full is dropped rather than
+// holding up the pipeline.
+type TailHub struct {
+	maxClients int
+	bufferSize int
+	log        *zap.Logger
+	onDrop     func()
+
+	mu      sync.Mutex
+	clients map[*TailSubscription]struct{}
+}
+
+// NewTailHub creates a hub serving at most maxClients clients with bufferSize
+// logs of buffer each.
+func NewTailHub(maxClients, bufferSize int, log *zap.Logger) *TailHub {
+	return &TailHub{
+		maxClients: maxClients,
+		bufferSize: max(bufferSize, 1),
+		log:        log,
+		clients:    make(map[*TailSubscription]struct{}),
+	}
+}
+
+// SetOnDrop registers a callback run whenever a slow client is dropped. It
+// must be called before Publish.
+func (h *TailHub) SetOnDrop(fn func()) {
+	h.onDrop = fn
+}
+
+// Subscribe registers a client for the logs matching filter.
+func (h *TailHub) Subscribe(filter TailFilter) (*TailSubscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.maxClients > 0 && len(h.clients) >= h.maxClients {
+		return nil, ErrTooManyTailClients
+	}
+	sub := &TailSubscription{hub: h, filter: filter, logs: make(chan *models.TrafficLog, h.bufferSize)}
+	h.clients[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// Clients returns the number of connected clients.
+func (h *TailHub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.clients)
+}
+
+// Publish sends a copy of trafficLog to every client whose filter matches,
+// without blocking. A nil hub ignores it.
+func (h *TailHub) Publish(trafficLog *models.TrafficLog) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.clients) == 0 {
+		return
+	}
+	// The publisher may still update the original, e.g. its database ID.
+	copied := *trafficLog
+	for sub := range h.clients {
+		if !sub.filter.matches(&copied) {
+			continue
+		}
+		select {
+		case sub.logs <- &copied:
+		default:
+			delete(h.clients, sub)
+			close(sub.logs)
+			if h.onDrop != nil {
+				h.onDrop()
+			}
+		}
+	}
+}
+
+func (h *TailHub) remove(sub *TailSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[sub]; ok {
+		delete(h.clients, sub)
+		close(sub.logs)
+	}
+}
+
+// Handler streams the matching logs as server-sent events, one JSON
+// TrafficLog per "data" line. The source_ip, domain (with "*" wildcards at
+// either end) and port query parameters filter the stream. A client dropped
+// for falling behind gets a final "dropped" event.
+func (h *TailHub) Handler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTailFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+
+		return
+	}
+
+	sub, err := h.Subscribe(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case trafficLog, ok := <-sub.Logs():
+			if !ok {
+				h.log.Warn("Dropping slow live-tail client", zap.String("remote_addr", r.RemoteAddr))
+				_, _ = fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+				flusher.Flush()
+
+				return
+			}
+			err = writeTailEvent(w, trafficLog)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeTailEvent(w http.ResponseWriter, trafficLog *models.TrafficLog) error {
+	data, err := json.Marshal(trafficLog)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+
+	return err
+}
+
+func parseTailFilter(r *http.Request) (TailFilter, error) {
+	var filter TailFilter
+	query := r.URL.Query()
+	if sourceIP := query.Get("source_ip"); sourceIP != "" {
+		ip := net.ParseIP(sourceIP)
+		if ip == nil {
+			return filter, errors.New("source_ip must be a valid IP address")
+		}
+		filter.SourceIP = ip.String()
+	}
+
+	domain, err := storage.ParsePattern(query.Get("domain"))
+	if err != nil {
+		return filter, fmt.Errorf("domain: %w", err)
+	}
+	filter.Domain = domain
+
+	if port := query.Get("port"); port != "" {
+		filter.Port, err = strconv.Atoi(port)
+		if err != nil || filter.Port < 1 || filter.Port > 65535 {
+			return filter, errors.New("port must be between 1 and 65535")
+		}
+	}
+
+	return filter, nil
+}
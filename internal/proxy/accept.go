@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+)
+
+// refusalLogInterval is how often a rate limited, connection limited,
+// non-whitelisted or unauthenticated attempt is logged per source IP and
+// status; the ones in between are only counted in the metrics, so a client
+// hammering the proxy doesn't add a database row per refused connection.
+const refusalLogInterval = time.Second
+
+// maxSampledSources bounds the source IPs a refusalSampler remembers.
+const maxSampledSources = 10000
+
+// acceptListener reports every client connection that got through the
+// listeners it wraps, before the SOCKS handshake starts, so that connections
+// which never authenticate or dial are recorded too.
+type acceptListener struct {
+	net.Listener
+	accepted func(source string)
+}
+
+func (l *acceptListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted(security.HostIP(conn.RemoteAddr().String()))
+
+	return conn, nil
+}
+
+type refusalKey struct {
+	source string
+	status string
+}
+
+// refusalSampler picks the refused connections worth logging: the first per
+// source IP and status in each refusalLogInterval.
+type refusalSampler struct {
+	mu   sync.Mutex
+	last map[refusalKey]time.Time
+}
+
+func newRefusalSampler() *refusalSampler {
+	return &refusalSampler{last: make(map[refusalKey]time.Time)}
+}
+
+// sample reports whether a refusal of source with status at now should be
+// logged. When maxSampledSources are remembered, expired ones are dropped
+// first and a new source is not logged if none were.
+func (r *refusalSampler) sample(source, status string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := refusalKey{source: source, status: status}
+	if last, ok := r.last[key]; ok && now.Sub(last) < refusalLogInterval {
+		return false
+	}
+	if _, ok := r.last[key]; !ok && len(r.last) >= maxSampledSources {
+		for k, last := range r.last {
+			if now.Sub(last) >= refusalLogInterval {
+				delete(r.last, k)
+			}
+		}
+		if len(r.last) >= maxSampledSources {
+			return false
+		}
+	}
+	r.last[key] = now
+
+	return true
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+func TestAcceptedConnectionsRecorded(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.MaxConnections = 1
+	cfg.Proxy.LogAccepts = true
+	cfg.Proxy.LogFailures = true
+
+	server, events := newTestServer(t, cfg)
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	connect := func() {
+		conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+	}
+
+	// The first connection is accepted even though it never sends anything.
+	connect()
+	event := receiveEvent(t, events)
+	if event.Status != models.StatusAccepted || event.SourceIP != "127.0.0.1" || event.DestinationIP != "" {
+		t.Errorf("expected an accepted event from 127.0.0.1, got %+v", event)
+	}
+
+	// The second is over proxy.max_connections and refused instead.
+	connect()
+	if event := receiveEvent(t, events); event.Status != models.StatusConnectionLimited {
+		t.Errorf("expected a connection_limited event, got %+v", event)
+	}
+
+	// A third within the same second is refused but not logged again.
+	connect()
+	select {
+	case event := <-events:
+		t.Errorf("expected no further events, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRefusalSampler(t *testing.T) {
+	sampler := newRefusalSampler()
+	now := time.Now()
+
+	if !sampler.sample("192.0.2.1", models.StatusRateLimited, now) {
+		t.Error("expected the first refusal to be logged")
+	}
+	if sampler.sample("192.0.2.1", models.StatusRateLimited, now.Add(refusalLogInterval/2)) {
+		t.Error("expected a refusal within the interval to be skipped")
+	}
+	if !sampler.sample("192.0.2.1", models.StatusConnectionLimited, now) {
+		t.Error("expected another status to be sampled separately")
+	}
+	if !sampler.sample("192.0.2.1", models.StatusRateLimited, now.Add(refusalLogInterval)) {
+		t.Error("expected a refusal after the interval to be logged")
+	}
+}
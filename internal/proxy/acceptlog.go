@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand/v2"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"go.uber.org/zap"
+)
+
+// acceptLogger writes an audit record of every connection or dial the proxy
+// refuses and a sample of the dials it accepts. It logs directly rather than
+// through the traffic pipeline, so records survive analytics being switched
+// off or the pipeline dropping events.
+type acceptLogger struct {
+	log        *zap.Logger
+	sampleRate float64
+	hashSource bool
+	salt       string
+	sample     func() float64
+}
+
+// newAcceptLogger returns nil, which logs nothing, when disabled.
+func newAcceptLogger(enabled bool, sampleRate float64, hashSource bool, salt string, log *zap.Logger) *acceptLogger {
+	if !enabled {
+		return nil
+	}
+
+	return &acceptLogger{
+		log:        log.Named("accept"),
+		sampleRate: sampleRate,
+		hashSource: hashSource,
+		salt:       salt,
+		sample:     rand.Float64,
+	}
+}
+
+// accepted logs an accepted dial with probability sampleRate.
+func (l *acceptLogger) accepted(source, dest string) {
+	if l == nil || l.sampleRate <= 0 || l.sample() >= l.sampleRate {
+		return
+	}
+
+	l.log.Info("connection accepted",
+		zap.String("source", l.source(source)),
+		zap.String("destination", dest),
+		zap.Float64("sample_rate", l.sampleRate))
+}
+
+// refused logs a refused connection or dial and the reason. dest is empty
+// when the client was refused before naming a destination.
+func (l *acceptLogger) refused(source, dest string, reason error) {
+	if l == nil {
+		return
+	}
+
+	l.log.Warn("connection refused",
+		zap.String("source", l.source(source)),
+		zap.String("destination", dest),
+		zap.String("reason", reason.Error()))
+}
+
+// source strips the port from addr and, if configured, replaces the IP with a
+// salted SHA-256 hash so records can be correlated without storing the address.
+func (l *acceptLogger) source(addr string) string {
+	addr = security.HostIP(addr)
+	if !l.hashSource || addr == "" {
+		return addr
+	}
+
+	sum := sha256.Sum256([]byte(l.salt + addr))
+
+	return hex.EncodeToString(sum[:8])
+}
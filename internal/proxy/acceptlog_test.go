@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAcceptLogRecordsRefusedAndSamplesAccepted(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	cfg := &config.Config{}
+	cfg.Proxy.BlockPrivateDestinations = true
+	cfg.Proxy.PrivateDestinationExceptions = []string{addr[:strings.LastIndex(addr, ":")]}
+	cfg.Proxy.AcceptLog.Enabled = true
+	cfg.Proxy.AcceptLog.AcceptedSampleRate = 0.5
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(core)
+	server := NewServer(cfg, log, pipeline.NewCollector(make(chan pipeline.RawTrafficEvent, 10), log))
+
+	// Alternate draws below and above the sample rate so every other accept is logged.
+	draws := []float64{0.9, 0.1, 0.9, 0.1}
+	server.accepts.sample = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+
+		return draw
+	}
+
+	ctx := withConnInfo(context.Background(), connInfo{Source: "203.0.113.7:50000"})
+	for i := 0; i < 4; i++ {
+		conn, err := server.dialWithTracking(ctx, "tcp", addr)
+		if err != nil {
+			t.Fatalf("dial %d failed: %v", i, err)
+		}
+		_ = conn.Close()
+	}
+
+	if _, err := server.dialWithTracking(ctx, "tcp", "127.0.0.2:80"); err == nil {
+		t.Fatal("expected dial to a private destination to be refused")
+	}
+
+	accepted := logs.FilterMessage("connection accepted").All()
+	if len(accepted) != 2 {
+		t.Errorf("expected 2 of 4 accepted dials to be sampled, got %d", len(accepted))
+	}
+
+	refused := logs.FilterMessage("connection refused").All()
+	if len(refused) != 1 {
+		t.Fatalf("expected the refused dial to be logged, got %d entries", len(refused))
+	}
+	if refused[0].LoggerName != "accept" {
+		t.Errorf("expected the accept logger, got %q", refused[0].LoggerName)
+	}
+	fields := refused[0].ContextMap()
+	if fields["source"] != "203.0.113.7" || fields["destination"] != "127.0.0.2:80" {
+		t.Errorf("unexpected refused entry fields %v", fields)
+	}
+	if fields["reason"] != errPrivateDestination.Error() {
+		t.Errorf("expected reason %q, got %v", errPrivateDestination, fields["reason"])
+	}
+}
+
+func TestAcceptLogDefaultsToRefusedOnly(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	accepts := newAcceptLogger(true, 0, false, "", zap.New(core))
+
+	accepts.accepted("198.51.100.1:1234", "93.184.216.34:443")
+	accepts.refused("198.51.100.1:1234", "10.0.0.1:22", errDestinationBusy)
+
+	if entries := logs.All(); len(entries) != 1 || entries[0].Message != "connection refused" {
+		t.Errorf("expected only the refused dial to be logged, got %v", entries)
+	}
+}
+
+func TestAcceptLogHashesSource(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	accepts := newAcceptLogger(true, 1, true, "secret", zap.New(core))
+
+	accepts.refused("198.51.100.1:1234", "10.0.0.1:22", errDestinationBusy)
+	accepts.refused("198.51.100.1:5678", "10.0.0.1:22", errDestinationBusy)
+
+	entries := logs.All()
+	first, second := entries[0].ContextMap()["source"], entries[1].ContextMap()["source"]
+	if first == "198.51.100.1" || first == "" {
+		t.Errorf("expected source IP to be hashed, got %v", first)
+	}
+	if first != second {
+		t.Errorf("expected the same IP to hash identically, got %v and %v", first, second)
+	}
+}
+
+func TestAcceptLogRecordsConnectionRefusals(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.AcceptLog.Enabled = true
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(core)
+	server := NewServer(cfg, log, pipeline.NewCollector(make(chan pipeline.RawTrafficEvent, 10), log))
+
+	source := "203.0.113.7:50000"
+	server.sourceRejected(source)
+	server.rateLimited(source)
+	server.connectionRejected(source)
+	server.authFailed(source, "alice")
+
+	refused := logs.FilterMessage("connection refused").All()
+	want := []error{errSourceNotWhitelisted, errRateLimited, errTooManyConnections, errAuthFailed}
+	if len(refused) != len(want) {
+		t.Fatalf("expected %d refusals to be logged, got %d", len(want), len(refused))
+	}
+	for i, entry := range refused {
+		fields := entry.ContextMap()
+		if fields["reason"] != want[i].Error() || fields["source"] != "203.0.113.7" || fields["destination"] != "" {
+			t.Errorf("unexpected refused entry %d fields %v", i, fields)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	socks5 "github.com/armon/go-socks5"
+)
+
+// errAuthFailed is the reason recorded for clients that fail SOCKS5
+// username/password authentication.
+var errAuthFailed = errors.New("authentication failed")
+
+// credentialsFunc adapts a function to socks5.CredentialStore.
+type credentialsFunc func(user, password string) bool
+
+func (f credentialsFunc) Valid(user, password string) bool {
+	return f(user, password)
+}
+
+// userPassAuthenticator runs the SOCKS5 username/password negotiation against
+// a security.Authenticator and reports failed attempts with the client
+// address and the username offered.
+type userPassAuthenticator struct {
+	auth   *security.Authenticator
+	failed func(source, username string)
+}
+
+func (a *userPassAuthenticator) GetCode() uint8 {
+	return socks5.UserPassAuth
+}
+
+func (a *userPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer) (*socks5.AuthContext, error) {
+	var username string
+	negotiation := socks5.UserPassAuthenticator{
+		Credentials: credentialsFunc(func(user, password string) bool {
+			username = user
+
+			return a.auth.Authenticate(user, password)
+		}),
+	}
+
+	authContext, err := negotiation.Authenticate(reader, writer)
+	if errors.Is(err, socks5.UserAuthFailed) {
+		var source string
+		if conn, ok := writer.(net.Conn); ok {
+			source = conn.RemoteAddr().String()
+		}
+		a.failed(source, username)
+	}
+
+	return authContext, err
+}
+
+// credentials merges the single proxy.auth username/password, if set, with
+// the proxy.auth.users list. A user listed twice keeps the last password.
+func credentials(username, password string, users []config.Credential) map[string]string {
+	merged := make(map[string]string, len(users)+1)
+	if username != "" {
+		merged[username] = password
+	}
+	for _, user := range users {
+		merged[user.Username] = user.Password
+	}
+
+	return merged
+}
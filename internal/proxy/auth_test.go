@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// socksLogin negotiates username/password authentication on conn and returns
+// the server's status byte (0 means success).
+func socksLogin(t *testing.T, conn net.Conn, username, password string) byte {
+	t.Helper()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x02}); err != nil {
+		t.Fatalf("failed to send greeting: %v", err)
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(conn, method); err != nil || method[1] != 0x02 {
+		t.Fatalf("expected username/password to be selected, got %v, %v", method, err)
+	}
+
+	request := []byte{0x01, byte(len(username))}
+	request = append(request, username...)
+	request = append(request, byte(len(password)))
+	request = append(request, password...)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatalf("failed to send credentials: %v", err)
+	}
+	status := make([]byte, 2)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		t.Fatalf("failed to read auth status: %v", err)
+	}
+
+	return status[1]
+}
+
+func TestUsernamePasswordAuthentication(t *testing.T) {
+	// The destination hangs up straight away, ending the relay.
+	dest := startDestination(t, func(net.Conn) {})
+	_, portStr, _ := net.SplitHostPort(dest)
+	port, _ := strconv.Atoi(portStr)
+
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.Auth.Enabled = true
+	cfg.Proxy.Auth.Username = "alice"
+	cfg.Proxy.Auth.Password = "s3cret"
+	cfg.Proxy.Auth.Users = []config.Credential{{Username: "bob", Password: "hunter2"}}
+
+	server, events := newTestServer(t, cfg)
+	m, err := metrics.NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	server.SetMetrics(m)
+	failures := m.AuthFailures
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		return conn
+	}
+
+	rejected := dial()
+	if status := socksLogin(t, rejected, "alice", "wrong"); status == 0x00 {
+		t.Fatal("expected wrong credentials to be rejected")
+	}
+	if _, err := rejected.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection to be closed after failed authentication")
+	}
+	if got := testutil.ToFloat64(failures); got != 1 {
+		t.Errorf("expected 1 auth failure, got %v", got)
+	}
+
+	// The single username/password keeps working alongside the users list.
+	if status := socksLogin(t, dial(), "alice", "s3cret"); status != 0x00 {
+		t.Errorf("expected proxy.auth.username to be accepted, got status %d", status)
+	}
+
+	conn := dial()
+	if status := socksLogin(t, conn, "bob", "hunter2"); status != 0x00 {
+		t.Fatalf("expected a listed user to be accepted, got status %d", status)
+	}
+
+	// CONNECT to the destination by IPv4 address.
+	connect := []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, byte(port >> 8), byte(port)}
+	if _, err := conn.Write(connect); err != nil {
+		t.Fatalf("failed to send connect: %v", err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+		t.Fatalf("expected connect to succeed, got %v, %v", reply, err)
+	}
+	_ = conn.Close()
+
+	if event := receiveEvent(t, events); event.Username != "bob" {
+		t.Errorf("expected the authenticated username on the event, got %q", event.Username)
+	}
+}
+
+func TestAuthFailuresAreSampled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.LogFailures = true
+	server, events := newTestServer(t, cfg)
+	failures := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_auth_failures_total"})
+	server.SetMetrics(&metrics.Metrics{AuthFailures: failures})
+
+	for port := range 3 {
+		server.authFailed("203.0.113.7:"+strconv.Itoa(50000+port), "alice")
+	}
+	if got := testutil.ToFloat64(failures); got != 3 {
+		t.Errorf("expected every failure to be counted, got %v", got)
+	}
+	if event := receiveEvent(t, events); event.Status != models.StatusAuthFailed || event.Username != "alice" {
+		t.Errorf("expected an auth_failed event, got %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("expected repeated failures within the interval not to be logged, got %+v", event)
+	default:
+	}
+}
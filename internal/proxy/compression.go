@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// compressionStats counts bytes for compressed client tunnels. Wire bytes are
+// what actually crossed the network; logical bytes are the uncompressed SOCKS
+// stream seen by the proxy.
+type compressionStats struct {
+	wireIn     prometheus.Counter
+	wireOut    prometheus.Counter
+	logicalIn  prometheus.Counter
+	logicalOut prometheus.Counter
+}
+
+// newCompressionStats returns the socks5_proxy_compression_bytes_total
+// counters of m, or nil when metrics are disabled.
+func newCompressionStats(m *metrics.Metrics) *compressionStats {
+	if m == nil {
+		return nil
+	}
+
+	return &compressionStats{
+		wireIn:     m.CompressionBytes.WithLabelValues("wire", "in"),
+		wireOut:    m.CompressionBytes.WithLabelValues("wire", "out"),
+		logicalIn:  m.CompressionBytes.WithLabelValues("logical", "in"),
+		logicalOut: m.CompressionBytes.WithLabelValues("logical", "out"),
+	}
+}
+
+// compressionListener wraps accepted client connections in compressedConn.
+type compressionListener struct {
+	net.Listener
+	level int
+	stats *compressionStats
+}
+
+func (l *compressionListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return newCompressedConn(conn, l.level, l.stats)
+}
+
+// compressedConn is a net.Conn carrying a deflate stream in each direction.
+// Every Write is flushed so interactive protocols aren't held back waiting
+// for the compressor to fill a block.
+type compressedConn struct {
+	net.Conn
+	stats  *compressionStats
+	reader io.ReadCloser
+	writer *flate.Writer
+	wmu    sync.Mutex
+}
+
+func newCompressedConn(conn net.Conn, level int, stats *compressionStats) (*compressedConn, error) {
+	cc := &compressedConn{Conn: conn, stats: stats}
+
+	writer, err := flate.NewWriter(writerFunc(cc.writeWire), level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression level %d: %w", level, err)
+	}
+	cc.writer = writer
+	cc.reader = flate.NewReader(readerFunc(cc.readWire))
+
+	return cc, nil
+}
+
+func (cc *compressedConn) readWire(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	if cc.stats != nil {
+		cc.stats.wireIn.Add(float64(n))
+	}
+
+	return n, err
+}
+
+func (cc *compressedConn) writeWire(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	if cc.stats != nil {
+		cc.stats.wireOut.Add(float64(n))
+	}
+
+	return n, err
+}
+
+// Read returns decompressed bytes from the client.
+func (cc *compressedConn) Read(p []byte) (int, error) {
+	n, err := cc.reader.Read(p)
+	if cc.stats != nil {
+		cc.stats.logicalIn.Add(float64(n))
+	}
+
+	return n, err
+}
+
+// Write compresses p and flushes it to the client.
+func (cc *compressedConn) Write(p []byte) (int, error) {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+
+	n, err := cc.writer.Write(p)
+	if cc.stats != nil {
+		cc.stats.logicalOut.Add(float64(n))
+	}
+	if err != nil {
+		return n, err
+	}
+
+	return n, cc.writer.Flush()
+}
+
+// Close terminates the deflate stream and closes the connection.
+func (cc *compressedConn) Close() error {
+	cc.wmu.Lock()
+	_ = cc.writer.Close()
+	cc.wmu.Unlock()
+
+	_ = cc.reader.Close()
+
+	return cc.Conn.Close()
+}
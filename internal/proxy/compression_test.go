@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCompressedConnCountsWireAndLogicalBytes(t *testing.T) {
+	payload := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"), 1000)
+
+	clientSide, serverSide := net.Pipe()
+
+	clientMetrics, err := metrics.NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	serverMetrics, err := metrics.NewMetrics()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	clientStats, serverStats := newCompressionStats(clientMetrics), newCompressionStats(serverMetrics)
+
+	client, err := newCompressedConn(clientSide, flate.DefaultCompression, clientStats)
+	if err != nil {
+		t.Fatalf("failed to wrap client conn: %v", err)
+	}
+	server, err := newCompressedConn(serverSide, flate.DefaultCompression, serverStats)
+	if err != nil {
+		t.Fatalf("failed to wrap server conn: %v", err)
+	}
+
+	go func() {
+		_, _ = client.Write(payload)
+		_ = client.Close()
+	}()
+
+	received, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	_ = server.Close()
+
+	if !bytes.Equal(received, payload) {
+		t.Fatalf("payload corrupted: got %d bytes, want %d", len(received), len(payload))
+	}
+
+	logical := testutil.ToFloat64(serverStats.logicalIn)
+	wire := testutil.ToFloat64(serverStats.wireIn)
+	if logical != float64(len(payload)) {
+		t.Errorf("expected %d logical bytes, got %.0f", len(payload), logical)
+	}
+	if wire == 0 || wire >= logical {
+		t.Errorf("expected fewer wire bytes than logical bytes, got wire=%.0f logical=%.0f", wire, logical)
+	}
+	if sent := testutil.ToFloat64(clientStats.wireOut); sent != wire {
+		t.Errorf("expected sender wire bytes %.0f to match receiver, got %.0f", wire, sent)
+	}
+}
+
+func TestNewCompressedConnRejectsInvalidLevel(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer func() {
+		_ = clientSide.Close()
+		_ = serverSide.Close()
+	}()
+
+	if _, err := newCompressedConn(serverSide, 42, nil); err == nil {
+		t.Fatal("expected error for invalid compression level")
+	}
+}
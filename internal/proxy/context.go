@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+
+	socks5 "github.com/armon/go-socks5"
+)
+
+// connInfo carries details of the SOCKS5 request to dialWithTracking, which
+// otherwise only sees the resolved destination address.
+type connInfo struct {
+	// Source is the client address as host:port.
+	Source   string
+	Username string
+	// Domain is the hostname the client asked for, empty when it connected by IP.
+	Domain string
+}
+
+type connInfoKey struct{}
+
+func withConnInfo(ctx context.Context, info connInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+func connInfoFrom(ctx context.Context) connInfo {
+	info, _ := ctx.Value(connInfoKey{}).(connInfo)
+
+	return info
+}
+
+// requestRewriter stores request details in the context passed to the dialer.
+// It never changes the destination.
+type requestRewriter struct{}
+
+func (requestRewriter) Rewrite(ctx context.Context, req *socks5.Request) (context.Context, *socks5.AddrSpec) {
+	var info connInfo
+	if req.RemoteAddr != nil {
+		// Address, unlike String, brackets IPv6 addresses.
+		info.Source = req.RemoteAddr.Address()
+	}
+	if req.DestAddr != nil {
+		info.Domain = req.DestAddr.FQDN
+	}
+	if req.AuthContext != nil {
+		info.Username = req.AuthContext.Payload["Username"]
+	}
+
+	return withConnInfo(ctx, info), req.DestAddr
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errShuttingDown is returned for dials that complete after Shutdown began.
+var errShuttingDown = errors.New("proxy is shutting down")
+
+// connTracker keeps the set of open trackedConns and UDP associations so
+// Shutdown can wait for them to finish and force-close whatever is still open
+// at the deadline.
+type connTracker struct {
+	mu       sync.Mutex
+	conns    map[io.Closer]struct{}
+	draining bool
+	idle     chan struct{}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: make(map[io.Closer]struct{}),
+		idle:  make(chan struct{}),
+	}
+}
+
+// add registers tc, or reports false once draining has started.
+func (t *connTracker) add(tc io.Closer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.conns[tc] = struct{}{}
+
+	return true
+}
+
+func (t *connTracker) remove(tc io.Closer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, tc)
+	if t.draining && len(t.conns) == 0 {
+		t.closeIdle()
+	}
+}
+
+// active returns the number of open connections.
+func (t *connTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
+
+// drain refuses further connections and waits until every open one has
+// closed. If ctx ends first, the remaining connections are closed, which
+// still emits their traffic events, and ctx's error is returned.
+func (t *connTracker) drain(ctx context.Context) error {
+	t.mu.Lock()
+	t.draining = true
+	if len(t.conns) == 0 {
+		t.closeIdle()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	remaining := make([]io.Closer, 0, len(t.conns))
+	for tc := range t.conns {
+		remaining = append(remaining, tc)
+	}
+	t.mu.Unlock()
+
+	for _, tc := range remaining {
+		_ = tc.Close()
+	}
+
+	return ctx.Err()
+}
+
+// closeIdle must be called with mu held.
+func (t *connTracker) closeIdle() {
+	select {
+	case <-t.idle:
+	default:
+		close(t.idle)
+	}
+}
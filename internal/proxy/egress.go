@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	socks5 "github.com/armon/go-socks5"
+	"go.uber.org/zap"
+)
+
+// errEgressDenied is returned when a destination is refused by the
+// proxy.egress allow/deny rules.
+var errEgressDenied = errors.New("destination denied by egress policy")
+
+// egressRules is one list of egress rules: exact domains, "*.suffix"
+// wildcards matching any subdomain, and IP or CIDR networks.
+type egressRules struct {
+	domains  map[string]bool
+	suffixes []string
+	networks []*net.IPNet
+}
+
+func (r *egressRules) add(rule string) error {
+	if strings.Contains(rule, "/") || net.ParseIP(rule) != nil {
+		network, err := parseIPOrCIDR(rule)
+		if err != nil {
+			return err
+		}
+		r.networks = append(r.networks, network)
+
+		return nil
+	}
+
+	domain, wildcard := strings.CutPrefix(normalizeDomain(rule), "*.")
+	if domain == "" || strings.ContainsAny(domain, "* ") {
+		return fmt.Errorf("invalid egress rule %q", rule)
+	}
+	if wildcard {
+		r.suffixes = append(r.suffixes, "."+domain)
+
+		return nil
+	}
+	if r.domains == nil {
+		r.domains = make(map[string]bool)
+	}
+	r.domains[domain] = true
+
+	return nil
+}
+
+func (r *egressRules) empty() bool {
+	return len(r.domains) == 0 && len(r.suffixes) == 0 && len(r.networks) == 0
+}
+
+// matches reports whether domain (possibly empty) or ip (possibly nil)
+// matches any rule.
+func (r *egressRules) matches(domain string, ip net.IP) bool {
+	if domain != "" {
+		if r.domains[domain] {
+			return true
+		}
+		for _, suffix := range r.suffixes {
+			if strings.HasSuffix(domain, suffix) {
+				return true
+			}
+		}
+	}
+	if ip != nil {
+		for _, network := range r.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// egressPolicy decides which destinations clients may reach. Rules come from
+// proxy.egress.allow and proxy.egress.deny plus an optional rules file, which
+// reload re-reads.
+type egressPolicy struct {
+	allowEntries []string
+	denyEntries  []string
+	file         string
+
+	mu    sync.RWMutex
+	allow egressRules
+	deny  egressRules
+}
+
+// newEgressPolicy builds the policy and loads the rules file. Invalid rules
+// are skipped and reported through invalid; an unreadable rules file is an
+// error.
+func newEgressPolicy(allow, deny []string, file string) (policy *egressPolicy, invalid []error, err error) {
+	policy = &egressPolicy{allowEntries: allow, denyEntries: deny, file: file}
+	invalid, err = policy.reload()
+
+	return policy, invalid, err
+}
+
+// update replaces the configured lists and rules file and reloads the rules.
+// The previous rules stay in place if the file can't be read.
+func (p *egressPolicy) update(allow, deny []string, file string) (invalid []error, err error) {
+	p.allowEntries, p.denyEntries, p.file = allow, deny, file
+
+	return p.reload()
+}
+
+// reload rebuilds the rules from the configured lists and the rules file.
+// The previous rules stay in place if the file can't be read.
+func (p *egressPolicy) reload() (invalid []error, err error) {
+	var allow, deny egressRules
+	for _, rule := range p.allowEntries {
+		if err := allow.add(rule); err != nil {
+			invalid = append(invalid, err)
+		}
+	}
+	for _, rule := range p.denyEntries {
+		if err := deny.add(rule); err != nil {
+			invalid = append(invalid, err)
+		}
+	}
+
+	if p.file != "" {
+		content, err := os.ReadFile(p.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read egress rules file: %w", err)
+		}
+		invalid = append(invalid, parseEgressRules(content, &allow, &deny)...)
+	}
+
+	p.mu.Lock()
+	p.allow, p.deny = allow, deny
+	p.mu.Unlock()
+
+	return invalid, nil
+}
+
+// parseEgressRules adds the "allow <rule>" and "deny <rule>" lines of
+// content to allow and deny. Blank lines and lines starting with # are
+// ignored.
+func parseEgressRules(content []byte, allow, deny *egressRules) (invalid []error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		action, rule, _ := strings.Cut(text, " ")
+		rule = strings.TrimSpace(rule)
+		var err error
+		switch action {
+		case "allow":
+			err = allow.add(rule)
+		case "deny":
+			err = deny.add(rule)
+		default:
+			err = fmt.Errorf("unknown action %q", action)
+		}
+		if err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %w", line, err))
+		}
+	}
+
+	return invalid
+}
+
+// allowed reports whether a destination requested as domain (empty when the
+// client asked for an IP) that resolved to ip may be reached. Deny rules win;
+// otherwise a non-empty allow list must match. A nil policy allows everything.
+func (p *egressPolicy) allowed(domain string, ip net.IP) bool {
+	if p == nil {
+		return true
+	}
+	domain = normalizeDomain(domain)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.deny.matches(domain, ip) {
+		return false
+	}
+
+	return p.allow.empty() || p.allow.matches(domain, ip)
+}
+
+// egressRuleSet refuses CONNECT requests the egress policy denies, so the
+// client gets a "connection not allowed by ruleset" reply, and defers every
+// other decision to next.
+type egressRuleSet struct {
+	server *Server
+	next   socks5.RuleSet
+}
+
+func (r egressRuleSet) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.Command == socks5.ConnectCommand && req.DestAddr != nil &&
+		!r.server.egress.allowed(req.DestAddr.FQDN, req.DestAddr.IP) {
+		info := connInfoFrom(ctx)
+		r.server.egressDenied(info.Source, info.Username, req.DestAddr.FQDN, req.DestAddr.Address(), "tcp")
+
+		return ctx, false
+	}
+
+	return r.next.Allow(ctx, req)
+}
+
+// egressDenied records a destination refused by the egress policy. source
+// and addr are host:port; domain is empty when the client asked for an IP.
+func (s *Server) egressDenied(source, username, domain, addr, protocol string) {
+	s.log.Warn("destination denied by egress policy",
+		zap.String("source", source), zap.String("domain", domain), zap.String("addr", addr))
+	if s.metrics != nil {
+		s.metrics.EgressDenied.Inc()
+	}
+	s.accepts.refused(source, addr, errEgressDenied)
+	s.attemptFailed(pipeline.RawTrafficEvent{
+		Status:   models.StatusBlocked,
+		Username: username,
+		Domain:   domain,
+		Protocol: protocol,
+	}, source, addr)
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEgressPolicy(t *testing.T) {
+	policy, invalid, err := newEgressPolicy(
+		[]string{"corp.example", "*.corp.example", "198.51.100.0/24", "*.*.bad"},
+		[]string{"*.ads.corp.example", "198.51.100.7"},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("failed to create policy: %v", err)
+	}
+	if len(invalid) != 1 {
+		t.Fatalf("expected one invalid rule, got %v", invalid)
+	}
+
+	tests := []struct {
+		domain  string
+		ip      string
+		allowed bool
+	}{
+		{domain: "corp.example", ip: "203.0.113.1", allowed: true},
+		{domain: "WWW.Corp.Example.", ip: "203.0.113.1", allowed: true},
+		{domain: "tracker.ads.corp.example", ip: "203.0.113.1", allowed: false},
+		{domain: "notcorp.example", ip: "203.0.113.1", allowed: false},
+		{ip: "198.51.100.1", allowed: true},
+		{ip: "198.51.100.7", allowed: false},
+		{domain: "corp.example", ip: "198.51.100.7", allowed: false},
+		{ip: "192.0.2.1", allowed: false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.allowed(tt.domain, net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("%s (%s): expected allowed=%v, got %v", tt.domain, tt.ip, tt.allowed, got)
+		}
+	}
+
+	var open *egressPolicy
+	if !open.allowed("example.com", nil) {
+		t.Error("expected a nil policy to allow everything")
+	}
+}
+
+func TestEgressRulesFileReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "egress.rules")
+	if err := os.WriteFile(file, []byte("# ads\ndeny *.doubleclick.net\n\n"), 0o600); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+
+	policy, invalid, err := newEgressPolicy(nil, []string{"blocked.example"}, file)
+	if err != nil || len(invalid) != 0 {
+		t.Fatalf("failed to create policy: %v, %v", invalid, err)
+	}
+	if policy.allowed("ad.doubleclick.net", nil) || policy.allowed("blocked.example", nil) {
+		t.Error("expected the file and config deny rules to apply")
+	}
+
+	if err := os.WriteFile(file, []byte("allow example.org\nblock example.net\n"), 0o600); err != nil {
+		t.Fatalf("failed to write rules: %v", err)
+	}
+	invalid, err = policy.reload()
+	if err != nil || len(invalid) != 1 {
+		t.Fatalf("expected one invalid line, got %v, %v", invalid, err)
+	}
+	if !policy.allowed("example.org", nil) || policy.allowed("ad.doubleclick.net", nil) {
+		t.Error("expected the reloaded file to replace the previous rules")
+	}
+
+	_ = os.Remove(file)
+	if _, err := policy.reload(); err == nil {
+		t.Error("expected a missing rules file to be reported")
+	}
+	if !policy.allowed("example.org", nil) {
+		t.Error("expected the rules to be kept when the file can't be read")
+	}
+}
+
+func TestEgressDeniedConnectGetsRuleFailure(t *testing.T) {
+	addr := startDestination(t, func(net.Conn) {})
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.Egress.Deny = []string{host}
+	cfg.Proxy.LogFailures = true
+
+	server, events := newTestServer(t, cfg)
+	denied := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_egress_denied_total"})
+	server.SetMetrics(&metrics.Metrics{EgressDenied: denied})
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	client, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := client.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatalf("failed to send greeting: %v", err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatalf("failed to read method: %v", err)
+	}
+	request := append([]byte{0x05, 0x01, 0x00, addrTypeIPv4}, net.ParseIP(host).To4()...)
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("failed to send connect: %v", err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != 0x02 {
+		t.Fatalf("expected a ruleset failure reply, got %v, %v", reply, err)
+	}
+
+	event := receiveEvent(t, events)
+	if event.Status != models.StatusBlocked || event.DestinationIP != host || event.Port != port ||
+		event.SourceIP != "127.0.0.1" {
+		t.Errorf("unexpected blocked event %+v", event)
+	}
+	if got := testutil.ToFloat64(denied); got != 1 {
+		t.Errorf("expected 1 denied connection, got %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+)
+
+// errDestinationBusy is returned when a destination already has the maximum
+// number of concurrent connections.
+var errDestinationBusy = errors.New("too many concurrent connections to destination")
+
+// errTooManyConnections is the reason recorded for client connections closed
+// because proxy.max_connections were already open.
+var errTooManyConnections = errors.New("too many concurrent client connections")
+
+// destinationLimiter caps concurrent connections per destination address.
+type destinationLimiter struct {
+	limit  int
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// newDestinationLimiter returns nil, which never limits, when limit is not positive.
+func newDestinationLimiter(limit int) *destinationLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &destinationLimiter{
+		limit:  limit,
+		active: make(map[string]int),
+	}
+}
+
+// acquire reserves a connection slot for dest, reporting false when the
+// destination is at its limit.
+func (l *destinationLimiter) acquire(dest string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[dest] >= l.limit {
+		return false
+	}
+	l.active[dest]++
+
+	return true
+}
+
+// release frees a slot previously reserved with acquire.
+func (l *destinationLimiter) release(dest string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[dest] <= 1 {
+		delete(l.active, dest)
+
+		return
+	}
+	l.active[dest]--
+}
+
+// limitListener enforces proxy.max_connections on client connections. Since
+// go-socks5 owns the accept loop, connections over the limit are closed here,
+// before any handshake, and Accept moves on to the next one.
+type limitListener struct {
+	net.Listener
+	pool     *pipeline.ConnectionPool
+	rejected func(source string)
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if l.pool.AddConnection() {
+			return &limitedConn{Conn: conn, pool: l.pool}, nil
+		}
+
+		if l.rejected != nil {
+			l.rejected(security.HostIP(conn.RemoteAddr().String()))
+		}
+		_ = conn.Close()
+	}
+}
+
+// limitedConn returns its slot to the pool on the first Close.
+type limitedConn struct {
+	net.Conn
+	pool   *pipeline.ConnectionPool
+	closed atomic.Bool
+}
+
+func (c *limitedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.pool.RemoveConnection()
+	}
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// errPrivateDestination is returned when a dial targets an internal address
+// while proxy.block_private_destinations is enabled.
+var errPrivateDestination = errors.New("destination is a private or internal address")
+
+// privateDestinationPolicy refuses dials to loopback, RFC 1918, link-local,
+// unique local (ULA) and unspecified addresses, so clients cannot use the
+// proxy to reach internal services.
+type privateDestinationPolicy struct {
+	exceptions []*net.IPNet
+}
+
+// newPrivateDestinationPolicy parses the allowed exceptions, given as IPs or
+// CIDRs. Invalid entries are skipped, which errs on the side of blocking, and
+// reported through invalid.
+func newPrivateDestinationPolicy(exceptions []string) (policy *privateDestinationPolicy, invalid []error) {
+	policy = &privateDestinationPolicy{}
+	for _, exception := range exceptions {
+		network, err := parseIPOrCIDR(exception)
+		if err != nil {
+			invalid = append(invalid, err)
+
+			continue
+		}
+		policy.exceptions = append(policy.exceptions, network)
+	}
+
+	return policy, invalid
+}
+
+// allowed reports whether dialing addr (host:port) is permitted. Hostnames are
+// allowed through because the SOCKS server resolves them before dialing.
+func (p *privateDestinationPolicy) allowed(addr string) bool {
+	if p == nil {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isInternalIP(ip) {
+		return true
+	}
+
+	for _, network := range p.exceptions {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+func parseIPOrCIDR(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", value)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrivateDestinationPolicy(t *testing.T) {
+	policy, invalid := newPrivateDestinationPolicy([]string{"10.20.0.0/16", "fd00::53", "not-an-ip"})
+	if len(invalid) != 1 {
+		t.Fatalf("expected one invalid exception, got %v", invalid)
+	}
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{addr: "8.8.8.8:53", allowed: true},
+		{addr: "[2001:4860:4860::8888]:443", allowed: true},
+		{addr: "example.com:443", allowed: true},
+		{addr: "10.0.0.1:80", allowed: false},
+		{addr: "172.16.5.4:80", allowed: false},
+		{addr: "192.168.1.1:80", allowed: false},
+		{addr: "127.0.0.1:6379", allowed: false},
+		{addr: "[::1]:6379", allowed: false},
+		{addr: "169.254.169.254:80", allowed: false},
+		{addr: "[fe80::1]:80", allowed: false},
+		{addr: "[fd12:3456::1]:80", allowed: false},
+		{addr: "0.0.0.0:80", allowed: false},
+		// Exceptions.
+		{addr: "10.20.1.1:80", allowed: true},
+		{addr: "[fd00::53]:53", allowed: true},
+	}
+
+	for _, tt := range tests {
+		if got := policy.allowed(tt.addr); got != tt.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", tt.addr, tt.allowed, got)
+		}
+	}
+}
+
+func TestDialBlocksPrivateDestinations(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.BlockPrivateDestinations = true
+
+	server, _ := newTestServer(t, cfg)
+	blocked := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_blocked_destinations_total"})
+	server.SetMetrics(&metrics.Metrics{BlockedDestinations: blocked})
+
+	addr := startDestination(t, func(net.Conn) {})
+
+	if _, err := server.dialWithTracking(context.Background(), "tcp", addr); !errors.Is(err, errPrivateDestination) {
+		t.Fatalf("expected loopback dial to be blocked, got %v", err)
+	}
+	if got := testutil.ToFloat64(blocked); got != 1 {
+		t.Errorf("expected 1 blocked dial, got %v", got)
+	}
+}
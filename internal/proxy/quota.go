@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// errQuotaExceeded is returned when the source IP or user has used up its
+// byte quota.
+var errQuotaExceeded = errors.New("byte quota exceeded")
+
+// Quotas tracks which source IPs and users transferred more than their byte
+// quota within the sliding window, from the traffic logs in the database.
+// The usage is refreshed periodically, so enforcement lags by up to the
+// refresh interval plus the time logs take to reach the database, and bytes
+// of connections still open only count once they are logged.
+type Quotas struct {
+	repo      storage.Repository
+	window    time.Duration
+	ipBytes   int64
+	userBytes int64
+	log       *zap.Logger
+
+	mu        sync.RWMutex
+	overIPs   map[string]bool
+	overUsers map[string]bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewQuotas returns quotas of ipBytes per source IP and userBytes per user
+// over window. A zero limit disables that quota.
+func NewQuotas(repo storage.Repository, window time.Duration, ipBytes, userBytes int64, log *zap.Logger) *Quotas {
+	return &Quotas{
+		repo:      repo,
+		window:    window,
+		ipBytes:   ipBytes,
+		userBytes: userBytes,
+		log:       log,
+		overIPs:   make(map[string]bool),
+		overUsers: make(map[string]bool),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Refresh re-reads which source IPs and users are over their quota.
+func (q *Quotas) Refresh(ctx context.Context) error {
+	overIPs, err := q.over(ctx, q.ipBytes, false)
+	if err != nil {
+		return err
+	}
+	overUsers, err := q.over(ctx, q.userBytes, true)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ip := range overIPs {
+		if !q.overIPs[ip] {
+			q.log.Warn("Source IP exceeded its byte quota", zap.String("source", ip), zap.Int64("limit", q.ipBytes))
+		}
+	}
+	for user := range overUsers {
+		if !q.overUsers[user] {
+			q.log.Warn("User exceeded its byte quota", zap.String("username", user), zap.Int64("limit", q.userBytes))
+		}
+	}
+	q.overIPs, q.overUsers = overIPs, overUsers
+
+	return nil
+}
+
+func (q *Quotas) over(ctx context.Context, limit int64, byUser bool) (map[string]bool, error) {
+	over := make(map[string]bool)
+	if limit <= 0 {
+		return over, nil
+	}
+
+	end := time.Now()
+	usage, err := q.repo.GetByteUsage(ctx, end.Add(-q.window), end, storage.UsageFilter{
+		ByUser:   byUser,
+		MinBytes: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range usage {
+		over[u.Key] = true
+	}
+
+	return over, nil
+}
+
+// exceeded reports whether source or, when set, username is over its quota.
+// A nil Quotas never is.
+func (q *Quotas) exceeded(source, username string) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.overIPs[source] || (username != "" && q.overUsers[username])
+}
+
+// Start refreshes every interval until Stop. A failed refresh is logged and
+// the previous usage kept.
+func (q *Quotas) Start(interval time.Duration) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-q.stop:
+				return
+			case <-ticker.C:
+				if err := q.Refresh(context.Background()); err != nil {
+					q.log.Error("failed to refresh byte quotas", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop halts refreshing.
+func (q *Quotas) Stop() {
+	close(q.stop)
+	q.wg.Wait()
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestQuotasRefuseDialsOverLimit(t *testing.T) {
+	repo := storage.NewInMemoryRepository(10)
+	ctx := context.Background()
+	now := time.Now()
+	for _, log := range []*models.TrafficLog{
+		{SourceIP: "127.0.0.1", Timestamp: now, BytesIn: 600},
+		{SourceIP: "192.0.2.1", Username: "alice", Timestamp: now, BytesIn: 400},
+		{SourceIP: "192.0.2.2", Username: "alice", Timestamp: now, BytesIn: 400},
+		// Outside the window.
+		{SourceIP: "192.0.2.3", Timestamp: now.Add(-2 * time.Hour), BytesIn: 5000},
+	} {
+		if err := repo.SaveTrafficLog(ctx, log); err != nil {
+			t.Fatalf("failed to save log: %v", err)
+		}
+	}
+
+	quotas := NewQuotas(repo, time.Hour, 500, 700, zap.NewNop())
+	if err := quotas.Refresh(ctx); err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	tests := []struct {
+		source, username string
+		exceeded         bool
+	}{
+		{"127.0.0.1", "", true},
+		{"192.0.2.1", "", false},
+		{"192.0.2.1", "alice", true},
+		{"192.0.2.3", "bob", false},
+	}
+	for _, tt := range tests {
+		if got := quotas.exceeded(tt.source, tt.username); got != tt.exceeded {
+			t.Errorf("%s/%s: expected exceeded=%v, got %v", tt.source, tt.username, tt.exceeded, got)
+		}
+	}
+
+	server, events := newTestServer(t, &config.Config{})
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_quota_rejections_total"})
+	server.SetMetrics(&metrics.Metrics{QuotaRejections: rejected})
+	server.SetQuotas(quotas)
+	server.cfg.Proxy.LogFailures = true
+
+	addr := startDestination(t, func(net.Conn) {})
+	dialCtx := withConnInfo(ctx, connInfo{Source: "127.0.0.1:40000"})
+	if _, err := server.dialWithTracking(dialCtx, "tcp", addr); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("expected the dial to be refused, got %v", err)
+	}
+	if got := testutil.ToFloat64(rejected); got != 1 {
+		t.Errorf("expected 1 rejection, got %v", got)
+	}
+	if event := receiveEvent(t, events); event.Status != models.StatusBlocked || event.SourceIP != "127.0.0.1" {
+		t.Errorf("expected a blocked event, got %+v", event)
+	}
+}
+
+func TestQuotasRefuseUDPAssociate(t *testing.T) {
+	repo := storage.NewInMemoryRepository(10)
+	ctx := context.Background()
+	log := &models.TrafficLog{SourceIP: "127.0.0.1", Timestamp: time.Now(), BytesIn: 600}
+	if err := repo.SaveTrafficLog(ctx, log); err != nil {
+		t.Fatalf("failed to save log: %v", err)
+	}
+	quotas := NewQuotas(repo, time.Hour, 500, 0, zap.NewNop())
+	if err := quotas.Refresh(ctx); err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.UDP.Enabled = true
+	server, _ := newTestServer(t, cfg)
+	server.SetQuotas(quotas)
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	control, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = control.Close()
+	}()
+	_ = control.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := control.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatalf("failed to send greeting: %v", err)
+	}
+	if _, err := io.ReadFull(control, make([]byte, 2)); err != nil {
+		t.Fatalf("failed to read method: %v", err)
+	}
+	if _, err := control.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatalf("failed to send associate: %v", err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(control, reply); err != nil || reply[1] != replyNotAllowed {
+		t.Errorf("expected associate over quota to be refused, got %v, %v", reply, err)
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+)
+
+// errRateLimited is the reason recorded for client connections closed by the
+// rate limit.
+var errRateLimited = errors.New("connection rate limit exceeded")
+
+// rateLimitListener closes client connections from a source IP that has
+// exceeded the rate limit before the SOCKS handshake starts.
+type rateLimitListener struct {
+	net.Listener
+	limiter  *security.RateLimiter
+	rejected func(source string)
+}
+
+func (l *rateLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		source := l.limiter.GetSourceIP(conn.RemoteAddr().String())
+		if l.limiter.Allow(source) {
+			return conn, nil
+		}
+
+		l.rejected(source)
+		_ = conn.Close()
+	}
+}
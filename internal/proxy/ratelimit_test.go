@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestRateLimitRefusesConnections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+
+	server, _ := newTestServer(t, cfg)
+	limited := prometheus.NewCounter(prometheus.CounterOpts{Name: "rate_limited"})
+	server.SetMetrics(&metrics.Metrics{RateLimitedConnections: limited})
+	// A rate this low won't refill during the test.
+	server.SetRateLimiter(security.NewRateLimiter(1, 0, true, zap.NewNop()))
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	served := func() bool {
+		conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		// SOCKS5 greeting offering "no authentication".
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		_, _ = conn.Write([]byte{0x05, 0x01, 0x00})
+		_, err = io.ReadFull(conn, make([]byte, 2))
+
+		return err == nil
+	}
+
+	if !served() {
+		t.Fatal("expected the first connection to be served")
+	}
+
+	refused := false
+	for i := 0; i < 5 && !refused; i++ {
+		refused = !served()
+	}
+	if !refused {
+		t.Fatal("expected connections over the rate limit to be refused")
+	}
+	if got := testutil.ToFloat64(limited); got != 1 {
+		t.Errorf("expected 1 rate limited connection, got %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// defaultRelayBufferBytes matches the buffer size io.Copy allocates.
+const defaultRelayBufferBytes = 32 * 1024
+
+// newRelayBufferPool returns a pool of relay copy buffers of the given size.
+func newRelayBufferPool(size int) *sync.Pool {
+	if size <= 0 {
+		size = defaultRelayBufferBytes
+	}
+
+	return &sync.Pool{
+		New: func() any {
+			buf := make([]byte, size)
+
+			return &buf
+		},
+	}
+}
+
+// WriteTo relays data from the destination to w. go-socks5 copies with
+// io.Copy, which prefers WriterTo, so implementing it keeps the relay buffer
+// size under our control instead of the 32 KiB io.Copy default.
+func (tc *trackedConn) WriteTo(w io.Writer) (int64, error) {
+	return relay(w, readerFunc(tc.Read), tc.server.relayBuffers)
+}
+
+// ReadFrom relays data from r to the destination using a pooled buffer.
+func (tc *trackedConn) ReadFrom(r io.Reader) (int64, error) {
+	return relay(writerFunc(tc.Write), r, tc.server.relayBuffers)
+}
+
+// relay copies src to dst with a buffer taken from pool. It deliberately
+// avoids io.CopyBuffer so src/dst WriterTo/ReaderFrom implementations
+// (including trackedConn's own) are not re-entered.
+func relay(dst io.Writer, src io.Reader, pool *sync.Pool) (int64, error) {
+	bufPtr, _ := pool.Get().(*[]byte)
+	defer pool.Put(bufPtr)
+	buf := *bufPtr
+
+	var written int64
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			if nw < 0 || nw > nr {
+				nw = 0
+				if werr == nil {
+					werr = errors.New("invalid write result")
+				}
+			}
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+
+		if rerr != nil {
+			if errors.Is(rerr, io.EOF) {
+				return written, nil
+			}
+
+			return written, rerr
+		}
+	}
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"go.uber.org/zap"
+)
+
+// streamConn is a net.Conn whose reads come from r and whose writes go to w.
+type streamConn struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (c *streamConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *streamConn) Write(p []byte) (int, error)        { return c.w.Write(p) }
+func (c *streamConn) Close() error                       { return nil }
+func (c *streamConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *streamConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+func newRelayTestServer(bufferBytes int) *Server {
+	cfg := &config.Config{}
+	cfg.Proxy.RelayBufferBytes = bufferBytes
+
+	return NewServer(cfg, zap.NewNop(), nil)
+}
+
+func TestTrackedConnRelay(t *testing.T) {
+	server := newRelayTestServer(1024)
+	payload := bytes.Repeat([]byte("socks5"), 10000)
+
+	var sink bytes.Buffer
+	tc := &trackedConn{
+		Conn:   &streamConn{r: bytes.NewReader(payload), w: &sink},
+		server: server,
+	}
+
+	// Destination -> client uses WriterTo.
+	var client bytes.Buffer
+	n, err := io.Copy(&client, tc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != int64(len(payload)) || !bytes.Equal(client.Bytes(), payload) {
+		t.Fatalf("expected %d relayed bytes, got %d", len(payload), n)
+	}
+	if tc.bytesIn.Load() != int64(len(payload)) {
+		t.Errorf("expected bytesIn %d, got %d", len(payload), tc.bytesIn.Load())
+	}
+
+	// Client -> destination uses ReaderFrom.
+	n, err = io.Copy(tc, bytes.NewReader(payload[:5000]))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 5000 || sink.Len() != 5000 {
+		t.Fatalf("expected 5000 relayed bytes, got %d", n)
+	}
+	if tc.bytesOut.Load() != 5000 {
+		t.Errorf("expected bytesOut 5000, got %d", tc.bytesOut.Load())
+	}
+}
+
+// BenchmarkRelayBufferSize relays a payload read from a loopback TCP socket so
+// the per-syscall cost of small buffers shows up in the throughput numbers.
+func BenchmarkRelayBufferSize(b *testing.B) {
+	const payloadSize = 8 * 1024 * 1024
+	payload := bytes.Repeat([]byte{0xAB}, payloadSize)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = conn.Write(payload)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	for _, size := range []int{1024, 4 * 1024, 32 * 1024, 128 * 1024} {
+		b.Run(fmt.Sprintf("%dKiB", size/1024), func(b *testing.B) {
+			server := newRelayTestServer(size)
+			b.SetBytes(payloadSize)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				tc := &trackedConn{Conn: conn, server: server}
+				if _, err := tc.WriteTo(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+				_ = conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"go.uber.org/zap"
+)
+
+// Reload applies the reloadable proxy settings of cfg to the running server:
+// the source IP whitelist, the credentials when authentication is enabled,
+// and the egress rules, re-reading proxy.egress.rules_file. Open connections
+// are unaffected. If the rules file can't be read the other settings are
+// still applied, the current egress rules are kept and the error returned.
+func (s *Server) Reload(cfg *config.Config) error {
+	s.reloadConfigWhitelist(cfg.Proxy.IPWhitelist)
+
+	if s.auth != nil {
+		authCfg := cfg.Proxy.Auth
+		s.auth.SetUsers(credentials(authCfg.Username, authCfg.Password, authCfg.Users))
+	}
+
+	if s.egress == nil {
+		return nil
+	}
+	egress := cfg.Proxy.Egress
+	invalid, err := s.egress.update(egress.Allow, egress.Deny, egress.RulesFile)
+	if err != nil {
+		return err
+	}
+	for _, err := range invalid {
+		s.log.Error("ignoring invalid egress rule", zap.Error(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+)
+
+func TestReload(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.Auth.Enabled = true
+	cfg.Proxy.Auth.Users = []config.Credential{{Username: "alice", Password: "a"}}
+
+	server, _ := newTestServer(t, cfg)
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	updated := &config.Config{}
+	updated.Proxy.IPWhitelist = []string{"192.0.2.0/24"}
+	updated.Proxy.Auth.Users = []config.Credential{{Username: "bob", Password: "b"}}
+	updated.Proxy.Egress.Deny = []string{"*.doubleclick.net"}
+	if err := server.Reload(updated); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	if server.whitelist.IsAllowed("127.0.0.1") || !server.whitelist.IsAllowed("192.0.2.1") {
+		t.Error("expected the reloaded whitelist to apply")
+	}
+	if server.auth.Authenticate("alice", "a") || !server.auth.Authenticate("bob", "b") {
+		t.Error("expected the reloaded credentials to apply")
+	}
+	if server.egress.allowed("ad.doubleclick.net", net.IPv4(192, 0, 2, 1)) {
+		t.Error("expected the reloaded egress rules to apply")
+	}
+
+	updated.Proxy.Egress.RulesFile = "/nonexistent/egress.rules"
+	if err := server.Reload(updated); err == nil {
+		t.Error("expected an unreadable rules file to be reported")
+	}
+	if server.egress.allowed("ad.doubleclick.net", nil) {
+		t.Error("expected the egress rules to be kept when the rules file can't be read")
+	}
+}
@@ -3,101 +3,558 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/andev0x/socks5-proxy-analytics/internal/tracing"
 	socks5 "github.com/armon/go-socks5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 // Server manages the SOCKS5 proxy server.
 type Server struct {
-	cfg       *config.Config
-	log       *zap.Logger
-	collector *pipeline.Collector
-	listener  net.Listener
+	cfg          *config.Config
+	log          *zap.Logger
+	collector    *pipeline.Collector
+	listeners    []net.Listener
+	relayBuffers *sync.Pool
+	ready        <-chan struct{}
+	destinations *destinationLimiter
+	private      *privateDestinationPolicy
+	egress       *egressPolicy
+	accepts      *acceptLogger
+	metrics      *metrics.Metrics
+	conns        *connTracker
+	clients      *pipeline.ConnectionPool
+	decisions    *security.DecisionCache
+	whitelist    *security.IPWhitelist
+	auth         *security.Authenticator
+	rateLimit    *security.RateLimiter
+	quotas       *Quotas
+	hijacks      *hijackRegistry
+	refusals     *refusalSampler
+	// userThrottles holds proxy.throttle.users by username.
+	userThrottles map[string]int64
+
+	// whitelistMu guards the two sources merged into whitelist.
+	whitelistMu      sync.Mutex
+	configWhitelist  []string
+	managedWhitelist []string
 }
 
 // NewServer creates a new SOCKS5 proxy server.
 func NewServer(cfg *config.Config, log *zap.Logger, collector *pipeline.Collector) *Server {
-	return &Server{
-		cfg:       cfg,
-		log:       log,
-		collector: collector,
+	s := &Server{
+		cfg:          cfg,
+		log:          log,
+		collector:    collector,
+		relayBuffers: newRelayBufferPool(cfg.Proxy.RelayBufferBytes),
+		destinations: newDestinationLimiter(cfg.Proxy.MaxDialsPerDestination),
+		conns:        newConnTracker(),
+		refusals:     newRefusalSampler(),
+		clients:      pipeline.NewConnectionPool(cfg.Proxy.MaxConnections, log),
+		accepts: newAcceptLogger(
+			cfg.Proxy.AcceptLog.Enabled,
+			cfg.Proxy.AcceptLog.AcceptedSampleRate,
+			cfg.Proxy.AcceptLog.HashSourceIP,
+			cfg.Proxy.AcceptLog.HashSalt,
+			log,
+		),
+	}
+
+	s.decisions = security.NewDecisionCache(
+		time.Duration(cfg.Proxy.DecisionCache.TTLMs)*time.Millisecond,
+		cfg.Proxy.DecisionCache.MaxEntries,
+	)
+	s.whitelist = newWhitelist(cfg.Proxy.IPWhitelist, s.decisions, log)
+	s.configWhitelist = cfg.Proxy.IPWhitelist
+
+	s.userThrottles = make(map[string]int64, len(cfg.Proxy.Throttle.Users))
+	for _, user := range cfg.Proxy.Throttle.Users {
+		s.userThrottles[user.Username] = user.BytesPerSecond
+	}
+
+	if cfg.Proxy.BlockPrivateDestinations {
+		policy, invalid := newPrivateDestinationPolicy(cfg.Proxy.PrivateDestinationExceptions)
+		for _, err := range invalid {
+			log.Error("ignoring private destination exception", zap.Error(err))
+		}
+		s.private = policy
 	}
+
+	return s
+}
+
+// SetMetrics records proxy metrics in m. It must be called before Start.
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetRateLimiter limits how often each source IP may open a connection.
+// Connections over the limit are closed before the SOCKS handshake. It must
+// be called before Start.
+func (s *Server) SetRateLimiter(limiter *security.RateLimiter) {
+	s.rateLimit = limiter
+}
+
+// SetQuotas refuses dials from source IPs and users over their byte quota.
+// It must be called before Start.
+func (s *Server) SetQuotas(quotas *Quotas) {
+	s.quotas = quotas
+}
+
+// SetReadyGate delays accepting connections until ready is closed, or until
+// proxy.ready_warmup_ms elapses if that is set. The listener is bound
+// immediately, so clients connecting early queue in the accept backlog
+// instead of being refused. It must be called before Start.
+func (s *Server) SetReadyGate(ready <-chan struct{}) {
+	s.ready = ready
 }
 
 // Start starts the SOCKS5 proxy server.
 func (s *Server) Start() error {
 	conf := &socks5.Config{
 		Resolver: &socks5.DNSResolver{},
+		Rewriter: requestRewriter{},
 	}
 
 	// Add dialer with traffic tracking
 	conf.Dial = s.dialWithTracking
 
+	conf.Rules = socks5.PermitAll()
+	if s.cfg.Proxy.UDP.Enabled {
+		s.hijacks = newHijackRegistry()
+		conf.Rules = associateRules{server: s}
+	}
+	egress := s.cfg.Proxy.Egress
+	policy, invalid, err := newEgressPolicy(egress.Allow, egress.Deny, egress.RulesFile)
+	if err != nil {
+		return err
+	}
+	for _, err := range invalid {
+		s.log.Error("ignoring invalid egress rule", zap.Error(err))
+	}
+	s.egress = policy
+	conf.Rules = egressRuleSet{server: s, next: conf.Rules}
+
+	if authCfg := s.cfg.Proxy.Auth; authCfg.Enabled {
+		s.auth = security.NewMultiUserAuthenticator(credentials(authCfg.Username, authCfg.Password, authCfg.Users))
+		s.auth.SetDecisionCache(s.decisions)
+		conf.AuthMethods = []socks5.Authenticator{&userPassAuthenticator{auth: s.auth, failed: s.authFailed}}
+	}
+
 	socksServer, err := socks5.New(conf)
 	if err != nil {
 		return fmt.Errorf("failed to create SOCKS5 server: %w", err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", s.cfg.Proxy.Address, s.cfg.Proxy.Port)
-	lc := &net.ListenConfig{}
-	listener, err := lc.Listen(context.Background(), "tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	var tlsConfig *tls.Config
+	if tlsCfg := s.cfg.Proxy.TLS; tlsCfg.Enabled {
+		tlsConfig, err = newTLSConfig(tlsCfg.CertFile, tlsCfg.KeyFile, tlsCfg.MinVersion, tlsCfg.CipherSuites, s.log)
+		if err != nil {
+			return err
+		}
 	}
 
-	s.listener = listener
-	s.log.Info("SOCKS5 server started", zap.String("address", addr))
+	addrs := s.listenAddresses()
+	for _, addr := range addrs {
+		listener, err := s.listen(addr, tlsConfig)
+		if err != nil {
+			_ = s.Stop()
+			s.listeners = nil
+
+			return err
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+	s.warnOpenProxy(addrs)
+	s.log.Info("SOCKS5 server started", zap.Strings("addresses", addrs),
+		zap.Bool("auth", s.cfg.Proxy.Auth.Enabled),
+		zap.Bool("tls", s.cfg.Proxy.TLS.Enabled),
+		zap.Bool("compression", s.cfg.Proxy.Compression.Enabled),
+		zap.Bool("udp", s.cfg.Proxy.UDP.Enabled))
 
-	// Accept connections in a goroutine
+	// Accept connections in a goroutine per listener
 	go func() {
-		if err := socksServer.Serve(listener); err != nil {
-			if !errors.Is(err, net.ErrClosed) {
-				s.log.Error("SOCKS5 server error", zap.Error(err))
-			}
+		s.waitReady()
+
+		for _, listener := range s.listeners {
+			go func() {
+				if err := socksServer.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+					s.log.Error("SOCKS5 server error", zap.String("address", listener.Addr().String()), zap.Error(err))
+				}
+			}()
 		}
 	}()
 
 	return nil
 }
 
+// listenAddresses returns proxy.listeners, or proxy.address:proxy.port when
+// no listeners are configured.
+func (s *Server) listenAddresses() []string {
+	if len(s.cfg.Proxy.Listeners) > 0 {
+		return s.cfg.Proxy.Listeners
+	}
+
+	return []string{net.JoinHostPort(s.cfg.Proxy.Address, strconv.Itoa(s.cfg.Proxy.Port))}
+}
+
+// warnOpenProxy warns about listeners on every interface while neither
+// authentication nor an IP whitelist restricts who may relay through them.
+func (s *Server) warnOpenProxy(addrs []string) {
+	if s.cfg.Proxy.Auth.Enabled || s.whitelist.IsEnabled() {
+		return
+	}
+
+	for _, addr := range addrs {
+		host, _, _ := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			s.log.Warn("proxy listens on all interfaces with neither proxy.auth nor proxy.ip_whitelist set; "+
+				"anyone who can reach it can relay through it", zap.String("address", addr))
+		}
+	}
+}
+
+// listen binds addr and wraps the listener with the connection admission,
+// TLS and compression layers. Every listener shares the server's pools.
+func (s *Server) listen(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	lc := &net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	listener = &whitelistListener{Listener: listener, whitelist: s.whitelist, rejected: s.sourceRejected}
+	if s.rateLimit != nil {
+		listener = &rateLimitListener{Listener: listener, limiter: s.rateLimit, rejected: s.rateLimited}
+	}
+	if s.cfg.Proxy.MaxConnections > 0 {
+		listener = &limitListener{Listener: listener, pool: s.clients, rejected: s.connectionRejected}
+	}
+	if s.cfg.Proxy.LogAccepts {
+		listener = &acceptListener{Listener: listener, accepted: s.connectionAccepted}
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	if s.cfg.Proxy.Compression.Enabled {
+		listener = &compressionListener{
+			Listener: listener,
+			level:    s.cfg.Proxy.Compression.Level,
+			stats:    newCompressionStats(s.metrics),
+		}
+	}
+	if s.hijacks != nil {
+		listener = &hijackListener{Listener: listener, registry: s.hijacks}
+	}
+
+	return listener, nil
+}
+
+// connectionAccepted emits an accepted event for a new client connection,
+// the top of the accept-to-success funnel.
+func (s *Server) connectionAccepted(source string) {
+	s.emitAttempt(pipeline.RawTrafficEvent{Status: models.StatusAccepted}, source, "")
+}
+
+func (s *Server) connectionRejected(source string) {
+	if s.metrics != nil {
+		s.metrics.RejectedConnections.Inc()
+	}
+	s.accepts.refused(source, "", errTooManyConnections)
+	if s.refusals.sample(source, models.StatusConnectionLimited, time.Now()) {
+		s.attemptFailed(pipeline.RawTrafficEvent{Status: models.StatusConnectionLimited}, source, "")
+	}
+}
+
+func (s *Server) sourceRejected(source string) {
+	s.log.Debug("connection from source outside ip_whitelist refused", zap.String("source", source))
+	if s.metrics != nil {
+		s.metrics.WhitelistRejections.Inc()
+	}
+	s.accepts.refused(source, "", errSourceNotWhitelisted)
+	if s.refusals.sample(source, models.StatusBlocked, time.Now()) {
+		s.attemptFailed(pipeline.RawTrafficEvent{Status: models.StatusBlocked}, source, "")
+	}
+}
+
+func (s *Server) rateLimited(source string) {
+	s.log.Debug("connection refused by rate limit", zap.String("source", source))
+	if s.metrics != nil {
+		s.metrics.RateLimitedConnections.Inc()
+	}
+	s.accepts.refused(source, "", errRateLimited)
+	if s.refusals.sample(source, models.StatusRateLimited, time.Now()) {
+		s.attemptFailed(pipeline.RawTrafficEvent{Status: models.StatusRateLimited}, source, "")
+	}
+}
+
+// authFailed records a failed SOCKS5 authentication. Every failure is
+// counted, but a client guessing passwords is logged and persisted at most
+// once per refusalLogInterval.
+func (s *Server) authFailed(source, username string) {
+	if s.metrics != nil {
+		s.metrics.AuthFailures.Inc()
+	}
+	s.accepts.refused(source, "", errAuthFailed)
+	if !s.refusals.sample(security.HostIP(source), models.StatusAuthFailed, time.Now()) {
+		return
+	}
+
+	s.log.Warn("SOCKS5 authentication failed", zap.String("source", source), zap.String("username", username))
+	s.attemptFailed(pipeline.RawTrafficEvent{Status: models.StatusAuthFailed, Username: username}, source, "")
+}
+
+// attemptFailed emits event for a connection attempt that didn't get
+// through, unless proxy.log_failures is off. source and addr are host:port
+// or bare hosts; addr is empty when the client never named a destination.
+func (s *Server) attemptFailed(event pipeline.RawTrafficEvent, source, addr string) {
+	if !s.cfg.Proxy.LogFailures {
+		return
+	}
+
+	s.emitAttempt(event, source, addr)
+}
+
+// emitAttempt fills in event from source and addr, as for attemptFailed,
+// and collects it.
+func (s *Server) emitAttempt(event pipeline.RawTrafficEvent, source, addr string) {
+	event.SourceIP, _ = parseAddress(source)
+	if addr != "" {
+		event.DestinationIP, event.Port = parseAddress(addr)
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.Protocol == "" {
+		event.Protocol = "tcp"
+	}
+
+	_ = s.collector.Collect(event)
+}
+
+// waitReady blocks until the ready gate opens or the warmup expires.
+func (s *Server) waitReady() {
+	if s.ready == nil {
+		return
+	}
+
+	var warmup <-chan time.Time
+	if s.cfg.Proxy.ReadyWarmupMs > 0 {
+		timer := time.NewTimer(time.Duration(s.cfg.Proxy.ReadyWarmupMs) * time.Millisecond)
+		defer timer.Stop()
+		warmup = timer.C
+	}
+
+	start := time.Now()
+	select {
+	case <-s.ready:
+		s.log.Info("Pipeline ready, accepting connections", zap.Duration("waited", time.Since(start)))
+	case <-warmup:
+		s.log.Warn("Pipeline not ready after warmup, accepting connections anyway",
+			zap.Duration("waited", time.Since(start)))
+	}
+}
+
 func (s *Server) dialWithTracking(ctx context.Context, network, addr string) (net.Conn, error) {
-	// Default dialer
 	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+		Timeout:   time.Duration(s.cfg.Proxy.DialTimeoutMs) * time.Millisecond,
+		KeepAlive: time.Duration(s.cfg.Proxy.DialKeepAliveMs) * time.Millisecond,
+	}
+
+	info := connInfoFrom(ctx)
+
+	// The connection span covers the dial and the transfer; trackedConn ends
+	// it on close.
+	ctx, span := tracing.Tracer().Start(ctx, "proxy.connection", trace.WithAttributes(
+		attribute.String("proxy.source", info.Source),
+		attribute.String("proxy.destination", addr),
+		attribute.String("proxy.domain", info.Domain),
+	))
+	if err := s.admit(info, addr); err != nil {
+		endSpan(span, err)
+
+		return nil, err
 	}
 
+	dialCtx, dialSpan := tracing.Tracer().Start(ctx, "proxy.dial", trace.WithSpanKind(trace.SpanKindClient))
 	start := time.Now()
-	conn, err := dialer.DialContext(ctx, network, addr)
+	conn, err := dialer.DialContext(dialCtx, network, addr)
 	latency := time.Since(start).Milliseconds()
+	endSpan(dialSpan, err)
 
 	if err != nil {
+		endSpan(span, err)
+		s.destinations.release(addr)
 		s.log.Debug("dial failed", zap.String("addr", addr), zap.Error(err))
+		s.attemptFailed(pipeline.RawTrafficEvent{
+			Status:    models.StatusDialFailed,
+			Username:  info.Username,
+			Domain:    info.Domain,
+			Timestamp: start,
+			LatencyMs: latency,
+		}, info.Source, addr)
 
 		return nil, err
 	}
 
 	// Wrap the connection to track traffic
-	return &trackedConn{
-		Conn:      conn,
-		server:    s,
-		destAddr:  addr,
-		timestamp: start,
-		latency:   latency,
-	}, nil
+	tc := &trackedConn{
+		Conn:        conn,
+		server:      s,
+		source:      info.Source,
+		destAddr:    addr,
+		domain:      info.Domain,
+		username:    info.Username,
+		established: time.Now(),
+		latency:     latency,
+		span:        span,
+		idleTimeout: time.Duration(s.cfg.Proxy.IdleTimeoutMs) * time.Millisecond,
+	}
+	if !s.conns.add(tc) {
+		endSpan(span, errShuttingDown)
+		s.destinations.release(addr)
+		_ = conn.Close()
+		s.accepts.refused(info.Source, addr, errShuttingDown)
+
+		return nil, errShuttingDown
+	}
+	if s.cfg.Proxy.SNI.Enabled && info.Domain == "" {
+		tc.sni = &sniSniffer{}
+	}
+	if rate := s.throttleRate(info.Username); rate > 0 {
+		tc.throttle(rate)
+	}
+	tc.extendDeadline()
+	if s.cfg.Proxy.InterimIntervalMs > 0 {
+		tc.stopInterim = make(chan struct{})
+		go tc.reportInterim(time.Duration(s.cfg.Proxy.InterimIntervalMs) * time.Millisecond)
+	}
+
+	s.accepts.accepted(info.Source, addr)
+	if s.metrics != nil {
+		s.metrics.TotalConnections.Inc()
+		s.metrics.ActiveConnections.Inc()
+		s.metrics.ObserveLatency(ctx, float64(latency))
+	}
+
+	return tc, nil
 }
 
-// Stop stops the SOCKS5 proxy server.
+// admit applies the byte quotas, the private destination policy and the
+// per-destination dial limit to a dial of addr, recording a refusal. On
+// success the caller holds a dial slot for addr and must release it.
+func (s *Server) admit(info connInfo, addr string) error {
+	if s.quotaExceeded(info, addr, "tcp") {
+		return errQuotaExceeded
+	}
+
+	if !s.private.allowed(addr) {
+		s.log.Warn("dial to private destination blocked", zap.String("addr", addr))
+		if s.metrics != nil {
+			s.metrics.BlockedDestinations.Inc()
+		}
+		s.accepts.refused(info.Source, addr, errPrivateDestination)
+		s.attemptFailed(pipeline.RawTrafficEvent{
+			Status:   models.StatusBlocked,
+			Username: info.Username,
+			Domain:   info.Domain,
+		}, info.Source, addr)
+
+		return errPrivateDestination
+	}
+
+	if !s.destinations.acquire(addr) {
+		s.log.Debug("dial refused", zap.String("addr", addr), zap.Error(errDestinationBusy))
+		s.accepts.refused(info.Source, addr, errDestinationBusy)
+		s.attemptFailed(pipeline.RawTrafficEvent{
+			Status:   models.StatusDialFailed,
+			Username: info.Username,
+			Domain:   info.Domain,
+		}, info.Source, addr)
+
+		return errDestinationBusy
+	}
+
+	return nil
+}
+
+// quotaExceeded reports whether the client or user of info is over its byte
+// quota, recording the refusal of its traffic to addr, which may be empty.
+func (s *Server) quotaExceeded(info connInfo, addr, protocol string) bool {
+	if !s.quotas.exceeded(security.HostIP(info.Source), info.Username) {
+		return false
+	}
+
+	s.log.Debug("dial refused", zap.String("source", info.Source), zap.String("username", info.Username),
+		zap.String("protocol", protocol), zap.Error(errQuotaExceeded))
+	if s.metrics != nil {
+		s.metrics.QuotaRejections.Inc()
+	}
+	s.accepts.refused(info.Source, addr, errQuotaExceeded)
+	s.attemptFailed(pipeline.RawTrafficEvent{
+		Status:   models.StatusBlocked,
+		Username: info.Username,
+		Domain:   info.Domain,
+		Protocol: protocol,
+	}, info.Source, addr)
+
+	return true
+}
+
+// endSpan ends span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Stop stops the SOCKS5 proxy server from accepting new connections on any
+// of its listeners. Connections already open are left to finish; use
+// Shutdown to wait for them.
 func (s *Server) Stop() error {
-	if s.listener != nil {
-		return s.listener.Close()
+	var errs []error
+	for _, listener := range s.listeners {
+		errs = append(errs, listener.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Shutdown stops accepting new connections and waits for the open ones to
+// finish. If ctx ends first, the remaining connections are closed and ctx's
+// error is returned. Every connection has emitted its traffic event by the
+// time Shutdown returns, so the collector can be closed afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+
+	if open := s.conns.active(); open > 0 {
+		s.log.Info("Waiting for open connections to finish", zap.Int("connections", open))
+	}
+	if err := s.conns.drain(ctx); err != nil {
+		s.log.Warn("Shutdown timeout reached, closed remaining connections", zap.Error(err))
+
+		return err
 	}
 
 	return nil
@@ -106,63 +563,249 @@ func (s *Server) Stop() error {
 // trackedConn wraps a net.Conn to track bytes read/written.
 type trackedConn struct {
 	net.Conn
-	server    *Server
-	destAddr  string
-	timestamp time.Time
-	latency   int64
-	bytesIn   int64
-	bytesOut  int64
+	server      *Server
+	source      string
+	destAddr    string
+	domain      string
+	username    string
+	established time.Time
+	latency     int64
+	// span traces the connection from dial to close; it may be nil.
+	span     trace.Span
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	// idleTimeout, when positive, is the deadline set on the destination
+	// connection and pushed forward by every transfer in either direction, so
+	// the relay fails and closes the connection once it goes quiet. idledOut
+	// records that it did.
+	idleTimeout time.Duration
+	idledOut    atomic.Bool
+
+	// readThrottle and writeThrottle pace each direction when
+	// proxy.throttle applies; they are nil otherwise. done is closed by
+	// Close to end paced waits.
+	readThrottle  *security.Throttle
+	writeThrottle *security.Throttle
+	done          chan struct{}
+
+	// sni looks for the TLS SNI hostname in what the client sends when
+	// proxy.sni.enabled is set and the client connected by IP; it is nil
+	// otherwise. sniDomain holds the hostname once found.
+	sni       *sniSniffer
+	sniDomain atomic.Pointer[string]
+
+	// firstByteMs is the time from dial completion to the first byte read
+	// from the destination; it is only meaningful once firstByteSeen is set.
+	firstByteMs   atomic.Int64
+	firstByteSeen atomic.Bool
+
+	closed atomic.Bool
+	// ioMu is held shared by Read and Write and exclusively by Close, which
+	// waits for in-flight calls so the final event sees their byte counts.
+	ioMu sync.RWMutex
+
+	// stopInterim ends reportInterim; it is nil when interim events are off.
+	stopInterim chan struct{}
+	// reportMu guards the byte counts already reported by interim events.
+	reportMu    sync.Mutex
+	reportedIn  int64
+	reportedOut int64
+	finished    bool
 }
 
 func (tc *trackedConn) Read(p []byte) (n int, err error) {
-	n, err = tc.Conn.Read(p)
-	tc.bytesIn += int64(n)
+	tc.ioMu.RLock()
+	defer tc.ioMu.RUnlock()
+
+	n, err = tc.Conn.Read(chunk(tc.readThrottle, p))
+	tc.bytesIn.Add(int64(n))
+	tc.touch(n, err)
+
+	if n > 0 && !tc.firstByteSeen.Load() {
+		tc.firstByteMs.Store(time.Since(tc.established).Milliseconds())
+		tc.firstByteSeen.Store(true)
+	}
+	// Hand the bytes over once the throttle allows them.
+	if perr := tc.pace(tc.readThrottle, n); perr != nil && err == nil {
+		err = perr
+	}
 
 	return n, err
 }
 
 func (tc *trackedConn) Write(p []byte) (n int, err error) {
-	n, err = tc.Conn.Write(p)
-	tc.bytesOut += int64(n)
+	tc.ioMu.RLock()
+	defer tc.ioMu.RUnlock()
 
-	return n, err
+	tc.sniff(p)
+
+	if tc.writeThrottle == nil {
+		n, err = tc.Conn.Write(p)
+		tc.bytesOut.Add(int64(n))
+		tc.touch(n, err)
+
+		return n, err
+	}
+
+	for len(p) > 0 {
+		next := chunk(tc.writeThrottle, p)
+		if err := tc.pace(tc.writeThrottle, len(next)); err != nil {
+			return n, err
+		}
+		written, err := tc.Conn.Write(next)
+		n += written
+		tc.bytesOut.Add(int64(written))
+		tc.touch(written, err)
+		if err != nil {
+			return n, err
+		}
+		p = p[len(next):]
+	}
+
+	return n, nil
+}
+
+// touch extends the idle deadline after a transfer and notes when it expired.
+func (tc *trackedConn) touch(n int, err error) {
+	if tc.idleTimeout <= 0 {
+		return
+	}
+	if n > 0 {
+		tc.extendDeadline()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		tc.idledOut.Store(true)
+	}
+}
+
+// extendDeadline moves the read and write deadlines idleTimeout from now.
+func (tc *trackedConn) extendDeadline() {
+	if tc.idleTimeout > 0 {
+		_ = tc.Conn.SetDeadline(time.Now().Add(tc.idleTimeout))
+	}
 }
 
 func (tc *trackedConn) Close() error {
-	// Log the traffic event
-	remoteAddr := tc.RemoteAddr()
-	var sourceIP string
-	if tcpAddr, ok := remoteAddr.(*net.TCPAddr); ok {
-		sourceIP = tcpAddr.IP.String()
+	if !tc.closed.CompareAndSwap(false, true) {
+		return tc.Conn.Close()
+	}
+	if tc.done != nil {
+		close(tc.done)
+	}
+
+	// Closing first unblocks pending reads and writes; the lock then waits
+	// for them to record their bytes.
+	err := tc.Conn.Close()
+	tc.ioMu.Lock()
+	defer tc.ioMu.Unlock()
+
+	tc.server.destinations.release(tc.destAddr)
+	if tc.stopInterim != nil {
+		close(tc.stopInterim)
+	}
+
+	if m := tc.server.metrics; m != nil {
+		m.ActiveConnections.Dec()
+		m.ClosedConnections.Inc()
 	}
+	if tc.idledOut.Load() {
+		tc.server.log.Debug("Closed idle connection",
+			zap.String("addr", tc.destAddr), zap.Duration("idle_timeout", tc.idleTimeout))
+		if m := tc.server.metrics; m != nil {
+			m.IdleTimeouts.Inc()
+		}
+	}
+
+	tc.report(false)
+	if tc.span != nil {
+		tc.span.SetAttributes(
+			attribute.Int64("proxy.bytes_in", tc.bytesIn.Load()),
+			attribute.Int64("proxy.bytes_out", tc.bytesOut.Load()),
+		)
+		tc.span.End()
+	}
+	tc.server.conns.remove(tc)
+
+	return err
+}
+
+// reportInterim emits an interim event every interval while bytes flow.
+func (tc *trackedConn) reportInterim(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tc.stopInterim:
+			return
+		case <-ticker.C:
+			tc.report(true)
+		}
+	}
+}
+
+// report emits a traffic event carrying the bytes transferred since the
+// previous one, stamped with the time it is emitted so that the bytes fall in
+// the period they were transferred in; the connection opened DurationMs
+// earlier. Interim events are skipped when nothing was transferred, and once
+// the final event has been emitted.
+func (tc *trackedConn) report(interim bool) {
+	tc.reportMu.Lock()
+	defer tc.reportMu.Unlock()
 
+	if tc.finished {
+		return
+	}
+	bytesIn, bytesOut := tc.bytesIn.Load(), tc.bytesOut.Load()
+	deltaIn, deltaOut := bytesIn-tc.reportedIn, bytesOut-tc.reportedOut
+	if interim && deltaIn == 0 && deltaOut == 0 {
+		return
+	}
+	tc.reportedIn, tc.reportedOut = bytesIn, bytesOut
+	tc.finished = !interim
+
+	// Log the traffic event
+	sourceIP, _ := parseAddress(tc.source)
 	destIP, destPort := parseAddress(tc.destAddr)
+	domain := tc.domain
+	if sni := tc.sniDomain.Load(); sni != nil && domain == "" {
+		domain = *sni
+	}
 
+	now := time.Now()
 	event := pipeline.RawTrafficEvent{
-		SourceIP:      sourceIP,
-		DestinationIP: destIP,
-		Domain:        "", // Could be enhanced with reverse DNS lookup
-		Port:          destPort,
-		Timestamp:     tc.timestamp,
-		LatencyMs:     tc.latency,
-		BytesIn:       tc.bytesIn,
-		BytesOut:      tc.bytesOut,
-		Protocol:      "tcp",
+		SourceIP:          sourceIP,
+		Username:          tc.username,
+		DestinationIP:     destIP,
+		Domain:            domain,
+		Port:              destPort,
+		Timestamp:         now,
+		LatencyMs:         tc.latency,
+		FirstByteReceived: tc.firstByteSeen.Load(),
+		FirstByteMs:       tc.firstByteMs.Load(),
+		DurationMs:        now.Sub(tc.established).Milliseconds(),
+		BytesIn:           deltaIn,
+		BytesOut:          deltaOut,
+		Protocol:          "tcp",
+		Interim:           interim,
 	}
 
-	_ = tc.server.collector.Collect(event)
+	if m := tc.server.metrics; m != nil {
+		m.BytesIn.Add(float64(deltaIn))
+		m.BytesOut.Add(float64(deltaOut))
+	}
 
-	return tc.Conn.Close()
+	_ = tc.server.collector.Collect(event)
 }
 
+// parseAddress splits a host:port or bare host address into its host, with
+// IPs in canonical form, and its port (0 if absent).
 func parseAddress(addr string) (string, int) {
-	host, portStr, err := net.SplitHostPort(addr)
-	if err != nil {
-		return addr, 0
-	}
-
 	port := 0
-	_, _ = fmt.Sscanf(portStr, "%d", &port)
+	if _, portStr, err := net.SplitHostPort(addr); err == nil {
+		_, _ = fmt.Sscanf(portStr, "%d", &port)
+	}
 
-	return host, port
+	return security.HostIP(addr), port
 }
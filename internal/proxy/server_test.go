@@ -0,0 +1,782 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	socks5 "github.com/armon/go-socks5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// startDestination starts a TCP server that runs handle for each connection.
+func startDestination(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func newTestServer(t *testing.T, cfg *config.Config) (*Server, chan pipeline.RawTrafficEvent) {
+	t.Helper()
+
+	events := make(chan pipeline.RawTrafficEvent, 10)
+	log := zap.NewNop()
+
+	return NewServer(cfg, log, pipeline.NewCollector(events, log)), events
+}
+
+func receiveEvent(t *testing.T, events chan pipeline.RawTrafficEvent) pipeline.RawTrafficEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for traffic event")
+	}
+
+	return pipeline.RawTrafficEvent{}
+}
+
+func TestFirstByteLatency(t *testing.T) {
+	const delay = 100 * time.Millisecond
+
+	addr := startDestination(t, func(conn net.Conn) {
+		time.Sleep(delay)
+		_, _ = conn.Write([]byte("hello"))
+	})
+
+	server, events := newTestServer(t, &config.Config{})
+
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	_ = conn.Close()
+
+	event := receiveEvent(t, events)
+	if !event.FirstByteReceived {
+		t.Fatal("expected first byte to be recorded")
+	}
+	if event.FirstByteMs < delay.Milliseconds()-10 {
+		t.Errorf("expected first byte latency of at least ~%dms, got %dms", delay.Milliseconds(), event.FirstByteMs)
+	}
+	if event.FirstByteMs < event.LatencyMs {
+		t.Errorf("expected first byte latency %dms to exceed dial latency %dms", event.FirstByteMs, event.LatencyMs)
+	}
+}
+
+func TestFirstByteNeverReceived(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	server, events := newTestServer(t, &config.Config{})
+
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	_, _ = conn.Write([]byte("ping"))
+	_ = conn.Close()
+
+	event := receiveEvent(t, events)
+	if event.FirstByteReceived || event.FirstByteMs != 0 {
+		t.Errorf("expected no first byte, got received=%v ms=%d", event.FirstByteReceived, event.FirstByteMs)
+	}
+}
+
+func TestReadyGateDefersAccept(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+
+	server, _ := newTestServer(t, cfg)
+	ready := make(chan struct{})
+	server.SetReadyGate(ready)
+
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// SOCKS5 greeting offering "no authentication".
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatalf("failed to send greeting: %v", err)
+	}
+
+	reply := make([]byte, 2)
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := io.ReadFull(conn, reply); err == nil {
+		t.Fatal("expected no handshake reply before the pipeline is ready")
+	}
+
+	close(ready)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("expected handshake reply after the pipeline is ready: %v", err)
+	}
+	if reply[0] != 0x05 || reply[1] != 0x00 {
+		t.Errorf("unexpected handshake reply %v", reply)
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Listeners = []string{"127.0.0.1:0", "127.0.0.1:0"}
+
+	server, _ := newTestServer(t, cfg)
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if len(server.listeners) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(server.listeners))
+	}
+
+	var addrs []string
+	for _, listener := range server.listeners {
+		addrs = append(addrs, listener.Addr().String())
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		reply := make([]byte, 2)
+		if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			t.Fatalf("failed to send greeting: %v", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+			t.Errorf("expected a handshake reply on %s, got %v: %v", listener.Addr(), reply, err)
+		}
+		_ = conn.Close()
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Fatalf("failed to stop: %v", err)
+	}
+	for _, addr := range addrs {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			t.Errorf("expected %s to be closed after Stop", addr)
+		}
+	}
+}
+
+func TestListenFailureClosesBoundListeners(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = taken.Close()
+	}()
+
+	cfg := &config.Config{}
+	cfg.Proxy.Listeners = []string{"127.0.0.1:0", taken.Addr().String()}
+
+	server, _ := newTestServer(t, cfg)
+	if err := server.Start(); err == nil {
+		_ = server.Stop()
+		t.Fatal("expected Start to fail on an address in use")
+	}
+	if len(server.listeners) != 0 {
+		t.Errorf("expected no listeners left open, got %d", len(server.listeners))
+	}
+}
+
+func TestMaxDialsPerDestination(t *testing.T) {
+	hold := func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	}
+	busy := startDestination(t, hold)
+	other := startDestination(t, hold)
+
+	cfg := &config.Config{}
+	cfg.Proxy.MaxDialsPerDestination = 2
+	server, _ := newTestServer(t, cfg)
+
+	dial := func(addr string) (net.Conn, error) {
+		return server.dialWithTracking(context.Background(), "tcp", addr)
+	}
+
+	var open []net.Conn
+	t.Cleanup(func() {
+		for _, conn := range open {
+			_ = conn.Close()
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(busy)
+		if err != nil {
+			t.Fatalf("dial %d failed: %v", i, err)
+		}
+		open = append(open, conn)
+	}
+
+	if _, err := dial(busy); !errors.Is(err, errDestinationBusy) {
+		t.Fatalf("expected third dial to be refused, got %v", err)
+	}
+
+	conn, err := dial(other)
+	if err != nil {
+		t.Fatalf("expected other destination to be unaffected: %v", err)
+	}
+	_ = conn.Close()
+
+	_ = open[0].Close()
+	_ = open[0].Close() // A second close must not free another slot.
+
+	conn, err = dial(busy)
+	if err != nil {
+		t.Fatalf("expected dial to succeed after a connection closed: %v", err)
+	}
+	open = append(open, conn)
+
+	if _, err := dial(busy); !errors.Is(err, errDestinationBusy) {
+		t.Fatalf("expected cap to still apply, got %v", err)
+	}
+}
+
+func TestRequestedDomainRecorded(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	_, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	server, events := newTestServer(t, &config.Config{})
+
+	tests := []struct {
+		name string
+		dest *socks5.AddrSpec
+		want string
+	}{
+		{"domain", &socks5.AddrSpec{FQDN: "localhost", IP: net.IPv4(127, 0, 0, 1), Port: port}, "localhost"},
+		{"raw ip", &socks5.AddrSpec{IP: net.IPv4(127, 0, 0, 1), Port: port}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := requestRewriter{}.Rewrite(context.Background(), &socks5.Request{DestAddr: tt.dest})
+
+			conn, err := server.dialWithTracking(ctx, "tcp", addr)
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			_ = conn.Close()
+
+			if event := receiveEvent(t, events); event.Domain != tt.want {
+				t.Errorf("expected domain %q, got %q", tt.want, event.Domain)
+			}
+		})
+	}
+}
+
+func TestFailedDialEmitsEvent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	cfg := &config.Config{}
+	cfg.Proxy.LogFailures = true
+	server, events := newTestServer(t, cfg)
+
+	ctx, _ := requestRewriter{}.Rewrite(context.Background(), &socks5.Request{
+		RemoteAddr:  &socks5.AddrSpec{IP: net.IPv4(10, 0, 0, 1), Port: 50000},
+		DestAddr:    &socks5.AddrSpec{FQDN: "localhost", IP: net.IPv4(127, 0, 0, 1)},
+		AuthContext: &socks5.AuthContext{Payload: map[string]string{"Username": "alice"}},
+	})
+	if _, err := server.dialWithTracking(ctx, "tcp", addr); err == nil {
+		t.Fatal("expected the dial to a closed port to fail")
+	}
+
+	event := receiveEvent(t, events)
+	if event.Status != models.StatusDialFailed || event.SourceIP != "10.0.0.1" || event.Username != "alice" ||
+		event.Domain != "localhost" || event.DestinationIP != "127.0.0.1" {
+		t.Errorf("unexpected failure event %+v", event)
+	}
+
+	cfg.Proxy.LogFailures = false
+	_, _ = server.dialWithTracking(ctx, "tcp", addr)
+	if len(events) != 0 {
+		t.Errorf("expected no event with log_failures off, got %+v", <-events)
+	}
+}
+
+func TestIPv6AddressesAreCanonical(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			_, _ = conn.Write([]byte("hello"))
+			_ = conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	server, events := newTestServer(t, &config.Config{})
+	ctx, _ := requestRewriter{}.Rewrite(context.Background(), &socks5.Request{
+		RemoteAddr: &socks5.AddrSpec{IP: net.ParseIP("2001:0db8:0:0::0001"), Port: 50000},
+		DestAddr:   &socks5.AddrSpec{IP: net.IPv6loopback, Port: port},
+	})
+
+	// go-socks5 dials the bracketed form; the uncompressed spelling must be
+	// recorded the same way.
+	conn, err := server.dialWithTracking(ctx, "tcp", "[0:0:0:0:0:0:0:1]:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	_, _ = io.ReadFull(conn, make([]byte, 5))
+	_ = conn.Close()
+
+	event := receiveEvent(t, events)
+	if event.SourceIP != "2001:db8::1" || event.DestinationIP != "::1" || event.Port != port {
+		t.Errorf("expected canonical IPv6 addresses, got source %q destination %q port %d",
+			event.SourceIP, event.DestinationIP, event.Port)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		host string
+		port int
+	}{
+		{"10.0.0.1:443", "10.0.0.1", 443},
+		{"[2001:db8::1]:443", "2001:db8::1", 443},
+		{"[2001:0DB8:0000::0001]:443", "2001:db8::1", 443},
+		{"[::ffff:10.0.0.1]:80", "10.0.0.1", 80},
+		{"[fe80::1%eth0]:22", "fe80::1", 22},
+		{"[2001:db8::1]", "2001:db8::1", 0},
+		{"2001:db8::1", "2001:db8::1", 0},
+		{"example.com:80", "example.com", 80},
+	}
+
+	for _, tt := range tests {
+		host, port := parseAddress(tt.addr)
+		if host != tt.host || port != tt.port {
+			t.Errorf("parseAddress(%q) = %q, %d; expected %q, %d", tt.addr, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestShutdownDrainsOpenConnections(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	server, events := newTestServer(t, &config.Config{})
+
+	finishing, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	stuck, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = finishing.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected shutdown to time out on the stuck connection, got %v", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("expected shutdown to wait for open connections, returned after %v", waited)
+	}
+
+	// Both connections emitted their events before Shutdown returned.
+	if len(events) != 2 {
+		t.Errorf("expected 2 traffic events, got %d", len(events))
+	}
+	if _, err := stuck.Write([]byte("x")); err == nil {
+		t.Error("expected the stuck connection to be closed at the deadline")
+	}
+
+	if _, err := server.dialWithTracking(context.Background(), "tcp", addr); !errors.Is(err, errShuttingDown) {
+		t.Errorf("expected dials after shutdown to be refused, got %v", err)
+	}
+}
+
+func TestShutdownWithoutConnections(t *testing.T) {
+	server, _ := newTestServer(t, &config.Config{})
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected an idle server to shut down cleanly, got %v", err)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.MaxConnections = 1
+
+	server, _ := newTestServer(t, cfg)
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"})
+	server.SetMetrics(&metrics.Metrics{RejectedConnections: rejected})
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	// handshake reports whether the proxy answers a SOCKS5 greeting on conn.
+	handshake := func(conn net.Conn) bool {
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			return false
+		}
+		reply := make([]byte, 2)
+		_, err := io.ReadFull(conn, reply)
+
+		return err == nil
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+
+		return conn
+	}
+
+	first := dial()
+	if !handshake(first) {
+		t.Fatal("expected the first connection to be served")
+	}
+	if handshake(dial()) {
+		t.Fatal("expected a connection over max_connections to be closed")
+	}
+	if got := testutil.ToFloat64(rejected); got != 1 {
+		t.Errorf("expected 1 rejected connection, got %v", got)
+	}
+
+	_ = first.Close()
+	deadline := time.Now().Add(time.Second)
+	for server.clients.GetActiveConnections() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !handshake(dial()) {
+		t.Error("expected a connection to be served once a slot was freed")
+	}
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("hello"))
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	m := &metrics.Metrics{
+		ActiveConnections: prometheus.NewGauge(prometheus.GaugeOpts{Name: "active"}),
+		TotalConnections:  prometheus.NewCounter(prometheus.CounterOpts{Name: "total"}),
+		ClosedConnections: prometheus.NewCounter(prometheus.CounterOpts{Name: "closed"}),
+		BytesIn:           prometheus.NewCounter(prometheus.CounterOpts{Name: "bytes_in"}),
+		BytesOut:          prometheus.NewCounter(prometheus.CounterOpts{Name: "bytes_out"}),
+		LatencyHistogram:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"}),
+	}
+	server, _ := newTestServer(t, &config.Config{})
+	server.SetMetrics(m)
+
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if got := testutil.ToFloat64(m.ActiveConnections); got != 1 {
+		t.Errorf("expected 1 active connection, got %v", got)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	_, _ = conn.Write([]byte("hi"))
+	_ = conn.Close()
+
+	want := map[string]struct {
+		collector prometheus.Collector
+		value     float64
+	}{
+		"active":    {m.ActiveConnections, 0},
+		"total":     {m.TotalConnections, 1},
+		"closed":    {m.ClosedConnections, 1},
+		"bytes in":  {m.BytesIn, 5},
+		"bytes out": {m.BytesOut, 2},
+	}
+	for name, w := range want {
+		if got := testutil.ToFloat64(w.collector); got != w.value {
+			t.Errorf("%s: expected %v, got %v", name, w.value, got)
+		}
+	}
+}
+
+func TestInterimEvents(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(conn, conn)
+	})
+
+	cfg := &config.Config{}
+	cfg.Proxy.InterimIntervalMs = 20
+	server, events := newTestServer(t, cfg)
+
+	dialed := time.Now()
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	echo := func(msg string) {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, len(msg))); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+	}
+
+	echo("hello")
+	interim := receiveEvent(t, events)
+	if !interim.Interim || interim.BytesIn != 5 || interim.BytesOut != 5 {
+		t.Errorf("expected an interim event for the first 5 bytes each way, got %+v", interim)
+	}
+	// Events are stamped when emitted, at least one interval after the dial,
+	// so their bytes fall in the period they were transferred in.
+	if interim.Timestamp.Sub(dialed) < 20*time.Millisecond {
+		t.Errorf("expected the interim event stamped on emission, got %v after the dial",
+			interim.Timestamp.Sub(dialed))
+	}
+
+	echo("hi")
+	_ = conn.Close()
+
+	// Idle ticks emit nothing, so the next event carries only the new bytes.
+	for {
+		event := receiveEvent(t, events)
+		if event.Interim {
+			if event.BytesIn != 2 {
+				t.Errorf("expected a delta of 2 bytes, got %+v", event)
+			}
+
+			continue
+		}
+		if event.BytesIn+interim.BytesIn > 7 || event.BytesOut+interim.BytesOut > 7 {
+			t.Errorf("expected the final event to carry only the remaining delta, got %+v", event)
+		}
+		if !event.Timestamp.After(interim.Timestamp) {
+			t.Errorf("expected the final event stamped after the interim one, got %v and %v",
+				event.Timestamp, interim.Timestamp)
+		}
+		if opened := event.Timestamp.Add(-time.Duration(event.DurationMs) * time.Millisecond); opened.Before(
+			dialed.Add(-time.Millisecond)) || opened.After(interim.Timestamp) {
+			t.Errorf("expected timestamp - duration to be when the connection opened, got %v (dialed %v)", opened, dialed)
+		}
+
+		break
+	}
+}
+
+func TestIdleTimeoutClosesQuietConnections(t *testing.T) {
+	const idle = 100 * time.Millisecond
+
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(conn, conn)
+	})
+
+	m := &metrics.Metrics{
+		ActiveConnections: prometheus.NewGauge(prometheus.GaugeOpts{Name: "active"}),
+		TotalConnections:  prometheus.NewCounter(prometheus.CounterOpts{Name: "total"}),
+		ClosedConnections: prometheus.NewCounter(prometheus.CounterOpts{Name: "closed"}),
+		IdleTimeouts:      prometheus.NewCounter(prometheus.CounterOpts{Name: "idle_timeouts"}),
+		BytesIn:           prometheus.NewCounter(prometheus.CounterOpts{Name: "bytes_in"}),
+		BytesOut:          prometheus.NewCounter(prometheus.CounterOpts{Name: "bytes_out"}),
+		LatencyHistogram:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"}),
+	}
+	cfg := &config.Config{}
+	cfg.Proxy.IdleTimeoutMs = int(idle.Milliseconds())
+	server, events := newTestServer(t, cfg)
+	server.SetMetrics(m)
+
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	start := time.Now()
+
+	// Activity keeps pushing the deadline forward past the first timeout.
+	for range 3 {
+		time.Sleep(idle / 2)
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	// The relay from the destination, as go-socks5 runs it, fails once the
+	// connection goes quiet and the proxy then closes it.
+	relayed, err := conn.(io.WriterTo).WriteTo(io.Discard)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the relay to hit the idle deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*idle/2+idle {
+		t.Errorf("expected activity to extend the deadline, relay ended after %v", elapsed)
+	}
+	_ = conn.Close()
+
+	event := receiveEvent(t, events)
+	if event.BytesOut != 12 || event.BytesIn != relayed {
+		t.Errorf("expected the reaped connection's traffic event, got %+v", event)
+	}
+	if got := testutil.ToFloat64(m.IdleTimeouts); got != 1 {
+		t.Errorf("expected 1 idle timeout, got %v", got)
+	}
+}
+
+func TestTrackedConnConcurrentReadWriteClose(t *testing.T) {
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(conn, conn)
+	})
+
+	server, events := newTestServer(t, &config.Config{})
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	// Relay in both directions, as go-socks5 does, and close mid-stream.
+	var read, written int64
+	done := make(chan struct{}, 2)
+	go func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 512)
+		for {
+			n, err := conn.Read(buf)
+			read += int64(n)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 512)
+		for {
+			n, err := conn.Write(buf)
+			written += int64(n)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+	<-done
+	<-done
+
+	event := receiveEvent(t, events)
+	if event.BytesIn != read || event.BytesOut != written {
+		t.Errorf("expected the event to count %d bytes in and %d out, got %d and %d",
+			read, written, event.BytesIn, event.BytesOut)
+	}
+}
+
+func TestWarnOpenProxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      bool
+		whitelist []string
+		addrs     []string
+		warnings  int
+	}{
+		{name: "all interfaces", addrs: []string{"0.0.0.0:1080", "[::]:1080", ":1080"}, warnings: 3},
+		{name: "loopback", addrs: []string{"127.0.0.1:1080"}},
+		{name: "auth", auth: true, addrs: []string{"0.0.0.0:1080"}},
+		{name: "whitelist", whitelist: []string{"10.0.0.0/8"}, addrs: []string{"0.0.0.0:1080"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Proxy.Auth.Enabled = tt.auth
+			cfg.Proxy.IPWhitelist = tt.whitelist
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			log := zap.New(core)
+			server := NewServer(cfg, log, pipeline.NewCollector(make(chan pipeline.RawTrafficEvent, 1), log))
+			server.warnOpenProxy(tt.addrs)
+
+			if got := logs.FilterMessageSnippet("anyone who can reach it").Len(); got != tt.warnings {
+				t.Errorf("expected %d warnings, got %d", tt.warnings, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TLS record framing of a ClientHello.
+const (
+	tlsRecordHeaderBytes = 5
+	tlsRecordHandshake   = 0x16
+	// maxSNIBytes bounds how much of a client stream is buffered looking for
+	// the ClientHello: one TLS record of the maximum size and its header.
+	maxSNIBytes = tlsRecordHeaderBytes + 16384
+)
+
+// errSNIFound stops the handshake once the ClientHello has been read.
+var errSNIFound = errors.New("client hello read")
+
+// sniSniffer recovers the SNI hostname from the first TLS record a client
+// sends, for connections made by IP. It only copies the bytes passing through
+// to the destination, so it never holds up the stream, whether or not the
+// client speaks TLS, and gives up after the first record.
+type sniSniffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+}
+
+// observe feeds the next bytes the client sent and returns the SNI hostname
+// once the first record is complete and carries one.
+func (s *sniSniffer) observe(p []byte) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done || len(p) == 0 {
+		return "", false
+	}
+	s.buf = append(s.buf, p[:min(len(p), maxSNIBytes-len(s.buf))]...)
+	if s.buf[0] != tlsRecordHandshake {
+		s.finish()
+
+		return "", false
+	}
+	if len(s.buf) < tlsRecordHeaderBytes {
+		return "", false
+	}
+
+	recordBytes := tlsRecordHeaderBytes + (int(s.buf[3])<<8 | int(s.buf[4]))
+	if recordBytes > maxSNIBytes {
+		s.finish()
+
+		return "", false
+	}
+	if len(s.buf) < recordBytes {
+		return "", false
+	}
+
+	name := serverName(s.buf[:recordBytes])
+	s.finish()
+
+	return name, name != ""
+}
+
+// sniff feeds p, about to be written to the destination, to the SNI sniffer.
+func (tc *trackedConn) sniff(p []byte) {
+	if tc.sni == nil {
+		return
+	}
+	if name, ok := tc.sni.observe(p); ok {
+		tc.sniDomain.Store(&name)
+	}
+}
+
+func (s *sniSniffer) finish() {
+	s.done = true
+	s.buf = nil
+}
+
+// serverName parses record as a ClientHello with crypto/tls and returns its
+// SNI hostname, or "" if it is not a ClientHello or has none.
+func serverName(record []byte) string {
+	var name string
+	conn := tls.Server(&recordConn{r: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+
+			return nil, errSNIFound
+		},
+		MinVersion: tls.VersionTLS12,
+	})
+	_ = conn.Handshake()
+
+	return name
+}
+
+// recordConn is a net.Conn reading a captured record and discarding writes,
+// such as the alert sent when the handshake is abandoned.
+type recordConn struct {
+	r io.Reader
+}
+
+func (c *recordConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *recordConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *recordConn) Close() error                       { return nil }
+func (c *recordConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *recordConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *recordConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *recordConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *recordConn) SetWriteDeadline(_ time.Time) error { return nil }
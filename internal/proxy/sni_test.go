@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+)
+
+// clientHello returns the first TLS record a crypto/tls client sends to
+// serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer func() {
+		_ = server.Close()
+	}()
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}).Handshake()
+		_ = client.Close()
+	}()
+
+	header := make([]byte, tlsRecordHeaderBytes)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("failed to read record header: %v", err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatalf("failed to read record: %v", err)
+	}
+
+	return append(header, body...)
+}
+
+func TestSNISniffer(t *testing.T) {
+	hello := clientHello(t, "example.com")
+
+	tests := []struct {
+		name   string
+		writes [][]byte
+		want   string
+	}{
+		{name: "single write", writes: [][]byte{hello}, want: "example.com"},
+		{name: "split writes", writes: [][]byte{hello[:3], hello[3:40], hello[40:]}, want: "example.com"},
+		{name: "trailing data", writes: [][]byte{append(append([]byte{}, hello...), "data"...)}, want: "example.com"},
+		// crypto/tls sends no SNI for IP addresses.
+		{name: "no sni", writes: [][]byte{clientHello(t, "127.0.0.1")}},
+		{name: "not tls", writes: [][]byte{[]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")}},
+		{name: "truncated", writes: [][]byte{hello[:len(hello)-1]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sniffer sniSniffer
+			var got string
+			for _, p := range tt.writes {
+				if name, ok := sniffer.observe(p); ok {
+					got = name
+				}
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// Once the first record has been seen, later bytes are ignored.
+	var sniffer sniSniffer
+	sniffer.observe([]byte("plain"))
+	if _, ok := sniffer.observe(hello); ok || sniffer.buf != nil {
+		t.Error("expected the sniffer to stop after the first record")
+	}
+}
+
+func TestSNIRecordedForIPConnections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.SNI.Enabled = true
+	server, events := newTestServer(t, cfg)
+
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	if _, err := conn.Write(clientHello(t, "example.com")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	_ = conn.Close()
+
+	if event := receiveEvent(t, events); event.Domain != "example.com" {
+		t.Errorf("expected domain %q, got %q", "example.com", event.Domain)
+	}
+}
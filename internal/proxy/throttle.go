@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+)
+
+// throttleRate returns the bytes per second a connection of username may
+// transfer in each direction, 0 for no limit. Per-user limits in
+// proxy.throttle.users take precedence over proxy.throttle.bytes_per_second.
+func (s *Server) throttleRate(username string) int64 {
+	if rate, ok := s.userThrottles[username]; ok && username != "" {
+		return rate
+	}
+
+	return s.cfg.Proxy.Throttle.BytesPerSecond
+}
+
+// throttle paces tc to bytesPerSecond in each direction.
+func (tc *trackedConn) throttle(bytesPerSecond int64) {
+	tc.readThrottle = security.NewThrottle(bytesPerSecond)
+	tc.writeThrottle = security.NewThrottle(bytesPerSecond)
+	tc.done = make(chan struct{})
+}
+
+// chunk returns the prefix of p a single transfer paced by throttle may
+// take. A nil throttle takes all of p.
+func chunk(throttle *security.Throttle, p []byte) []byte {
+	if throttle == nil {
+		return p
+	}
+
+	return p[:min(len(p), throttle.Burst())]
+}
+
+// pace waits until throttle allows n more bytes. The wait ends early with
+// net.ErrClosed when the connection is closed, so a paced transfer never
+// holds up Close.
+func (tc *trackedConn) pace(throttle *security.Throttle, n int) error {
+	if throttle == nil {
+		return nil
+	}
+	wait := throttle.Reserve(n)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-tc.done:
+		return net.ErrClosed
+	}
+}
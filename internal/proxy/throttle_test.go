@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+)
+
+func TestThrottlePacesConnections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Throttle.BytesPerSecond = 10000
+	cfg.Proxy.Throttle.Users = []config.UserThrottle{{Username: "vip", BytesPerSecond: 0}}
+	server, _ := newTestServer(t, cfg)
+
+	if server.throttleRate("alice") != 10000 || server.throttleRate("vip") != 0 {
+		t.Fatal("expected per-user limits to override the global one")
+	}
+
+	addr := startDestination(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	conn, err := server.dialWithTracking(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	// The first second's worth of bytes goes out at once, the rest is paced.
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 11500)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected the write to take about 150ms, took %v", elapsed)
+	}
+
+	// Closing ends a paced write promptly instead of waiting out the throttle.
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 50000))
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	_ = conn.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected the write to fail with net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("paced write not interrupted by close")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected close to return promptly, took %v", elapsed)
+	}
+}
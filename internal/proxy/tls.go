@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// tlsVersions maps proxy.tls.min_version values to crypto/tls versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the server TLS config for SOCKS-over-TLS. Handshakes
+// from clients that support nothing at or above minVersion are refused and
+// logged with the highest version the client offered. cipherSuites names the
+// allowed TLS 1.2 suites (TLS 1.3 suites are not configurable); empty keeps
+// the Go defaults. Suites Go considers insecure are rejected.
+func newTLSConfig(certFile, keyFile, minVersion string, cipherSuites []string, log *zap.Logger) (*tls.Config, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS min_version %q, expected one of 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+
+	suites, err := parseCipherSuites(cipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		CipherSuites: suites,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if offered := slices.Max(append(hello.SupportedVersions, 0)); offered < version {
+				log.Warn("refusing TLS handshake below minimum version",
+					zap.String("client", hello.Conn.RemoteAddr().String()),
+					zap.String("offered", tls.VersionName(offered)),
+					zap.String("minimum", tls.VersionName(version)))
+			}
+
+			// Keep the base config; the handshake itself enforces MinVersion.
+			return nil, nil
+		},
+	}, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key to dir,
+// returning their paths.
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "proxy test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.TLS.Enabled = true
+	cfg.Proxy.TLS.CertFile, cfg.Proxy.TLS.KeyFile = writeTestCertificate(t, t.TempDir())
+	cfg.Proxy.TLS.MinVersion = "1.2"
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	log := zap.New(core)
+	server := NewServer(cfg, log, pipeline.NewCollector(make(chan pipeline.RawTrafficEvent, 10), log))
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	addr := server.listeners[0].Addr().String()
+
+	dial := func(version uint16) (*tls.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         version,
+			MaxVersion:         version,
+		})
+	}
+
+	if conn, err := dial(tls.VersionTLS10); err == nil {
+		_ = conn.Close()
+		t.Fatal("expected TLS 1.0 handshake to be refused")
+	}
+
+	refused := logs.FilterMessage("refusing TLS handshake below minimum version").All()
+	if len(refused) != 1 || refused[0].ContextMap()["offered"] != "TLS 1.0" {
+		t.Errorf("expected the refused TLS 1.0 handshake to be logged, got %v", refused)
+	}
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		conn, err := dial(version)
+		if err != nil {
+			t.Fatalf("%s handshake failed: %v", tls.VersionName(version), err)
+		}
+
+		// SOCKS5 greeting offering "no authentication".
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+			t.Fatalf("failed to send greeting: %v", err)
+		}
+		reply := make([]byte, 2)
+		if _, err := io.ReadFull(conn, reply); err != nil || reply[0] != 0x05 || reply[1] != 0x00 {
+			t.Errorf("%s: unexpected SOCKS reply %v, %v", tls.VersionName(version), reply, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestNewTLSConfigRejectsInvalidSettings(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	if _, err := newTLSConfig(certFile, keyFile, "1.4", nil, zap.NewNop()); err == nil {
+		t.Error("expected an unknown min_version to be rejected")
+	}
+	if _, err := newTLSConfig(certFile, keyFile, "1.2", []string{"TLS_RSA_WITH_RC4_128_SHA"}, zap.NewNop()); err == nil {
+		t.Error("expected an insecure cipher suite to be rejected")
+	}
+
+	suites := []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	tlsConfig, err := newTLSConfig(certFile, keyFile, "1.2", suites, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected valid settings to be accepted: %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 || len(tlsConfig.CipherSuites) != 1 {
+		t.Errorf("unexpected config: min %x, suites %v", tlsConfig.MinVersion, tlsConfig.CipherSuites)
+	}
+}
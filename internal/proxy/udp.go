@@ -0,0 +1,599 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/pipeline"
+	socks5 "github.com/armon/go-socks5"
+	"go.uber.org/zap"
+)
+
+// SOCKS5 reply codes and address types used by UDP ASSOCIATE (RFC 1928).
+const (
+	replySucceeded      = 0x00
+	replyGeneralFailure = 0x01
+	replyNotAllowed     = 0x02
+	addrTypeIPv4        = 0x01
+	addrTypeFQDN        = 0x03
+	addrTypeIPv6        = 0x04
+)
+
+// maxDatagram is the largest UDP payload relayed in either direction.
+const maxDatagram = 65535
+
+var errMalformedDatagram = errors.New("malformed SOCKS5 UDP datagram")
+
+// resolvedTTL is how long an association reuses a resolved destination name
+// before looking it up again, so DNS changes are picked up.
+const resolvedTTL = time.Minute
+
+// maxResolved caps the destination names an association keeps resolved, so a
+// client sending to ever new names can't grow it without bound.
+const maxResolved = 1024
+
+// hijackRegistry indexes the client connections being served by go-socks5 by
+// remote address, so a UDP ASSOCIATE request can take over its connection:
+// go-socks5 answers every ASSOCIATE with "command not supported" and hands
+// the rules only the request.
+type hijackRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*hijackableConn
+}
+
+func newHijackRegistry() *hijackRegistry {
+	return &hijackRegistry{conns: make(map[string]*hijackableConn)}
+}
+
+// take hijacks the connection from remoteAddr, or returns nil if there is none.
+func (r *hijackRegistry) take(remoteAddr string) net.Conn {
+	r.mu.Lock()
+	conn := r.conns[remoteAddr]
+	delete(r.conns, remoteAddr)
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	conn.hijacked.Store(true)
+
+	return conn.Conn
+}
+
+func (r *hijackRegistry) remove(conn *hijackableConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns[conn.key] == conn {
+		delete(r.conns, conn.key)
+	}
+}
+
+// hijackListener registers every accepted connection with its registry. It
+// must be the outermost listener so hijacked writes bypass go-socks5 but
+// still go through TLS and compression.
+type hijackListener struct {
+	net.Listener
+	registry *hijackRegistry
+}
+
+func (l *hijackListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	hc := &hijackableConn{Conn: conn, registry: l.registry, key: conn.RemoteAddr().String()}
+	l.registry.mu.Lock()
+	l.registry.conns[hc.key] = hc
+	l.registry.mu.Unlock()
+
+	return hc, nil
+}
+
+// hijackableConn discards go-socks5's writes and close once hijacked; the
+// UDP association then owns the underlying connection.
+type hijackableConn struct {
+	net.Conn
+	registry *hijackRegistry
+	key      string
+	hijacked atomic.Bool
+}
+
+func (c *hijackableConn) Write(p []byte) (int, error) {
+	if c.hijacked.Load() {
+		return len(p), nil
+	}
+
+	return c.Conn.Write(p)
+}
+
+func (c *hijackableConn) Close() error {
+	if c.hijacked.Load() {
+		return nil
+	}
+	c.registry.remove(c)
+
+	return c.Conn.Close()
+}
+
+// associateRules permits every request and serves UDP ASSOCIATE itself.
+type associateRules struct {
+	server *Server
+}
+
+func (r associateRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.Command == socks5.AssociateCommand && req.RemoteAddr != nil {
+		r.server.associate(ctx, req.RemoteAddr.Address())
+	}
+
+	return ctx, true
+}
+
+// associate takes over the control connection from remoteAddr and relays
+// UDP for it until that connection closes.
+func (s *Server) associate(ctx context.Context, remoteAddr string) {
+	control := s.hijacks.take(remoteAddr)
+	if control == nil {
+		s.log.Warn("UDP associate from unknown connection", zap.String("source", remoteAddr))
+
+		return
+	}
+
+	info := connInfoFrom(ctx)
+	info.Source = control.RemoteAddr().String()
+	if s.quotaExceeded(info, "", "udp") {
+		_ = writeReply(control, replyNotAllowed, nil)
+		_ = control.Close()
+
+		return
+	}
+
+	assoc, err := s.newUDPAssociation(control, info.Username)
+	if err != nil {
+		s.log.Warn("UDP associate failed", zap.String("source", remoteAddr), zap.Error(err))
+		_ = writeReply(control, replyGeneralFailure, nil)
+		_ = control.Close()
+
+		return
+	}
+
+	if err := writeReply(control, replySucceeded, assoc.client.LocalAddr().(*net.UDPAddr)); err != nil {
+		_ = assoc.Close()
+
+		return
+	}
+	assoc.start()
+}
+
+// writeReply sends a SOCKS5 reply carrying bind, or an empty IPv4 address.
+func writeReply(w io.Writer, code byte, bind *net.UDPAddr) error {
+	reply := []byte{0x05, code, 0x00}
+	if bind == nil {
+		reply = append(reply, addrTypeIPv4, 0, 0, 0, 0, 0, 0)
+	} else {
+		reply = appendAddr(reply, bind.AddrPort())
+	}
+	_, err := w.Write(reply)
+
+	return err
+}
+
+func appendAddr(b []byte, addr netip.AddrPort) []byte {
+	ip := addr.Addr().Unmap()
+	if ip.Is4() {
+		b = append(b, addrTypeIPv4)
+	} else {
+		b = append(b, addrTypeIPv6)
+	}
+	b = append(b, ip.AsSlice()...)
+
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+// udpFlow accumulates the traffic between an association and one destination.
+type udpFlow struct {
+	destAddr      string
+	domain        string
+	started       time.Time
+	lastSeen      time.Time
+	firstByteMs   int64
+	firstByteSeen bool
+	bytesIn       int64
+	bytesOut      int64
+}
+
+// udpResolution is a destination name resolved for an association.
+type udpResolution struct {
+	dest    netip.AddrPort
+	expires time.Time
+}
+
+// udpAssociation relays datagrams between a SOCKS client and destinations.
+// Traffic is aggregated per destination flow, and each flow becomes one
+// traffic event when it has been idle for proxy.udp.idle_timeout_ms or when
+// the association ends with its control connection.
+type udpAssociation struct {
+	server   *Server
+	control  net.Conn
+	client   *net.UDPConn // Faces the SOCKS client.
+	remote   *net.UDPConn // Faces destinations.
+	clientIP netip.Addr
+	username string
+	idle     time.Duration
+
+	mu         sync.Mutex
+	clientAddr netip.AddrPort
+	flows      map[netip.AddrPort]*udpFlow
+	resolved   map[string]udpResolution
+	closed     bool
+	done       chan struct{}
+}
+
+func (s *Server) newUDPAssociation(control net.Conn, username string) (*udpAssociation, error) {
+	local, _ := control.LocalAddr().(*net.TCPAddr)
+	remote, _ := control.RemoteAddr().(*net.TCPAddr)
+	if local == nil || remote == nil {
+		return nil, errors.New("control connection is not TCP")
+	}
+	clientIP, _ := netip.AddrFromSlice(remote.IP)
+
+	// Listen on the address the client reached us on, so it can be sent
+	// back as the relay address.
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		return nil, err
+	}
+	out, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		_ = client.Close()
+
+		return nil, err
+	}
+
+	idle := time.Duration(s.cfg.Proxy.UDP.IdleTimeoutMs) * time.Millisecond
+	if idle <= 0 {
+		idle = time.Minute
+	}
+
+	assoc := &udpAssociation{
+		server:   s,
+		control:  control,
+		client:   client,
+		remote:   out,
+		clientIP: clientIP.Unmap(),
+		username: username,
+		idle:     idle,
+		flows:    make(map[netip.AddrPort]*udpFlow),
+		resolved: make(map[string]udpResolution),
+		done:     make(chan struct{}),
+	}
+	if !s.conns.add(assoc) {
+		_ = client.Close()
+		_ = out.Close()
+
+		return nil, errShuttingDown
+	}
+
+	return assoc, nil
+}
+
+func (a *udpAssociation) start() {
+	go func() {
+		// The association lasts as long as its control connection.
+		_, _ = io.Copy(io.Discard, a.control)
+		_ = a.Close()
+	}()
+	go a.relayFromClient()
+	go a.relayFromDestinations()
+	go a.expireIdleFlows()
+}
+
+func (a *udpAssociation) relayFromClient() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := a.client.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			_ = a.Close()
+
+			return
+		}
+		// Only the client holding the control connection may use the relay.
+		if from.Addr().Unmap() != a.clientIP {
+			continue
+		}
+
+		host, port, payload, err := parseDatagram(buf[:n])
+		if err != nil {
+			a.server.log.Debug("dropping UDP datagram", zap.Error(err))
+
+			continue
+		}
+		dest, domain, ok := a.destination(host, port)
+		if !ok {
+			continue
+		}
+
+		if _, err := a.remote.WriteToUDPAddrPort(payload, dest); err != nil {
+			a.server.log.Debug("UDP send failed", zap.Stringer("addr", dest), zap.Error(err))
+
+			continue
+		}
+		a.sent(from, dest, domain, len(payload))
+	}
+}
+
+// destination resolves host and applies the byte quotas and the destination
+// policy, returning the address to send to and the requested domain, if any.
+func (a *udpAssociation) destination(host string, port uint16) (netip.AddrPort, string, bool) {
+	requested := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	var domain string
+	if _, err := netip.ParseAddr(host); err != nil {
+		domain = host
+	}
+
+	a.mu.Lock()
+	dest, ok := a.cachedDestination(requested, time.Now())
+	a.mu.Unlock()
+	if !ok {
+		addr, err := net.ResolveUDPAddr("udp", requested)
+		if err != nil {
+			a.server.log.Debug("UDP destination lookup failed", zap.String("addr", requested), zap.Error(err))
+
+			return netip.AddrPort{}, "", false
+		}
+		dest = netip.AddrPortFrom(addr.AddrPort().Addr().Unmap(), port)
+
+		a.mu.Lock()
+		a.cacheDestination(requested, dest, time.Now())
+		a.mu.Unlock()
+	}
+
+	info := connInfo{Source: a.control.RemoteAddr().String(), Username: a.username, Domain: domain}
+	if a.server.quotaExceeded(info, dest.String(), "udp") {
+		return netip.AddrPort{}, "", false
+	}
+	if !a.server.private.allowed(dest.String()) {
+		a.server.log.Warn("UDP datagram to private destination blocked", zap.Stringer("addr", dest))
+		if m := a.server.metrics; m != nil {
+			m.BlockedDestinations.Inc()
+		}
+		a.server.accepts.refused(a.control.RemoteAddr().String(), dest.String(), errPrivateDestination)
+		a.server.attemptFailed(pipeline.RawTrafficEvent{
+			Status:   models.StatusBlocked,
+			Username: a.username,
+			Domain:   domain,
+			Protocol: "udp",
+		}, a.control.RemoteAddr().String(), dest.String())
+
+		return netip.AddrPort{}, "", false
+	}
+	if !a.server.egress.allowed(domain, dest.Addr().AsSlice()) {
+		a.server.egressDenied(a.control.RemoteAddr().String(), a.username, domain, dest.String(), "udp")
+
+		return netip.AddrPort{}, "", false
+	}
+
+	return dest, domain, true
+}
+
+// cachedDestination returns the unexpired resolution of requested. a.mu must
+// be held.
+func (a *udpAssociation) cachedDestination(requested string, now time.Time) (netip.AddrPort, bool) {
+	r, ok := a.resolved[requested]
+	if !ok || now.After(r.expires) {
+		return netip.AddrPort{}, false
+	}
+
+	return r.dest, true
+}
+
+// cacheDestination stores the resolution of requested for resolvedTTL. When
+// maxResolved names are cached the expired ones are dropped first, then an
+// arbitrary one. a.mu must be held.
+func (a *udpAssociation) cacheDestination(requested string, dest netip.AddrPort, now time.Time) {
+	if _, ok := a.resolved[requested]; !ok && len(a.resolved) >= maxResolved {
+		a.pruneResolved(now)
+		for name := range a.resolved {
+			if len(a.resolved) < maxResolved {
+				break
+			}
+			delete(a.resolved, name)
+		}
+	}
+	a.resolved[requested] = udpResolution{dest: dest, expires: now.Add(resolvedTTL)}
+}
+
+// pruneResolved drops expired resolutions. a.mu must be held.
+func (a *udpAssociation) pruneResolved(now time.Time) {
+	for name, r := range a.resolved {
+		if now.After(r.expires) {
+			delete(a.resolved, name)
+		}
+	}
+}
+
+// sent records a datagram from the client to dest, starting a flow if needed.
+func (a *udpAssociation) sent(from, dest netip.AddrPort, domain string, size int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return
+	}
+	a.clientAddr = from
+
+	now := time.Now()
+	flow, ok := a.flows[dest]
+	if !ok {
+		flow = &udpFlow{destAddr: dest.String(), domain: domain, started: now}
+		a.flows[dest] = flow
+
+		a.server.accepts.accepted(a.control.RemoteAddr().String(), flow.destAddr)
+		if m := a.server.metrics; m != nil {
+			m.TotalConnections.Inc()
+			m.ActiveConnections.Inc()
+		}
+	}
+	flow.lastSeen = now
+	flow.bytesOut += int64(size)
+}
+
+func (a *udpAssociation) relayFromDestinations() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := a.remote.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			_ = a.Close()
+
+			return
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		// Drop datagrams from destinations the client never sent to.
+		a.mu.Lock()
+		flow := a.flows[from]
+		client := a.clientAddr
+		if flow != nil {
+			now := time.Now()
+			if !flow.firstByteSeen {
+				flow.firstByteMs = now.Sub(flow.started).Milliseconds()
+				flow.firstByteSeen = true
+			}
+			flow.lastSeen = now
+			flow.bytesIn += int64(n)
+		}
+		a.mu.Unlock()
+		if flow == nil {
+			continue
+		}
+
+		datagram := appendAddr([]byte{0, 0, 0}, from)
+		datagram = append(datagram, buf[:n]...)
+		_, _ = a.client.WriteToUDPAddrPort(datagram, client)
+	}
+}
+
+// expireIdleFlows emits the events of flows idle for longer than a.idle.
+func (a *udpAssociation) expireIdleFlows() {
+	ticker := time.NewTicker(a.idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case now := <-ticker.C:
+			a.mu.Lock()
+			var expired []*udpFlow
+			for dest, flow := range a.flows {
+				if now.Sub(flow.lastSeen) >= a.idle {
+					delete(a.flows, dest)
+					expired = append(expired, flow)
+				}
+			}
+			a.pruneResolved(now)
+			a.mu.Unlock()
+
+			for _, flow := range expired {
+				a.emit(flow)
+			}
+		}
+	}
+}
+
+// emit sends the traffic event of a finished flow, stamped with its last
+// packet like the final event of a TCP connection.
+func (a *udpAssociation) emit(flow *udpFlow) {
+	destIP, destPort := parseAddress(flow.destAddr)
+	event := pipeline.RawTrafficEvent{
+		SourceIP:          a.clientIP.String(),
+		Username:          a.username,
+		DestinationIP:     destIP,
+		Domain:            flow.domain,
+		Port:              destPort,
+		Timestamp:         flow.lastSeen,
+		FirstByteReceived: flow.firstByteSeen,
+		FirstByteMs:       flow.firstByteMs,
+		DurationMs:        flow.lastSeen.Sub(flow.started).Milliseconds(),
+		BytesIn:           flow.bytesIn,
+		BytesOut:          flow.bytesOut,
+		Protocol:          "udp",
+	}
+
+	if m := a.server.metrics; m != nil {
+		m.ActiveConnections.Dec()
+		m.ClosedConnections.Inc()
+		m.BytesIn.Add(float64(flow.bytesIn))
+		m.BytesOut.Add(float64(flow.bytesOut))
+	}
+
+	_ = a.server.collector.Collect(event)
+}
+
+// Close ends the association and emits the events of its open flows.
+func (a *udpAssociation) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+
+		return nil
+	}
+	a.closed = true
+	close(a.done)
+	flows := a.flows
+	a.flows = nil
+	a.mu.Unlock()
+
+	for _, flow := range flows {
+		a.emit(flow)
+	}
+
+	_ = a.client.Close()
+	_ = a.remote.Close()
+	err := a.control.Close()
+	a.server.conns.remove(a)
+
+	return err
+}
+
+// parseDatagram splits a SOCKS5 UDP request into its destination and payload.
+// Fragmented datagrams are not supported and rejected.
+func parseDatagram(b []byte) (host string, port uint16, payload []byte, err error) {
+	if len(b) < 4 || b[2] != 0 {
+		return "", 0, nil, errMalformedDatagram
+	}
+
+	rest := b[4:]
+	switch b[3] {
+	case addrTypeIPv4, addrTypeIPv6:
+		size := net.IPv4len
+		if b[3] == addrTypeIPv6 {
+			size = net.IPv6len
+		}
+		if len(rest) < size+2 {
+			return "", 0, nil, errMalformedDatagram
+		}
+		host = net.IP(rest[:size]).String()
+		rest = rest[size:]
+	case addrTypeFQDN:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+			return "", 0, nil, errMalformedDatagram
+		}
+		host = string(rest[1 : 1+rest[0]])
+		rest = rest[1+rest[0]:]
+	default:
+		return "", 0, nil, errMalformedDatagram
+	}
+
+	return host, binary.BigEndian.Uint16(rest), rest[2:], nil
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+)
+
+// startUDPEcho starts a UDP server that echoes every datagram back.
+func startUDPEcho(t *testing.T) netip.AddrPort {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDPAddrPort(buf[:n], from)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestUDPAssociate(t *testing.T) {
+	echo := startUDPEcho(t)
+
+	cfg := &config.Config{}
+	cfg.Proxy.Address = "127.0.0.1"
+	cfg.Proxy.UDP.Enabled = true
+	cfg.Proxy.UDP.IdleTimeoutMs = 60000
+
+	server, events := newTestServer(t, cfg)
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+
+	control, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = control.Close()
+	}()
+	_ = control.SetDeadline(time.Now().Add(time.Second))
+
+	// No authentication, then UDP ASSOCIATE with an unspecified client address.
+	if _, err := control.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatalf("failed to send greeting: %v", err)
+	}
+	if _, err := io.ReadFull(control, make([]byte, 2)); err != nil {
+		t.Fatalf("failed to read method: %v", err)
+	}
+	if _, err := control.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatalf("failed to send associate: %v", err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(control, reply); err != nil || reply[1] != replySucceeded || reply[3] != addrTypeIPv4 {
+		t.Fatalf("expected associate to succeed with an IPv4 relay, got %v, %v", reply, err)
+	}
+	relay := netip.AddrPortFrom(netip.AddrFrom4([4]byte(reply[4:8])), uint16(reply[8])<<8|uint16(reply[9]))
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	datagram := appendAddr([]byte{0, 0, 0}, echo)
+	datagram = append(datagram, "ping"...)
+	if _, err := client.WriteToUDPAddrPort(datagram, relay); err != nil {
+		t.Fatalf("failed to send datagram: %v", err)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxDatagram)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read echoed datagram: %v", err)
+	}
+	if !bytes.Equal(buf[:n], datagram) {
+		t.Errorf("expected the echo wrapped in the destination's header, got %v", buf[:n])
+	}
+
+	// Closing the control connection ends the association and logs the flow.
+	_ = control.Close()
+	event := receiveEvent(t, events)
+	if event.Protocol != "udp" || event.DestinationIP != "127.0.0.1" || event.Port != int(echo.Port()) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.BytesOut != 4 || event.BytesIn != 4 || !event.FirstByteReceived {
+		t.Errorf("expected 4 bytes each way and a first byte, got %+v", event)
+	}
+}
+
+func TestParseDatagram(t *testing.T) {
+	host, port, payload, err := parseDatagram([]byte{0, 0, 0, addrTypeFQDN, 3, 'a', '.', 'b', 0, 53, 'x'})
+	if err != nil || host != "a.b" || port != 53 || string(payload) != "x" {
+		t.Errorf("unexpected parse result %q %d %q %v", host, port, payload, err)
+	}
+
+	// Fragments are not supported.
+	if _, _, _, err := parseDatagram([]byte{0, 0, 1, addrTypeIPv4, 1, 2, 3, 4, 0, 53}); err == nil {
+		t.Error("expected a fragmented datagram to be rejected")
+	}
+	// Truncated domain.
+	if _, _, _, err := parseDatagram([]byte{0, 0, 0, addrTypeFQDN, 10, 'a'}); err == nil {
+		t.Error("expected a truncated datagram to be rejected")
+	}
+}
+
+func TestUDPResolvedCacheIsBounded(t *testing.T) {
+	assoc := &udpAssociation{resolved: make(map[string]udpResolution)}
+	dest := netip.MustParseAddrPort("192.0.2.1:53")
+	now := time.Now()
+
+	assoc.cacheDestination("old.example:53", dest, now.Add(-2*resolvedTTL))
+	if _, ok := assoc.cachedDestination("old.example:53", now); ok {
+		t.Error("expected an expired resolution to be looked up again")
+	}
+
+	for i := range maxResolved + 10 {
+		assoc.cacheDestination(fmt.Sprintf("host%d.example:53", i), dest, now)
+	}
+	if len(assoc.resolved) > maxResolved {
+		t.Errorf("expected at most %d cached names, got %d", maxResolved, len(assoc.resolved))
+	}
+	if _, ok := assoc.resolved["old.example:53"]; ok {
+		t.Error("expected the expired resolution to be evicted first")
+	}
+	if got, ok := assoc.cachedDestination(fmt.Sprintf("host%d.example:53", maxResolved+9), now); !ok || got != dest {
+		t.Errorf("expected the newest name to be cached, got %v %v", got, ok)
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/security"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// errSourceNotWhitelisted is the reason recorded for client connections from
+// a source outside the whitelist.
+var errSourceNotWhitelisted = errors.New("source not in ip_whitelist")
+
+// whitelistListener closes client connections whose source IP is not in
+// the whitelist before the SOCKS handshake starts.
+type whitelistListener struct {
+	net.Listener
+	whitelist *security.IPWhitelist
+	rejected  func(source string)
+}
+
+func (l *whitelistListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		source := security.HostIP(conn.RemoteAddr().String())
+		if l.whitelist.IsAllowed(source) {
+			return conn, nil
+		}
+
+		l.rejected(source)
+		_ = conn.Close()
+	}
+}
+
+// newWhitelist builds the source IP whitelist from proxy.ip_whitelist,
+// logging entries that are neither IPs nor CIDRs. An empty whitelist allows
+// every source until a reload adds entries.
+func newWhitelist(entries []string, cache *security.DecisionCache, log *zap.Logger) *security.IPWhitelist {
+	logInvalidWhitelistEntries(entries, log)
+
+	whitelist := security.NewIPWhitelist(entries)
+	whitelist.SetDecisionCache(cache)
+
+	return whitelist
+}
+
+func logInvalidWhitelistEntries(entries []string, log *zap.Logger) {
+	for _, entry := range entries {
+		if _, err := parseIPOrCIDR(entry); err != nil {
+			log.Error("ignoring invalid ip_whitelist entry", zap.Error(err))
+		}
+	}
+}
+
+// SetManagedWhitelist replaces the whitelist entries managed through the API,
+// which apply on top of proxy.ip_whitelist. Entries must already be
+// canonical, as stored by the API.
+func (s *Server) SetManagedWhitelist(entries []string) {
+	s.whitelistMu.Lock()
+	defer s.whitelistMu.Unlock()
+
+	if slices.Equal(s.managedWhitelist, entries) {
+		return
+	}
+	s.managedWhitelist = slices.Clone(entries)
+	s.whitelist.Reload(slices.Concat(s.configWhitelist, s.managedWhitelist))
+	s.log.Info("Applied managed whitelist entries", zap.Int("entries", len(entries)))
+}
+
+// reloadConfigWhitelist replaces the entries from proxy.ip_whitelist, keeping
+// the managed ones.
+func (s *Server) reloadConfigWhitelist(entries []string) {
+	s.whitelistMu.Lock()
+	defer s.whitelistMu.Unlock()
+
+	logInvalidWhitelistEntries(entries, s.log)
+	s.configWhitelist = entries
+	s.whitelist.Reload(slices.Concat(s.configWhitelist, s.managedWhitelist))
+}
+
+// WhitelistSync periodically applies the whitelist entries stored in the
+// database by the API's admin endpoints to a running server, so they reach
+// the proxy without a restart and survive one.
+type WhitelistSync struct {
+	repo   storage.Repository
+	server *Server
+	log    *zap.Logger
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewWhitelistSync returns a job applying repo's whitelist entries to server.
+func NewWhitelistSync(repo storage.Repository, server *Server, log *zap.Logger) *WhitelistSync {
+	return &WhitelistSync{
+		repo:   repo,
+		server: server,
+		log:    log,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Sync applies the stored entries once.
+func (w *WhitelistSync) Sync(ctx context.Context) error {
+	stored, err := w.repo.GetWhitelistEntries(ctx)
+	if err != nil {
+		return err
+	}
+	entries := make([]string, len(stored))
+	for i, entry := range stored {
+		entries[i] = entry.Entry
+	}
+	w.server.SetManagedWhitelist(entries)
+
+	return nil
+}
+
+// Start syncs every interval until Stop. A failed sync is logged and the
+// current entries kept.
+func (w *WhitelistSync) Start(interval time.Duration) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if err := w.Sync(context.Background()); err != nil {
+					w.log.Error("failed to sync whitelist entries", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop halts syncing.
+func (w *WhitelistSync) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/metrics"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestIPWhitelistRejectsUnlistedSources(t *testing.T) {
+	tests := []struct {
+		name      string
+		whitelist []string
+		allowed   bool
+	}{
+		{"listed range", []string{"127.0.0.0/8"}, true},
+		{"listed ip", []string{"127.0.0.1"}, true},
+		{"unlisted", []string{"192.0.2.0/24"}, false},
+		{"empty", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Proxy.Address = "127.0.0.1"
+			cfg.Proxy.IPWhitelist = tt.whitelist
+
+			server, _ := newTestServer(t, cfg)
+			rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"})
+			server.SetMetrics(&metrics.Metrics{WhitelistRejections: rejected})
+			if err := server.Start(); err != nil {
+				t.Fatalf("failed to start server: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = server.Stop()
+			})
+
+			conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			// SOCKS5 greeting offering "no authentication".
+			_ = conn.SetDeadline(time.Now().Add(time.Second))
+			_, _ = conn.Write([]byte{0x05, 0x01, 0x00})
+			_, err = io.ReadFull(conn, make([]byte, 2))
+
+			if served := err == nil; served != tt.allowed {
+				t.Errorf("expected served=%v, got error %v", tt.allowed, err)
+			}
+			if want := map[bool]float64{true: 0, false: 1}[tt.allowed]; testutil.ToFloat64(rejected) != want {
+				t.Errorf("expected %v rejections, got %v", want, testutil.ToFloat64(rejected))
+			}
+		})
+	}
+}
+
+func TestWhitelistRejectionsAreSampled(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.LogFailures = true
+	server, events := newTestServer(t, cfg)
+
+	for range 3 {
+		server.sourceRejected("203.0.113.7")
+	}
+	if event := receiveEvent(t, events); event.Status != models.StatusBlocked || event.SourceIP != "203.0.113.7" {
+		t.Errorf("expected a blocked event, got %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("expected repeated rejections within the interval not to be logged, got %+v", event)
+	default:
+	}
+}
+
+func TestWhitelistSyncMergesManagedEntries(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Proxy.IPWhitelist = []string{"192.0.2.1"}
+	server, _ := newTestServer(t, cfg)
+
+	repo := storage.NewInMemoryRepository(10)
+	ctx := context.Background()
+	if err := repo.AddWhitelistEntry(ctx, &models.WhitelistEntry{Entry: "10.0.0.0/8"}); err != nil {
+		t.Fatalf("failed to add entry: %v", err)
+	}
+
+	whitelistSync := NewWhitelistSync(repo, server, zap.NewNop())
+	if err := whitelistSync.Sync(ctx); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if !server.whitelist.IsAllowed("192.0.2.1") || !server.whitelist.IsAllowed("10.1.2.3") {
+		t.Error("expected both the config and managed entries to apply")
+	}
+
+	// A config reload keeps the managed entries.
+	updated := &config.Config{}
+	updated.Proxy.IPWhitelist = []string{"198.51.100.1"}
+	if err := server.Reload(updated); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	if server.whitelist.IsAllowed("192.0.2.1") || !server.whitelist.IsAllowed("10.1.2.3") {
+		t.Error("expected the reload to replace only the config entries")
+	}
+
+	if _, err := repo.DeleteWhitelistEntry(ctx, "10.0.0.0/8"); err != nil {
+		t.Fatalf("failed to delete entry: %v", err)
+	}
+	if err := whitelistSync.Sync(ctx); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if server.whitelist.IsAllowed("10.1.2.3") || !server.whitelist.IsAllowed("198.51.100.1") {
+		t.Error("expected the deleted entry to be dropped")
+	}
+}
@@ -0,0 +1,132 @@
+package security
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DecisionCache remembers recent allow/deny decisions for a short TTL so
+// repeated checks for the same client skip the underlying lookup. It holds
+// at most maxEntries decisions, evicting the least recently used.
+type DecisionCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedDecision struct {
+	key     string
+	allowed bool
+	expires time.Time
+}
+
+// NewDecisionCache creates a decision cache. It returns nil, which disables
+// caching, when ttl or maxEntries is not positive.
+func NewDecisionCache(ttl time.Duration, maxEntries int) *DecisionCache {
+	if ttl <= 0 || maxEntries <= 0 {
+		return nil
+	}
+
+	return &DecisionCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Get returns the cached decision for key, if one exists and has not expired.
+func (c *DecisionCache) Get(key string) (allowed, ok bool) {
+	if c == nil {
+		return false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return false, false
+	}
+
+	decision := elem.Value.(*cachedDecision)
+	if !c.now().Before(decision.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+
+		return false, false
+	}
+	c.lru.MoveToFront(elem)
+
+	return decision.allowed, true
+}
+
+// Put records a decision for key.
+func (c *DecisionCache) Put(key string, allowed bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if elem, found := c.entries[key]; found {
+		decision := elem.Value.(*cachedDecision)
+		decision.allowed = allowed
+		decision.expires = expires
+		c.lru.MoveToFront(elem)
+
+		return
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDecision).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedDecision{key: key, allowed: allowed, expires: expires})
+}
+
+// Invalidate drops every cached decision. Call it whenever the rules the
+// decisions were derived from change.
+func (c *DecisionCache) Invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Len returns the number of cached decisions, including expired ones not yet evicted.
+func (c *DecisionCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// credentialKey derives a cache key from credentials so plaintext passwords
+// are not kept in the cache.
+func credentialKey(username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package security
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// certCheckInterval is how often CertReloader looks for changed files.
+const certCheckInterval = 10 * time.Second
+
+// CertReloader serves a TLS certificate from a PEM certificate and key file
+// pair, reloading it once either file changes so certificates can be rotated
+// without a restart. A pair that fails to load, e.g. while only one of the
+// files has been replaced, is logged and the previous certificate kept.
+type CertReloader struct {
+	certFile      string
+	keyFile       string
+	log           *zap.Logger
+	checkInterval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the certificate, returning an error if either file
+// is missing or the pair is invalid.
+func NewCertReloader(certFile, keyFile string, log *zap.Logger) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, log: log, checkInterval: certCheckInterval}
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TLSConfig returns a server config serving the reloaded certificate.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// GetCertificate returns the current certificate, first reloading it if the
+// files changed since the last check. It is a tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < r.checkInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+
+	modTime, err := r.latestModTime()
+	if err == nil && modTime.After(r.modTime) {
+		err = r.load(modTime)
+		if err == nil {
+			r.log.Info("Reloaded TLS certificate", zap.String("cert_file", r.certFile))
+		}
+	}
+	if err != nil {
+		r.log.Warn("Keeping the current TLS certificate", zap.Error(err))
+	}
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+// latestModTime returns the later modification time of the two files.
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("TLS certificate files: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
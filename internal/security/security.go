@@ -2,36 +2,104 @@
 package security
 
 import (
+	"crypto/subtle"
+	"fmt"
+	"math"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// Authenticator handles SOCKS5 authentication.
+// Authenticator handles SOCKS5 authentication against a set of
+// username/password credentials.
 type Authenticator struct {
-	username string
-	password string
-	enabled  bool
+	users   map[string]string
+	enabled bool
+	mu      sync.RWMutex
+	cache   *DecisionCache
 }
 
 // NewAuthenticator creates a new authenticator with the given credentials.
 func NewAuthenticator(username, password string) *Authenticator {
-	return &Authenticator{
-		username: username,
-		password: password,
-		enabled:  true,
+	return NewMultiUserAuthenticator(map[string]string{username: password})
+}
+
+// NewMultiUserAuthenticator creates an authenticator accepting any of the
+// given username to password pairs.
+func NewMultiUserAuthenticator(users map[string]string) *Authenticator {
+	a := &Authenticator{
+		users:   make(map[string]string, len(users)),
+		enabled: true,
 	}
+	for username, password := range users {
+		a.users[username] = password
+	}
+
+	return a
 }
 
-// Authenticate checks if the provided credentials are valid.
+// SetDecisionCache enables caching of authentication results. The cache is
+// invalidated whenever the credentials change.
+func (a *Authenticator) SetDecisionCache(cache *DecisionCache) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cache = cache
+}
+
+// Authenticate checks if the provided credentials are valid. An empty
+// username or password never is, so a user configured without one cannot be
+// used to log in.
 func (a *Authenticator) Authenticate(username, password string) bool {
 	if !a.enabled {
 		return true
 	}
+	if username == "" || password == "" {
+		return false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	key := credentialKey(username, password)
+	if allowed, ok := a.cache.Get(key); ok {
+		return allowed
+	}
+
+	expected, found := a.users[username]
+	allowed := found && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	a.cache.Put(key, allowed)
+
+	return allowed
+}
+
+// AddUser adds a user, replacing the password if the user already exists.
+func (a *Authenticator) AddUser(username, password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users[username] = password
+	a.cache.Invalidate()
+}
+
+// SetUsers replaces all credentials with users, e.g. after a config reload.
+func (a *Authenticator) SetUsers(users map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = make(map[string]string, len(users))
+	for username, password := range users {
+		a.users[username] = password
+	}
+	a.cache.Invalidate()
+}
 
-	return username == a.username && password == a.password
+// RemoveUser revokes a user's credentials.
+func (a *Authenticator) RemoveUser(username string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.users, username)
+	a.cache.Invalidate()
 }
 
 // IsEnabled returns whether authentication is enabled.
@@ -39,60 +107,202 @@ func (a *Authenticator) IsEnabled() bool {
 	return a.enabled
 }
 
-// IPWhitelist handles IP filtering.
+// IPWhitelist handles IP filtering. Entries are exact IPs or CIDR ranges.
 type IPWhitelist struct {
-	allowedIPs map[string]bool
-	enabled    bool
-	mu         sync.RWMutex
+	allowedIPs      map[string]bool
+	allowedNetworks map[string]*net.IPNet
+	enabled         bool
+	mu              sync.RWMutex
+	cache           *DecisionCache
 }
 
-// NewIPWhitelist creates a new IP whitelist from the given IP addresses.
+// NewIPWhitelist creates a new IP whitelist from the given IP addresses and
+// CIDR ranges. Entries that parse as neither never match.
 func NewIPWhitelist(ips []string) *IPWhitelist {
 	whitelist := &IPWhitelist{
-		allowedIPs: make(map[string]bool),
-		enabled:    len(ips) > 0,
+		allowedIPs:      make(map[string]bool),
+		allowedNetworks: make(map[string]*net.IPNet),
+		enabled:         len(ips) > 0,
 	}
 
 	for _, ip := range ips {
-		whitelist.allowedIPs[ip] = true
+		whitelist.add(ip)
 	}
 
 	return whitelist
 }
 
+// SetDecisionCache enables caching of whitelist decisions. The cache is
+// invalidated whenever the whitelist changes.
+func (w *IPWhitelist) SetDecisionCache(cache *DecisionCache) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cache = cache
+}
+
 // IsAllowed checks if an IP address is allowed.
 func (w *IPWhitelist) IsAllowed(ip string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
 	if !w.enabled {
 		return true
 	}
 
-	w.mu.RLock()
-	defer w.mu.RUnlock()
+	if allowed, ok := w.cache.Get(ip); ok {
+		return allowed
+	}
+
+	allowed := w.matches(ip)
+	w.cache.Put(ip, allowed)
+
+	return allowed
+}
+
+func (w *IPWhitelist) matches(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return w.allowedIPs[ip]
+	}
+	if w.allowedIPs[parsed.String()] {
+		return true
+	}
+
+	for _, network := range w.allowedNetworks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
 
-	return w.allowedIPs[ip]
+	return false
 }
 
-// AddIP adds an IP address to the whitelist.
+// AddIP adds an IP address or CIDR range to the whitelist.
 func (w *IPWhitelist) AddIP(ip string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	w.allowedIPs[ip] = true
+	w.add(ip)
+	w.cache.Invalidate()
 }
 
-// RemoveIP removes an IP address from the whitelist.
+// RemoveIP removes an IP address or CIDR range from the whitelist.
 func (w *IPWhitelist) RemoveIP(ip string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	delete(w.allowedIPs, ip)
+	if strings.Contains(ip, "/") {
+		delete(w.allowedNetworks, canonicalCIDR(ip))
+	} else {
+		delete(w.allowedIPs, canonicalIP(ip))
+	}
+	w.cache.Invalidate()
+}
+
+// IsEnabled returns whether the whitelist restricts sources, which it does
+// once it has entries.
+func (w *IPWhitelist) IsEnabled() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.enabled
+}
+
+// Reload replaces the whitelist with ips and drops cached decisions.
+func (w *IPWhitelist) Reload(ips []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.allowedIPs = make(map[string]bool, len(ips))
+	w.allowedNetworks = make(map[string]*net.IPNet)
+	for _, ip := range ips {
+		w.add(ip)
+	}
+	w.enabled = len(ips) > 0
+	w.cache.Invalidate()
+}
+
+// add must be called with mu held or before the whitelist is shared.
+func (w *IPWhitelist) add(entry string) {
+	if !strings.Contains(entry, "/") {
+		w.allowedIPs[canonicalIP(entry)] = true
+
+		return
+	}
+
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		w.allowedNetworks[network.String()] = network
+	}
+}
+
+// CanonicalWhitelistEntry validates a whitelist entry and returns it in the
+// form the whitelist stores it: an IP in canonical form or a CIDR range's
+// network, so every spelling of an entry names the same one.
+func CanonicalWhitelistEntry(entry string) (string, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR range %q", entry)
+		}
+
+		return network.String(), nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", entry)
+	}
+
+	return ip.String(), nil
+}
+
+// canonicalIP normalizes ip so equivalent spellings (e.g. of IPv6 addresses)
+// match. Values that don't parse are returned unchanged.
+func canonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+
+	return ip
+}
+
+// HostIP returns the host of a host:port or bare address, with IPs in the
+// canonical form of net.IP.String: brackets and zones are dropped, IPv6 is
+// compressed and IPv4-mapped addresses become IPv4. Traffic logs, rate
+// limiting and the whitelist all key on it, so every spelling of an address
+// counts as the same source. Hosts that aren't IPs are returned as is.
+func HostIP(addr string) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip, _, _ := strings.Cut(host, "%")
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+
+	return host
+}
+
+// canonicalCIDR normalizes a CIDR entry to its network address, so
+// "10.1.2.3/8" and "10.0.0.0/8" name the same range. Values that don't parse
+// are returned unchanged.
+func canonicalCIDR(cidr string) string {
+	if _, network, err := net.ParseCIDR(cidr); err == nil {
+		return network.String()
+	}
+
+	return cidr
 }
 
 // RateLimiter implements token bucket rate limiting.
 type RateLimiter struct {
 	requestsPerSecond int
+	burst             int
 	buckets           map[string]*tokenBucket
 	mu                sync.RWMutex
 	enabled           bool
 	log               *zap.Logger
+	now               func() time.Time
+	stop              chan struct{}
+	wg                sync.WaitGroup
 }
 
 type tokenBucket struct {
@@ -101,43 +311,52 @@ type tokenBucket struct {
 	ratePerMs float64
 }
 
-// NewRateLimiter creates a new rate limiter with token bucket algorithm.
-func NewRateLimiter(requestsPerSecond int, enabled bool, log *zap.Logger) *RateLimiter {
+// NewRateLimiter creates a new rate limiter with token bucket algorithm. Each
+// client's bucket holds up to burst tokens and refills at requestsPerSecond,
+// so a client may briefly exceed the rate by up to burst requests. A burst
+// below 1 defaults to requestsPerSecond.
+func NewRateLimiter(requestsPerSecond, burst int, enabled bool, log *zap.Logger) *RateLimiter {
+	if burst < 1 {
+		burst = requestsPerSecond
+	}
+
 	return &RateLimiter{
 		requestsPerSecond: requestsPerSecond,
+		burst:             burst,
 		buckets:           make(map[string]*tokenBucket),
 		enabled:           enabled,
 		log:               log,
+		now:               time.Now,
+		stop:              make(chan struct{}),
 	}
 }
 
 // Allow checks if a request from the identifier is allowed.
 func (rl *RateLimiter) Allow(identifier string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
 	if !rl.enabled {
 		return true
 	}
 
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
 	bucket, exists := rl.buckets[identifier]
-	now := time.Now()
+	now := rl.now()
 
 	if !exists {
+		// A new client starts with a full bucket; this request takes a token.
 		bucket = &tokenBucket{
-			tokens:    float64(rl.requestsPerSecond),
+			tokens:    float64(rl.burst),
 			lastTime:  now,
 			ratePerMs: float64(rl.requestsPerSecond) / 1000.0,
 		}
 		rl.buckets[identifier] = bucket
-
-		return true
 	}
 
 	// Calculate tokens to add based on elapsed time
 	elapsed := now.Sub(bucket.lastTime).Milliseconds()
 	tokensToAdd := float64(elapsed) * bucket.ratePerMs
-	bucket.tokens = minFloat(float64(rl.requestsPerSecond), bucket.tokens+tokensToAdd)
+	bucket.tokens = minFloat(float64(rl.burst), bucket.tokens+tokensToAdd)
 	bucket.lastTime = now
 
 	// Check if we have at least one token
@@ -150,14 +369,99 @@ func (rl *RateLimiter) Allow(identifier string) bool {
 	return false
 }
 
-// GetSourceIP extracts the source IP from a remote address.
-func (rl *RateLimiter) GetSourceIP(remoteAddr string) string {
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		return remoteAddr
+// Reconfigure changes the rate, burst and enabled state of a running
+// limiter. Existing buckets keep their tokens, capped at the new burst, and
+// refill at the new rate. A burst below 1 defaults to requestsPerSecond.
+func (rl *RateLimiter) Reconfigure(requestsPerSecond, burst int, enabled bool) {
+	if burst < 1 {
+		burst = requestsPerSecond
 	}
 
-	return host
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.requestsPerSecond = requestsPerSecond
+	rl.burst = burst
+	rl.enabled = enabled
+	for _, bucket := range rl.buckets {
+		bucket.ratePerMs = float64(requestsPerSecond) / 1000.0
+		bucket.tokens = minFloat(float64(burst), bucket.tokens)
+	}
+}
+
+// RetryAfter returns how long identifier has to wait for its next token,
+// or zero if a request would be allowed now.
+func (rl *RateLimiter) RetryAfter(identifier string) time.Duration {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	bucket, exists := rl.buckets[identifier]
+	if !rl.enabled || !exists || bucket.ratePerMs <= 0 {
+		return 0
+	}
+
+	elapsed := rl.now().Sub(bucket.lastTime).Milliseconds()
+	tokens := bucket.tokens + float64(elapsed)*bucket.ratePerMs
+	if tokens >= 1.0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil((1.0-tokens)/bucket.ratePerMs)) * time.Millisecond
+}
+
+// Sweep evicts the buckets of identifiers not seen for at least ttl. An
+// evicted client starts again with a full bucket, which is what an idle
+// bucket would have refilled to anyway.
+func (rl *RateLimiter) Sweep(ttl time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := rl.now().Add(-ttl)
+	evicted := 0
+	for identifier, bucket := range rl.buckets {
+		if !bucket.lastTime.After(cutoff) {
+			delete(rl.buckets, identifier)
+			evicted++
+		}
+	}
+
+	if evicted > 0 {
+		rl.log.Debug("evicted idle rate limit buckets",
+			zap.Int("evicted", evicted), zap.Int("remaining", len(rl.buckets)))
+	}
+}
+
+// StartSweeper runs Sweep(ttl) every interval until Stop is called, so
+// buckets for clients that went away don't accumulate.
+func (rl *RateLimiter) StartSweeper(interval, ttl time.Duration) {
+	rl.wg.Add(1)
+	go func() {
+		defer rl.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-rl.stop:
+				return
+			case <-ticker.C:
+				rl.Sweep(ttl)
+			}
+		}
+	}()
+}
+
+// Stop halts the sweeper.
+func (rl *RateLimiter) Stop() {
+	close(rl.stop)
+	rl.wg.Wait()
+}
+
+// GetSourceIP extracts the source IP from a remote address, in the canonical
+// form of HostIP.
+func (rl *RateLimiter) GetSourceIP(remoteAddr string) string {
+	return HostIP(remoteAddr)
 }
 
 func minFloat(a, b float64) float64 {
@@ -1,7 +1,18 @@
 package security
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -29,6 +40,56 @@ func TestAuthenticator(t *testing.T) {
 	}
 }
 
+func TestAuthenticatorRejectsEmptyCredentials(t *testing.T) {
+	auth := NewMultiUserAuthenticator(map[string]string{"": "", "alice": ""})
+
+	if auth.Authenticate("", "") {
+		t.Error("expected empty credentials to fail")
+	}
+	if auth.Authenticate("alice", "") {
+		t.Error("expected a user without a password to fail")
+	}
+}
+
+func TestMultiUserAuthenticator(t *testing.T) {
+	auth := NewMultiUserAuthenticator(map[string]string{"alice": "a-pass", "bob": "b-pass"})
+	auth.SetDecisionCache(NewDecisionCache(time.Minute, 100))
+
+	if !auth.Authenticate("alice", "a-pass") || !auth.Authenticate("bob", "b-pass") {
+		t.Error("expected every configured user to authenticate")
+	}
+	if auth.Authenticate("alice", "b-pass") {
+		t.Error("expected another user's password to fail")
+	}
+
+	// Revoking a user must not be masked by a cached allow.
+	auth.RemoveUser("bob")
+	if auth.Authenticate("bob", "b-pass") {
+		t.Error("expected a removed user to fail")
+	}
+
+	auth.AddUser("carol", "c-pass")
+	if !auth.Authenticate("carol", "c-pass") {
+		t.Error("expected an added user to authenticate")
+	}
+}
+
+func TestAuthenticatorSetUsers(t *testing.T) {
+	auth := NewMultiUserAuthenticator(map[string]string{"alice": "a", "bob": "b"})
+	auth.SetDecisionCache(NewDecisionCache(time.Minute, 10))
+	if !auth.Authenticate("alice", "a") {
+		t.Fatal("expected alice to authenticate")
+	}
+
+	auth.SetUsers(map[string]string{"bob": "new", "carol": "c"})
+	if auth.Authenticate("alice", "a") {
+		t.Error("expected alice to be removed, despite the cached decision")
+	}
+	if auth.Authenticate("bob", "b") || !auth.Authenticate("bob", "new") || !auth.Authenticate("carol", "c") {
+		t.Error("expected the new credentials to apply")
+	}
+}
+
 func TestIPWhitelist(t *testing.T) {
 	ips := []string{"192.168.1.1", "192.168.1.2"}
 	whitelist := NewIPWhitelist(ips)
@@ -60,6 +121,38 @@ func TestIPWhitelist(t *testing.T) {
 	}
 }
 
+func TestIPWhitelistCIDR(t *testing.T) {
+	whitelist := NewIPWhitelist([]string{"10.1.0.0/16", "2001:db8::/32", "2001:db8:ffff::1"})
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"10.2.0.1", false},
+		{"2001:db8:1::5", true},
+		{"2001:0db8:ffff:0000:0000:0000:0000:0001", true},
+		{"2001:db9::1", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := whitelist.IsAllowed(tt.ip); got != tt.allowed {
+			t.Errorf("IsAllowed(%q) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	// Ranges are matched by network, whatever host bits the entry was written with.
+	whitelist.RemoveIP("10.1.255.255/16")
+	if whitelist.IsAllowed("10.1.2.3") {
+		t.Error("expected 10.1.2.3 to be disallowed after removing its range")
+	}
+
+	whitelist.AddIP("192.168.7.9/24")
+	if !whitelist.IsAllowed("192.168.7.200") || !whitelist.IsAllowed("::ffff:192.168.7.1") {
+		t.Error("expected addresses in an added range, including IPv4-mapped ones, to be allowed")
+	}
+}
+
 func TestEmptyWhitelist(t *testing.T) {
 	whitelist := NewIPWhitelist([]string{})
 
@@ -71,7 +164,7 @@ func TestEmptyWhitelist(t *testing.T) {
 
 func TestRateLimiter(t *testing.T) {
 	log, _ := zap.NewDevelopment()
-	limiter := NewRateLimiter(10, true, log)
+	limiter := NewRateLimiter(10, 0, true, log)
 
 	// First 10 requests should be allowed
 	allowed := 0
@@ -93,7 +186,7 @@ func TestRateLimiter(t *testing.T) {
 
 func TestRateLimiterDisabled(t *testing.T) {
 	log, _ := zap.NewDevelopment()
-	limiter := NewRateLimiter(10, false, log)
+	limiter := NewRateLimiter(10, 0, false, log)
 
 	// All requests should be allowed when disabled
 	for i := 0; i < 100; i++ {
@@ -103,9 +196,174 @@ func TestRateLimiterDisabled(t *testing.T) {
 	}
 }
 
+func TestRateLimiterBurst(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1, 5, true, zap.NewNop())
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow("client") {
+			t.Fatalf("expected request %d to fit in the burst of 5", i+1)
+		}
+	}
+	if limiter.Allow("client") {
+		t.Fatal("expected the request after the burst to be refused")
+	}
+
+	// Refill is at the steady rate, not the burst size.
+	now = now.Add(time.Second)
+	if !limiter.Allow("client") || limiter.Allow("client") {
+		t.Error("expected exactly one token to refill after a second")
+	}
+
+	// The bucket never holds more than the burst, however long the client is idle.
+	now = now.Add(time.Hour)
+	allowed := 0
+	for limiter.Allow("client") {
+		allowed++
+	}
+	if allowed != 5 {
+		t.Errorf("expected an idle bucket to refill to the burst of 5, got %d", allowed)
+	}
+}
+
+func TestRateLimiterReconfigure(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1, 5, false, zap.NewNop())
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		limiter.Allow("client")
+	}
+
+	// Enabling caps the existing bucket at the new burst.
+	limiter.Reconfigure(10, 2, true)
+	if !limiter.Allow("client") || !limiter.Allow("client") || limiter.Allow("client") {
+		t.Error("expected exactly the new burst of 2 requests to be allowed")
+	}
+
+	// The bucket refills at the new rate: 10/s is one token per 100ms.
+	now = now.Add(100 * time.Millisecond)
+	if !limiter.Allow("client") {
+		t.Error("expected a token after 100ms at the new rate")
+	}
+
+	limiter.Reconfigure(10, 2, false)
+	if !limiter.Allow("client") {
+		t.Error("expected requests to be allowed once disabled")
+	}
+}
+
+func TestRateLimiterBurstDefaultsToRate(t *testing.T) {
+	limiter := NewRateLimiter(3, 0, true, zap.NewNop())
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if limiter.Allow("client") {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("expected the burst to default to the rate of 3, got %d", allowed)
+	}
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 0, true, zap.NewNop())
+	limiter.now = func() time.Time { return now }
+
+	if limiter.RetryAfter("client") != 0 {
+		t.Error("expected an unseen client not to wait")
+	}
+
+	for i := 0; i < 2; i++ {
+		limiter.Allow("client")
+	}
+	if got := limiter.RetryAfter("client"); got <= 0 || got > 500*time.Millisecond {
+		t.Errorf("expected to wait at most one token interval (500ms), got %v", got)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if got := limiter.RetryAfter("client"); got != 0 {
+		t.Errorf("expected a token to be available after 500ms, got %v", got)
+	}
+}
+
+func TestRateLimiterSweepEvictsIdleBuckets(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(1, 0, true, zap.NewNop())
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("idle")
+	now = now.Add(4 * time.Minute)
+	limiter.Allow("active")
+
+	now = now.Add(time.Minute)
+	limiter.Sweep(5 * time.Minute)
+
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error("expected the idle bucket to be evicted")
+	}
+	if _, ok := limiter.buckets["active"]; !ok {
+		t.Error("expected the recently used bucket to be kept")
+	}
+}
+
+func TestRateLimiterSweeperStops(t *testing.T) {
+	limiter := NewRateLimiter(10, 0, true, zap.NewNop())
+	limiter.Allow("client")
+
+	limiter.StartSweeper(time.Millisecond, 0)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		limiter.mu.RLock()
+		remaining := len(limiter.buckets)
+		limiter.mu.RUnlock()
+		if remaining == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	limiter.Stop()
+
+	if len(limiter.buckets) != 0 {
+		t.Error("expected the sweeper to evict the bucket")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(1000)
+	now := throttle.bucket.lastTime
+	throttle.now = func() time.Time { return now }
+
+	if throttle.Burst() != 1000 {
+		t.Errorf("expected a burst of one second, got %d", throttle.Burst())
+	}
+	if wait := throttle.Reserve(1000); wait != 0 {
+		t.Errorf("expected a full bucket to allow a second's worth of bytes, got %v", wait)
+	}
+	if wait := throttle.Reserve(500); wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, got %v", wait)
+	}
+
+	// Refilling repays the debt before allowing more.
+	now = now.Add(500 * time.Millisecond)
+	if wait := throttle.Reserve(250); wait != 250*time.Millisecond {
+		t.Errorf("expected to wait 250ms, got %v", wait)
+	}
+	now = now.Add(10 * time.Second)
+	if wait := throttle.Reserve(1000); wait != 0 {
+		t.Errorf("expected the bucket to refill up to the burst, got %v", wait)
+	}
+	if wait := throttle.Reserve(1); wait != time.Millisecond {
+		t.Errorf("expected to wait 1ms, got %v", wait)
+	}
+}
+
 func TestGetSourceIP(t *testing.T) {
 	log, _ := zap.NewDevelopment()
-	limiter := NewRateLimiter(100, true, log)
+	limiter := NewRateLimiter(100, 0, true, log)
 
 	tests := []struct {
 		input    string
@@ -114,6 +372,10 @@ func TestGetSourceIP(t *testing.T) {
 		{"192.168.1.1:5000", "192.168.1.1"},
 		{"10.0.0.1:8080", "10.0.0.1"},
 		{"[::1]:8080", "::1"},
+		{"[2001:0db8:0000::0001]:443", "2001:db8::1"},
+		{"[::ffff:192.168.1.1]:5000", "192.168.1.1"},
+		{"[fe80::1%eth0]:22", "fe80::1"},
+		{"2001:db8::1", "2001:db8::1"},
 		{"invalid", "invalid"},
 	}
 
@@ -124,3 +386,195 @@ func TestGetSourceIP(t *testing.T) {
 		}
 	}
 }
+
+func TestCanonicalWhitelistEntry(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"192.168.1.1", "192.168.1.1"},
+		{"2001:0db8::0001", "2001:db8::1"},
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"invalid", ""},
+		{"10.0.0.0/33", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		result, err := CanonicalWhitelistEntry(tt.input)
+		if result != tt.expected || (err != nil) != (tt.expected == "") {
+			t.Errorf("%q: expected %q, got %q (%v)", tt.input, tt.expected, result, err)
+		}
+	}
+}
+
+func TestDecisionCacheReusesAndInvalidates(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewDecisionCache(time.Minute, 100)
+	cache.now = func() time.Time { return now }
+
+	whitelist := NewIPWhitelist([]string{"192.168.1.1"})
+	whitelist.SetDecisionCache(cache)
+
+	if !whitelist.IsAllowed("192.168.1.1") {
+		t.Fatal("expected 192.168.1.1 to be allowed")
+	}
+
+	// Mutate the underlying set without going through the whitelist API; the
+	// cached allow must still be served within the TTL.
+	whitelist.mu.Lock()
+	delete(whitelist.allowedIPs, "192.168.1.1")
+	whitelist.mu.Unlock()
+
+	if !whitelist.IsAllowed("192.168.1.1") {
+		t.Error("expected cached allow decision to be reused within the TTL")
+	}
+
+	now = now.Add(time.Minute)
+	if whitelist.IsAllowed("192.168.1.1") {
+		t.Error("expected decision to be re-evaluated after the TTL")
+	}
+
+	whitelist.AddIP("192.168.1.1")
+	if !whitelist.IsAllowed("192.168.1.1") {
+		t.Fatal("expected 192.168.1.1 to be allowed after adding")
+	}
+
+	whitelist.Reload([]string{"10.0.0.1"})
+	if whitelist.IsAllowed("192.168.1.1") {
+		t.Error("expected cached allow decision to be invalidated on reload")
+	}
+}
+
+func TestDecisionCacheBounded(t *testing.T) {
+	cache := NewDecisionCache(time.Minute, 3)
+
+	for i := range 10 {
+		cache.Put(fmt.Sprintf("10.0.0.%d", i), true)
+	}
+
+	if cache.Len() != 3 {
+		t.Errorf("expected cache to hold 3 entries, got %d", cache.Len())
+	}
+	if _, ok := cache.Get("10.0.0.0"); ok {
+		t.Error("expected oldest entry to be evicted")
+	}
+	if _, ok := cache.Get("10.0.0.9"); !ok {
+		t.Error("expected newest entry to be cached")
+	}
+}
+
+func TestAuthenticatorDecisionCache(t *testing.T) {
+	auth := NewAuthenticator("testuser", "testpass")
+	auth.SetDecisionCache(NewDecisionCache(time.Minute, 100))
+
+	for range 2 {
+		if !auth.Authenticate("testuser", "testpass") {
+			t.Error("expected valid credentials to authenticate")
+		}
+		if auth.Authenticate("testuser", "wrongpass") {
+			t.Error("expected invalid password to fail")
+		}
+	}
+}
+
+// writeCertificate writes a self-signed certificate for commonName and its
+// key to dir, returning their paths.
+func writeCertificate(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestCertReloaderPicksUpRotatedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+
+	reloader, err := NewCertReloader(certFile, keyFile, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	reloader.checkInterval = 0
+
+	commonName := func() string {
+		t.Helper()
+
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("failed to get certificate: %v", err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+
+		return parsed.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Fatalf("expected the initial certificate, got %q", got)
+	}
+
+	// Move the clock of the rotated files forward, as coarse file system
+	// timestamps may not change within the test.
+	later := time.Now().Add(time.Minute)
+	writeCertificate(t, dir, "second")
+	for _, file := range []string{certFile, keyFile} {
+		_ = os.Chtimes(file, later, later)
+	}
+	if got := commonName(); got != "second" {
+		t.Errorf("expected the rotated certificate, got %q", got)
+	}
+
+	// A broken pair keeps the last good certificate.
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(keyFile, later, later)
+	if got := commonName(); got != "second" {
+		t.Errorf("expected the last good certificate to be kept, got %q", got)
+	}
+}
+
+func TestNewCertReloaderFailsFast(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "test")
+
+	if _, err := NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile, zap.NewNop()); err == nil {
+		t.Error("expected a missing certificate file to be rejected")
+	}
+	if _, err := NewCertReloader(keyFile, certFile, zap.NewNop()); err == nil {
+		t.Error("expected an invalid certificate pair to be rejected")
+	}
+}
@@ -0,0 +1,55 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle paces a byte stream to a fixed rate with a token bucket holding
+// up to one second of bytes. Unlike RateLimiter, which refuses requests once
+// the bucket is empty, a transfer always takes its bytes and the caller
+// waits for the bucket to refill.
+type Throttle struct {
+	mu     sync.Mutex
+	bucket tokenBucket
+	burst  float64
+	now    func() time.Time
+}
+
+// NewThrottle returns a throttle allowing bytesPerSecond, starting with a
+// full bucket.
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	t := &Throttle{burst: float64(bytesPerSecond), now: time.Now}
+	t.bucket = tokenBucket{
+		tokens:    t.burst,
+		lastTime:  t.now(),
+		ratePerMs: float64(bytesPerSecond) / 1000.0,
+	}
+
+	return t
+}
+
+// Burst returns the most bytes one transfer should take, so that no wait
+// exceeds about a second.
+func (t *Throttle) Burst() int {
+	return max(int(t.burst), 1)
+}
+
+// Reserve takes n bytes from the bucket, going into debt if it holds fewer,
+// and returns how long the caller must wait for the debt to be repaid.
+func (t *Throttle) Reserve(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	elapsedMs := float64(now.Sub(t.bucket.lastTime)) / float64(time.Millisecond)
+	t.bucket.tokens = minFloat(t.burst, t.bucket.tokens+elapsedMs*t.bucket.ratePerMs)
+	t.bucket.lastTime = now
+
+	t.bucket.tokens -= float64(n)
+	if t.bucket.tokens >= 0 || t.bucket.ratePerMs <= 0 {
+		return 0
+	}
+
+	return time.Duration(-t.bucket.tokens / t.bucket.ratePerMs * float64(time.Millisecond))
+}
@@ -0,0 +1,780 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"github.com/andev0x/socks5-proxy-analytics/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clickHouseSchema is the traffic_logs table for ClickHouse. Columns are named
+// after the TrafficLog JSON fields so rows round-trip through JSONEachRow.
+// ClickHouse has no sequences, so id stays 0 unless the caller sets it; use
+// the uuid primary key strategy for stable identities.
+const clickHouseSchema = `CREATE TABLE IF NOT EXISTS traffic_logs (
+	id UInt64,
+	uuid Nullable(UUID),
+	source_ip String,
+	source_country LowCardinality(String),
+	region LowCardinality(String),
+	username String,
+	destination_ip String,
+	dest_country LowCardinality(String),
+	dest_asn UInt32,
+	domain String,
+	punycode_decoded String,
+	suspicious Bool,
+	port Int32,
+	timestamp DateTime64(3, 'UTC'),
+	latency_ms Int64,
+	first_byte_ms Nullable(Int64),
+	duration_ms Int64,
+	bytes_in Int64,
+	bytes_out Int64,
+	protocol LowCardinality(String),
+	interim Bool DEFAULT false,
+	status LowCardinality(String) DEFAULT 'success',
+	created_at DateTime64(3, 'UTC')
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (timestamp, source_ip)`
+
+// clickHouseWhitelistSchema holds the whitelist entries managed through the
+// API. Re-adding an entry inserts a new row that replaces the old one, so
+// reads use FINAL.
+const clickHouseWhitelistSchema = `CREATE TABLE IF NOT EXISTS ip_whitelist (
+	entry String,
+	comment String,
+	created_at DateTime64(3, 'UTC')
+) ENGINE = ReplacingMergeTree
+ORDER BY entry`
+
+// clickHouseMigrations upgrade tables created by earlier versions.
+var clickHouseMigrations = []string{
+	`ALTER TABLE traffic_logs ADD COLUMN IF NOT EXISTS interim Bool DEFAULT false AFTER protocol`,
+	`ALTER TABLE traffic_logs ADD COLUMN IF NOT EXISTS status LowCardinality(String) DEFAULT 'success' AFTER interim`,
+	`ALTER TABLE traffic_logs ADD COLUMN IF NOT EXISTS dest_country LowCardinality(String) AFTER destination_ip`,
+	`ALTER TABLE traffic_logs ADD COLUMN IF NOT EXISTS dest_asn UInt32 AFTER dest_country`,
+}
+
+// clickHouseTime is the layout of DateTime64(3) query parameters.
+const clickHouseTime = "2006-01-02 15:04:05.000"
+
+// ClickHouseRepository implements Repository using ClickHouse over its HTTP
+// interface. Aggregations run in ClickHouse; the concurrency and gap
+// calculations share their Go post-processing with PostgresRepository.
+type ClickHouseRepository struct {
+	endpoint string
+	database string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// NewClickHouseRepository connects to the ClickHouse HTTP interface described
+// by the database settings and creates the traffic_logs table if needed. Any
+// sslmode other than "disable" connects over HTTPS.
+func NewClickHouseRepository(cfg *config.Config) (*ClickHouseRepository, error) {
+	r := newClickHouseRepository(cfg)
+	for _, statement := range append([]string{clickHouseSchema, clickHouseWhitelistSchema}, clickHouseMigrations...) {
+		if err := r.exec(context.Background(), statement, nil); err != nil {
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// newClickHouseRepository returns a client of the configured ClickHouse
+// server without touching the schema.
+func newClickHouseRepository(cfg *config.Config) *ClickHouseRepository {
+	scheme := "https"
+	if cfg.Database.SSLMode == "" || cfg.Database.SSLMode == "disable" {
+		scheme = "http"
+	}
+
+	return &ClickHouseRepository{
+		endpoint: fmt.Sprintf("%s://%s:%d/", scheme, cfg.Database.Host, cfg.Database.Port),
+		database: cfg.Database.Database,
+		user:     cfg.Database.User,
+		password: cfg.Database.Password,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxConnsPerHost:     max(cfg.Database.MaxOpenConns, 0),
+			MaxIdleConnsPerHost: cfg.Database.MaxIdleConns,
+			IdleConnTimeout:     90 * time.Second,
+		}},
+	}
+}
+
+// do sends query to ClickHouse with params bound to its {name:Type}
+// placeholders. When body is set it is sent as the data of an INSERT and the
+// query goes in the URL.
+func (r *ClickHouseRepository) do(
+	ctx context.Context, query string, params map[string]string, body io.Reader,
+) (_ io.ReadCloser, err error) {
+	operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	ctx, span := tracing.Tracer().Start(ctx, "clickhouse "+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", "clickhouse"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", query),
+		))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	values := url.Values{}
+	values.Set("database", r.database)
+	values.Set("output_format_json_quote_64bit_integers", "0")
+	values.Set("date_time_output_format", "iso")
+	values.Set("date_time_input_format", "best_effort")
+	values.Set("input_format_skip_unknown_fields", "1")
+	for name, value := range params {
+		values.Set("param_"+name, value)
+	}
+
+	if body == nil {
+		body = strings.NewReader(query)
+	} else {
+		values.Set("query", query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint+"?"+values.Encode(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build ClickHouse request: %w", err)
+	}
+	req.Header.Set("X-ClickHouse-User", r.user)
+	req.Header.Set("X-ClickHouse-Key", r.password)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach ClickHouse: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+		return nil, fmt.Errorf("clickhouse: %s", strings.TrimSpace(string(msg)))
+	}
+
+	return resp.Body, nil
+}
+
+func (r *ClickHouseRepository) exec(ctx context.Context, query string, params map[string]string) error {
+	body, err := r.do(ctx, query, params, nil)
+	if err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+// query runs a SELECT and decodes its JSONEachRow rows into dest, a pointer
+// to a slice.
+func (r *ClickHouseRepository) query(ctx context.Context, dest any, query string, params map[string]string) error {
+	body, err := r.do(ctx, query+" FORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+
+	// Rewrite the rows as a JSON array so dest's element type decodes them.
+	var rows bytes.Buffer
+	rows.WriteByte('[')
+	dec := json.NewDecoder(body)
+	for {
+		var row json.RawMessage
+		if err := dec.Decode(&row); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to decode ClickHouse result: %w", err)
+		}
+		if rows.Len() > 1 {
+			rows.WriteByte(',')
+		}
+		rows.Write(row)
+	}
+	rows.WriteByte(']')
+
+	if err := json.Unmarshal(rows.Bytes(), dest); err != nil {
+		return fmt.Errorf("failed to decode ClickHouse result: %w", err)
+	}
+
+	return nil
+}
+
+func timeRange(startTime, endTime time.Time) map[string]string {
+	return map[string]string{
+		"start": startTime.UTC().Format(clickHouseTime),
+		"end":   endTime.UTC().Format(clickHouseTime),
+	}
+}
+
+// SaveTrafficLog saves a single traffic log to ClickHouse.
+func (r *ClickHouseRepository) SaveTrafficLog(ctx context.Context, log *models.TrafficLog) error {
+	return r.SaveTrafficLogs(ctx, []*models.TrafficLog{log})
+}
+
+// SaveTrafficLogs inserts logs in a single JSONEachRow request.
+func (r *ClickHouseRepository) SaveTrafficLogs(ctx context.Context, logs []*models.TrafficLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	now := time.Now().UTC()
+	for _, log := range logs {
+		row := *log
+		if row.CreatedAt.IsZero() {
+			row.CreatedAt = now
+		}
+		if row.Status == "" {
+			row.Status = models.StatusSuccess
+		}
+		if err := enc.Encode(&row); err != nil {
+			return fmt.Errorf("failed to encode traffic log: %w", err)
+		}
+	}
+
+	body, err := r.do(ctx, "INSERT INTO traffic_logs FORMAT JSONEachRow", nil, &buf)
+	if err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+// groupStats is the aggregate column list shared by the top-N queries.
+// Connections are counted, and latencies averaged, over final logs only.
+const groupStats = `countIf(status = 'success' AND NOT interim) AS count,
+	sum(bytes_in) AS total_bytes_in,
+	sum(bytes_out) AS total_bytes_out,
+	coalesce(avgOrNullIf(latency_ms, status = 'success' AND NOT interim), 0) AS avg_latency_ms`
+
+// GetTopDomains retrieves the top domains by connection count.
+func (r *ClickHouseRepository) GetTopDomains(
+	ctx context.Context, limit int, match Pattern,
+) ([]models.DomainStats, error) {
+	params := map[string]string{"limit": strconv.Itoa(limit)}
+	var stats []models.DomainStats
+	err := r.query(ctx, &stats, `SELECT domain, `+groupStats+`
+	FROM traffic_logs
+	WHERE domain != ''`+patternCondition("domain", match, params)+`
+	GROUP BY domain
+	ORDER BY count DESC
+	LIMIT {limit:UInt32}`, params)
+
+	return stats, err
+}
+
+// GetTopSourceIPs retrieves the top source IPs by connection count.
+func (r *ClickHouseRepository) GetTopSourceIPs(
+	ctx context.Context, limit int, match Pattern,
+) ([]models.SourceIPStats, error) {
+	params := map[string]string{"limit": strconv.Itoa(limit)}
+	var stats []models.SourceIPStats
+	err := r.query(ctx, &stats, `SELECT source_ip, `+groupStats+`
+	FROM traffic_logs
+	WHERE 1`+patternCondition("source_ip", match, params)+`
+	GROUP BY source_ip
+	ORDER BY count DESC
+	LIMIT {limit:UInt32}`, params)
+
+	return stats, err
+}
+
+// patternCondition returns an " AND" condition restricting column to match,
+// binding the LIKE pattern in params, or "" for the zero pattern. column must
+// be a trusted column name.
+func patternCondition(column string, match Pattern, params map[string]string) string {
+	if match.IsZero() {
+		return ""
+	}
+	params["pattern"] = match.Like()
+
+	return " AND " + column + " LIKE {pattern:String}"
+}
+
+// GetTopUsers retrieves the top authenticated proxy users by connection count.
+func (r *ClickHouseRepository) GetTopUsers(ctx context.Context, limit int) ([]models.UserStats, error) {
+	var stats []models.UserStats
+	err := r.query(ctx, &stats, `SELECT username, `+groupStats+`
+	FROM traffic_logs
+	WHERE username != ''
+	GROUP BY username
+	ORDER BY count DESC
+	LIMIT {limit:UInt32}`, map[string]string{"limit": strconv.Itoa(limit)})
+
+	return stats, err
+}
+
+// GetTopPorts retrieves the top destination ports by connection count.
+func (r *ClickHouseRepository) GetTopPorts(ctx context.Context, limit int) ([]models.PortStats, error) {
+	var stats []models.PortStats
+	err := r.query(ctx, &stats, `SELECT port, `+groupStats+`
+	FROM traffic_logs
+	GROUP BY port
+	ORDER BY count DESC
+	LIMIT {limit:UInt32}`, map[string]string{"limit": strconv.Itoa(limit)})
+
+	return stats, err
+}
+
+// GetDomainsForSourceIP retrieves the domains a source IP connected to in a
+// time range, most contacted first.
+func (r *ClickHouseRepository) GetDomainsForSourceIP(
+	ctx context.Context, sourceIP string, startTime, endTime time.Time, limit int,
+) ([]models.DomainStats, error) {
+	params := timeRange(startTime, endTime)
+	params["source_ip"] = sourceIP
+	params["limit"] = strconv.Itoa(limit)
+
+	var stats []models.DomainStats
+	err := r.query(ctx, &stats, `SELECT domain, `+groupStats+`
+	FROM traffic_logs
+	WHERE source_ip = {source_ip:String} AND domain != ''
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY domain
+	ORDER BY count DESC, domain
+	LIMIT {limit:UInt32}`, params)
+
+	return stats, err
+}
+
+// GetTrafficStats retrieves aggregate traffic statistics for a time range.
+func (r *ClickHouseRepository) GetTrafficStats(
+	ctx context.Context, startTime, endTime time.Time,
+) (*models.TrafficStats, error) {
+	var stats []models.TrafficStats
+	err := r.query(ctx, &stats, `SELECT
+		countIf(status = 'success' AND NOT interim) AS total_connections,
+		sum(bytes_in) AS total_bytes_in,
+		sum(bytes_out) AS total_bytes_out,
+		coalesce(avgOrNullIf(latency_ms, status = 'success' AND NOT interim), 0) AS avg_latency_ms,
+		coalesce(avgOrNullIf(first_byte_ms, status = 'success' AND NOT interim), 0) AS avg_first_byte_ms,
+		coalesce(avgOrNullIf(duration_ms, status = 'success' AND NOT interim), 0) AS avg_duration_ms,
+		maxIf(duration_ms, status = 'success' AND NOT interim) AS max_duration_ms
+	FROM traffic_logs
+	WHERE timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}`,
+		timeRange(startTime, endTime))
+	if err != nil || len(stats) == 0 {
+		return &models.TrafficStats{}, err
+	}
+
+	return &stats[0], nil
+}
+
+// GetTrafficByTimeRange retrieves paginated traffic logs for a time range.
+func (r *ClickHouseRepository) GetTrafficByTimeRange(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+) ([]models.TrafficLog, error) {
+	query, params := trafficQuery(startTime, endTime, limit, offset, filter)
+	var logs []models.TrafficLog
+	err := r.query(ctx, &logs, query, params)
+
+	return logs, err
+}
+
+// StreamTrafficByTimeRange calls fn with each log of a page of traffic logs
+// as rows arrive. See Repository.StreamTrafficByTimeRange.
+func (r *ClickHouseRepository) StreamTrafficByTimeRange(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+	fn func(*models.TrafficLog) error,
+) error {
+	query, params := trafficQuery(startTime, endTime, limit, offset, filter)
+	body, err := r.do(ctx, query+" FORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+
+	dec := json.NewDecoder(body)
+	for {
+		var log models.TrafficLog
+		if err := dec.Decode(&log); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode ClickHouse result: %w", err)
+		}
+		if err := fn(&log); err != nil {
+			return err
+		}
+	}
+}
+
+// trafficQuery builds the SELECT of a page of traffic logs and its
+// parameters.
+func trafficQuery(
+	startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+) (query string, params map[string]string) {
+	params = timeRange(startTime, endTime)
+	params["limit"] = strconv.Itoa(limit)
+	params["offset"] = strconv.Itoa(offset)
+
+	where := []string{"timestamp >= {start:DateTime64(3, 'UTC')}", "timestamp <= {end:DateTime64(3, 'UTC')}"}
+	if filter.SourceCIDR != "" {
+		// Only test values that parse as addresses so a stray malformed row
+		// can't fail the whole query.
+		where = append(where, "if(isIPv4String(source_ip) OR isIPv6String(source_ip), "+
+			"isIPAddressInRange(source_ip, {source_cidr:String}), 0)")
+		params["source_cidr"] = filter.SourceCIDR
+	}
+	if filter.SourceIP != "" {
+		where = append(where, "source_ip = {source_ip:String}")
+		params["source_ip"] = filter.SourceIP
+	}
+	if filter.Domain != "" {
+		where = append(where, "domain = {domain:String}")
+		params["domain"] = filter.Domain
+	}
+
+	return `SELECT *
+	FROM traffic_logs
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY timestamp DESC
+	LIMIT {limit:UInt32} OFFSET {offset:UInt32}`, params
+}
+
+// GetConcurrentConnections returns the peak and average number of
+// simultaneously open connections per bucket. See
+// PostgresRepository.GetConcurrentConnections.
+func (r *ClickHouseRepository) GetConcurrentConnections(
+	ctx context.Context, startTime, endTime time.Time, bucket time.Duration, smoothWindow int,
+) ([]models.ConcurrencyBucket, error) {
+	params := timeRange(startTime, endTime)
+	params["limit"] = strconv.Itoa(maxConcurrencyIntervals + 1)
+
+	var intervals []connectionInterval
+	err := r.query(ctx, &intervals, `SELECT timestamp - toIntervalMillisecond(duration_ms) AS Opened,
+		duration_ms AS DurationMs
+	FROM traffic_logs
+	WHERE status = 'success' AND NOT interim
+		AND timestamp >= {start:DateTime64(3, 'UTC')}
+		AND timestamp - toIntervalMillisecond(duration_ms) < {end:DateTime64(3, 'UTC')}
+	LIMIT {limit:UInt32}`, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIntervals(len(intervals)); err != nil {
+		return nil, err
+	}
+
+	return smoothConcurrency(computeConcurrency(intervals, startTime, endTime, bucket), smoothWindow), nil
+}
+
+// bucketIndex is the index of a log's bucket from the start of the range.
+const bucketIndex = `intDiv(toUnixTimestamp64Milli(timestamp) - toUnixTimestamp64Milli({start:DateTime64(3, 'UTC')}),
+		{bucket_ms:Int64})`
+
+// GetTrafficTimeSeries groups logs into fixed, epoch-aligned buckets by the
+// time they were emitted. See PostgresRepository.GetTrafficTimeSeries.
+func (r *ClickHouseRepository) GetTrafficTimeSeries(
+	ctx context.Context, startTime, endTime time.Time, interval time.Duration, smoothWindow int,
+) ([]models.TrafficBucket, error) {
+	if interval <= 0 {
+		return []models.TrafficBucket{}, nil
+	}
+	startTime = startTime.Truncate(interval)
+
+	params := timeRange(startTime, endTime)
+	params["bucket_ms"] = strconv.FormatInt(interval.Milliseconds(), 10)
+
+	var totals []bucketTotals
+	err := r.query(ctx, &totals, `SELECT `+bucketIndex+` AS Bucket,
+		countIf(status = 'success' AND NOT interim) AS Connections,
+		sum(bytes_in) AS BytesIn,
+		sum(bytes_out) AS BytesOut,
+		coalesce(avgOrNullIf(latency_ms, status = 'success' AND NOT interim), 0) AS AvgLatency
+	FROM traffic_logs
+	WHERE timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp < {end:DateTime64(3, 'UTC')}
+	GROUP BY Bucket`, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTimeSeries(totals, startTime, endTime, interval, smoothWindow), nil
+}
+
+// GetUserDailyUsage sums traffic per authenticated user per calendar day,
+// with day boundaries taken in loc (UTC when nil).
+func (r *ClickHouseRepository) GetUserDailyUsage(
+	ctx context.Context, startTime, endTime time.Time, loc *time.Location,
+) ([]models.UserDailyUsage, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	params := timeRange(startTime, endTime)
+	params["tz"] = loc.String()
+
+	var usage []models.UserDailyUsage
+	err := r.query(ctx, &usage, `SELECT username,
+		formatDateTime(toTimeZone(timestamp, {tz:String}), '%F') AS day,
+		countIf(status = 'success' AND NOT interim) AS count,
+		sum(bytes_in) AS total_bytes_in,
+		sum(bytes_out) AS total_bytes_out,
+		sum(bytes_in + bytes_out) AS total_bytes
+	FROM traffic_logs
+	WHERE username != ''
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY username, day
+	ORDER BY username, day`, params)
+
+	return usage, err
+}
+
+// GetByteUsage retrieves the bytes transferred per source IP or user.
+func (r *ClickHouseRepository) GetByteUsage(
+	ctx context.Context, startTime, endTime time.Time, filter UsageFilter,
+) ([]models.ByteUsage, error) {
+	column := filter.column()
+	params := timeRange(startTime, endTime)
+	params["key"] = filter.Key
+	params["min_bytes"] = strconv.FormatInt(filter.MinBytes, 10)
+
+	var usage []models.ByteUsage
+	err := r.query(ctx, &usage, `SELECT `+column+` AS key, sum(bytes_in + bytes_out) AS bytes
+	FROM traffic_logs
+	WHERE `+column+` != ''
+		AND ({key:String} = '' OR `+column+` = {key:String})
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY key
+	HAVING bytes >= {min_bytes:Int64}
+	ORDER BY bytes DESC`, params)
+
+	return usage, err
+}
+
+// GetSuspiciousConnections retrieves the most recent logs whose domain was
+// flagged as a likely homograph.
+func (r *ClickHouseRepository) GetSuspiciousConnections(
+	ctx context.Context, startTime, endTime time.Time, limit int,
+) ([]models.TrafficLog, error) {
+	params := timeRange(startTime, endTime)
+	params["limit"] = strconv.Itoa(limit)
+
+	var logs []models.TrafficLog
+	err := r.query(ctx, &logs, `SELECT *
+	FROM traffic_logs
+	WHERE suspicious AND status = 'success' AND NOT interim
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	ORDER BY timestamp DESC
+	LIMIT {limit:UInt32}`, params)
+
+	return logs, err
+}
+
+// GetRegionStats retrieves traffic statistics grouped by source region.
+func (r *ClickHouseRepository) GetRegionStats(
+	ctx context.Context, startTime, endTime time.Time,
+) ([]models.RegionStats, error) {
+	var stats []models.RegionStats
+	err := r.query(ctx, &stats, `SELECT region, `+groupStats+`
+	FROM traffic_logs
+	WHERE region != ''
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY region
+	ORDER BY count DESC`, timeRange(startTime, endTime))
+
+	return stats, err
+}
+
+// GetProtocolBreakdown retrieves traffic statistics grouped by protocol and
+// application category.
+func (r *ClickHouseRepository) GetProtocolBreakdown(
+	ctx context.Context, startTime, endTime time.Time,
+) ([]models.ProtocolStats, error) {
+	var stats []models.ProtocolStats
+	err := r.query(ctx, &stats, `SELECT protocol, `+portCategoryExpr()+` AS category, `+groupStats+`
+	FROM traffic_logs
+	WHERE timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY protocol, category
+	ORDER BY count DESC, protocol, category`, timeRange(startTime, endTime))
+
+	return stats, err
+}
+
+// GetCountryStats retrieves traffic statistics grouped by source or
+// destination country.
+func (r *ClickHouseRepository) GetCountryStats(
+	ctx context.Context, startTime, endTime time.Time, destination bool,
+) ([]models.CountryStats, error) {
+	column := countryColumn(destination)
+	var stats []models.CountryStats
+	err := r.query(ctx, &stats, `SELECT `+column+` AS country, `+groupStats+`
+	FROM traffic_logs
+	WHERE `+column+` != ''
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY country
+	ORDER BY count DESC`, timeRange(startTime, endTime))
+
+	return stats, err
+}
+
+// GetFailureStats counts failed connection attempts by status and source IP,
+// most frequent first.
+func (r *ClickHouseRepository) GetFailureStats(
+	ctx context.Context, startTime, endTime time.Time, limit int,
+) ([]models.FailureStats, error) {
+	params := timeRange(startTime, endTime)
+	params["limit"] = strconv.Itoa(limit)
+
+	var stats []models.FailureStats
+	err := r.query(ctx, &stats, `SELECT status, source_ip, count() AS count, max(timestamp) AS last_seen
+	FROM traffic_logs
+	WHERE status NOT IN ('success', 'accepted')
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp <= {end:DateTime64(3, 'UTC')}
+	GROUP BY status, source_ip
+	ORDER BY count DESC, last_seen DESC
+	LIMIT {limit:UInt32}`, params)
+
+	return stats, err
+}
+
+// GetTrafficGaps returns the buckets in which fewer than threshold
+// connections closed. See PostgresRepository.GetTrafficGaps.
+func (r *ClickHouseRepository) GetTrafficGaps(
+	ctx context.Context, startTime, endTime time.Time, bucket time.Duration, threshold int64,
+) ([]models.TrafficGap, error) {
+	if bucket <= 0 {
+		return []models.TrafficGap{}, nil
+	}
+
+	params := timeRange(startTime, endTime)
+	params["bucket_ms"] = strconv.FormatInt(bucket.Milliseconds(), 10)
+
+	var counts []bucketCount
+	err := r.query(ctx, &counts, `SELECT `+bucketIndex+` AS Bucket, count() AS Count
+	FROM traffic_logs
+	WHERE status = 'success' AND NOT interim
+		AND timestamp >= {start:DateTime64(3, 'UTC')} AND timestamp < {end:DateTime64(3, 'UTC')}
+	GROUP BY Bucket`, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return findGaps(counts, startTime, endTime, bucket, threshold), nil
+}
+
+// DeleteOlderThan deletes the logs with a timestamp before cutoff with a
+// single lightweight DELETE, which ClickHouse applies without locking the
+// table, so it isn't batched. The count is taken just before the delete.
+func (r *ClickHouseRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	params := map[string]string{"cutoff": cutoff.UTC().Format(clickHouseTime)}
+	var counts []struct {
+		Count int64 `json:"count"`
+	}
+	err := r.query(ctx, &counts,
+		`SELECT count() AS count FROM traffic_logs WHERE timestamp < {cutoff:DateTime64(3, 'UTC')}`, params)
+	if err != nil || len(counts) == 0 || counts[0].Count == 0 {
+		return 0, err
+	}
+
+	if err := r.exec(ctx,
+		`DELETE FROM traffic_logs WHERE timestamp < {cutoff:DateTime64(3, 'UTC')}`, params); err != nil {
+		return 0, err
+	}
+
+	return counts[0].Count, nil
+}
+
+// GetWhitelistEntries returns the managed whitelist entries.
+func (r *ClickHouseRepository) GetWhitelistEntries(ctx context.Context) ([]models.WhitelistEntry, error) {
+	var entries []models.WhitelistEntry
+	err := r.query(ctx, &entries, `SELECT entry, comment, created_at FROM ip_whitelist FINAL ORDER BY entry`, nil)
+
+	return entries, err
+}
+
+// AddWhitelistEntry inserts entry, keeping the creation time of an existing
+// entry for the same address.
+func (r *ClickHouseRepository) AddWhitelistEntry(ctx context.Context, entry *models.WhitelistEntry) error {
+	var existing []models.WhitelistEntry
+	err := r.query(ctx, &existing, `SELECT entry, comment, created_at FROM ip_whitelist FINAL
+		WHERE entry = {entry:String}`, map[string]string{"entry": entry.Entry})
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(existing) > 0:
+		entry.CreatedAt = existing[0].CreatedAt
+	case entry.CreatedAt.IsZero():
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+		return fmt.Errorf("failed to encode whitelist entry: %w", err)
+	}
+	body, err := r.do(ctx, "INSERT INTO ip_whitelist FORMAT JSONEachRow", nil, &buf)
+	if err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+// DeleteWhitelistEntry deletes the entry for address with a lightweight
+// DELETE.
+func (r *ClickHouseRepository) DeleteWhitelistEntry(ctx context.Context, address string) (bool, error) {
+	params := map[string]string{"entry": address}
+	var counts []struct {
+		Count int64 `json:"count"`
+	}
+	err := r.query(ctx, &counts, `SELECT count() AS count FROM ip_whitelist WHERE entry = {entry:String}`, params)
+	if err != nil || len(counts) == 0 || counts[0].Count == 0 {
+		return false, err
+	}
+
+	if err := r.exec(ctx, `DELETE FROM ip_whitelist WHERE entry = {entry:String}`, params); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Ping runs a trivial query to check that ClickHouse answers.
+func (r *ClickHouseRepository) Ping(ctx context.Context) error {
+	return r.exec(ctx, "SELECT 1", nil)
+}
+
+// Reconnect releases idle HTTP connections, so that the next queries open
+// new ones.
+func (r *ClickHouseRepository) Reconnect() error {
+	r.client.CloseIdleConnections()
+
+	return nil
+}
+
+// Close releases idle HTTP connections.
+func (r *ClickHouseRepository) Close() error {
+	r.client.CloseIdleConnections()
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/config"
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// fakeClickHouse answers ClickHouse HTTP requests with respond, after
+// recording the query and its URL parameters.
+func fakeClickHouse(t *testing.T, respond func(query string, params map[string]string, w http.ResponseWriter)) (
+	*ClickHouseRepository, *[]string,
+) {
+	t.Helper()
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-ClickHouse-User") != "analytics" {
+			http.Error(w, "Code: 516. Authentication failed", http.StatusUnauthorized)
+
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		query := req.URL.Query().Get("query")
+		if query == "" {
+			query = string(body)
+		} else {
+			query += "\n" + string(body)
+		}
+		queries = append(queries, query)
+
+		params := make(map[string]string)
+		for name, values := range req.URL.Query() {
+			if after, ok := strings.CutPrefix(name, "param_"); ok {
+				params[after] = values[0]
+			}
+		}
+		respond(query, params, w)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	cfg := &config.Config{}
+	cfg.Database.Host = host
+	cfg.Database.Port, _ = strconv.Atoi(port)
+	cfg.Database.User = "analytics"
+	cfg.Database.Database = "socksdb"
+	cfg.Database.SSLMode = "disable"
+
+	repo, err := NewClickHouseRepository(cfg)
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+
+	return repo, &queries
+}
+
+func TestClickHouseRepositorySaveAndQuery(t *testing.T) {
+	repo, queries := fakeClickHouse(t, func(query string, params map[string]string, w http.ResponseWriter) {
+		if strings.Contains(query, "GROUP BY domain") {
+			if params["limit"] != "5" {
+				t.Errorf("expected limit bound as a parameter, got %v", params)
+			}
+			_, _ = io.WriteString(w, `{"domain":"example.com","count":3,"total_bytes_in":300,`+
+				`"total_bytes_out":30,"avg_latency_ms":12.5}`+"\n"+
+				`{"domain":"example.org","count":1,"total_bytes_in":100,"total_bytes_out":10,"avg_latency_ms":4}`+"\n")
+		}
+	})
+
+	if !strings.Contains((*queries)[0], "CREATE TABLE IF NOT EXISTS traffic_logs") {
+		t.Errorf("expected the table to be created, got %q", (*queries)[0])
+	}
+
+	logs := []*models.TrafficLog{
+		{SourceIP: "10.0.0.1", Domain: "example.com", Port: 443, Timestamp: time.Now()},
+		{SourceIP: "10.0.0.2", Domain: "example.org", Port: 80, Timestamp: time.Now()},
+	}
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+	insert := (*queries)[len(*queries)-1]
+	if !strings.HasPrefix(insert, "INSERT INTO traffic_logs FORMAT JSONEachRow") ||
+		strings.Count(insert, `"source_ip"`) != 2 {
+		t.Errorf("expected a JSONEachRow insert of both logs, got %q", insert)
+	}
+
+	stats, err := repo.GetTopDomains(context.Background(), 5, Pattern{})
+	if err != nil {
+		t.Fatalf("failed to get top domains: %v", err)
+	}
+	if len(stats) != 2 || stats[0].Domain != "example.com" || stats[0].Count != 3 || stats[0].AvgLatency != 12.5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestClickHouseRepositoryTopDomainsPattern(t *testing.T) {
+	repo, queries := fakeClickHouse(t, func(_ string, params map[string]string, w http.ResponseWriter) {
+		if pattern, ok := params["pattern"]; ok && pattern != `%.a\_b.net` {
+			t.Errorf("expected the escaped pattern bound as a parameter, got %q", pattern)
+		}
+	})
+
+	match, _ := ParsePattern(`*.a_b.net`)
+	if _, err := repo.GetTopDomains(context.Background(), 5, match); err != nil {
+		t.Fatalf("failed to get top domains: %v", err)
+	}
+	if query := (*queries)[len(*queries)-1]; !strings.Contains(query, "domain LIKE {pattern:String}") {
+		t.Errorf("expected a LIKE filter, got %q", query)
+	}
+}
+
+func TestClickHouseRepositoryTrafficFilter(t *testing.T) {
+	repo, queries := fakeClickHouse(t, func(_ string, params map[string]string, w http.ResponseWriter) {
+		if cidr, ok := params["source_cidr"]; ok && cidr != "10.0.0.0/8" {
+			t.Errorf("expected the CIDR bound as a parameter, got %q", cidr)
+		}
+		_, _ = io.WriteString(w, `{"id":0,"uuid":null,"source_ip":"10.1.2.3","domain":"example.com",`+
+			`"suspicious":false,"port":443,"timestamp":"2024-03-01T12:00:00.250Z","first_byte_ms":null}`+"\n")
+	})
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	logs, err := repo.GetTrafficByTimeRange(context.Background(), start, start.Add(24*time.Hour), 10, 0,
+		TrafficFilter{SourceCIDR: "10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("failed to get traffic: %v", err)
+	}
+
+	query := (*queries)[len(*queries)-1]
+	if !strings.Contains(query, "isIPAddressInRange(source_ip, {source_cidr:String})") {
+		t.Errorf("expected a CIDR filter, got %q", query)
+	}
+	if len(logs) != 1 || logs[0].SourceIP != "10.1.2.3" ||
+		!logs[0].Timestamp.Equal(start.Add(12*time.Hour+250*time.Millisecond)) {
+		t.Errorf("unexpected logs %+v", logs)
+	}
+}
+
+func TestClickHouseRepositoryStreamTraffic(t *testing.T) {
+	repo, _ := fakeClickHouse(t, func(query string, _ map[string]string, w http.ResponseWriter) {
+		if strings.HasPrefix(query, "SELECT *") {
+			_, _ = io.WriteString(w, `{"id":0,"source_ip":"10.0.0.1","timestamp":"2024-03-01T12:00:01Z"}`+"\n"+
+				`{"id":0,"source_ip":"10.0.0.2","timestamp":"2024-03-01T12:00:00Z"}`+"\n")
+		}
+	})
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var sources []string
+	err := repo.StreamTrafficByTimeRange(context.Background(), start, start.Add(24*time.Hour), 10, 0, TrafficFilter{},
+		func(log *models.TrafficLog) error {
+			sources = append(sources, log.SourceIP)
+
+			return nil
+		})
+	if err != nil || len(sources) != 2 || sources[0] != "10.0.0.1" {
+		t.Fatalf("unexpected streamed logs %v: %v", sources, err)
+	}
+
+	stop := errors.New("client went away")
+	err = repo.StreamTrafficByTimeRange(context.Background(), start, start.Add(24*time.Hour), 10, 0, TrafficFilter{},
+		func(*models.TrafficLog) error {
+			return stop
+		})
+	if !errors.Is(err, stop) {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+}
+
+func TestClickHouseRepositoryReportsServerErrors(t *testing.T) {
+	repo, _ := fakeClickHouse(t, func(query string, _ map[string]string, w http.ResponseWriter) {
+		if strings.HasPrefix(query, "SELECT") {
+			http.Error(w, "Code: 60. DB::Exception: Table socksdb.traffic_logs does not exist", http.StatusNotFound)
+		}
+	})
+
+	_, err := repo.GetTopPorts(context.Background(), 10)
+	if err == nil || !strings.Contains(err.Error(), "Code: 60") {
+		t.Errorf("expected the ClickHouse error message, got %v", err)
+	}
+}
+
+func TestNewRepositoryRejectsUnknownDriver(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Database.Driver = "mysql"
+
+	if _, err := NewRepository(cfg); err == nil {
+		t.Error("expected an unknown driver to be rejected")
+	}
+}
+
+func TestCheckConnection(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		queries = append(queries, req.URL.Query().Get("query")+string(body))
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	cfg := &config.Config{}
+	cfg.Database.Driver = DriverClickHouse
+	cfg.Database.Host = host
+	cfg.Database.Port, _ = strconv.Atoi(port)
+
+	if err := CheckConnection(context.Background(), cfg); err != nil {
+		t.Fatalf("expected the check to pass, got %v", err)
+	}
+	// The check must not create or migrate tables.
+	if len(queries) != 1 || queries[0] != "SELECT 1" {
+		t.Errorf("expected a single SELECT 1, got %q", queries)
+	}
+
+	server.Close()
+	if err := CheckConnection(context.Background(), cfg); err == nil {
+		t.Error("expected the check to fail once the server is gone")
+	}
+
+	cfg.Database.Driver = DriverMemory
+	if err := CheckConnection(context.Background(), cfg); err != nil {
+		t.Errorf("expected the memory driver to pass, got %v", err)
+	}
+	cfg.Database.Driver = "oracle"
+	if err := CheckConnection(context.Background(), cfg); err == nil {
+		t.Error("expected an unknown driver to fail")
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// maxConcurrencyIntervals caps the connections loaded to compute concurrency,
+// so a wide range over a busy proxy cannot exhaust the API's memory.
+var maxConcurrencyIntervals = 1_000_000
+
+// ErrTooManyConnections is returned by GetConcurrentConnections when the range
+// holds more connections than it will load.
+var ErrTooManyConnections = errors.New("too many connections in range")
+
+// checkIntervals rejects a result that hit the maxConcurrencyIntervals+1 row
+// limit of the query.
+func checkIntervals(n int) error {
+	if n > maxConcurrencyIntervals {
+		return ErrTooManyConnections
+	}
+
+	return nil
+}
+
+// connectionInterval is the time span during which a connection was open.
+// Logs are stamped when they are emitted, so a connection opened duration_ms
+// before the timestamp of its final log.
+type connectionInterval struct {
+	Opened     time.Time
+	DurationMs int64
+}
+
+type concurrencyEvent struct {
+	at    time.Time
+	delta int64
+}
+
+// smoothConcurrency sets the moving average of AvgConcurrent over
+// smoothWindow buckets on each bucket when smoothWindow is positive.
+func smoothConcurrency(buckets []models.ConcurrencyBucket, smoothWindow int) []models.ConcurrencyBucket {
+	if smoothWindow <= 0 {
+		return buckets
+	}
+
+	values := make([]float64, len(buckets))
+	for i, b := range buckets {
+		values[i] = b.AvgConcurrent
+	}
+	for i, v := range movingAverage(values, smoothWindow) {
+		buckets[i].SmoothedAvgConcurrent = &v
+	}
+
+	return buckets
+}
+
+// computeConcurrency runs a sweep line over connection intervals and returns,
+// for each bucket in [start, end), the peak and time-weighted average number
+// of simultaneously open connections.
+func computeConcurrency(
+	intervals []connectionInterval, start, end time.Time, bucket time.Duration,
+) []models.ConcurrencyBucket {
+	if bucket <= 0 || !end.After(start) {
+		return []models.ConcurrencyBucket{}
+	}
+
+	events := make([]concurrencyEvent, 0, len(intervals)*2)
+	for _, iv := range intervals {
+		open := iv.Opened
+		closed := iv.Opened.Add(time.Duration(iv.DurationMs) * time.Millisecond)
+		if closed.Before(start) || !open.Before(end) {
+			continue
+		}
+
+		if open.Before(start) {
+			open = start
+		}
+		if closed.After(end) {
+			closed = end
+		}
+
+		events = append(events, concurrencyEvent{at: open, delta: 1}, concurrencyEvent{at: closed, delta: -1})
+	}
+
+	// Opens sort before closes at the same instant so zero-length and
+	// back-to-back connections still register in the peak.
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta > events[j].delta
+		}
+
+		return events[i].at.Before(events[j].at)
+	})
+
+	buckets := make([]models.ConcurrencyBucket, 0, int(end.Sub(start)/bucket)+1)
+	var current int64
+	idx := 0
+
+	for bucketStart := start; bucketStart.Before(end); bucketStart = bucketStart.Add(bucket) {
+		bucketEnd := bucketStart.Add(bucket)
+		if bucketEnd.After(end) {
+			bucketEnd = end
+		}
+
+		peak := current
+		last := bucketStart
+		var area float64
+
+		for idx < len(events) && events[idx].at.Before(bucketEnd) {
+			ev := events[idx]
+			area += float64(current) * float64(ev.at.Sub(last))
+			current += ev.delta
+			if current > peak {
+				peak = current
+			}
+			last = ev.at
+			idx++
+		}
+		area += float64(current) * float64(bucketEnd.Sub(last))
+
+		buckets = append(buckets, models.ConcurrencyBucket{
+			BucketStart:   bucketStart,
+			MaxConcurrent: peak,
+			AvgConcurrent: area / float64(bucketEnd.Sub(bucketStart)),
+		})
+	}
+
+	return buckets
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+func TestComputeConcurrency(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	intervals := []connectionInterval{
+		{Opened: base, DurationMs: 60_000},                        // open all of bucket 0
+		{Opened: base.Add(10 * time.Second), DurationMs: 20_000},  // 10s-30s
+		{Opened: base.Add(20 * time.Second), DurationMs: 5_000},   // 20s-25s
+		{Opened: base.Add(-30 * time.Second), DurationMs: 45_000}, // started before range, until 15s
+		{Opened: base.Add(90 * time.Second), DurationMs: 60_000},  // runs past the range end
+		{Opened: base.Add(-time.Hour), DurationMs: 1_000},         // entirely before the range
+		{Opened: base.Add(100 * time.Second), DurationMs: 0},      // zero-length connection
+		{Opened: base.Add(3 * time.Minute), DurationMs: 1_000},    // entirely after the range
+	}
+
+	buckets := computeConcurrency(intervals, base, base.Add(2*time.Minute), time.Minute)
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(buckets))
+	}
+
+	// Bucket 0: 0-10s: 2, 10-15s: 3, 15-20s: 2, 20-25s: 3, 25-30s: 2, 30-60s: 1.
+	if buckets[0].MaxConcurrent != 3 {
+		t.Errorf("expected peak 3 in bucket 0, got %d", buckets[0].MaxConcurrent)
+	}
+	expectedAvg := (2*10 + 3*5 + 2*5 + 3*5 + 2*5 + 1*30) / 60.0
+	if math.Abs(buckets[0].AvgConcurrent-expectedAvg) > 1e-9 {
+		t.Errorf("expected avg %.4f in bucket 0, got %.4f", expectedAvg, buckets[0].AvgConcurrent)
+	}
+
+	// Bucket 1: one connection from 90s to the range end, plus an instant one at 100s.
+	if buckets[1].MaxConcurrent != 2 {
+		t.Errorf("expected peak 2 in bucket 1, got %d", buckets[1].MaxConcurrent)
+	}
+	if math.Abs(buckets[1].AvgConcurrent-0.5) > 1e-9 {
+		t.Errorf("expected avg 0.5 in bucket 1, got %.4f", buckets[1].AvgConcurrent)
+	}
+	if !buckets[1].BucketStart.Equal(base.Add(time.Minute)) {
+		t.Errorf("unexpected bucket start %s", buckets[1].BucketStart)
+	}
+}
+
+func TestComputeConcurrencyEmpty(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	buckets := computeConcurrency(nil, base, base.Add(5*time.Minute), time.Minute)
+	if len(buckets) != 5 {
+		t.Fatalf("expected 5 zero-filled buckets, got %d", len(buckets))
+	}
+	for i, b := range buckets {
+		if b.MaxConcurrent != 0 || b.AvgConcurrent != 0 {
+			t.Errorf("expected empty bucket %d, got %+v", i, b)
+		}
+	}
+}
+
+func TestConcurrencyRejectsTooManyConnections(t *testing.T) {
+	limit := maxConcurrencyIntervals
+	maxConcurrencyIntervals = 2
+	t.Cleanup(func() { maxConcurrencyIntervals = limit })
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewInMemoryRepository(0)
+	for i := range 3 {
+		log := &models.TrafficLog{
+			Timestamp:  base.Add(time.Duration(i) * time.Second),
+			Status:     models.StatusSuccess,
+			DurationMs: 1_000,
+		}
+		if err := repo.SaveTrafficLog(context.Background(), log); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := repo.GetConcurrentConnections(context.Background(), base, base.Add(time.Minute), time.Minute, 0)
+	if !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("expected ErrTooManyConnections, got %v", err)
+	}
+
+	if _, err := repo.GetConcurrentConnections(
+		context.Background(), base.Add(1500*time.Millisecond), base.Add(time.Minute), time.Minute, 0,
+	); err != nil {
+		t.Fatalf("expected a narrower range to succeed, got %v", err)
+	}
+}
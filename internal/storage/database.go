@@ -2,17 +2,113 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/andev0x/socks5-proxy-analytics/internal/config"
 	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
 )
 
-// NewDatabase creates a new database connection using the provided configuration.
+// Database drivers selectable with database.driver.
+const (
+	DriverPostgres   = "postgres"
+	DriverClickHouse = "clickhouse"
+	DriverMemory     = "memory"
+)
+
+// NewRepository connects to the database selected by database.driver and
+// returns its repository. PostgreSQL is used when the driver is empty.
+func NewRepository(cfg *config.Config) (Repository, error) {
+	if cfg.Database.Driver != "" && cfg.Database.Driver != DriverPostgres &&
+		cfg.Database.OnConflict != "" && cfg.Database.OnConflict != OnConflictError {
+		return nil, fmt.Errorf("database.on_conflict %q is only supported by the postgres driver",
+			cfg.Database.OnConflict)
+	}
+
+	switch cfg.Database.Driver {
+	case "", DriverPostgres:
+		db, err := NewDatabase(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		repo := NewPostgresRepository(db)
+		repo.maxIdleConns = cfg.Database.MaxIdleConns
+		repo.SetInsertBatchSize(insertBatchSize(cfg))
+		repo.SetDeleteBatchSize(cfg.Retention.BatchSize)
+		if err := repo.SetConflictMode(cfg.Database.OnConflict, cfg.Database.DedupeColumn); err != nil {
+			_ = repo.Close()
+
+			return nil, err
+		}
+
+		return repo, nil
+	case DriverClickHouse:
+		return NewClickHouseRepository(cfg)
+	case DriverMemory:
+		return NewInMemoryRepository(cfg.Database.MemoryMaxLogs), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Database.Driver)
+	}
+}
+
+// insertBatchSize returns database.insert_batch_size, falling back to
+// pipeline.batch_size so a configured batch is written in one statement.
+func insertBatchSize(cfg *config.Config) int {
+	if cfg.Database.InsertBatchSize > 0 {
+		return cfg.Database.InsertBatchSize
+	}
+
+	return cfg.Pipeline.BatchSize
+}
+
+// CheckConnection connects to the configured database and pings it,
+// without creating or migrating any tables, then disconnects.
+func CheckConnection(ctx context.Context, cfg *config.Config) error {
+	switch cfg.Database.Driver {
+	case "", DriverPostgres:
+		db, err := openDatabase(cfg)
+		if err != nil {
+			return err
+		}
+		repo := NewPostgresRepository(db)
+		defer func() {
+			_ = repo.Close()
+		}()
+
+		return repo.Ping(ctx)
+	case DriverClickHouse:
+		return newClickHouseRepository(cfg).Ping(ctx)
+	case DriverMemory:
+		return nil
+	default:
+		return fmt.Errorf("unknown database driver %q", cfg.Database.Driver)
+	}
+}
+
+// NewDatabase creates a new database connection using the provided
+// configuration and migrates the schema.
 func NewDatabase(cfg *config.Config) (*gorm.DB, error) {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run migrations
+	if err := db.AutoMigrate(&models.TrafficLog{}, &models.WhitelistEntry{}); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return db, nil
+}
+
+// openDatabase connects to PostgreSQL with the configured pool limits.
+func openDatabase(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Database.Host,
@@ -30,9 +126,19 @@ func NewDatabase(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Run migrations
-	if err := db.AutoMigrate(&models.TrafficLog{}); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database handle: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetimeMs) * time.Millisecond)
+
+	// Query spans are no-ops unless tracing is configured. Bound values are
+	// left out since they carry client IPs and domains.
+	plugin := gormtracing.NewPlugin(gormtracing.WithoutMetrics(), gormtracing.WithoutQueryVariables())
+	if err := db.Use(plugin); err != nil {
+		return nil, fmt.Errorf("failed to enable query tracing: %w", err)
 	}
 
 	return db, nil
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// bucketCount is the number of connections that closed in one bucket,
+// identified by its index from the start of the range.
+type bucketCount struct {
+	Bucket int64
+	Count  int64
+}
+
+// findGaps zero-fills the buckets in [start, end) that had no connections and
+// returns every bucket whose count is below threshold, in time order.
+func findGaps(counts []bucketCount, start, end time.Time, bucket time.Duration, threshold int64) []models.TrafficGap {
+	gaps := []models.TrafficGap{}
+	if bucket <= 0 || !end.After(start) {
+		return gaps
+	}
+
+	byBucket := make(map[int64]int64, len(counts))
+	for _, c := range counts {
+		byBucket[c.Bucket] += c.Count
+	}
+
+	for i, bucketStart := int64(0), start; bucketStart.Before(end); i, bucketStart = i+1, bucketStart.Add(bucket) {
+		if count := byBucket[i]; count < threshold {
+			gaps = append(gaps, models.TrafficGap{BucketStart: bucketStart, Count: count})
+		}
+	}
+
+	return gaps
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindGapsZeroFills(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	counts := []bucketCount{
+		{Bucket: 0, Count: 10},
+		{Bucket: 1, Count: 2},
+		// Bucket 2 has no rows at all.
+		{Bucket: 3, Count: 8},
+	}
+
+	gaps := findGaps(counts, start, start.Add(5*time.Minute), time.Minute, 5)
+
+	want := []struct {
+		offset time.Duration
+		count  int64
+	}{
+		{offset: time.Minute, count: 2},
+		{offset: 2 * time.Minute, count: 0},
+		{offset: 4 * time.Minute, count: 0},
+	}
+	if len(gaps) != len(want) {
+		t.Fatalf("expected %d gaps, got %+v", len(want), gaps)
+	}
+	for i, w := range want {
+		if !gaps[i].BucketStart.Equal(start.Add(w.offset)) || gaps[i].Count != w.count {
+			t.Errorf("gap %d: expected %v with %d connections, got %+v", i, start.Add(w.offset), w.count, gaps[i])
+		}
+	}
+}
+
+func TestFindGapsInvalidRange(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if gaps := findGaps(nil, start, start, time.Minute, 1); len(gaps) != 0 {
+		t.Errorf("expected no gaps for an empty range, got %+v", gaps)
+	}
+	if gaps := findGaps(nil, start, start.Add(time.Hour), 0, 1); len(gaps) != 0 {
+		t.Errorf("expected no gaps for a zero bucket, got %+v", gaps)
+	}
+}
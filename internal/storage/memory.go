@@ -0,0 +1,686 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// InMemoryRepository implements Repository by keeping traffic logs in a slice
+// and computing statistics in Go. It needs no external database, which suits
+// tests and trying the proxy locally. Once maxLogs logs are stored the oldest
+// are discarded; maxLogs of 0 or less means no limit.
+type InMemoryRepository struct {
+	mu      sync.RWMutex
+	logs    []models.TrafficLog
+	maxLogs int
+	nextID  uint
+	// whitelist holds the managed whitelist entries by address.
+	whitelist map[string]models.WhitelistEntry
+}
+
+// NewInMemoryRepository creates an empty in-memory repository.
+func NewInMemoryRepository(maxLogs int) *InMemoryRepository {
+	return &InMemoryRepository{maxLogs: maxLogs}
+}
+
+// SaveTrafficLog stores a single traffic log.
+func (r *InMemoryRepository) SaveTrafficLog(ctx context.Context, log *models.TrafficLog) error {
+	return r.SaveTrafficLogs(ctx, []*models.TrafficLog{log})
+}
+
+// SaveTrafficLogs stores logs, assigning IDs, creation times and the default
+// status as the database would.
+func (r *InMemoryRepository) SaveTrafficLogs(_ context.Context, logs []*models.TrafficLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, log := range logs {
+		r.nextID++
+		log.ID = r.nextID
+		if log.CreatedAt.IsZero() {
+			log.CreatedAt = now
+		}
+		if log.Status == "" {
+			log.Status = models.StatusSuccess
+		}
+		r.logs = append(r.logs, *log)
+	}
+
+	if r.maxLogs > 0 && len(r.logs) > r.maxLogs {
+		// Copy so the discarded logs can be garbage collected.
+		r.logs = slices.Clone(r.logs[len(r.logs)-r.maxLogs:])
+	}
+
+	return nil
+}
+
+// snapshot returns the stored logs matching keep.
+func (r *InMemoryRepository) snapshot(keep func(*models.TrafficLog) bool) []models.TrafficLog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var logs []models.TrafficLog
+	for i := range r.logs {
+		if keep(&r.logs[i]) {
+			logs = append(logs, r.logs[i])
+		}
+	}
+
+	return logs
+}
+
+func between(startTime, endTime time.Time) func(*models.TrafficLog) bool {
+	return func(log *models.TrafficLog) bool {
+		return !log.Timestamp.Before(startTime) && !log.Timestamp.After(endTime)
+	}
+}
+
+func all(*models.TrafficLog) bool {
+	return true
+}
+
+// isConnection reports whether log is the final log of a connection that was
+// succeeded, i.e. one that counts towards connection totals.
+func isConnection(log *models.TrafficLog) bool {
+	return !log.Interim && log.Status == models.StatusSuccess
+}
+
+// groupTotals accumulates the connection count, bytes and latency of a group.
+// Interim logs add their bytes but are not counted as connections, and
+// neither are failed attempts.
+type groupTotals struct {
+	count      int64
+	bytesIn    int64
+	bytesOut   int64
+	latencySum int64
+}
+
+func (g groupTotals) avgLatency() float64 {
+	if g.count == 0 {
+		return 0
+	}
+
+	return float64(g.latencySum) / float64(g.count)
+}
+
+// groupBy totals logs by key, skipping logs whose key is the zero value when
+// skipEmpty is set, and returns the keys ordered by count descending, then by
+// key, together with their totals.
+func groupBy[K cmp.Ordered](
+	logs []models.TrafficLog, key func(*models.TrafficLog) K, skipEmpty bool,
+) ([]K, map[K]groupTotals) {
+	var zero K
+	totals := make(map[K]groupTotals)
+	for i := range logs {
+		k := key(&logs[i])
+		if skipEmpty && k == zero {
+			continue
+		}
+		g := totals[k]
+		if isConnection(&logs[i]) {
+			g.count++
+			g.latencySum += logs[i].LatencyMs
+		}
+		g.bytesIn += logs[i].BytesIn
+		g.bytesOut += logs[i].BytesOut
+		totals[k] = g
+	}
+
+	keys := make([]K, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		if c := cmp.Compare(totals[b].count, totals[a].count); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a, b)
+	})
+
+	return keys, totals
+}
+
+func limitTo[T any](items []T, limit int) []T {
+	if limit >= 0 && len(items) > limit {
+		return items[:limit]
+	}
+
+	return items
+}
+
+func domainStats(logs []models.TrafficLog, limit int) []models.DomainStats {
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) string { return log.Domain }, true)
+
+	stats := make([]models.DomainStats, 0, len(keys))
+	for _, k := range limitTo(keys, limit) {
+		g := totals[k]
+		stats = append(stats, models.DomainStats{
+			Domain: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats
+}
+
+// GetTopDomains retrieves the top domains by connection count.
+func (r *InMemoryRepository) GetTopDomains(
+	_ context.Context, limit int, match Pattern,
+) ([]models.DomainStats, error) {
+	return domainStats(r.snapshot(func(log *models.TrafficLog) bool { return match.Matches(log.Domain) }), limit), nil
+}
+
+// GetTopSourceIPs retrieves the top source IPs by connection count.
+func (r *InMemoryRepository) GetTopSourceIPs(
+	_ context.Context, limit int, match Pattern,
+) ([]models.SourceIPStats, error) {
+	logs := r.snapshot(func(log *models.TrafficLog) bool { return match.Matches(log.SourceIP) })
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) string { return log.SourceIP }, false)
+
+	stats := make([]models.SourceIPStats, 0, len(keys))
+	for _, k := range limitTo(keys, limit) {
+		g := totals[k]
+		stats = append(stats, models.SourceIPStats{
+			SourceIP: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetTopUsers retrieves the top authenticated proxy users by connection count.
+func (r *InMemoryRepository) GetTopUsers(_ context.Context, limit int) ([]models.UserStats, error) {
+	keys, totals := groupBy(r.snapshot(all), func(log *models.TrafficLog) string { return log.Username }, true)
+
+	stats := make([]models.UserStats, 0, len(keys))
+	for _, k := range limitTo(keys, limit) {
+		g := totals[k]
+		stats = append(stats, models.UserStats{
+			Username: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetTopPorts retrieves the top destination ports by connection count.
+func (r *InMemoryRepository) GetTopPorts(_ context.Context, limit int) ([]models.PortStats, error) {
+	keys, totals := groupBy(r.snapshot(all), func(log *models.TrafficLog) int { return log.Port }, false)
+
+	stats := make([]models.PortStats, 0, len(keys))
+	for _, k := range limitTo(keys, limit) {
+		g := totals[k]
+		stats = append(stats, models.PortStats{
+			Port: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetDomainsForSourceIP retrieves the domains a source IP connected to in a
+// time range, most contacted first.
+func (r *InMemoryRepository) GetDomainsForSourceIP(
+	_ context.Context, sourceIP string, startTime, endTime time.Time, limit int,
+) ([]models.DomainStats, error) {
+	inRange := between(startTime, endTime)
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		return log.SourceIP == sourceIP && inRange(log)
+	})
+
+	return domainStats(logs, limit), nil
+}
+
+// GetTrafficStats retrieves aggregate traffic statistics for a time range.
+func (r *InMemoryRepository) GetTrafficStats(
+	_ context.Context, startTime, endTime time.Time,
+) (*models.TrafficStats, error) {
+	var stats models.TrafficStats
+	var latencySum, durationSum, firstByteSum, firstByteCount int64
+	for _, log := range r.snapshot(between(startTime, endTime)) {
+		stats.TotalBytesIn += log.BytesIn
+		stats.TotalBytesOut += log.BytesOut
+		if !isConnection(&log) {
+			continue
+		}
+		stats.TotalConnections++
+		latencySum += log.LatencyMs
+		durationSum += log.DurationMs
+		stats.MaxDuration = max(stats.MaxDuration, log.DurationMs)
+		if log.FirstByteMs != nil {
+			firstByteSum += *log.FirstByteMs
+			firstByteCount++
+		}
+	}
+
+	if stats.TotalConnections > 0 {
+		stats.AvgLatency = float64(latencySum) / float64(stats.TotalConnections)
+		stats.AvgDuration = float64(durationSum) / float64(stats.TotalConnections)
+	}
+	if firstByteCount > 0 {
+		stats.AvgFirstByte = float64(firstByteSum) / float64(firstByteCount)
+	}
+
+	return &stats, nil
+}
+
+// newestFirst sorts logs by timestamp, most recent first.
+func newestFirst(logs []models.TrafficLog) {
+	slices.SortStableFunc(logs, func(a, b models.TrafficLog) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+}
+
+// GetTrafficByTimeRange retrieves paginated traffic logs for a time range.
+func (r *InMemoryRepository) GetTrafficByTimeRange(
+	_ context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+) ([]models.TrafficLog, error) {
+	var network *net.IPNet
+	if filter.SourceCIDR != "" {
+		var err error
+		if _, network, err = net.ParseCIDR(filter.SourceCIDR); err != nil {
+			return nil, fmt.Errorf("invalid source CIDR %q: %w", filter.SourceCIDR, err)
+		}
+	}
+
+	inRange := between(startTime, endTime)
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		if network != nil {
+			if ip := net.ParseIP(log.SourceIP); ip == nil || !network.Contains(ip) {
+				return false
+			}
+		}
+		if filter.SourceIP != "" && log.SourceIP != filter.SourceIP {
+			return false
+		}
+		if filter.Domain != "" && log.Domain != filter.Domain {
+			return false
+		}
+
+		return inRange(log)
+	})
+	newestFirst(logs)
+
+	if offset >= len(logs) {
+		return []models.TrafficLog{}, nil
+	}
+
+	return limitTo(logs[max(offset, 0):], limit), nil
+}
+
+// StreamTrafficByTimeRange calls fn with each log GetTrafficByTimeRange
+// returns. The logs are already in memory, so nothing is saved by streaming.
+func (r *InMemoryRepository) StreamTrafficByTimeRange(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+	fn func(*models.TrafficLog) error,
+) error {
+	logs, err := r.GetTrafficByTimeRange(ctx, startTime, endTime, limit, offset, filter)
+	if err != nil {
+		return err
+	}
+	for i := range logs {
+		if err := fn(&logs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetConcurrentConnections returns the peak and average number of
+// simultaneously open connections per bucket. See
+// PostgresRepository.GetConcurrentConnections.
+func (r *InMemoryRepository) GetConcurrentConnections(
+	_ context.Context, startTime, endTime time.Time, bucket time.Duration, smoothWindow int,
+) ([]models.ConcurrencyBucket, error) {
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		opened := log.Timestamp.Add(-time.Duration(log.DurationMs) * time.Millisecond)
+
+		return isConnection(log) && !log.Timestamp.Before(startTime) && opened.Before(endTime)
+	})
+	if err := checkIntervals(len(logs)); err != nil {
+		return nil, err
+	}
+
+	intervals := make([]connectionInterval, 0, len(logs))
+	for _, log := range logs {
+		intervals = append(intervals, connectionInterval{
+			Opened:     log.Timestamp.Add(-time.Duration(log.DurationMs) * time.Millisecond),
+			DurationMs: log.DurationMs,
+		})
+	}
+
+	return smoothConcurrency(computeConcurrency(intervals, startTime, endTime, bucket), smoothWindow), nil
+}
+
+// bucketOf returns the index of the bucket ts falls in, counting from start.
+func bucketOf(ts, start time.Time, bucket time.Duration) int64 {
+	return int64(ts.Sub(start) / bucket)
+}
+
+// GetTrafficTimeSeries groups logs into fixed, epoch-aligned buckets by the
+// time they were emitted. See PostgresRepository.GetTrafficTimeSeries.
+func (r *InMemoryRepository) GetTrafficTimeSeries(
+	_ context.Context, startTime, endTime time.Time, interval time.Duration, smoothWindow int,
+) ([]models.TrafficBucket, error) {
+	if interval <= 0 {
+		return []models.TrafficBucket{}, nil
+	}
+	startTime = startTime.Truncate(interval)
+
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		return !log.Timestamp.Before(startTime) && log.Timestamp.Before(endTime)
+	})
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) int64 {
+		return bucketOf(log.Timestamp, startTime, interval)
+	}, false)
+
+	series := make([]bucketTotals, 0, len(keys))
+	for _, k := range keys {
+		g := totals[k]
+		series = append(series, bucketTotals{
+			Bucket: k, Connections: g.count, BytesIn: g.bytesIn, BytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return buildTimeSeries(series, startTime, endTime, interval, smoothWindow), nil
+}
+
+// GetUserDailyUsage sums traffic per authenticated user per calendar day,
+// with day boundaries taken in loc (UTC when nil).
+func (r *InMemoryRepository) GetUserDailyUsage(
+	_ context.Context, startTime, endTime time.Time, loc *time.Location,
+) ([]models.UserDailyUsage, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	type userDay struct {
+		username string
+		day      string
+	}
+	byDay := make(map[userDay]*models.UserDailyUsage)
+	for _, log := range r.snapshot(between(startTime, endTime)) {
+		if log.Username == "" {
+			continue
+		}
+		key := userDay{log.Username, log.Timestamp.In(loc).Format(time.DateOnly)}
+		usage, ok := byDay[key]
+		if !ok {
+			usage = &models.UserDailyUsage{Username: key.username, Day: key.day}
+			byDay[key] = usage
+		}
+		if isConnection(&log) {
+			usage.Count++
+		}
+		usage.TotalBytesIn += log.BytesIn
+		usage.TotalBytesOut += log.BytesOut
+		usage.TotalBytes += log.BytesIn + log.BytesOut
+	}
+
+	usage := make([]models.UserDailyUsage, 0, len(byDay))
+	for _, u := range byDay {
+		usage = append(usage, *u)
+	}
+	slices.SortFunc(usage, func(a, b models.UserDailyUsage) int {
+		return cmp.Or(cmp.Compare(a.Username, b.Username), cmp.Compare(a.Day, b.Day))
+	})
+
+	return usage, nil
+}
+
+// GetByteUsage retrieves the bytes transferred per source IP or user.
+func (r *InMemoryRepository) GetByteUsage(
+	_ context.Context, startTime, endTime time.Time, filter UsageFilter,
+) ([]models.ByteUsage, error) {
+	bytes := make(map[string]int64)
+	for _, log := range r.snapshot(between(startTime, endTime)) {
+		key := log.SourceIP
+		if filter.ByUser {
+			key = log.Username
+		}
+		if key == "" || (filter.Key != "" && key != filter.Key) {
+			continue
+		}
+		bytes[key] += log.BytesIn + log.BytesOut
+	}
+
+	usage := make([]models.ByteUsage, 0, len(bytes))
+	for key, total := range bytes {
+		if total >= filter.MinBytes {
+			usage = append(usage, models.ByteUsage{Key: key, Bytes: total})
+		}
+	}
+	slices.SortFunc(usage, func(a, b models.ByteUsage) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Key, b.Key))
+	})
+
+	return usage, nil
+}
+
+// GetSuspiciousConnections retrieves the most recent logs whose domain was
+// flagged as a likely homograph.
+func (r *InMemoryRepository) GetSuspiciousConnections(
+	_ context.Context, startTime, endTime time.Time, limit int,
+) ([]models.TrafficLog, error) {
+	inRange := between(startTime, endTime)
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		return log.Suspicious && isConnection(log) && inRange(log)
+	})
+	newestFirst(logs)
+
+	return limitTo(logs, limit), nil
+}
+
+// GetRegionStats retrieves traffic statistics grouped by source region.
+func (r *InMemoryRepository) GetRegionStats(
+	_ context.Context, startTime, endTime time.Time,
+) ([]models.RegionStats, error) {
+	logs := r.snapshot(between(startTime, endTime))
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) string { return log.Region }, true)
+
+	stats := make([]models.RegionStats, 0, len(keys))
+	for _, k := range keys {
+		g := totals[k]
+		stats = append(stats, models.RegionStats{
+			Region: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetProtocolBreakdown retrieves traffic statistics grouped by protocol and
+// application category.
+func (r *InMemoryRepository) GetProtocolBreakdown(
+	_ context.Context, startTime, endTime time.Time,
+) ([]models.ProtocolStats, error) {
+	logs := r.snapshot(between(startTime, endTime))
+	// groupBy needs an ordered key, so the pair is joined with a separator
+	// neither part contains.
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) string {
+		return log.Protocol + "\x00" + models.CategoryOfPort(log.Port)
+	}, false)
+
+	stats := make([]models.ProtocolStats, 0, len(keys))
+	for _, k := range keys {
+		protocol, category, _ := strings.Cut(k, "\x00")
+		g := totals[k]
+		stats = append(stats, models.ProtocolStats{
+			Protocol: protocol, Category: category,
+			Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetCountryStats retrieves traffic statistics grouped by source or
+// destination country.
+func (r *InMemoryRepository) GetCountryStats(
+	_ context.Context, startTime, endTime time.Time, destination bool,
+) ([]models.CountryStats, error) {
+	logs := r.snapshot(between(startTime, endTime))
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) string {
+		if destination {
+			return log.DestCountry
+		}
+
+		return log.SourceCountry
+	}, true)
+
+	stats := make([]models.CountryStats, 0, len(keys))
+	for _, k := range keys {
+		g := totals[k]
+		stats = append(stats, models.CountryStats{
+			Country: k, Count: g.count, TotalBytesIn: g.bytesIn, TotalBytesOut: g.bytesOut, AvgLatency: g.avgLatency(),
+		})
+	}
+
+	return stats, nil
+}
+
+// GetFailureStats counts failed connection attempts by status and source IP,
+// most frequent first.
+func (r *InMemoryRepository) GetFailureStats(
+	_ context.Context, startTime, endTime time.Time, limit int,
+) ([]models.FailureStats, error) {
+	inRange := between(startTime, endTime)
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		return models.IsFailure(log.Status) && inRange(log)
+	})
+
+	type failureKey struct{ status, sourceIP string }
+	byKey := make(map[failureKey]*models.FailureStats)
+	stats := make([]*models.FailureStats, 0)
+	for _, log := range logs {
+		key := failureKey{log.Status, log.SourceIP}
+		s, ok := byKey[key]
+		if !ok {
+			s = &models.FailureStats{Status: log.Status, SourceIP: log.SourceIP}
+			byKey[key] = s
+			stats = append(stats, s)
+		}
+		s.Count++
+		if log.Timestamp.After(s.LastSeen) {
+			s.LastSeen = log.Timestamp
+		}
+	}
+
+	slices.SortStableFunc(stats, func(a, b *models.FailureStats) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+
+	result := make([]models.FailureStats, 0, len(stats))
+	for _, s := range limitTo(stats, limit) {
+		result = append(result, *s)
+	}
+
+	return result, nil
+}
+
+// GetTrafficGaps returns the buckets in which fewer than threshold
+// connections closed. See PostgresRepository.GetTrafficGaps.
+func (r *InMemoryRepository) GetTrafficGaps(
+	_ context.Context, startTime, endTime time.Time, bucket time.Duration, threshold int64,
+) ([]models.TrafficGap, error) {
+	if bucket <= 0 {
+		return []models.TrafficGap{}, nil
+	}
+
+	logs := r.snapshot(func(log *models.TrafficLog) bool {
+		return !log.Timestamp.Before(startTime) && log.Timestamp.Before(endTime)
+	})
+	keys, totals := groupBy(logs, func(log *models.TrafficLog) int64 {
+		return bucketOf(log.Timestamp, startTime, bucket)
+	}, false)
+
+	counts := make([]bucketCount, 0, len(keys))
+	for _, k := range keys {
+		counts = append(counts, bucketCount{Bucket: k, Count: totals[k].count})
+	}
+
+	return findGaps(counts, startTime, endTime, bucket, threshold), nil
+}
+
+// DeleteOlderThan deletes the logs with a timestamp before cutoff.
+func (r *InMemoryRepository) DeleteOlderThan(_ context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := len(r.logs)
+	r.logs = slices.DeleteFunc(r.logs, func(log models.TrafficLog) bool {
+		return log.Timestamp.Before(cutoff)
+	})
+
+	return int64(kept - len(r.logs)), nil
+}
+
+// GetWhitelistEntries returns the managed whitelist entries.
+func (r *InMemoryRepository) GetWhitelistEntries(_ context.Context) ([]models.WhitelistEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]models.WhitelistEntry, 0, len(r.whitelist))
+	for _, entry := range r.whitelist {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b models.WhitelistEntry) int {
+		return cmp.Compare(a.Entry, b.Entry)
+	})
+
+	return entries, nil
+}
+
+// AddWhitelistEntry stores entry, keeping the creation time of an existing
+// entry for the same address.
+func (r *InMemoryRepository) AddWhitelistEntry(_ context.Context, entry *models.WhitelistEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.whitelist[entry.Entry]; ok {
+		entry.CreatedAt = existing.CreatedAt
+	} else if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if r.whitelist == nil {
+		r.whitelist = make(map[string]models.WhitelistEntry)
+	}
+	r.whitelist[entry.Entry] = *entry
+
+	return nil
+}
+
+// DeleteWhitelistEntry deletes the entry for address.
+func (r *InMemoryRepository) DeleteWhitelistEntry(_ context.Context, address string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.whitelist[address]
+	delete(r.whitelist, address)
+
+	return ok, nil
+}
+
+// Ping always succeeds; there is no database to reach.
+func (r *InMemoryRepository) Ping(_ context.Context) error {
+	return nil
+}
+
+// Close is a no-op; the stored logs stay readable.
+func (r *InMemoryRepository) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// Pattern matches a column value exactly, or by prefix, suffix or substring
+// when it starts and/or ends with a "*" wildcard: "*.cdn.net" matches every
+// subdomain of cdn.net and "10.1.*" every value starting with "10.1.". The
+// zero Pattern matches everything.
+type Pattern struct {
+	value string
+	// leading and trailing record a wildcard at either end of value.
+	leading, trailing bool
+}
+
+// ParsePattern parses a filter with optional wildcards at its ends. A "*"
+// anywhere else is rejected.
+func ParsePattern(filter string) (Pattern, error) {
+	var p Pattern
+	p.value, p.leading = strings.CutPrefix(filter, "*")
+	p.value, p.trailing = strings.CutSuffix(p.value, "*")
+	if strings.Contains(p.value, "*") {
+		return Pattern{}, errors.New("wildcards are only allowed at the start or end of a filter")
+	}
+
+	return p, nil
+}
+
+// IsZero reports whether the pattern matches everything, as an empty filter
+// or one of only wildcards does.
+func (p Pattern) IsZero() bool {
+	return p.value == ""
+}
+
+// Like returns the pattern for a LIKE comparison using backslash as the
+// escape character, so "%", "_" and "\" in the filter match literally.
+func (p Pattern) Like() string {
+	like := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(p.value)
+	if p.leading {
+		like = "%" + like
+	}
+	if p.trailing {
+		like += "%"
+	}
+
+	return like
+}
+
+// Matches reports whether s matches the pattern.
+func (p Pattern) Matches(s string) bool {
+	switch {
+	case p.IsZero():
+		return true
+	case p.leading && p.trailing:
+		return strings.Contains(s, p.value)
+	case p.leading:
+		return strings.HasSuffix(s, p.value)
+	case p.trailing:
+		return strings.HasPrefix(s, p.value)
+	default:
+		return s == p.value
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import "testing"
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		filter  string
+		like    string
+		matches []string
+		misses  []string
+	}{
+		{filter: "", like: "", matches: []string{"", "example.com"}},
+		{filter: "example.com", like: "example.com", matches: []string{"example.com"}, misses: []string{"a.example.com"}},
+		{
+			filter: "*.cdn.net", like: "%.cdn.net",
+			matches: []string{"a.cdn.net", "x.y.cdn.net"}, misses: []string{"cdn.net", "a.cdn.net.evil"},
+		},
+		{filter: "10.1.*", like: `10.1.%`, matches: []string{"10.1.2.3"}, misses: []string{"10.10.2.3"}},
+		{filter: "*cdn*", like: "%cdn%", matches: []string{"cdn", "a.cdn.net"}, misses: []string{"example.com"}},
+		{filter: "*", like: "", matches: []string{"anything"}},
+		{
+			filter: `a_b%c\*`, like: `a\_b\%c\\%`,
+			matches: []string{`a_b%c\`, `a_b%c\d`}, misses: []string{`axb%c\`, `a_bzc\`},
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.filter)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", tt.filter, err)
+		}
+		if !p.IsZero() && p.Like() != tt.like {
+			t.Errorf("%q: expected LIKE pattern %q, got %q", tt.filter, tt.like, p.Like())
+		}
+		for _, s := range tt.matches {
+			if !p.Matches(s) {
+				t.Errorf("%q: expected %q to match", tt.filter, s)
+			}
+		}
+		for _, s := range tt.misses {
+			if p.Matches(s) {
+				t.Errorf("%q: expected %q not to match", tt.filter, s)
+			}
+		}
+	}
+
+	if _, err := ParsePattern("a*.example.com"); err == nil {
+		t.Error("expected a wildcard in the middle to be rejected")
+	}
+}
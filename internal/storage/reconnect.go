@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"go.uber.org/zap"
+)
+
+// Reconnector is implemented by repositories that can drop their pooled
+// connections, so that the next queries connect to the database afresh.
+type Reconnector interface {
+	Reconnect() error
+}
+
+// ReconnectingRepository wraps a Repository and watches its writes and
+// pings. After threshold consecutive failures it reports the database as
+// unhealthy, drops the pooled connections, which go stale when the database
+// restarts, and pings the database every interval until it answers again.
+// Failed writes are left to the caller to retry.
+type ReconnectingRepository struct {
+	Repository
+	threshold int
+	log       *zap.Logger
+
+	mu       sync.Mutex
+	failures int
+	healthy  atomic.Bool
+
+	down     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewReconnectingRepository wraps repo, reconnecting after threshold
+// consecutive failures. Values below 1 reconnect after every failure.
+func NewReconnectingRepository(repo Repository, threshold int, log *zap.Logger) *ReconnectingRepository {
+	r := &ReconnectingRepository{
+		Repository: repo,
+		threshold:  max(threshold, 1),
+		log:        log,
+		down:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	r.healthy.Store(true)
+
+	return r
+}
+
+// SaveTrafficLog saves log and records whether the database accepted it.
+func (r *ReconnectingRepository) SaveTrafficLog(ctx context.Context, log *models.TrafficLog) error {
+	return r.observe(ctx, r.Repository.SaveTrafficLog(ctx, log))
+}
+
+// SaveTrafficLogs saves logs and records whether the database accepted them.
+func (r *ReconnectingRepository) SaveTrafficLogs(ctx context.Context, logs []*models.TrafficLog) error {
+	return r.observe(ctx, r.Repository.SaveTrafficLogs(ctx, logs))
+}
+
+// Ping pings the database and records whether it answered.
+func (r *ReconnectingRepository) Ping(ctx context.Context) error {
+	return r.observe(ctx, r.Repository.Ping(ctx))
+}
+
+// Healthy reports whether the database is considered reachable: false from
+// the threshold-th consecutive failure until a write or ping succeeds.
+func (r *ReconnectingRepository) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// observe records the outcome of an operation and returns err. Errors of
+// operations cancelled by their caller say nothing about the database and
+// are not counted.
+func (r *ReconnectingRepository) observe(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		r.recovered()
+
+		return nil
+	}
+
+	r.failures++
+	if r.failures >= r.threshold && r.healthy.Load() {
+		r.healthy.Store(false)
+		r.log.Warn("database unhealthy after repeated failures, reconnecting",
+			zap.Int("failures", r.failures), zap.Error(err))
+		select {
+		case r.down <- struct{}{}:
+		default:
+		}
+	}
+
+	return err
+}
+
+// recovered resets the failure count. r.mu must be held.
+func (r *ReconnectingRepository) recovered() {
+	r.failures = 0
+	if !r.healthy.Load() {
+		r.log.Info("database connection recovered")
+		r.healthy.Store(true)
+	}
+}
+
+// Start reconnects whenever the database becomes unhealthy, retrying every
+// interval until it answers a ping, until Close.
+func (r *ReconnectingRepository) Start(interval time.Duration) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-r.down:
+			}
+
+			for !r.Healthy() && !r.reconnect(interval) {
+				select {
+				case <-r.stop:
+					return
+				case <-time.After(interval):
+				}
+			}
+		}
+	}()
+}
+
+// reconnect drops the pooled connections, if the repository supports it,
+// and reports whether the database then answers a ping within timeout.
+func (r *ReconnectingRepository) reconnect(timeout time.Duration) bool {
+	if reconnector, ok := r.Repository.(Reconnector); ok {
+		if err := reconnector.Reconnect(); err != nil {
+			r.log.Warn("failed to reset database connections", zap.Error(err))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := r.Repository.Ping(ctx); err != nil {
+		r.log.Warn("database still unreachable", zap.Error(err))
+
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recovered()
+
+	return true
+}
+
+// Close stops reconnecting and closes the wrapped repository.
+func (r *ReconnectingRepository) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.wg.Wait()
+
+	return r.Repository.Close()
+}
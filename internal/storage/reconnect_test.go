@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"go.uber.org/zap"
+)
+
+var errDatabaseDown = errors.New("connection refused")
+
+// flakyRepository is an in-memory repository whose writes and pings fail
+// while down is set, like a database that is restarting.
+type flakyRepository struct {
+	*InMemoryRepository
+	down       atomic.Bool
+	reconnects atomic.Int32
+}
+
+func (r *flakyRepository) SaveTrafficLogs(ctx context.Context, logs []*models.TrafficLog) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+
+	return r.InMemoryRepository.SaveTrafficLogs(ctx, logs)
+}
+
+func (r *flakyRepository) Ping(ctx context.Context) error {
+	if r.down.Load() {
+		return errDatabaseDown
+	}
+
+	return r.InMemoryRepository.Ping(ctx)
+}
+
+func (r *flakyRepository) Reconnect() error {
+	r.reconnects.Add(1)
+
+	return nil
+}
+
+func TestReconnectingRepositoryRecovers(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyRepository{InMemoryRepository: NewInMemoryRepository(0)}
+	repo := NewReconnectingRepository(flaky, 3, zap.NewNop())
+	repo.Start(10 * time.Millisecond)
+	defer func() {
+		_ = repo.Close()
+	}()
+
+	logs := []*models.TrafficLog{{SourceIP: "10.0.0.1", Timestamp: time.Now()}}
+	flaky.down.Store(true)
+	for i := 0; i < 2; i++ {
+		if err := repo.SaveTrafficLogs(ctx, logs); !errors.Is(err, errDatabaseDown) {
+			t.Fatalf("expected the write error to be returned, got %v", err)
+		}
+	}
+	if !repo.Healthy() {
+		t.Fatal("expected the database to stay healthy below the failure threshold")
+	}
+
+	_ = repo.SaveTrafficLogs(ctx, logs)
+	if repo.Healthy() {
+		t.Fatal("expected the database to be unhealthy after 3 consecutive failures")
+	}
+
+	// Let a few reconnection attempts fail before the database comes back.
+	time.Sleep(30 * time.Millisecond)
+	if repo.Healthy() {
+		t.Fatal("expected the database to stay unhealthy while it is down")
+	}
+	flaky.down.Store(false)
+
+	deadline := time.Now().Add(time.Second)
+	for !repo.Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the database to recover once it answers pings")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if flaky.reconnects.Load() < 2 {
+		t.Errorf("expected the pool to be reset on every attempt, got %d resets", flaky.reconnects.Load())
+	}
+
+	if err := repo.SaveTrafficLogs(ctx, logs); err != nil {
+		t.Fatalf("expected writes to succeed after recovery: %v", err)
+	}
+	if stored, _ := flaky.GetTrafficStats(ctx, time.Time{}, time.Now().Add(time.Minute)); stored.TotalConnections != 1 {
+		t.Errorf("expected the retried log to be stored, got %+v", stored)
+	}
+}
+
+func TestReconnectingRepositoryIgnoresCancelledWrites(t *testing.T) {
+	flaky := &flakyRepository{InMemoryRepository: NewInMemoryRepository(0)}
+	flaky.down.Store(true)
+	repo := NewReconnectingRepository(flaky, 1, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = repo.SaveTrafficLogs(ctx, []*models.TrafficLog{{SourceIP: "10.0.0.1"}})
+	if !repo.Healthy() {
+		t.Error("expected a write cancelled by its caller not to count as a failure")
+	}
+
+	_ = repo.SaveTrafficLogs(context.Background(), []*models.TrafficLog{{SourceIP: "10.0.0.1"}})
+	if repo.Healthy() {
+		t.Error("expected the database to be unhealthy after a failed write")
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("expected Close to succeed without Start: %v", err)
+	}
+}
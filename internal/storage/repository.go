@@ -3,33 +3,214 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andev0x/socks5-proxy-analytics/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository defines the interface for traffic log storage operations.
 type Repository interface {
 	SaveTrafficLog(ctx context.Context, log *models.TrafficLog) error
 	SaveTrafficLogs(ctx context.Context, logs []*models.TrafficLog) error
-	GetTopDomains(ctx context.Context, limit int) ([]models.DomainStats, error)
-	GetTopSourceIPs(ctx context.Context, limit int) ([]models.SourceIPStats, error)
+	GetTopDomains(ctx context.Context, limit int, match Pattern) ([]models.DomainStats, error)
+	GetTopSourceIPs(ctx context.Context, limit int, match Pattern) ([]models.SourceIPStats, error)
+	GetTopPorts(ctx context.Context, limit int) ([]models.PortStats, error)
+	GetTopUsers(ctx context.Context, limit int) ([]models.UserStats, error)
+	GetDomainsForSourceIP(
+		ctx context.Context, sourceIP string, startTime, endTime time.Time, limit int,
+	) ([]models.DomainStats, error)
 	GetTrafficStats(ctx context.Context, startTime, endTime time.Time) (*models.TrafficStats, error)
 	GetTrafficByTimeRange(
-		ctx context.Context, startTime, endTime time.Time, limit, offset int,
+		ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
 	) ([]models.TrafficLog, error)
+	// StreamTrafficByTimeRange calls fn with each log GetTrafficByTimeRange
+	// would return, in the same order, as it is read from the database, so a
+	// large page is never held in memory. It stops at the first error from fn
+	// and returns it.
+	StreamTrafficByTimeRange(
+		ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+		fn func(*models.TrafficLog) error,
+	) error
+	GetConcurrentConnections(
+		ctx context.Context, startTime, endTime time.Time, bucket time.Duration, smoothWindow int,
+	) ([]models.ConcurrencyBucket, error)
+	GetTrafficTimeSeries(
+		ctx context.Context, startTime, endTime time.Time, interval time.Duration, smoothWindow int,
+	) ([]models.TrafficBucket, error)
+	GetUserDailyUsage(
+		ctx context.Context, startTime, endTime time.Time, loc *time.Location,
+	) ([]models.UserDailyUsage, error)
+	GetSuspiciousConnections(
+		ctx context.Context, startTime, endTime time.Time, limit int,
+	) ([]models.TrafficLog, error)
+	GetRegionStats(ctx context.Context, startTime, endTime time.Time) ([]models.RegionStats, error)
+	// GetCountryStats groups traffic by source country, or by destination
+	// country when destination is set, leaving out logs without one.
+	GetCountryStats(
+		ctx context.Context, startTime, endTime time.Time, destination bool,
+	) ([]models.CountryStats, error)
+	GetFailureStats(
+		ctx context.Context, startTime, endTime time.Time, limit int,
+	) ([]models.FailureStats, error)
+	// GetProtocolBreakdown groups traffic by protocol and by the application
+	// category of the destination port, see models.CategoryOfPort.
+	GetProtocolBreakdown(ctx context.Context, startTime, endTime time.Time) ([]models.ProtocolStats, error)
+	GetTrafficGaps(
+		ctx context.Context, startTime, endTime time.Time, bucket time.Duration, threshold int64,
+	) ([]models.TrafficGap, error)
+	// GetByteUsage returns the bytes transferred per source IP, or per user,
+	// ordered by most bytes first.
+	GetByteUsage(ctx context.Context, startTime, endTime time.Time, filter UsageFilter) ([]models.ByteUsage, error)
+	// DeleteOlderThan deletes the logs with a timestamp before cutoff and
+	// returns how many were deleted.
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+	// GetWhitelistEntries returns the whitelist entries managed through the
+	// API, ordered by entry.
+	GetWhitelistEntries(ctx context.Context) ([]models.WhitelistEntry, error)
+	// AddWhitelistEntry stores entry, replacing the comment of an existing
+	// entry with the same address.
+	AddWhitelistEntry(ctx context.Context, entry *models.WhitelistEntry) error
+	// DeleteWhitelistEntry removes the entry for address and reports whether
+	// it existed.
+	DeleteWhitelistEntry(ctx context.Context, address string) (bool, error)
+	// Ping reports whether the database is reachable.
+	Ping(ctx context.Context) error
 	Close() error
 }
 
+// TrafficFilter narrows traffic log queries. Zero-valued fields match everything.
+type TrafficFilter struct {
+	// SourceCIDR keeps only logs whose source IP lies in the network, e.g. "10.0.0.0/8".
+	SourceCIDR string
+	// SourceIP keeps only logs from this exact source IP.
+	SourceIP string
+	// Domain keeps only logs for this exact requested domain.
+	Domain string
+}
+
+// UsageFilter narrows byte usage queries. Zero-valued fields match everything.
+type UsageFilter struct {
+	// ByUser groups by username instead of source IP, leaving out
+	// unauthenticated traffic.
+	ByUser bool
+	// Key keeps only this source IP or username.
+	Key string
+	// MinBytes keeps only those that transferred at least this many bytes.
+	MinBytes int64
+}
+
+// column returns the column usage is grouped by.
+func (f UsageFilter) column() string {
+	if f.ByUser {
+		return "username"
+	}
+
+	return "source_ip"
+}
+
+// defaultInsertBatchSize is the number of rows per INSERT statement when no
+// insert batch size is set.
+const defaultInsertBatchSize = 100
+
+// postgresMaxParams is the most bind parameters PostgreSQL accepts in one
+// statement, which bounds the rows per INSERT to this over the column count.
+const postgresMaxParams = 65535
+
+// defaultDeleteBatchSize is the number of rows per DELETE statement of
+// DeleteOlderThan when no batch size is set.
+const defaultDeleteBatchSize = 10000
+
+// defaultMaxIdleConns is the database/sql idle pool size, restored by
+// Reconnect unless NewRepository configured another.
+const defaultMaxIdleConns = 2
+
+// Conflict modes selectable with database.on_conflict. They decide what
+// SaveTrafficLogs does with a log whose dedupe column matches a stored row.
+const (
+	// OnConflictError fails the batch, leaving the stored row unchanged.
+	OnConflictError = "error"
+	// OnConflictIgnore skips the log and saves the rest of the batch.
+	OnConflictIgnore = "ignore"
+	// OnConflictUpdate overwrites the stored row with the log.
+	OnConflictUpdate = "update"
+)
+
 // PostgresRepository implements Repository using PostgreSQL.
 type PostgresRepository struct {
-	db *gorm.DB
+	db              *gorm.DB
+	insertBatchSize int
+	deleteBatchSize int
+	onConflict      clause.Expression
+	maxIdleConns    int
 }
 
 // NewPostgresRepository creates a new PostgreSQL repository.
 func NewPostgresRepository(db *gorm.DB) *PostgresRepository {
-	return &PostgresRepository{db: db}
+	return &PostgresRepository{
+		db:              db,
+		insertBatchSize: defaultInsertBatchSize,
+		deleteBatchSize: defaultDeleteBatchSize,
+		maxIdleConns:    defaultMaxIdleConns,
+	}
+}
+
+// SetInsertBatchSize sets the number of rows SaveTrafficLogs writes per
+// INSERT statement; larger batches are split. Values below 1 restore the
+// default of 100, and sizes needing more than PostgreSQL's 65535 bind
+// parameters are lowered to the most rows that fit.
+func (r *PostgresRepository) SetInsertBatchSize(size int) {
+	if size < 1 {
+		size = defaultInsertBatchSize
+	}
+	r.insertBatchSize = min(size, r.maxInsertBatchSize())
+}
+
+// maxInsertBatchSize is the most traffic logs one INSERT can bind.
+func (r *PostgresRepository) maxInsertBatchSize() int {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(&models.TrafficLog{}); err != nil || len(stmt.Schema.DBNames) == 0 {
+		return defaultInsertBatchSize
+	}
+
+	return postgresMaxParams / len(stmt.Schema.DBNames)
+}
+
+// SetDeleteBatchSize sets the number of rows DeleteOlderThan deletes per
+// statement. Values below 1 restore the default of 10000.
+func (r *PostgresRepository) SetDeleteBatchSize(size int) {
+	if size < 1 {
+		size = defaultDeleteBatchSize
+	}
+	r.deleteBatchSize = size
+}
+
+// SetConflictMode sets how SaveTrafficLogs handles logs whose column value
+// is already stored, making replays of the same batch idempotent. column
+// must carry a unique index, such as "uuid"; logs with a NULL value never
+// conflict.
+func (r *PostgresRepository) SetConflictMode(mode, column string) error {
+	target := []clause.Column{{Name: column}}
+
+	switch mode {
+	case "", OnConflictError:
+		r.onConflict = nil
+	case OnConflictIgnore:
+		r.onConflict = clause.OnConflict{Columns: target, DoNothing: true}
+	case OnConflictUpdate:
+		r.onConflict = clause.OnConflict{Columns: target, UpdateAll: true}
+	default:
+		return fmt.Errorf("unknown conflict mode %q", mode)
+	}
+
+	if r.onConflict != nil && column == "" {
+		return fmt.Errorf("conflict mode %q requires a dedupe column", mode)
+	}
+
+	return nil
 }
 
 // SaveTrafficLog saves a single traffic log to the database.
@@ -43,20 +224,27 @@ func (r *PostgresRepository) SaveTrafficLogs(ctx context.Context, logs []*models
 		return nil
 	}
 
-	return r.db.WithContext(ctx).CreateInBatches(logs, 100).Error
+	db := r.db.WithContext(ctx)
+	if r.onConflict != nil {
+		db = db.Clauses(r.onConflict)
+	}
+
+	return db.CreateInBatches(logs, r.insertBatchSize).Error
 }
 
 // GetTopDomains retrieves the top domains by connection count.
-func (r *PostgresRepository) GetTopDomains(ctx context.Context, limit int) ([]models.DomainStats, error) {
+func (r *PostgresRepository) GetTopDomains(
+	ctx context.Context, limit int, match Pattern,
+) ([]models.DomainStats, error) {
 	var stats []models.DomainStats
-	err := r.db.WithContext(ctx).
+	err := applyPattern(r.db.WithContext(ctx), "domain", match).
 		Table("traffic_logs").
 		Select(
 			"domain",
-			"COUNT(*) as count",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
 			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
 			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
-			"COALESCE(AVG(latency_ms), 0) as avg_latency",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
 		).
 		Where("domain != ''").
 		Group("domain").
@@ -68,16 +256,18 @@ func (r *PostgresRepository) GetTopDomains(ctx context.Context, limit int) ([]mo
 }
 
 // GetTopSourceIPs retrieves the top source IPs by connection count.
-func (r *PostgresRepository) GetTopSourceIPs(ctx context.Context, limit int) ([]models.SourceIPStats, error) {
+func (r *PostgresRepository) GetTopSourceIPs(
+	ctx context.Context, limit int, match Pattern,
+) ([]models.SourceIPStats, error) {
 	var stats []models.SourceIPStats
-	err := r.db.WithContext(ctx).
+	err := applyPattern(r.db.WithContext(ctx), "source_ip", match).
 		Table("traffic_logs").
 		Select(
 			"source_ip",
-			"COUNT(*) as count",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
 			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
 			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
-			"COALESCE(AVG(latency_ms), 0) as avg_latency",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
 		).
 		Group("source_ip").
 		Order("count DESC").
@@ -87,6 +277,84 @@ func (r *PostgresRepository) GetTopSourceIPs(ctx context.Context, limit int) ([]
 	return stats, err
 }
 
+// applyPattern restricts query to rows whose column matches match. column
+// must be a trusted column name; the pattern itself is bound as a parameter.
+func applyPattern(query *gorm.DB, column string, match Pattern) *gorm.DB {
+	if match.IsZero() {
+		return query
+	}
+
+	return query.Where(column+` LIKE ? ESCAPE '\'`, match.Like())
+}
+
+// GetTopUsers retrieves the top authenticated proxy users by connection count.
+// Logs recorded without authentication have no username and are excluded.
+func (r *PostgresRepository) GetTopUsers(ctx context.Context, limit int) ([]models.UserStats, error) {
+	var stats []models.UserStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"username",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Where("username <> ''").
+		Group("username").
+		Order("count DESC").
+		Limit(limit).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// GetTopPorts retrieves the top destination ports by connection count.
+func (r *PostgresRepository) GetTopPorts(ctx context.Context, limit int) ([]models.PortStats, error) {
+	var stats []models.PortStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"port",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Group("port").
+		Order("count DESC").
+		Limit(limit).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// GetDomainsForSourceIP retrieves the domains a source IP connected to in a
+// time range, most contacted first.
+func (r *PostgresRepository) GetDomainsForSourceIP(
+	ctx context.Context, sourceIP string, startTime, endTime time.Time, limit int,
+) ([]models.DomainStats, error) {
+	var stats []models.DomainStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"domain",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Where("source_ip = ?", sourceIP).
+		Where("domain != ''").
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group("domain").
+		Order("count DESC, domain").
+		Limit(limit).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
 // GetTrafficStats retrieves aggregate traffic statistics for a time range.
 func (r *PostgresRepository) GetTrafficStats(
 	ctx context.Context, startTime, endTime time.Time,
@@ -95,10 +363,13 @@ func (r *PostgresRepository) GetTrafficStats(
 	err := r.db.WithContext(ctx).
 		Table("traffic_logs").
 		Select(
-			"COUNT(*) as total_connections",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as total_connections",
 			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
 			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
-			"COALESCE(AVG(latency_ms), 0) as avg_latency",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+			"COALESCE(AVG(first_byte_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_first_byte",
+			"COALESCE(AVG(duration_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_duration",
+			"COALESCE(MAX(duration_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as max_duration",
 		).
 		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
 		Scan(&stats).Error
@@ -108,19 +379,424 @@ func (r *PostgresRepository) GetTrafficStats(
 
 // GetTrafficByTimeRange retrieves paginated traffic logs for a time range.
 func (r *PostgresRepository) GetTrafficByTimeRange(
-	ctx context.Context, startTime, endTime time.Time, limit, offset int,
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
 ) ([]models.TrafficLog, error) {
 	var logs []models.TrafficLog
+	err := r.trafficQuery(ctx, startTime, endTime, limit, offset, filter).Find(&logs).Error
+
+	return logs, err
+}
+
+// StreamTrafficByTimeRange calls fn with each log of a page of traffic logs
+// as rows arrive. See Repository.StreamTrafficByTimeRange.
+func (r *PostgresRepository) StreamTrafficByTimeRange(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+	fn func(*models.TrafficLog) error,
+) error {
+	query := r.trafficQuery(ctx, startTime, endTime, limit, offset, filter).Model(&models.TrafficLog{})
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var log models.TrafficLog
+		if err := query.ScanRows(rows, &log); err != nil {
+			return err
+		}
+		if err := fn(&log); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *PostgresRepository) trafficQuery(
+	ctx context.Context, startTime, endTime time.Time, limit, offset int, filter TrafficFilter,
+) *gorm.DB {
+	return applyTrafficFilter(r.db.WithContext(ctx), filter).
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Order("timestamp DESC").
+		Limit(limit).
+		Offset(offset)
+}
+
+// applyTrafficFilter adds the WHERE clauses for filter to query.
+func applyTrafficFilter(query *gorm.DB, filter TrafficFilter) *gorm.DB {
+	if filter.SourceCIDR != "" {
+		// source_ip is a text column; only cast values that look like addresses
+		// so a stray malformed row can't fail the whole query.
+		query = query.Where(
+			"CASE WHEN source_ip ~ '^[0-9A-Fa-f:.]+$' THEN source_ip::inet END <<= ?::cidr",
+			filter.SourceCIDR,
+		)
+	}
+	if filter.SourceIP != "" {
+		query = query.Where("source_ip = ?", filter.SourceIP)
+	}
+	if filter.Domain != "" {
+		query = query.Where("domain = ?", filter.Domain)
+	}
+
+	return query
+}
+
+// GetConcurrentConnections returns the peak and average number of
+// simultaneously open connections per bucket, treating each final log as open
+// from timestamp - duration_ms until its timestamp, when it was emitted on
+// close. When smoothWindow is positive, each
+// bucket also carries the moving average of the average over that many buckets.
+// It returns ErrTooManyConnections rather than load more than
+// maxConcurrencyIntervals connections.
+func (r *PostgresRepository) GetConcurrentConnections(
+	ctx context.Context, startTime, endTime time.Time, bucket time.Duration, smoothWindow int,
+) ([]models.ConcurrencyBucket, error) {
+	var intervals []connectionInterval
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select("timestamp - duration_ms * INTERVAL '1 millisecond' AS opened", "duration_ms").
+		Where("status = 'success' AND NOT interim").
+		Where("timestamp >= ?", startTime).
+		Where("timestamp - duration_ms * INTERVAL '1 millisecond' < ?", endTime).
+		Limit(maxConcurrencyIntervals + 1).
+		Scan(&intervals).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIntervals(len(intervals)); err != nil {
+		return nil, err
+	}
+
+	return smoothConcurrency(computeConcurrency(intervals, startTime, endTime, bucket), smoothWindow), nil
+}
+
+// GetTrafficTimeSeries groups logs by the time they were emitted into fixed
+// buckets of length interval in [startTime, endTime), with empty buckets
+// zero-filled. Buckets are aligned to multiples of interval since the Unix
+// epoch, so a 1h interval starts on the hour and a 24h interval at UTC
+// midnight. When smoothWindow is positive, each bucket also carries moving
+// averages over that many buckets.
+func (r *PostgresRepository) GetTrafficTimeSeries(
+	ctx context.Context, startTime, endTime time.Time, interval time.Duration, smoothWindow int,
+) ([]models.TrafficBucket, error) {
+	if interval <= 0 {
+		return []models.TrafficBucket{}, nil
+	}
+	startTime = startTime.Truncate(interval)
+
+	var totals []bucketTotals
 	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"FLOOR(EXTRACT(EPOCH FROM (timestamp - ?)) * 1000 / ?)::bigint as bucket, "+
+				"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as connections, "+
+				"COALESCE(SUM(bytes_in), 0) as bytes_in, "+
+				"COALESCE(SUM(bytes_out), 0) as bytes_out, "+
+				"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+			startTime, interval.Milliseconds(),
+		).
+		Where("timestamp >= ? AND timestamp < ?", startTime, endTime).
+		Group("bucket").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTimeSeries(totals, startTime, endTime, interval, smoothWindow), nil
+}
+
+// GetUserDailyUsage sums traffic per authenticated user per calendar day,
+// with day boundaries taken in loc (UTC when nil). Unauthenticated traffic
+// is excluded.
+func (r *PostgresRepository) GetUserDailyUsage(
+	ctx context.Context, startTime, endTime time.Time, loc *time.Location,
+) ([]models.UserDailyUsage, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var usage []models.UserDailyUsage
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"username, "+
+				"to_char(timestamp AT TIME ZONE ?, 'YYYY-MM-DD') as day, "+
+				"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count, "+
+				"COALESCE(SUM(bytes_in), 0) as total_bytes_in, "+
+				"COALESCE(SUM(bytes_out), 0) as total_bytes_out, "+
+				"COALESCE(SUM(bytes_in + bytes_out), 0) as total_bytes",
+			loc.String(),
+		).
+		Where("username != ''").
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group("username, day").
+		Order("username, day").
+		Scan(&usage).Error
+
+	return usage, err
+}
+
+// GetByteUsage retrieves the bytes transferred per source IP or user.
+func (r *PostgresRepository) GetByteUsage(
+	ctx context.Context, startTime, endTime time.Time, filter UsageFilter,
+) ([]models.ByteUsage, error) {
+	column := filter.column()
+	db := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(column+" as key", "COALESCE(SUM(bytes_in + bytes_out), 0) as bytes").
+		Where(column+" != ''").
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime)
+	if filter.Key != "" {
+		db = db.Where(column+" = ?", filter.Key)
+	}
+	db = db.Group(column)
+	if filter.MinBytes > 0 {
+		db = db.Having("SUM(bytes_in + bytes_out) >= ?", filter.MinBytes)
+	}
+
+	var usage []models.ByteUsage
+	err := db.Order("bytes DESC").Scan(&usage).Error
+
+	return usage, err
+}
+
+// GetSuspiciousConnections retrieves the most recent logs whose domain was
+// flagged as a likely homograph.
+func (r *PostgresRepository) GetSuspiciousConnections(
+	ctx context.Context, startTime, endTime time.Time, limit int,
+) ([]models.TrafficLog, error) {
+	var logs []models.TrafficLog
+	err := r.db.WithContext(ctx).
+		Where("suspicious = ?", true).
+		Where("status = 'success' AND NOT interim").
 		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
 		Order("timestamp DESC").
 		Limit(limit).
-		Offset(offset).
 		Find(&logs).Error
 
 	return logs, err
 }
 
+// GetRegionStats retrieves traffic statistics grouped by source region.
+func (r *PostgresRepository) GetRegionStats(
+	ctx context.Context, startTime, endTime time.Time,
+) ([]models.RegionStats, error) {
+	var stats []models.RegionStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"region",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Where("region != ''").
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group("region").
+		Order("count DESC").
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// GetProtocolBreakdown retrieves traffic statistics grouped by protocol and
+// application category.
+func (r *PostgresRepository) GetProtocolBreakdown(
+	ctx context.Context, startTime, endTime time.Time,
+) ([]models.ProtocolStats, error) {
+	var stats []models.ProtocolStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"protocol",
+			portCategoryExpr()+" as category",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group("protocol, category").
+		Order("count DESC, protocol, category").
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// portCategoryExpr returns a SQL expression evaluating to the application
+// category of a row's destination port, as models.CategoryOfPort would.
+func portCategoryExpr() string {
+	var expr strings.Builder
+	expr.WriteString("CASE")
+	for _, category := range models.PortCategories {
+		ports := make([]string, len(category.Ports))
+		for i, port := range category.Ports {
+			ports[i] = strconv.Itoa(port)
+		}
+		fmt.Fprintf(&expr, " WHEN port IN (%s) THEN '%s'", strings.Join(ports, ", "), category.Name)
+	}
+	fmt.Fprintf(&expr, " ELSE '%s' END", models.CategoryOther)
+
+	return expr.String()
+}
+
+// GetCountryStats retrieves traffic statistics grouped by source or
+// destination country.
+func (r *PostgresRepository) GetCountryStats(
+	ctx context.Context, startTime, endTime time.Time, destination bool,
+) ([]models.CountryStats, error) {
+	column := countryColumn(destination)
+	var stats []models.CountryStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			column+" as country",
+			"COUNT(*) FILTER (WHERE status = 'success' AND NOT interim) as count",
+			"COALESCE(SUM(bytes_in), 0) as total_bytes_in",
+			"COALESCE(SUM(bytes_out), 0) as total_bytes_out",
+			"COALESCE(AVG(latency_ms) FILTER (WHERE status = 'success' AND NOT interim), 0) as avg_latency",
+		).
+		Where(column+" != ''").
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group(column).
+		Order("count DESC").
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// countryColumn returns the column GetCountryStats groups by.
+func countryColumn(destination bool) string {
+	if destination {
+		return "dest_country"
+	}
+
+	return "source_country"
+}
+
+// GetFailureStats counts failed connection attempts by status and source IP,
+// most frequent first.
+func (r *PostgresRepository) GetFailureStats(
+	ctx context.Context, startTime, endTime time.Time, limit int,
+) ([]models.FailureStats, error) {
+	var stats []models.FailureStats
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select("status, source_ip, COUNT(*) as count, MAX(timestamp) as last_seen").
+		Where("status NOT IN ?", []string{models.StatusSuccess, models.StatusAccepted}).
+		Where("timestamp >= ? AND timestamp <= ?", startTime, endTime).
+		Group("status, source_ip").
+		Order("count DESC, last_seen DESC").
+		Limit(limit).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// GetTrafficGaps splits the range into buckets and returns those in which
+// fewer than threshold connections closed, by the timestamp of their final
+// log, including empty buckets, to highlight outages and quiet periods.
+func (r *PostgresRepository) GetTrafficGaps(
+	ctx context.Context, startTime, endTime time.Time, bucket time.Duration, threshold int64,
+) ([]models.TrafficGap, error) {
+	if bucket <= 0 {
+		return []models.TrafficGap{}, nil
+	}
+
+	var counts []bucketCount
+	err := r.db.WithContext(ctx).
+		Table("traffic_logs").
+		Select(
+			"FLOOR(EXTRACT(EPOCH FROM (timestamp - ?)) * 1000 / ?)::bigint as bucket, COUNT(*) as count",
+			startTime, bucket.Milliseconds(),
+		).
+		Where("status = 'success' AND NOT interim").
+		Where("timestamp >= ? AND timestamp < ?", startTime, endTime).
+		Group("bucket").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return findGaps(counts, startTime, endTime, bucket, threshold), nil
+}
+
+// DeleteOlderThan permanently deletes the logs with a timestamp before
+// cutoff, including soft-deleted ones. It deletes at most the delete batch
+// size per statement, each in its own short transaction, so a large backlog
+// never holds locks for long. On error it returns the rows deleted so far.
+func (r *PostgresRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		result := r.db.WithContext(ctx).Exec(
+			`DELETE FROM traffic_logs WHERE id IN (SELECT id FROM traffic_logs WHERE timestamp < ? LIMIT ?)`,
+			cutoff, r.deleteBatchSize,
+		)
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+		if result.RowsAffected < int64(r.deleteBatchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// GetWhitelistEntries returns the managed whitelist entries.
+func (r *PostgresRepository) GetWhitelistEntries(ctx context.Context) ([]models.WhitelistEntry, error) {
+	var entries []models.WhitelistEntry
+	err := r.db.WithContext(ctx).Order("entry").Find(&entries).Error
+
+	return entries, err
+}
+
+// AddWhitelistEntry inserts entry or updates the comment of an existing one,
+// reading back the stored row so an existing entry keeps its creation time.
+func (r *PostgresRepository) AddWhitelistEntry(ctx context.Context, entry *models.WhitelistEntry) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "entry"}},
+		DoUpdates: clause.AssignmentColumns([]string{"comment"}),
+	}, clause.Returning{}).Create(entry).Error
+}
+
+// DeleteWhitelistEntry deletes the entry for address.
+func (r *PostgresRepository) DeleteWhitelistEntry(ctx context.Context, address string) (bool, error) {
+	result := r.db.WithContext(ctx).Delete(&models.WhitelistEntry{}, "entry = ?", address)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// Ping checks that a connection to the database can be established.
+func (r *PostgresRepository) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+// Reconnect closes the idle pooled connections, which are stale once the
+// database has restarted, so that the next queries open new ones.
+func (r *PostgresRepository) Reconnect() error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	sqlDB.SetMaxIdleConns(0)
+	sqlDB.SetMaxIdleConns(r.maxIdleConns)
+
+	return nil
+}
+
 // Close closes the database connection.
 func (r *PostgresRepository) Close() error {
 	sqlDB, err := r.db.DB()
@@ -0,0 +1,910 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// repositoryContract is the behaviour every Repository must share, run
+// against PostgreSQL by TestPostgresRepository and against the in-memory
+// repository by TestInMemoryRepository.
+var repositoryContract = []struct {
+	name string
+	run  func(t *testing.T, repo Repository)
+}{
+	{"GetConcurrentConnections", testGetConcurrentConnections},
+	{"GetUserDailyUsage", testGetUserDailyUsage},
+	{"GetByteUsage", testGetByteUsage},
+	{"GetSuspiciousConnections", testGetSuspiciousConnections},
+	{"GetTrafficByTimeRangeSourceCIDR", testGetTrafficByTimeRangeSourceCIDR},
+	{"GetTrafficByTimeRangeSourceIPAndDomain", testGetTrafficByTimeRangeSourceIPAndDomain},
+	{"GetRegionStats", testGetRegionStats},
+	{"GetProtocolBreakdown", testGetProtocolBreakdown},
+	{"GetCountryStats", testGetCountryStats},
+	{"GetTrafficStatsDuration", testGetTrafficStatsDuration},
+	{"InterimAndFailedLogsAreNotConnections", testInterimAndFailedLogsAreNotConnections},
+	{"GetTopStatsPattern", testGetTopStatsPattern},
+	{"GetFailureStats", testGetFailureStats},
+	{"GetTopPorts", testGetTopPorts},
+	{"GetDomainsForSourceIP", testGetDomainsForSourceIP},
+	{"GetTrafficTimeSeries", testGetTrafficTimeSeries},
+	{"GetTopUsers", testGetTopUsers},
+	{"GetTrafficGaps", testGetTrafficGaps},
+	{"DeleteOlderThan", testDeleteOlderThan},
+	{"WhitelistEntries", testWhitelistEntries},
+}
+
+func TestPostgresRepository(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST not set, skipping PostgreSQL repository tests")
+	}
+
+	for _, tc := range repositoryContract {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, newTestRepository(t))
+		})
+	}
+}
+
+func TestInMemoryRepository(t *testing.T) {
+	for _, tc := range repositoryContract {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, NewInMemoryRepository(0))
+		})
+	}
+}
+
+// newTestRepository connects to the PostgreSQL instance described by the DB_*
+// environment variables and migrates a throwaway schema for the test. The
+// test is skipped when DB_HOST is not set.
+func newTestRepository(t *testing.T) Repository {
+	t.Helper()
+
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		t.Skip("DB_HOST not set, skipping PostgreSQL repository test")
+	}
+
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "5432"
+	}
+
+	dsn := fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"),
+	)
+	gormCfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+
+	admin, err := gorm.Open(postgres.Open(dsn), gormCfg)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn+" search_path="+schema), gormCfg)
+	if err != nil {
+		t.Fatalf("failed to connect to test schema: %v", err)
+	}
+	if err := db.AutoMigrate(&models.TrafficLog{}, &models.WhitelistEntry{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	repo := NewPostgresRepository(db)
+	t.Cleanup(func() {
+		_ = repo.Close()
+		_ = admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error
+		if sqlDB, err := admin.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return repo
+}
+
+// seedLogs saves logs through the repository, failing the test on error.
+func seedLogs(t *testing.T, repo Repository, logs ...*models.TrafficLog) {
+	t.Helper()
+
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to seed logs: %v", err)
+	}
+}
+
+func testGetConcurrentConnections(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Three connections overlapping during the first minute, one in the
+	// second. Logs are stamped when the connection closes.
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: base.Add(60 * time.Second), DurationMs: 60_000},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Timestamp: base.Add(30 * time.Second), DurationMs: 20_000},
+		&models.TrafficLog{SourceIP: "10.0.0.3", Timestamp: base.Add(25 * time.Second), DurationMs: 5_000},
+		&models.TrafficLog{SourceIP: "10.0.0.4", Timestamp: base.Add(120 * time.Second), DurationMs: 30_000},
+		// Ended before the range starts and must be ignored.
+		&models.TrafficLog{SourceIP: "10.0.0.5", Timestamp: base.Add(-time.Hour), DurationMs: 1_000},
+		// Opened after the range ends and must be ignored.
+		&models.TrafficLog{SourceIP: "10.0.0.6", Timestamp: base.Add(time.Hour), DurationMs: 1_000},
+	)
+
+	buckets, err := repo.GetConcurrentConnections(context.Background(), base, base.Add(2*time.Minute), time.Minute, 0)
+	if err != nil {
+		t.Fatalf("failed to get concurrency: %v", err)
+	}
+
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(buckets))
+	}
+	if buckets[0].MaxConcurrent != 3 {
+		t.Errorf("expected peak of 3 in first bucket, got %d", buckets[0].MaxConcurrent)
+	}
+	if buckets[1].MaxConcurrent != 1 {
+		t.Errorf("expected peak of 1 in second bucket, got %d", buckets[1].MaxConcurrent)
+	}
+}
+
+func testGetUserDailyUsage(t *testing.T, repo Repository) {
+	day1 := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Username: "alice", Timestamp: day1, BytesIn: 100, BytesOut: 10},
+		&models.TrafficLog{Username: "alice", Timestamp: day1.Add(time.Hour), BytesIn: 200, BytesOut: 20},
+		&models.TrafficLog{Username: "alice", Timestamp: day2, BytesIn: 400, BytesOut: 40},
+		&models.TrafficLog{Username: "bob", Timestamp: day1, BytesIn: 1000, BytesOut: 100},
+		// 23:30 UTC on day 1 is already day 2 in Berlin (UTC+1).
+		&models.TrafficLog{Username: "bob", Timestamp: day1.Add(13*time.Hour + 30*time.Minute), BytesIn: 5, BytesOut: 5},
+		// Unauthenticated traffic is not attributed to anyone.
+		&models.TrafficLog{Timestamp: day1, BytesIn: 9999},
+	)
+
+	start, end := day1.Add(-12*time.Hour), day2.Add(12*time.Hour)
+
+	usage, err := repo.GetUserDailyUsage(context.Background(), start, end, time.UTC)
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	want := []models.UserDailyUsage{
+		{Username: "alice", Day: "2025-01-01", Count: 2, TotalBytesIn: 300, TotalBytesOut: 30, TotalBytes: 330},
+		{Username: "alice", Day: "2025-01-02", Count: 1, TotalBytesIn: 400, TotalBytesOut: 40, TotalBytes: 440},
+		{Username: "bob", Day: "2025-01-01", Count: 2, TotalBytesIn: 1005, TotalBytesOut: 105, TotalBytes: 1110},
+	}
+	if len(usage) != len(want) {
+		t.Fatalf("expected %d rows, got %d: %+v", len(want), len(usage), usage)
+	}
+	for i := range want {
+		if usage[i] != want[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, want[i], usage[i])
+		}
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	usage, err = repo.GetUserDailyUsage(context.Background(), start, end, berlin)
+	if err != nil {
+		t.Fatalf("failed to get usage: %v", err)
+	}
+
+	var bobDays []string
+	for _, row := range usage {
+		if row.Username == "bob" {
+			bobDays = append(bobDays, row.Day)
+		}
+	}
+	if len(bobDays) != 2 || bobDays[0] != "2025-01-01" || bobDays[1] != "2025-01-02" {
+		t.Errorf("expected bob's late-night connection on the next Berlin day, got %v", bobDays)
+	}
+}
+
+func testGetByteUsage(t *testing.T, repo Repository) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.0.0.1", Username: "alice", Timestamp: now, BytesIn: 100, BytesOut: 10},
+		&models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: now, BytesIn: 200, Interim: true},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Username: "alice", Timestamp: now, BytesIn: 50},
+		&models.TrafficLog{SourceIP: "10.0.0.3", Username: "bob", Timestamp: now, BytesOut: 5},
+		// Outside the window.
+		&models.TrafficLog{SourceIP: "10.0.0.3", Timestamp: now.Add(-2 * time.Hour), BytesIn: 9999},
+	)
+
+	start, end := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name   string
+		filter UsageFilter
+		want   []models.ByteUsage
+	}{
+		{"by source IP", UsageFilter{}, []models.ByteUsage{
+			{Key: "10.0.0.1", Bytes: 310}, {Key: "10.0.0.2", Bytes: 50}, {Key: "10.0.0.3", Bytes: 5},
+		}},
+		{"by user", UsageFilter{ByUser: true}, []models.ByteUsage{{Key: "alice", Bytes: 160}, {Key: "bob", Bytes: 5}}},
+		{"one key", UsageFilter{Key: "10.0.0.2"}, []models.ByteUsage{{Key: "10.0.0.2", Bytes: 50}}},
+		{"minimum", UsageFilter{MinBytes: 50}, []models.ByteUsage{
+			{Key: "10.0.0.1", Bytes: 310}, {Key: "10.0.0.2", Bytes: 50},
+		}},
+	}
+	for _, tt := range tests {
+		usage, err := repo.GetByteUsage(context.Background(), start, end, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: failed to get usage: %v", tt.name, err)
+		}
+		if !slices.Equal(usage, tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, usage)
+		}
+	}
+}
+
+func testGetSuspiciousConnections(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Domain: "example.com", Timestamp: base},
+		&models.TrafficLog{
+			Domain: "xn--80ak6aa92e.com", PunycodeDecoded: "аррӏе.com", Suspicious: true, Timestamp: base,
+		},
+	)
+
+	logs, err := repo.GetSuspiciousConnections(context.Background(), base.Add(-time.Hour), base.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("failed to get suspicious connections: %v", err)
+	}
+	if len(logs) != 1 || logs[0].PunycodeDecoded != "аррӏе.com" {
+		t.Errorf("expected only the homograph connection, got %+v", logs)
+	}
+}
+
+func testGetTrafficByTimeRangeSourceCIDR(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.1.2.3", Timestamp: base},
+		&models.TrafficLog{SourceIP: "10.255.255.255", Timestamp: base},
+		&models.TrafficLog{SourceIP: "11.0.0.1", Timestamp: base},
+		&models.TrafficLog{SourceIP: "192.168.1.1", Timestamp: base},
+		&models.TrafficLog{SourceIP: "2001:db8::1", Timestamp: base},
+		&models.TrafficLog{SourceIP: "", Timestamp: base},
+	)
+
+	tests := []struct {
+		cidr string
+		want []string
+	}{
+		{cidr: "10.0.0.0/8", want: []string{"10.1.2.3", "10.255.255.255"}},
+		{cidr: "192.168.1.1/32", want: []string{"192.168.1.1"}},
+		{cidr: "2001:db8::/32", want: []string{"2001:db8::1"}},
+		{cidr: "172.16.0.0/12", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			logs, err := repo.GetTrafficByTimeRange(
+				context.Background(), base.Add(-time.Hour), base.Add(time.Hour), 100, 0,
+				TrafficFilter{SourceCIDR: tt.cidr},
+			)
+			if err != nil {
+				t.Fatalf("failed to query logs: %v", err)
+			}
+
+			got := make(map[string]bool, len(logs))
+			for _, log := range logs {
+				got[log.SourceIP] = true
+			}
+			if len(got) != len(tt.want) || len(logs) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for _, ip := range tt.want {
+				if !got[ip] {
+					t.Errorf("expected %s to be included", ip)
+				}
+			}
+		})
+	}
+}
+
+func testGetTrafficByTimeRangeSourceIPAndDomain(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.0.0.1", Domain: "example.com", Timestamp: base, Port: 1},
+		&models.TrafficLog{SourceIP: "10.0.0.1", Domain: "example.org", Timestamp: base, Port: 2},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Domain: "example.com", Timestamp: base, Port: 3},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Timestamp: base, Port: 4},
+	)
+
+	tests := []struct {
+		name   string
+		filter TrafficFilter
+		want   []int
+	}{
+		{"no filter", TrafficFilter{}, []int{1, 2, 3, 4}},
+		{"source ip", TrafficFilter{SourceIP: "10.0.0.1"}, []int{1, 2}},
+		{"domain", TrafficFilter{Domain: "example.com"}, []int{1, 3}},
+		{"both", TrafficFilter{SourceIP: "10.0.0.2", Domain: "example.com"}, []int{3}},
+		{"no match", TrafficFilter{SourceIP: "10.0.0.1", Domain: "example.net"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := repo.GetTrafficByTimeRange(
+				context.Background(), base.Add(-time.Hour), base.Add(time.Hour), 100, 0, tt.filter,
+			)
+			if err != nil {
+				t.Fatalf("failed to query logs: %v", err)
+			}
+
+			got := make([]int, 0, len(logs))
+			for _, log := range logs {
+				got = append(got, log.Port)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected ports %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func testGetRegionStats(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Region: "Europe", Timestamp: base, BytesIn: 100, LatencyMs: 10},
+		&models.TrafficLog{Region: "Europe", Timestamp: base, BytesIn: 300, LatencyMs: 30},
+		&models.TrafficLog{Region: "Asia", Timestamp: base, BytesIn: 50, LatencyMs: 5},
+		&models.TrafficLog{Timestamp: base, BytesIn: 9999},
+	)
+
+	stats, err := repo.GetRegionStats(context.Background(), base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get region stats: %v", err)
+	}
+
+	want := []models.RegionStats{
+		{Region: "Europe", Count: 2, TotalBytesIn: 400, AvgLatency: 20},
+		{Region: "Asia", Count: 1, TotalBytesIn: 50, AvgLatency: 5},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("expected %d regions, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, want[i], stats[i])
+		}
+	}
+}
+
+func testGetProtocolBreakdown(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Protocol: "tcp", Port: 443, Timestamp: base, BytesIn: 100, LatencyMs: 10},
+		&models.TrafficLog{Protocol: "tcp", Port: 80, Timestamp: base, BytesIn: 300, LatencyMs: 30},
+		&models.TrafficLog{Protocol: "tcp", Port: 22, Timestamp: base, BytesOut: 50, LatencyMs: 5},
+		&models.TrafficLog{Protocol: "udp", Port: 53, Timestamp: base, BytesIn: 20},
+		&models.TrafficLog{Protocol: "tcp", Port: 6379, Timestamp: base.Add(-2 * time.Hour), BytesIn: 9999},
+	)
+
+	stats, err := repo.GetProtocolBreakdown(context.Background(), base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get protocol breakdown: %v", err)
+	}
+
+	want := []models.ProtocolStats{
+		{Protocol: "tcp", Category: models.CategoryWeb, Count: 2, TotalBytesIn: 400, AvgLatency: 20},
+		{Protocol: "tcp", Category: models.CategorySSH, Count: 1, TotalBytesOut: 50, AvgLatency: 5},
+		{Protocol: "udp", Category: models.CategoryDNS, Count: 1, TotalBytesIn: 20},
+	}
+	if !slices.Equal(stats, want) {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func testGetCountryStats(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceCountry: "DE", DestCountry: "US", Timestamp: base, BytesIn: 100},
+		&models.TrafficLog{SourceCountry: "DE", DestCountry: "NL", Timestamp: base, BytesIn: 300},
+		&models.TrafficLog{SourceCountry: "FR", DestCountry: "US", Timestamp: base, BytesIn: 50},
+		&models.TrafficLog{Timestamp: base, BytesIn: 9999},
+	)
+	ctx := context.Background()
+
+	sources, err := repo.GetCountryStats(ctx, base.Add(-time.Hour), base.Add(time.Hour), false)
+	if err != nil {
+		t.Fatalf("failed to get source country stats: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Country != "DE" || sources[0].Count != 2 || sources[0].TotalBytesIn != 400 {
+		t.Errorf("unexpected source countries %+v", sources)
+	}
+
+	destinations, err := repo.GetCountryStats(ctx, base.Add(-time.Hour), base.Add(time.Hour), true)
+	if err != nil {
+		t.Fatalf("failed to get destination country stats: %v", err)
+	}
+	if len(destinations) != 2 || destinations[0].Country != "US" || destinations[0].TotalBytesIn != 150 {
+		t.Errorf("unexpected destination countries %+v", destinations)
+	}
+}
+
+func testGetTrafficStatsDuration(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Timestamp: base, DurationMs: 1_000},
+		&models.TrafficLog{Timestamp: base, DurationMs: 3_000},
+		// Outside the range.
+		&models.TrafficLog{Timestamp: base.Add(-2 * time.Hour), DurationMs: 60_000},
+	)
+
+	stats, err := repo.GetTrafficStats(context.Background(), base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get traffic stats: %v", err)
+	}
+	if stats.TotalConnections != 2 || stats.AvgDuration != 2_000 || stats.MaxDuration != 3_000 {
+		t.Errorf("expected 2 connections averaging 2000ms and peaking at 3000ms, got %+v", stats)
+	}
+}
+
+func testInterimAndFailedLogsAreNotConnections(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Domain: "example.com", Timestamp: base, BytesIn: 100, LatencyMs: 50, Interim: true},
+		&models.TrafficLog{Domain: "example.com", Timestamp: base, BytesIn: 20, LatencyMs: 50},
+		&models.TrafficLog{Domain: "example.com", Timestamp: base, BytesIn: 30, LatencyMs: 10},
+		&models.TrafficLog{Domain: "example.com", Timestamp: base, Status: models.StatusBlocked},
+	)
+
+	stats, err := repo.GetTrafficStats(context.Background(), base.Add(-time.Hour), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get traffic stats: %v", err)
+	}
+	if stats.TotalConnections != 2 || stats.TotalBytesIn != 150 || stats.AvgLatency != 30 {
+		t.Errorf("expected 2 connections, 150 bytes and 30ms latency, got %+v", stats)
+	}
+
+	domains, err := repo.GetTopDomains(context.Background(), 10, Pattern{})
+	if err != nil {
+		t.Fatalf("failed to get top domains: %v", err)
+	}
+	if len(domains) != 1 || domains[0].Count != 2 || domains[0].TotalBytesIn != 150 {
+		t.Errorf("expected 2 connections and 150 bytes for example.com, got %+v", domains)
+	}
+}
+
+func testGetTopStatsPattern(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.1.0.1", Domain: "a.cdn.net", Timestamp: base},
+		&models.TrafficLog{SourceIP: "10.1.0.1", Domain: "b.cdn.net", Timestamp: base},
+		&models.TrafficLog{SourceIP: "10.10.0.1", Domain: "cdn.net", Timestamp: base},
+		&models.TrafficLog{SourceIP: "10.10.0.1", Domain: "x_y.example", Timestamp: base},
+		&models.TrafficLog{SourceIP: "10.10.0.1", Domain: "xzy.example", Timestamp: base},
+	)
+	ctx := context.Background()
+
+	match, _ := ParsePattern("*.cdn.net")
+	domains, err := repo.GetTopDomains(ctx, 10, match)
+	if err != nil {
+		t.Fatalf("failed to get top domains: %v", err)
+	}
+	if len(domains) != 2 || domains[0].Domain == "cdn.net" || domains[1].Domain == "cdn.net" {
+		t.Errorf("expected only the subdomains of cdn.net, got %+v", domains)
+	}
+
+	// An underscore in the filter is literal, not a LIKE single-character wildcard.
+	match, _ = ParsePattern("x_y*")
+	if domains, err = repo.GetTopDomains(ctx, 10, match); err != nil || len(domains) != 1 ||
+		domains[0].Domain != "x_y.example" {
+		t.Errorf("expected only x_y.example, got %+v, %v", domains, err)
+	}
+
+	match, _ = ParsePattern("10.1.*")
+	ips, err := repo.GetTopSourceIPs(ctx, 10, match)
+	if err != nil {
+		t.Fatalf("failed to get top source IPs: %v", err)
+	}
+	if len(ips) != 1 || ips[0].SourceIP != "10.1.0.1" || ips[0].Count != 2 {
+		t.Errorf("expected only 10.1.0.1 with 2 connections, got %+v", ips)
+	}
+}
+
+func testGetFailureStats(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: base, Status: models.StatusAuthFailed},
+		&models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: base.Add(time.Minute), Status: models.StatusAuthFailed},
+		&models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: base, Status: models.StatusBlocked},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Timestamp: base, Status: models.StatusDialFailed},
+		&models.TrafficLog{SourceIP: "10.0.0.2", Timestamp: base},
+		// Accepted connections are not failures.
+		&models.TrafficLog{SourceIP: "10.0.0.3", Timestamp: base, Status: models.StatusAccepted},
+		&models.TrafficLog{SourceIP: "10.0.0.3", Timestamp: base, Status: models.StatusAccepted},
+		&models.TrafficLog{SourceIP: "10.0.0.3", Timestamp: base, Status: models.StatusAccepted},
+	)
+
+	stats, err := repo.GetFailureStats(context.Background(), base.Add(-time.Hour), base.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatalf("failed to get failure stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 groups, got %+v", stats)
+	}
+	if stats[0].Status != models.StatusAuthFailed || stats[0].SourceIP != "10.0.0.1" || stats[0].Count != 2 ||
+		!stats[0].LastSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("expected 2 auth failures from 10.0.0.1 first, got %+v", stats[0])
+	}
+	if stats[1].Count != 1 {
+		t.Errorf("expected a single failure next, got %+v", stats[1])
+	}
+}
+
+func testGetTopPorts(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Port: 443, Timestamp: base, BytesIn: 100, LatencyMs: 10},
+		&models.TrafficLog{Port: 443, Timestamp: base, BytesIn: 300, LatencyMs: 30},
+		&models.TrafficLog{Port: 443, Timestamp: base, BytesOut: 20, LatencyMs: 20},
+		&models.TrafficLog{Port: 80, Timestamp: base, BytesIn: 50, LatencyMs: 5},
+		&models.TrafficLog{Port: 80, Timestamp: base, BytesIn: 50, LatencyMs: 15},
+		&models.TrafficLog{Port: 6667, Timestamp: base, BytesIn: 1, LatencyMs: 1},
+	)
+
+	stats, err := repo.GetTopPorts(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("failed to get top ports: %v", err)
+	}
+
+	want := []models.PortStats{
+		{Port: 443, Count: 3, TotalBytesIn: 400, TotalBytesOut: 20, AvgLatency: 20},
+		{Port: 80, Count: 2, TotalBytesIn: 100, AvgLatency: 10},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("expected %d ports, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, want[i], stats[i])
+		}
+	}
+}
+
+func testGetDomainsForSourceIP(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	const ip = "192.168.1.10"
+
+	seedLogs(t, repo,
+		&models.TrafficLog{SourceIP: ip, Domain: "example.com", Timestamp: base, BytesIn: 100},
+		&models.TrafficLog{SourceIP: ip, Domain: "example.com", Timestamp: base, BytesIn: 200},
+		&models.TrafficLog{SourceIP: ip, Domain: "example.com", Timestamp: base, BytesIn: 300},
+		&models.TrafficLog{SourceIP: ip, Domain: "cdn.example.net", Timestamp: base, BytesIn: 50},
+		&models.TrafficLog{SourceIP: ip, Domain: "cdn.example.net", Timestamp: base, BytesIn: 50},
+		&models.TrafficLog{SourceIP: ip, Domain: "api.example.org", Timestamp: base, BytesIn: 10},
+		// Other sources, connections by IP and traffic outside the range are excluded.
+		&models.TrafficLog{SourceIP: "192.168.1.11", Domain: "example.com", Timestamp: base},
+		&models.TrafficLog{SourceIP: ip, Timestamp: base},
+		&models.TrafficLog{SourceIP: ip, Domain: "api.example.org", Timestamp: base.Add(-2 * time.Hour)},
+	)
+
+	domains, err := repo.GetDomainsForSourceIP(context.Background(), ip, base.Add(-time.Hour), base.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("failed to get domains for source IP: %v", err)
+	}
+
+	want := []models.DomainStats{
+		{Domain: "example.com", Count: 3, TotalBytesIn: 600},
+		{Domain: "cdn.example.net", Count: 2, TotalBytesIn: 100},
+		{Domain: "api.example.org", Count: 1, TotalBytesIn: 10},
+	}
+	if len(domains) != len(want) {
+		t.Fatalf("expected %d domains, got %+v", len(want), domains)
+	}
+	for i := range want {
+		if domains[i] != want[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, want[i], domains[i])
+		}
+	}
+}
+
+func testGetTrafficTimeSeries(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Timestamp: base.Add(5 * time.Minute), BytesIn: 100, BytesOut: 10, LatencyMs: 10},
+		&models.TrafficLog{Timestamp: base.Add(50 * time.Minute), BytesIn: 300, BytesOut: 30, LatencyMs: 30},
+		&models.TrafficLog{Timestamp: base.Add(2*time.Hour + time.Minute), BytesIn: 50, LatencyMs: 5},
+	)
+
+	// The range starts mid-hour; buckets still align to the hour.
+	series, err := repo.GetTrafficTimeSeries(
+		context.Background(), base.Add(time.Minute), base.Add(3*time.Hour), time.Hour, 0,
+	)
+	if err != nil {
+		t.Fatalf("failed to get time series: %v", err)
+	}
+
+	if len(series) != 3 {
+		t.Fatalf("expected 3 buckets, got %+v", series)
+	}
+	if !series[0].BucketStart.Equal(base) {
+		t.Errorf("expected first bucket at %v, got %v", base, series[0].BucketStart)
+	}
+	if series[0].Connections != 2 || series[0].BytesIn != 400 || series[0].BytesOut != 40 || series[0].AvgLatency != 20 {
+		t.Errorf("unexpected first bucket %+v", series[0])
+	}
+	if series[1].Connections != 0 {
+		t.Errorf("expected empty second bucket, got %+v", series[1])
+	}
+	if series[2].Connections != 1 || series[2].BytesIn != 50 {
+		t.Errorf("unexpected third bucket %+v", series[2])
+	}
+}
+
+func testGetTopUsers(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Username: "alice", Timestamp: base, BytesIn: 100, LatencyMs: 10},
+		&models.TrafficLog{Username: "alice", Timestamp: base, BytesOut: 40, LatencyMs: 30},
+		&models.TrafficLog{Username: "bob", Timestamp: base, BytesIn: 5, LatencyMs: 5},
+		// Unauthenticated traffic is excluded even though it is the most frequent.
+		&models.TrafficLog{Timestamp: base},
+		&models.TrafficLog{Timestamp: base},
+		&models.TrafficLog{Timestamp: base},
+	)
+
+	stats, err := repo.GetTopUsers(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("failed to get top users: %v", err)
+	}
+
+	want := []models.UserStats{
+		{Username: "alice", Count: 2, TotalBytesIn: 100, TotalBytesOut: 40, AvgLatency: 20},
+		{Username: "bob", Count: 1, TotalBytesIn: 5, AvgLatency: 5},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("expected %d users, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("row %d: expected %+v, got %+v", i, want[i], stats[i])
+		}
+	}
+}
+
+func testGetTrafficGaps(t *testing.T, repo Repository) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Steady traffic every 10s for 5 minutes, except a silent third minute.
+	var logs []*models.TrafficLog
+	for offset := time.Duration(0); offset < 5*time.Minute; offset += 10 * time.Second {
+		if offset >= 2*time.Minute && offset < 3*time.Minute {
+			continue
+		}
+		logs = append(logs, &models.TrafficLog{SourceIP: "10.0.0.1", Timestamp: base.Add(offset)})
+	}
+	seedLogs(t, repo, logs...)
+
+	gaps, err := repo.GetTrafficGaps(context.Background(), base, base.Add(5*time.Minute), time.Minute, 3)
+	if err != nil {
+		t.Fatalf("failed to get gaps: %v", err)
+	}
+
+	if len(gaps) != 1 {
+		t.Fatalf("expected exactly one gap, got %+v", gaps)
+	}
+	if !gaps[0].BucketStart.Equal(base.Add(2*time.Minute)) || gaps[0].Count != 0 {
+		t.Errorf("expected empty bucket at %v, got %+v", base.Add(2*time.Minute), gaps[0])
+	}
+}
+
+func TestInMemoryRepositoryDiscardsOldestPastCap(t *testing.T) {
+	repo := NewInMemoryRepository(2)
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	seedLogs(t, repo,
+		&models.TrafficLog{Domain: "a.example", Timestamp: base},
+		&models.TrafficLog{Domain: "b.example", Timestamp: base.Add(time.Second)},
+	)
+	third := &models.TrafficLog{Domain: "c.example", Timestamp: base.Add(2 * time.Second)}
+	seedLogs(t, repo, third)
+
+	if third.ID != 3 {
+		t.Errorf("expected the saved log to be assigned ID 3, got %d", third.ID)
+	}
+
+	logs, err := repo.GetTrafficByTimeRange(context.Background(), base, base.Add(time.Minute), 10, 0, TrafficFilter{})
+	if err != nil {
+		t.Fatalf("failed to get traffic: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Domain != "c.example" || logs[1].Domain != "b.example" {
+		t.Errorf("expected only the 2 newest logs to be kept, got %+v", logs)
+	}
+}
+
+// dryRunRepository returns a PostgresRepository that builds SQL without a
+// database, and the INSERT statements it generates.
+func dryRunRepository(t *testing.T) (*PostgresRepository, *[]string) {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry-run database: %v", err)
+	}
+
+	var inserts []string
+	err = db.Callback().Create().After("gorm:create").Register("test:capture", func(tx *gorm.DB) {
+		inserts = append(inserts, tx.Statement.SQL.String())
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+
+	return NewPostgresRepository(db), &inserts
+}
+
+func testDeleteOlderThan(t *testing.T, repo Repository) {
+	if pg, ok := repo.(*PostgresRepository); ok {
+		// Delete one row per statement to exercise the batching.
+		pg.SetDeleteBatchSize(1)
+	}
+
+	ctx := context.Background()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	logs := []*models.TrafficLog{
+		{SourceIP: "10.0.0.1", Timestamp: base.Add(-3 * time.Hour)},
+		{SourceIP: "10.0.0.2", Timestamp: base.Add(-2 * time.Hour)},
+		{SourceIP: "10.0.0.3", Timestamp: base.Add(-time.Hour)},
+		{SourceIP: "10.0.0.4", Timestamp: base},
+	}
+	if err := repo.SaveTrafficLogs(ctx, logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+
+	deleted, err := repo.DeleteOlderThan(ctx, base.Add(-90*time.Minute))
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 logs deleted, got %d, %v", deleted, err)
+	}
+
+	remaining, err := repo.GetTrafficByTimeRange(ctx, base.Add(-24*time.Hour), base, 10, 0, TrafficFilter{})
+	if err != nil {
+		t.Fatalf("failed to get traffic: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0].SourceIP != "10.0.0.4" || remaining[1].SourceIP != "10.0.0.3" {
+		t.Errorf("expected the two newest logs to remain, got %+v", remaining)
+	}
+}
+
+func testWhitelistEntries(t *testing.T, repo Repository) {
+	ctx := context.Background()
+
+	for _, entry := range []*models.WhitelistEntry{
+		{Entry: "10.0.0.0/8", Comment: "office"},
+		{Entry: "192.0.2.1", Comment: "incident"},
+	} {
+		if err := repo.AddWhitelistEntry(ctx, entry); err != nil {
+			t.Fatalf("failed to add %s: %v", entry.Entry, err)
+		}
+	}
+	created := time.Now()
+
+	// Re-adding updates the comment but keeps the entry's creation time.
+	updated := &models.WhitelistEntry{Entry: "10.0.0.0/8", Comment: "vpn"}
+	if err := repo.AddWhitelistEntry(ctx, updated); err != nil {
+		t.Fatalf("failed to update entry: %v", err)
+	}
+	if updated.CreatedAt.After(created) {
+		t.Errorf("expected the original creation time, got %v", updated.CreatedAt)
+	}
+
+	entries, err := repo.GetWhitelistEntries(ctx)
+	if err != nil {
+		t.Fatalf("failed to get entries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Entry != "10.0.0.0/8" || entries[0].Comment != "vpn" ||
+		entries[1].Entry != "192.0.2.1" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	if deleted, err := repo.DeleteWhitelistEntry(ctx, "192.0.2.1"); err != nil || !deleted {
+		t.Errorf("expected the entry to be deleted, got %v: %v", deleted, err)
+	}
+	if deleted, err := repo.DeleteWhitelistEntry(ctx, "192.0.2.1"); err != nil || deleted {
+		t.Errorf("expected a missing entry to be reported, got %v: %v", deleted, err)
+	}
+	if entries, _ := repo.GetWhitelistEntries(ctx); len(entries) != 1 {
+		t.Errorf("expected 1 entry left, got %+v", entries)
+	}
+}
+
+func TestSaveTrafficLogsInsertBatchSize(t *testing.T) {
+	repo, inserts := dryRunRepository(t)
+	repo.SetInsertBatchSize(2)
+
+	logs := []*models.TrafficLog{{Domain: "a.example"}, {Domain: "b.example"}, {Domain: "c.example"}}
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+
+	if len(*inserts) != 2 {
+		t.Errorf("expected 3 logs to be split into 2 statements, got %d", len(*inserts))
+	}
+}
+
+func TestSetInsertBatchSizeFitsBindParameters(t *testing.T) {
+	repo, inserts := dryRunRepository(t)
+	repo.SetInsertBatchSize(1_000_000)
+
+	logs := make([]*models.TrafficLog, repo.insertBatchSize+1)
+	for i := range logs {
+		logs[i] = &models.TrafficLog{Domain: "a.example"}
+	}
+	if err := repo.SaveTrafficLogs(context.Background(), logs); err != nil {
+		t.Fatalf("failed to save logs: %v", err)
+	}
+
+	if len(*inserts) != 2 {
+		t.Fatalf("expected the batch to be split into 2 statements, got %d", len(*inserts))
+	}
+	if params := strings.Count((*inserts)[0], "$"); params == 0 || params > postgresMaxParams {
+		t.Errorf("expected at most %d bind parameters per statement, got %d", postgresMaxParams, params)
+	}
+}
+
+func TestSaveTrafficLogsConflictMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{OnConflictError, ""},
+		{OnConflictIgnore, `ON CONFLICT ("uuid") DO NOTHING`},
+		{OnConflictUpdate, `ON CONFLICT ("uuid") DO UPDATE SET`},
+	}
+
+	for _, tt := range tests {
+		repo, inserts := dryRunRepository(t)
+		if err := repo.SetConflictMode(tt.mode, "uuid"); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.mode, err)
+		}
+		if err := repo.SaveTrafficLogs(context.Background(), []*models.TrafficLog{{Domain: "a.example"}}); err != nil {
+			t.Fatalf("%s: failed to save logs: %v", tt.mode, err)
+		}
+
+		insert := (*inserts)[0]
+		if tt.want == "" && strings.Contains(insert, "ON CONFLICT") ||
+			tt.want != "" && !strings.Contains(insert, tt.want) {
+			t.Errorf("%s: unexpected statement %q", tt.mode, insert)
+		}
+	}
+
+	if err := NewPostgresRepository(nil).SetConflictMode("merge", "uuid"); err == nil {
+		t.Error("expected an unknown conflict mode to be rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/andev0x/socks5-proxy-analytics/internal/models"
+)
+
+// movingAverage returns the trailing moving average of values over window
+// points. The first window-1 points have fewer predecessors than the window
+// holds, so they are averaged over the points that exist rather than padded
+// with zeros. A window of 1 or less returns a copy of values.
+func movingAverage(values []float64, window int) []float64 {
+	smoothed := make([]float64, len(values))
+	window = max(window, 1)
+
+	var sum float64
+	for i, v := range values {
+		sum += v
+		if i >= window {
+			sum -= values[i-window]
+		}
+		smoothed[i] = sum / float64(min(i+1, window))
+	}
+
+	return smoothed
+}
+
+// bucketTotals is the traffic logged in one bucket, identified by its index
+// from the start of the range.
+type bucketTotals struct {
+	Bucket      int64
+	Connections int64
+	BytesIn     int64
+	BytesOut    int64
+	AvgLatency  float64
+}
+
+// buildTimeSeries zero-fills the buckets in [start, end) and, when
+// smoothWindow is positive, attaches moving averages of connections and bytes.
+func buildTimeSeries(
+	totals []bucketTotals, start, end time.Time, bucket time.Duration, smoothWindow int,
+) []models.TrafficBucket {
+	series := []models.TrafficBucket{}
+	if bucket <= 0 || !end.After(start) {
+		return series
+	}
+
+	byBucket := make(map[int64]bucketTotals, len(totals))
+	for _, t := range totals {
+		byBucket[t.Bucket] = t
+	}
+
+	for i, bucketStart := int64(0), start; bucketStart.Before(end); i, bucketStart = i+1, bucketStart.Add(bucket) {
+		t := byBucket[i]
+		series = append(series, models.TrafficBucket{
+			BucketStart: bucketStart,
+			Connections: t.Connections,
+			BytesIn:     t.BytesIn,
+			BytesOut:    t.BytesOut,
+			AvgLatency:  t.AvgLatency,
+		})
+	}
+
+	if smoothWindow <= 0 {
+		return series
+	}
+
+	connections := make([]float64, len(series))
+	bytes := make([]float64, len(series))
+	for i, b := range series {
+		connections[i] = float64(b.Connections)
+		bytes[i] = float64(b.BytesIn + b.BytesOut)
+	}
+
+	smoothedConnections := movingAverage(connections, smoothWindow)
+	smoothedBytes := movingAverage(bytes, smoothWindow)
+	for i := range series {
+		series[i].SmoothedConnections = &smoothedConnections[i]
+		series[i].SmoothedBytes = &smoothedBytes[i]
+	}
+
+	return series
+}
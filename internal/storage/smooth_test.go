@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMovingAverage(t *testing.T) {
+	values := []float64{4, 8, 6, 2, 10, 0}
+
+	tests := []struct {
+		name   string
+		window int
+		want   []float64
+	}{
+		// Partial windows at the start average over the points seen so far.
+		{"window 3", 3, []float64{4, 6, 6, 16.0 / 3, 6, 4}},
+		{"window 1", 1, values},
+		{"window 0", 0, values},
+		{"window larger than series", 10, []float64{4, 6, 6, 5, 6, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := movingAverage(values, tt.window)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d points, got %d", len(tt.want), len(got))
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("point %d: expected %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+
+	if got := movingAverage(nil, 3); len(got) != 0 {
+		t.Errorf("expected empty result for empty series, got %v", got)
+	}
+}
+
+func TestBuildTimeSeries(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	totals := []bucketTotals{
+		{Bucket: 0, Connections: 3, BytesIn: 200, BytesOut: 100, AvgLatency: 12.5},
+		{Bucket: 2, Connections: 6, BytesIn: 900},
+		{Bucket: 3, Connections: 3},
+	}
+
+	series := buildTimeSeries(totals, base, base.Add(4*time.Minute), time.Minute, 2)
+	if len(series) != 4 {
+		t.Fatalf("expected 4 buckets, got %d", len(series))
+	}
+
+	// Bucket 1 is zero-filled; the first bucket's window holds only itself.
+	wantCounts := []float64{3, 1.5, 3, 4.5}
+	wantBytes := []float64{300, 150, 450, 450}
+	for i, b := range series {
+		if !b.BucketStart.Equal(base.Add(time.Duration(i) * time.Minute)) {
+			t.Errorf("bucket %d: unexpected start %v", i, b.BucketStart)
+		}
+		if b.SmoothedConnections == nil || *b.SmoothedConnections != wantCounts[i] {
+			t.Errorf("bucket %d: expected smoothed connections %v, got %v", i, wantCounts[i], b.SmoothedConnections)
+		}
+		if b.SmoothedBytes == nil || *b.SmoothedBytes != wantBytes[i] {
+			t.Errorf("bucket %d: expected smoothed bytes %v, got %v", i, wantBytes[i], b.SmoothedBytes)
+		}
+	}
+	if series[1].Connections != 0 || series[2].Connections != 6 {
+		t.Errorf("unexpected raw counts %d, %d", series[1].Connections, series[2].Connections)
+	}
+	if series[0].BytesIn != 200 || series[0].BytesOut != 100 || series[0].AvgLatency != 12.5 {
+		t.Errorf("unexpected first bucket %+v", series[0])
+	}
+
+	for _, b := range buildTimeSeries(totals, base, base.Add(4*time.Minute), time.Minute, 0) {
+		if b.SmoothedConnections != nil || b.SmoothedBytes != nil {
+			t.Fatal("expected no smoothed values without a window")
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetupWithoutEndpointIsNoop(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "", "test", 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("expected a no-op shutdown, got %v", err)
+	}
+
+	ctx, span := Tracer().Start(context.Background(), "noop")
+	defer span.End()
+	if span.SpanContext().IsValid() || TraceID(ctx) != "" {
+		t.Error("expected spans to be no-ops without an endpoint")
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	sampled := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	ctx, span := sampled.Tracer("test").Start(context.Background(), "sampled")
+	defer span.End()
+	if got := TraceID(ctx); got != span.SpanContext().TraceID().String() {
+		t.Errorf("expected the span's trace ID, got %q", got)
+	}
+
+	dropped := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+	ctx, span = dropped.Tracer("test").Start(context.Background(), "dropped")
+	defer span.End()
+	if got := TraceID(ctx); got != "" {
+		t.Errorf("expected no trace ID for an unsampled span, got %q", got)
+	}
+}